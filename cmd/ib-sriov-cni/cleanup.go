@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	ibtypes "github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+)
+
+// procDir is searched for the netns of running processes
+var procDir = "/proc"
+
+// liveNetnsIDs returns the identifiers of the netns of running processes and of the netns pinned in netnsDirs
+func liveNetnsIDs() map[string]bool {
+	paths, _ := filepath.Glob(filepath.Join(procDir, "[0-9]*", "ns", "net"))
+	for _, dir := range netnsDirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			paths = append(paths, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	ids := map[string]bool{}
+	for _, path := range paths {
+		// processes may exit while being scanned
+		if id, err := utils.GetNetnsID(path); err == nil {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+// cleanupCache removes the cached NetConfs of attachments whose netns no longer exists, e.g. after an unclean node
+// shutdown. Cached NetConfs without a netns identifier, written by older versions, are kept. With resetVF the VF
+// config of a stale attachment is reset as well, unless the VF is used by an attachment which is still alive.
+// With dryRun the stale attachments are only listed.
+func cleanupCache(w io.Writer, dir string, dryRun, resetVF bool) error {
+	cached, err := config.ListCachedNetConfs(dir)
+	if err != nil {
+		return err
+	}
+
+	live := liveNetnsIDs()
+	inUse := map[string]bool{}
+	var stale []config.CachedNetConf
+	for _, c := range cached {
+		switch {
+		case c.NetConf == nil:
+			stale = append(stale, c)
+		case c.NetConf.NetnsID == "" || live[c.NetConf.NetnsID]:
+			inUse[c.NetConf.DeviceID] = true
+		default:
+			stale = append(stale, c)
+		}
+	}
+
+	for _, c := range stale {
+		if c.NetConf == nil {
+			fmt.Fprintf(w, "stale %s: invalid cached NetConf: %v\n", c.Path, c.Err)
+		} else {
+			fmt.Fprintf(w, "stale %s: netns %s of VF %s is gone\n", c.Path, c.NetConf.NetnsID, c.NetConf.DeviceID)
+		}
+		if dryRun {
+			continue
+		}
+
+		if resetVF && c.NetConf != nil {
+			if inUse[c.NetConf.DeviceID] {
+				fmt.Fprintf(w, "skipping VF %s reset, it is used by another attachment\n", c.NetConf.DeviceID)
+			} else if err := resetStaleVF(c.NetConf); err != nil {
+				// the cache is kept so that the reset can be retried
+				_ = logging.Errorf("cleanupCache(): %v", err)
+				fmt.Fprintf(w, "failed to reset VF %s: %v\n", c.NetConf.DeviceID, err)
+				continue
+			}
+		}
+
+		if err := utils.CleanCachedNetConf(c.Path); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "removed %s\n", c.Path)
+	}
+
+	return nil
+}
+
+// resetStaleVF resets the VF config of a stale attachment, the reset also releases its GUID pool allocation
+func resetStaleVF(netConf *ibtypes.NetConf) error {
+	unlockPF, err := utils.LockPF(config.DefaultLockDir, netConf.Master)
+	if err != nil {
+		return err
+	}
+	defer unlockPF()
+
+	if err := newSriovManager().ResetVFConfig(netConf); err != nil {
+		return fmt.Errorf("failed to reset VF %s config: %w", netConf.DeviceID, err)
+	}
+	return nil
+}
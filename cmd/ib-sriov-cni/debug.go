@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/sriov"
+	ibtypes "github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+// debugCommand is the CNI_COMMAND value which dumps the plugin view of an attachment
+const debugCommand = "DEBUG"
+
+// debugInfo is the state of an attachment as seen by the plugin, printed as JSON in debug mode
+type debugInfo struct {
+	ContainerID string            `json:"containerID"`
+	IfName      string            `json:"ifName"`
+	PF          string            `json:"pf,omitempty"`
+	VFID        int               `json:"vfID"`
+	PciAddress  string            `json:"pciAddress,omitempty"`
+	GUID        string            `json:"guid,omitempty"`
+	PKey        string            `json:"pkey,omitempty"`
+	MTU         int               `json:"mtu,omitempty"`
+	LinkState   string            `json:"linkState,omitempty"`
+	OperState   string            `json:"operState,omitempty"`
+	CachedConf  *ibtypes.NetConf  `json:"cachedNetConf,omitempty"`
+	Errors      map[string]string `json:"errors,omitempty"`
+}
+
+// collectDebugInfo gathers the cached NetConf and the live VF state of an attachment, it doesn't change any state
+func collectDebugInfo(args *skel.CmdArgs) *debugInfo {
+	info := &debugInfo{ContainerID: args.ContainerID, IfName: args.IfName, Errors: map[string]string{}}
+
+	netConf, _, err := config.LoadConfFromCache(args)
+	if err != nil {
+		info.Errors["cache"] = err.Error()
+		return info
+	}
+	info.CachedConf = netConf
+	info.PF = netConf.Master
+	info.VFID = netConf.VFID
+	info.PciAddress = netConf.DeviceID
+	info.PKey = netConf.PKey
+
+	if pfLink, err := netlink.LinkByName(netConf.Master); err != nil {
+		info.Errors["pf"] = err.Error()
+	} else if vfs := pfLink.Attrs().Vfs; netConf.VFID < len(vfs) {
+		info.LinkState = sriov.LinkStateToString(vfs[netConf.VFID].LinkState)
+	}
+
+	if args.Netns == "" {
+		info.Errors["link"] = "CNI_NETNS is not set, skipping VF netdevice state"
+		return info
+	}
+
+	netns, err := ns.GetNS(args.Netns)
+	if err != nil {
+		info.Errors["netns"] = err.Error()
+		return info
+	}
+	defer netns.Close()
+
+	if err := netns.Do(func(_ ns.NetNS) error {
+		linkObj, err := netlink.LinkByName(args.IfName)
+		if err != nil {
+			return err
+		}
+		attrs := linkObj.Attrs()
+		info.MTU = attrs.MTU
+		info.OperState = attrs.OperState.String()
+		if hwaddr := attrs.HardwareAddr.String(); len(hwaddr) > 36 {
+			info.GUID = hwaddr[36:]
+		}
+		return nil
+	}); err != nil {
+		info.Errors["link"] = err.Error()
+	}
+
+	return info
+}
+
+// printDebugInfo writes the debug info of the attachment given by the CNI environment variables as JSON
+func printDebugInfo(w io.Writer) error {
+	args := &skel.CmdArgs{
+		ContainerID: os.Getenv("CNI_CONTAINERID"),
+		Netns:       os.Getenv("CNI_NETNS"),
+		IfName:      os.Getenv("CNI_IFNAME"),
+	}
+	if args.ContainerID == "" || args.IfName == "" {
+		return fmt.Errorf("CNI_CONTAINERID and CNI_IFNAME are required in %s mode", debugCommand)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(collectDebugInfo(args))
+}
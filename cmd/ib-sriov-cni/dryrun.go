@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	ibtypes "github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	"github.com/containernetworking/cni/pkg/skel"
+)
+
+// dryRunEnv enables the dry-run mode of ADD when set to "true", same as the dryRun network configuration field
+const dryRunEnv = "IB_SRIOV_CNI_DRY_RUN"
+
+// guid sources reported by the dry-run plan
+const (
+	guidSourceArgs = "cni-args"
+	guidSourcePool = "guidPool"
+	guidSourceConf = "netconf"
+)
+
+// dryRunPlan is what ADD would do for the attachment, printed as JSON in dry-run mode
+type dryRunPlan struct {
+	DryRun      bool     `json:"dryRun"`
+	ContainerID string   `json:"containerID"`
+	IfName      string   `json:"ifName"`
+	PF          string   `json:"pf"`
+	VFID        int      `json:"vfID"`
+	PciAddress  string   `json:"pciAddress"`
+	HostIFName  string   `json:"hostIFName"`
+	GUID        string   `json:"guid,omitempty"`
+	GUIDSource  string   `json:"guidSource"`
+	Actions     []string `json:"actions"`
+}
+
+// planAdd validates the configuration, the cni-args and the PF and VF state, and returns the actions ADD would take.
+// It doesn't change any state, a GUID from the GUID pool is not allocated.
+func planAdd(sm ibtypes.Manager, netConf *ibtypes.NetConf, args *skel.CmdArgs) (*dryRunPlan, error) {
+	plan := &dryRunPlan{
+		DryRun:      true,
+		ContainerID: args.ContainerID,
+		IfName:      args.IfName,
+		PF:          netConf.Master,
+		VFID:        netConf.VFID,
+		PciAddress:  netConf.DeviceID,
+		HostIFName:  netConf.HostIFNames,
+		GUIDSource:  guidSourceArgs,
+	}
+
+	if usesGUIDPool(netConf) {
+		plan.GUIDSource = guidSourcePool
+		plan.Actions = append(plan.Actions, fmt.Sprintf("allocate guid from the GUID pool %s-%s",
+			netConf.GUIDPool.RangeStart, netConf.GUIDPool.RangeEnd))
+	} else {
+		if usesStaticGUID(netConf) {
+			plan.GUIDSource = guidSourceConf
+		}
+		guid, err := selectGUID(netConf, args)
+		if err != nil {
+			return nil, err
+		}
+		guidAddr, err := utils.ParseAndNormalizeGUID(guid)
+		if err != nil {
+			return nil, fmt.Errorf("InfiniBand SRIOV-CNI failed, invalid guid %q from cni-args: %w", guid, err)
+		}
+		plan.GUID = guidAddr.String()
+	}
+
+	if err := sm.ValidateVF(netConf); err != nil {
+		return nil, err
+	}
+
+	if netConf.LinkState != "" {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set vf %d link state to %s", netConf.VFID, netConf.LinkState))
+	}
+	if netConf.Trust != "" {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set vf %d trust to %s", netConf.VFID, netConf.Trust))
+	}
+	if netConf.MinTxRate != 0 || netConf.MaxTxRate != 0 {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set vf %d tx rate to min %d max %d Mbps", netConf.VFID, netConf.MinTxRate, netConf.MaxTxRate))
+	}
+	if netConf.PKey != "" {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set vf %d pkey to %s", netConf.VFID, netConf.PKey))
+	}
+	for _, pkey := range netConf.PKeys {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("add vf %d to pkey %s", netConf.VFID, pkey))
+	}
+	netnsPath := targetNetns(netConf, args)
+	ifName := args.IfName
+	if netConf.RenameInterface != nil && !*netConf.RenameInterface {
+		ifName = netConf.HostIFNames
+	}
+	plan.Actions = append(plan.Actions,
+		fmt.Sprintf("set vf %d node and port guid", netConf.VFID),
+		fmt.Sprintf("move %s to netns %s as %s", netConf.HostIFNames, netnsPath, ifName))
+	if netConf.RdmaIsolation {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("move the RDMA device of %s to netns %s", netConf.DeviceID, netnsPath))
+	}
+	if netConf.MAC != "" {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set %s mac to %s", ifName, netConf.MAC))
+	}
+	if netConf.MTU != 0 {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set %s mtu to %d", ifName, netConf.MTU))
+	}
+	if netConf.DisableArpNd {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set %s arp off", ifName))
+	}
+	if netConf.Rings != nil {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set %s rings rx %d tx %d", ifName, netConf.Rings.RX, netConf.Rings.TX))
+	}
+	for _, neighbor := range netConf.Neighbors {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("set %s neighbor %s lladdr %s", ifName, neighbor.IP, neighbor.LLAddr))
+	}
+	if netConf.IPAM.Type != "" {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("assign IPs from IPAM plugin type %q", netConf.IPAM.Type))
+	} else if len(netConf.RuntimeConfig.IPs) > 0 {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("assign IPs %v from the ips capability", netConf.RuntimeConfig.IPs))
+	}
+	if netConf.PostSetupHook != nil {
+		plan.Actions = append(plan.Actions, fmt.Sprintf("run post setup hook %q", strings.Join(netConf.PostSetupHook.Command, " ")))
+	}
+
+	return plan, nil
+}
+
+// dryRunAdd is ADD in dry-run mode, it writes the actions ADD would take without changing any state: the VF picked
+// from the PF is not claimed and a VF left in a container netns is not recovered
+func dryRunAdd(w io.Writer, args *skel.CmdArgs) error {
+	netConf, err := config.LoadConfForDryRun(args.StdinData)
+	if err != nil {
+		return fmt.Errorf("InfiniBand SRI-OV CNI failed to load netconf: %w", err)
+	}
+	setupLogging(netConf)
+	logging.Debugf("cmdAdd(): dry-run container %s ifname %s netns %s deviceID %s", args.ContainerID, args.IfName, args.Netns, netConf.DeviceID)
+
+	if err = mergeCNIArgs(netConf, args.Args); err != nil {
+		return fmt.Errorf("InfiniBand SRIOV-CNI failed to parse CNI_ARGS: %w", err)
+	}
+	return printDryRun(w, netConf, args)
+}
+
+// printDryRun writes the actions ADD would take for the attachment as JSON
+func printDryRun(w io.Writer, netConf *ibtypes.NetConf, args *skel.CmdArgs) error {
+	plan, err := planAdd(newSriovManager(), netConf, args)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "    ")
+	return enc.Encode(plan)
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+)
+
+const (
+	// healthPath is the path the health endpoint is served on
+	healthPath = "/healthz"
+	// healthShutdownTimeout bounds the time the requests in flight are given on shutdown
+	healthShutdownTimeout = 5 * time.Second
+)
+
+// pfHealth is the SR-IOV status of a PF and the usage of its VFs by the plugin
+type pfHealth struct {
+	Name       string `json:"name"`
+	PciAddress string `json:"pciAddress,omitempty"`
+	TotalVFs   int    `json:"totalVFs"`
+	config.PFUsage
+	// Error is set when the PF status could not be read, the other fields may be partial then
+	Error string `json:"error,omitempty"`
+}
+
+// nodeHealth is the InfiniBand SR-IOV health of the node served by the health endpoint
+type nodeHealth struct {
+	PFs []pfHealth `json:"pfs"`
+	// CachedNetConfs attachments cached in the cache directory, including the invalid cache entries
+	CachedNetConfs int    `json:"cachedNetConfs"`
+	Error          string `json:"error,omitempty"`
+}
+
+// collectHealth reads the health of the node from sysfs and the cache directory cniDir, with the same helpers the
+// CNI commands use
+func collectHealth(cniDir string) nodeHealth {
+	health := nodeHealth{PFs: []pfHealth{}}
+	cached, err := config.ListCachedNetConfs(cniDir)
+	if err != nil {
+		health.Error = err.Error()
+	}
+	health.CachedNetConfs = len(cached)
+
+	pfs, err := utils.ListSriovPFs()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	for _, name := range pfs {
+		pf := pfHealth{Name: name}
+		pf.PciAddress, _ = utils.GetPfPciAddress(name)
+		if pf.TotalVFs, err = utils.GetSriovTotalVfs(name); err == nil {
+			pf.PFUsage, err = config.GetPFUsage(name, cniDir)
+		}
+		if err != nil {
+			pf.Error = err.Error()
+		}
+		health.PFs = append(health.PFs, pf)
+	}
+	return health
+}
+
+// healthHandler serves the health of the node as JSON, with status 500 when it could not be read
+func healthHandler(cniDir string) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(healthPath, func(w http.ResponseWriter, r *http.Request) {
+		health := collectHealth(cniDir)
+		w.Header().Set("Content-Type", "application/json")
+		if health.Error != "" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_ = json.NewEncoder(w).Encode(health)
+	})
+	return mux
+}
+
+// serveHealth serves the health endpoint on addr until ctx is done. The health mode is a long running helper which
+// is separate from the CNI commands, it only reads the node state and leaves the cache directory untouched.
+func serveHealth(ctx context.Context, addr, cniDir string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", addr, err)
+	}
+	return serveHealthOn(ctx, l, cniDir)
+}
+
+func serveHealthOn(ctx context.Context, l net.Listener, cniDir string) error {
+	server := &http.Server{Handler: healthHandler(cniDir)}
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(l)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), healthShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-done; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
@@ -1,146 +1,287 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/metrics"
+	"github.com/Mellanox/ib-sriov-cni/pkg/plugin"
 	"github.com/Mellanox/ib-sriov-cni/pkg/sriov"
+	"github.com/Mellanox/ib-sriov-cni/pkg/trace"
+	ibtypes "github.com/Mellanox/ib-sriov-cni/pkg/types"
 	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
 	"github.com/containernetworking/cni/pkg/skel"
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/cni/pkg/types/current"
-	"github.com/containernetworking/cni/pkg/version"
+	cniversion "github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ip"
 	"github.com/containernetworking/plugins/pkg/ipam"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/vishvananda/netlink"
 )
 
+// errCodeIBNotConfigured is the CNI error code of ADD failing as ib-kubernetes did not configure InfiniBand yet,
+// errCodeRetriable of the other failures a retry of ADD may get past. Codes from 100 are plugin specific.
 const (
-	infiniBandAnnotation = "mellanox.infiniband.app"
-	configuredInfiniBand = "configured"
+	errCodeIBNotConfigured = 101
+	errCodeRetriable       = 102
 )
 
+const (
+	// defaultAddRetryInterval is the interval before the first in-process retry of ADD when addRetryInterval is not
+	// set, the interval doubles on each retry up to maxAddRetryInterval
+	defaultAddRetryInterval = time.Second
+	maxAddRetryInterval     = 10 * time.Second
+)
+
+// Build metadata, set through ldflags at build time
+var (
+	version = "master@git"
+	commit  = "unknown commit"
+	date    = "unknown date"
+)
+
+// versionInfo is printed as JSON when the plugin is invoked with the -version flag
+type versionInfo struct {
+	Version           string   `json:"version"`
+	Commit            string   `json:"commit"`
+	Date              string   `json:"date"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+// newSriovManager creates the sriov manager used by the commands, it is replaced with a mock in tests
+var newSriovManager = sriov.NewSriovManager
+
 func init() {
 	// this ensures that main runs only on main thread (thread group leader).
 	// since namespace ops (unshare, setns) are done for a single thread, we
 	// must ensure that the goroutine does not jump from OS thread to thread
 	runtime.LockOSThread()
+
+	// free VFs are picked from the VFs read from sysfs
+	config.SetVFEnumerator(sriov.NewVFEnumerator())
 }
 
+// cmdAdd runs ADD and retries it up to addRetryAttempts times as long as it fails with a transient condition, the
+// failures a retry may get past are returned as plugin.RetriableError
 func cmdAdd(args *skel.CmdArgs) error {
-	netConf, err := config.LoadConf(args.StdinData)
-	if err != nil {
-		return fmt.Errorf("InfiniBand SRI-OV CNI failed to load netconf: %v", err)
+	attempts, interval := config.AddRetry(args.StdinData)
+	if interval == 0 {
+		interval = defaultAddRetryInterval
+	}
+	for i := 0; ; i++ {
+		// each attempt rolls back what it acquired before failing
+		err := plugin.Retriable(addAttempt(args))
+		if err == nil || i >= attempts || !plugin.IsTransient(err) {
+			return err
+		}
+		logging.Infof("cmdAdd(): attempt %d/%d failed with transient error %v, retrying in %v", i+1, attempts+1, err, interval)
+		time.Sleep(interval)
+		if interval *= 2; interval > maxAddRetryInterval {
+			interval = maxAddRetryInterval
+		}
 	}
+}
 
-	cniArgs := netConf.Args.CNI
-	if cniArgs[infiniBandAnnotation] != configuredInfiniBand {
-		return fmt.Errorf("InfiniBand SRIOV-CNI failed, InfiniBand status \"%s\" is not \"%s\" please check mellanox ib-kubernets",
-			infiniBandAnnotation, configuredInfiniBand)
+// addAttempt is a single attempt of ADD
+func addAttempt(args *skel.CmdArgs) (retErr error) {
+	start := time.Now()
+	stage := metrics.StageConfig
+	// dry-run is not recorded in the metrics
+	if os.Getenv(dryRunEnv) == "true" || config.DryRun(args.StdinData) {
+		return dryRunAdd(os.Stdout, args)
 	}
 
-	guid, ok := cniArgs["guid"]
-	if !ok {
-		return fmt.Errorf("InfiniBand SRIOV-CNI failed, no guid found from cni-args, please check mellanox ib-kubernets")
+	netConf, err := config.LoadConf(args.StdinData)
+	if errors.Is(err, config.ErrVFNetdevNotFound) {
+		// a crashed previous invocation may have left the VF in a container netns, recover it once
+		if recoverErr := recoverVF(args); recoverErr != nil {
+			_ = logging.Errorf("cmdAdd(): %v", recoverErr)
+		} else {
+			netConf, err = config.LoadConf(args.StdinData)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("InfiniBand SRI-OV CNI failed to load netconf: %w", err)
 	}
+	// a VF picked from the PF is claimed until its NetConf is cached, the claim is no longer needed once cmdAdd ends
+	defer func() {
+		_ = config.ReleaseVFClaim(netConf.CNIDir, netConf.DeviceID)
+	}()
+	setupLogging(netConf)
+	logging.Debugf("cmdAdd(): container %s ifname %s netns %s deviceID %s", args.ContainerID, args.IfName, args.Netns, netConf.DeviceID)
+	defer func() {
+		recordMetrics(netConf.MetricsPath, metrics.Sample{Command: "add", Stage: stage, Duration: time.Since(start), Err: retErr})
+		recordTrace(netConf, args, "add", stage, start, retErr)
+	}()
 
-	netConf.GUID = guid
+	if err = mergeCNIArgs(netConf, args.Args); err != nil {
+		return fmt.Errorf("InfiniBand SRIOV-CNI failed to parse CNI_ARGS: %w", err)
+	}
 
-	netns, err := ns.GetNS(args.Netns)
+	// the result of the previous plugins when the plugin is not the first of a chain
+	prevResult, err := parsePrevResult(args.StdinData)
 	if err != nil {
-		return fmt.Errorf("failed to open netns %q: %v", netns, err)
+		return err
 	}
-	defer netns.Close()
 
-	sm := sriov.NewSriovManager()
-	if err := sm.ApplyVFConfig(netConf); err != nil {
-		return fmt.Errorf("InfiniBand SRI-OV CNI failed to configure VF %q", err)
+	// a repeated ADD fails before anything is acquired, the rollback would otherwise undo the cached attachment
+	cachePath := filepath.Join(netConf.CNIDir, args.ContainerID+"-"+args.IfName)
+	if netConf.OnCacheConflict == ibtypes.CacheConflictFail {
+		if _, err := os.Stat(cachePath); err == nil {
+			return fmt.Errorf("InfiniBand SRIOV-CNI failed, NetConf of %s is already cached in %s: %w",
+				attachmentRef(netConf, args), cachePath, os.ErrExist)
+		}
 	}
 
-	result := &current.Result{}
-	result.Interfaces = []*current.Interface{{
-		Name:    args.IfName,
-		Sandbox: netns.Path(),
-	}}
+	// the netns is opened first so that it is still open when the rollback below runs
+	netnsPath := targetNetns(netConf, args)
+	netns, err := ns.GetNS(netnsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %w", netnsPath, err)
+	}
+	defer netns.Close()
 
-	err = sm.SetupVF(netConf, args.IfName, args.ContainerID, netns)
+	// any failure releases whatever was acquired so far, the flags are set as the resources are acquired so that
+	// reordering the steps can't leak them. A failing Setup undoes its own setup.
+	p := plugin.NewPlugin(newSriovManager(), plugin.NewCNIIPAM(args.StdinData))
+	setUp, cached := false, false
 	defer func() {
-		if err != nil {
-			err := netns.Do(func(_ ns.NetNS) error {
-				_, err := netlink.LinkByName(args.IfName)
-				return err
-			})
-			if err == nil {
-				_ = sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns)
+		if retErr == nil {
+			return
+		}
+		if cached {
+			if err := utils.CleanCachedNetConf(cachePath); err != nil {
+				_ = logging.Errorf("cmdAdd(): rollback: %v", err)
+			}
+		}
+		if setUp {
+			if err := p.Teardown(netConf, args.IfName, args.ContainerID, netns); err != nil {
+				_ = logging.Errorf("cmdAdd(): rollback: %v", err)
 			}
 		}
+		if netConf.AllocatedGUID != "" {
+			_ = utils.ReleaseGUID(netConf.GUIDPool.DataDir, netConf.AllocatedGUID, netConf.DeviceID)
+		}
 	}()
+
+	guid, err := selectGUID(netConf, args)
 	if err != nil {
-		return fmt.Errorf("failed to set up pod interface %q from the device %q: %v", args.IfName, netConf.Master, err)
+		return err
 	}
 
-	// run the IPAM plugin
-	if netConf.IPAM.Type != "" {
-		if netConf.IPAM.Type == "dhcp" {
-			return fmt.Errorf("ipam type dhcp is not supported")
-		}
-		r, err := ipam.ExecAdd(netConf.IPAM.Type, args.StdinData)
-		if err != nil {
-			return fmt.Errorf("failed to set up IPAM plugin type %q from the device %q: %v", netConf.IPAM.Type, netConf.Master, err)
-		}
+	guidAddr, err := utils.ParseAndNormalizeGUID(guid)
+	if err != nil {
+		return fmt.Errorf("InfiniBand SRIOV-CNI failed, invalid guid %q from cni-args: %w", guid, err)
+	}
 
-		defer func() {
-			if err != nil {
-				_ = ipam.ExecDel(netConf.IPAM.Type, args.StdinData)
-			}
-		}()
+	netConf.GUID = guidAddr.String()
+	logging.Debugf("cmdAdd(): using guid %s", netConf.GUID)
 
-		// Convert the IPAM result into the current Result type
-		newResult, err := current.NewResultFromResult(r)
+	if netConf.EnforceGUIDUniqueness {
+		// held until the NetConf is cached so that a concurrent ADD of the same guid finds it
+		unlockGUIDs, err := utils.LockGUIDs(config.DefaultLockDir)
 		if err != nil {
 			return err
 		}
-
-		if len(newResult.IPs) == 0 {
-			return errors.New("IPAM plugin returned missing IP config")
+		defer unlockGUIDs()
+		if err := checkGUIDUnique(netConf, args); err != nil {
+			return err
 		}
+	}
 
-		newResult.Interfaces = result.Interfaces
+	// a terminated plugin aborts the setup which is then undone, so that the VF is not left half configured
+	ctx, stopSignals := signalContext(context.Background())
+	defer stopSignals()
 
-		for _, ipc := range newResult.IPs {
-			// All addresses apply to the container interface (move from host)
-			ipc.Interface = current.Int(0)
-		}
+	// bound the VF configuration and setup, a wedged driver must not stall the Pod startup indefinitely
+	if netConf.OperationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(netConf.OperationTimeout)*time.Millisecond)
+		defer cancel()
+	}
 
-		err = netns.Do(func(_ ns.NetNS) error {
-			return ipam.ConfigureIface(args.IfName, newResult)
-		})
-		if err != nil {
-			return err
-		}
-		result = newResult
+	result, err := p.Setup(ctx, netConf, args.IfName, args.ContainerID, netns)
+	if err != nil {
+		stage = pluginStage(err, stage)
+		return err
+	}
+	setUp = true
+	logging.Infof("cmdAdd(): VF %s guid %s attached to container %s as %s with hardware address %s",
+		netConf.DeviceID, netConf.GUID, args.ContainerID, args.IfName, netConf.ContIFMAC)
+	if prevResult != nil {
+		result = chainResult(prevResult, result)
 	}
 
 	// Cache NetConf for CmdDel
-	if err = utils.SaveNetConf(args.ContainerID, config.DefaultCNIDir, args.IfName, netConf); err != nil {
-		return fmt.Errorf("error saving NetConf %q", err)
+	stage = metrics.StageCache
+	netConf.CacheVersion = config.CacheVersion
+	netConf.ContainerID, netConf.PodGUID = args.ContainerID, netConf.GUID
+	// the fail policy is handled before the setup, merging keeps only network configuration fields of the cached NetConf
+	var mergeFields []string
+	if netConf.OnCacheConflict == ibtypes.CacheConflictMerge {
+		mergeFields = config.NetConfFields()
+	}
+	conflict, err := utils.SaveNetConfPolicy(args.ContainerID, netConf.CNIDir, args.IfName, netConf, mergeFields,
+		netConf.CacheFormat == ibtypes.CacheFormatCompact)
+	if err != nil {
+		return fmt.Errorf("error saving NetConf %w", err)
+	}
+	cached = true
+	if conflict != nil {
+		// the runtime issued a duplicate ADD
+		changes := "none"
+		if len(conflict.Changes) > 0 {
+			changes = strings.Join(conflict.Changes, "; ")
+		}
+		logging.Warningf("cmdAdd(): NetConf of %s was already cached in %s, saved it with the %s policy, changes: %s",
+			attachmentRef(netConf, args), conflict.Path, netConf.OnCacheConflict, changes)
 	}
 
-	return types.PrintResult(result, current.ImplementedSpecVersion)
+	return printResult(os.Stdout, result, netConf.CNIVersion)
 }
 
-func cmdDel(args *skel.CmdArgs) error {
-	// https://github.com/kubernetes/kubernetes/pull/35240
-	if args.Netns == "" {
+func cmdDel(args *skel.CmdArgs) (retErr error) {
+	// https://github.com/kubernetes/kubernetes/pull/35240, the netnsOverride of the network configuration takes
+	// precedence over the missing netns
+	if args.Netns == "" && config.NetnsOverride(args.StdinData) == "" {
 		return nil
 	}
 
 	netConf, cRefPath, err := config.LoadConfFromCache(args)
 	if err != nil {
+		// DEL must be idempotent, a missing cache means the attachment was already deleted or never completed
+		if errors.Is(err, config.ErrNetConfCacheNotFound) {
+			bestEffortDel(args)
+			return nil
+		}
 		return err
 	}
+	setupLogging(netConf)
+	start := time.Now()
+	stage := metrics.StageIPAM
+	verification := ""
+	defer func() {
+		recordMetrics(netConf.MetricsPath, metrics.Sample{Command: "del", Stage: stage, Duration: time.Since(start), Err: retErr,
+			Verification: verification})
+		recordTrace(netConf, args, "del", stage, start, retErr)
+	}()
+	logging.Debugf("cmdDel(): container %s ifname %s netns %s deviceID %s", args.ContainerID, args.IfName, args.Netns, netConf.DeviceID)
 
 	defer func() {
 		if err == nil && cRefPath != "" {
@@ -148,49 +289,635 @@ func cmdDel(args *skel.CmdArgs) error {
 		}
 	}()
 
-	sm := sriov.NewSriovManager()
-
-	if netConf.IPAM.Type != "" {
-		if netConf.IPAM.Type == "dhcp" {
-			return fmt.Errorf("ipam type dhcp is not supported")
-		}
-		err = ipam.ExecDel(netConf.IPAM.Type, args.StdinData)
-		if err != nil {
-			return err
-		}
-	}
-
-	netns, err := ns.GetNS(args.Netns)
+	netnsPath := targetNetns(netConf, args)
+	netns, err := ns.GetNS(netnsPath)
 	if err != nil {
 		// according to:
 		// https://github.com/kubernetes/kubernetes/issues/43014#issuecomment-287164444
 		// if provided path does not exist (e.x. when node was restarted)
 		// plugin should silently return with success after releasing
 		// IPAM resources
-		_, ok := err.(ns.NSPathNotExistErr)
-		if ok {
+		if _, ok := err.(ns.NSPathNotExistErr); !ok {
+			stage = metrics.StageRelease
+			return fmt.Errorf("failed to open netns %s: %w", netnsPath, err)
+		}
+		netns = nil
+	} else {
+		defer netns.Close()
+	}
+
+	sm := newSriovManager()
+	p := plugin.NewPlugin(sm, plugin.NewCNIIPAM(args.StdinData))
+	if err = p.Teardown(netConf, args.IfName, args.ContainerID, netns); err != nil {
+		stage = pluginStage(err, stage)
+		if netns == nil && stage == metrics.StageRelease {
+			// the VF may be stuck in the deleted netns, reclaiming it never fails the command
+			// the cache is kept when it fails so that a later DEL can retry
+			_ = logging.Errorf("cmdDel(): %v", err)
 			return nil
 		}
+		return err
+	}
+
+	// a VF which was not reset never fails DEL, the runtime would retry a DEL which has nothing left to release
+	if netConf.VerifyDel {
+		verification = metrics.VerificationPassed
+		if err := sm.VerifyVFReset(netConf); err != nil {
+			verification = metrics.VerificationFailed
+			logging.Warningf("cmdDel(): teardown verification of %s failed: %v", attachmentRef(netConf, args), err)
+		}
+	}
+
+	return nil
+}
+
+// signalContext returns a context canceled once the process receives SIGTERM or SIGINT and a function to stop
+// handling the signals. The handling goroutine only cancels the context, the setup still runs and is undone on the
+// thread it is locked to.
+func signalContext(parent context.Context) (context.Context, func()) {
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	ctx, cancel := cancelOnSignal(parent, sigs)
+	return ctx, func() {
+		signal.Stop(sigs)
+		cancel()
+	}
+}
+
+// cancelOnSignal returns a context canceled once a signal is received from sigs
+func cancelOnSignal(parent context.Context, sigs <-chan os.Signal) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	go func() {
+		select {
+		case sig := <-sigs:
+			logging.Warningf("received %v, aborting and undoing the setup", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// parsePrevResult returns the prevResult of the network configuration converted to the current result version,
+// nil when there is none
+func parsePrevResult(stdinData []byte) (*current.Result, error) {
+	conf := &types.NetConf{}
+	if err := json.Unmarshal(stdinData, conf); err != nil {
+		return nil, fmt.Errorf("failed to load netconf: %w", err)
+	}
+	if err := cniversion.ParsePrevResult(conf); err != nil {
+		return nil, fmt.Errorf("failed to parse prevResult: %w", err)
+	}
+	if conf.PrevResult == nil {
+		return nil, nil
+	}
+	prevResult, err := current.NewResultFromResult(conf.PrevResult)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert prevResult: %w", err)
+	}
+	return prevResult, nil
+}
+
+// chainResult appends the VF interface, its IPs and routes to the result of the previous plugins of the chain. An
+// interface the previous plugins already report, same name in the same sandbox, is replaced in place and the IPs the
+// previous plugins report for it are dropped. The IPs of the VF are reindexed to the interfaces in the chained result,
+// an IP of an interface the VF result doesn't have is left without interface. The DNS of the previous plugins is kept
+// unless unset.
+func chainResult(prevResult, result *current.Result) *current.Result {
+	indexes := make([]int, len(result.Interfaces))
+	replaced := map[int]bool{}
+	for i, iface := range result.Interfaces {
+		if j := plugin.InterfaceIndex(prevResult, iface.Name, iface.Sandbox); j >= 0 {
+			prevResult.Interfaces[j] = iface
+			indexes[i] = j
+			replaced[j] = true
+			continue
+		}
+		prevResult.Interfaces = append(prevResult.Interfaces, iface)
+		indexes[i] = len(prevResult.Interfaces) - 1
+	}
+	if len(replaced) > 0 {
+		ips := prevResult.IPs[:0]
+		for _, ipc := range prevResult.IPs {
+			if ipc.Interface == nil || !replaced[*ipc.Interface] {
+				ips = append(ips, ipc)
+			}
+		}
+		prevResult.IPs = ips
+	}
+	for _, ipc := range result.IPs {
+		if ipc.Interface != nil {
+			if *ipc.Interface >= 0 && *ipc.Interface < len(indexes) {
+				ipc.Interface = current.Int(indexes[*ipc.Interface])
+			} else {
+				ipc.Interface = nil
+			}
+		}
+		prevResult.IPs = append(prevResult.IPs, ipc)
+	}
+	prevResult.Routes = append(prevResult.Routes, result.Routes...)
+	if len(prevResult.DNS.Nameservers) == 0 && len(prevResult.DNS.Search) == 0 && prevResult.DNS.Domain == "" {
+		prevResult.DNS = result.DNS
+	}
+	return prevResult
+}
+
+// attachmentRef identifies the attachment in errors by the Pod given in CNI_ARGS, when set, the container and interface
+func attachmentRef(netConf *ibtypes.NetConf, args *skel.CmdArgs) string {
+	ref := fmt.Sprintf("container %s interface %s", args.ContainerID, args.IfName)
+	if name := netConf.Args.CNI["K8S_POD_NAME"]; name != "" {
+		ref = fmt.Sprintf("pod %s/%s %s", netConf.Args.CNI["K8S_POD_NAMESPACE"], name, ref)
+	}
+	return ref
+}
+
+// withCNIErrorCodes returns the errors of the wrapped command with a plugin specific CNI error code, so that runtimes
+// and wrappers can act on them. The error message is kept in the error details.
+func withCNIErrorCodes(cmd func(*skel.CmdArgs) error) func(*skel.CmdArgs) error {
+	return func(args *skel.CmdArgs) error {
+		err := cmd(args)
+		if errors.Is(err, config.ErrIBNotConfigured) {
+			return &types.Error{Code: errCodeIBNotConfigured, Msg: config.ErrIBNotConfigured.Error(), Details: err.Error()}
+		}
+		if plugin.IsRetriable(err) {
+			return &types.Error{Code: errCodeRetriable, Msg: "retriable failure", Details: err.Error()}
+		}
+		return err
+	}
+}
+
+// mergeCNIArgs merges the CNI_ARGS pairs into the cni-args of the network configuration,
+// the network configuration args take precedence
+func mergeCNIArgs(netConf *ibtypes.NetConf, envArgs string) error {
+	parsed, err := utils.ParseCNIArgs(envArgs)
+	if err != nil {
+		return err
+	}
+	if netConf.Args.CNI == nil {
+		netConf.Args.CNI = map[string]string{}
+	}
+	for k, v := range parsed {
+		if _, ok := netConf.Args.CNI[k]; !ok {
+			netConf.Args.CNI[k] = v
+		}
+	}
+	return nil
+}
+
+// usesGUIDPool returns whether the VF GUID is allocated from the GUID pool rather than taken from cni-args
+func usesGUIDPool(netConf *ibtypes.NetConf) bool {
+	_, ok := netConf.Args.CNI["guid"]
+	return netConf.GUIDPool != nil && (!ok || !infiniBandConfigured(netConf))
+}
+
+// usesStaticGUID returns whether the VF GUID is taken from the network configuration rather than from cni-args
+func usesStaticGUID(netConf *ibtypes.NetConf) bool {
+	_, ok := netConf.Args.CNI["guid"]
+	return !ok && netConf.StaticGUID != ""
+}
+
+// infiniBandConfigured returns whether ib-kubernetes set the InfiniBand annotation cni-arg to the configured value,
+// ignoring surrounding whitespace and case, or the check is skipped
+func infiniBandConfigured(netConf *ibtypes.NetConf) bool {
+	if netConf.SkipIBStatusCheck {
+		return true
+	}
+	value := strings.TrimSpace(netConf.Args.CNI[netConf.InfiniBandAnnotation])
+	return strings.EqualFold(value, netConf.InfiniBandConfigured)
+}
+
+// selectGUID returns the VF GUID from cni-args set by ib-kubernetes, or allocates it from the GUID pool
+// when the pool is configured and cni-args don't provide it. A guid of the network configuration is used as is
+// when cni-args don't provide one, as is the guid file of the container with a guidSource directory.
+func selectGUID(netConf *ibtypes.NetConf, args *skel.CmdArgs) (string, error) {
+	cniArgs := netConf.Args.CNI
+	guids, ok := cniArgs["guid"]
+	if usesGUIDPool(netConf) {
+		// the first candidate is derived from the container id and VF index, the VF owns the GUID until it is released
+		guid, err := utils.AllocateGUID(netConf.GUIDPool.DataDir, netConf.GUIDPool.RangeStart, netConf.GUIDPool.RangeEnd,
+			netConf.DeviceID, fmt.Sprintf("%s/%d", args.ContainerID, netConf.VFID))
+		if err != nil {
+			return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, failed to allocate guid from the GUID pool: %w", err)
+		}
+		netConf.AllocatedGUID = guid
+		return guid, nil
+	}
+
+	if usesStaticGUID(netConf) {
+		return netConf.StaticGUID, nil
+	}
+
+	if !infiniBandConfigured(netConf) {
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, %w for %s, InfiniBand status %q is %q not %q please check mellanox ib-kubernets",
+			config.ErrIBNotConfigured, attachmentRef(netConf, args), netConf.InfiniBandAnnotation,
+			cniArgs[netConf.InfiniBandAnnotation], netConf.InfiniBandConfigured)
+	}
+
+	if !ok && netConf.GUIDSource != "" && netConf.GUIDSource != ibtypes.GUIDSourceCNIArgs {
+		var err error
+		if guids, err = readGUIDFile(netConf.GUIDSource, args.ContainerID); err != nil {
+			return "", err
+		}
+		ok = true
+	}
+
+	if !ok {
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, %w from cni-args (args.cni of the network configuration, "+
+			"then CNI_ARGS), please check mellanox ib-kubernets", utils.ErrGUIDMissing)
+	}
+
+	guid, err := utils.GUIDForInterface(guids, args.IfName)
+	if err != nil {
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, failed to select guid from cni-args: %w", err)
+	}
+	return guid, nil
+}
+
+// readGUIDFile returns the guids an external agent wrote for the container to the file named after the container id
+// in dir, the file has the format of the guid cni-arg
+func readGUIDFile(dir, containerID string) (string, error) {
+	if containerID == "" || filepath.Base(containerID) != containerID {
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, invalid container id %q to read the guid file of", containerID)
+	}
+	path := filepath.Join(dir, containerID)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		// the agent may not have written the file yet
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, %w, guid file %s does not exist", utils.ErrGUIDMissing, path)
+	}
+	if err != nil {
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed to read guid file: %w", err)
+	}
+	guids := strings.TrimSpace(string(data))
+	if guids == "" {
+		return "", fmt.Errorf("InfiniBand SRIOV-CNI failed, %w, guid file %s is empty", utils.ErrGUIDMissing, path)
+	}
+	return guids, nil
+}
+
+// checkGUIDUnique checks that the guid of the network configuration is not used by another attachment of the node
+// whose netns is alive, the cached NetConfs are the attachments of the node. The attachment being added may be
+// cached already when the ADD is repeated. NetConfs cached by older versions have no guid and are not checked.
+func checkGUIDUnique(netConf *ibtypes.NetConf, args *skel.CmdArgs) error {
+	cached, err := config.ListCachedNetConfs(netConf.CNIDir)
+	if err != nil {
+		return fmt.Errorf("InfiniBand SRIOV-CNI failed to check guid uniqueness: %w", err)
+	}
+
+	self := filepath.Join(netConf.CNIDir, args.ContainerID+"-"+args.IfName)
+	var live map[string]bool
+	for _, c := range cached {
+		if c.NetConf == nil || c.Path == self || !strings.EqualFold(c.NetConf.PodGUID, netConf.GUID) {
+			continue
+		}
+		if live == nil {
+			live = liveNetnsIDs()
+		}
+		// cached NetConfs without a netns identifier, written by older versions, are taken as alive
+		if c.NetConf.NetnsID == "" || live[c.NetConf.NetnsID] {
+			return fmt.Errorf("InfiniBand SRIOV-CNI failed, guid %s is already used by container %s (cached in %s)",
+				netConf.GUID, c.NetConf.ContainerID, c.Path)
+		}
+	}
+	return nil
+}
+
+// recordMetrics records the outcome of a command when metrics are enabled, failing to record never fails the command
+func recordMetrics(path string, s metrics.Sample) {
+	if err := metrics.Record(path, s); err != nil {
+		logging.Warningf("failed to record metrics to %s: %v", path, err)
+	}
+}
+
+// recordTrace appends the invocation to the trace of the traceDir of the network configuration, a failure to trace
+// never fails the operation
+func recordTrace(netConf *ibtypes.NetConf, args *skel.CmdArgs, command, stage string, start time.Time, err error) {
+	if netConf.TraceDir == "" {
+		return
+	}
+	r := trace.Record{
+		Time:        start.UTC(),
+		Command:     command,
+		ContainerID: args.ContainerID,
+		Netns:       args.Netns,
+		IfName:      args.IfName,
+		Args:        args.Args,
+		DurationMs:  time.Since(start).Milliseconds(),
+	}
+	if config, marshalErr := json.Marshal(netConf); marshalErr == nil {
+		r.Config = config
+	}
+	if err != nil {
+		r.Stage = stage
+		r.Error = err.Error()
+	}
+	if traceErr := trace.Append(netConf.TraceDir, int64(netConf.TraceMaxSize), r); traceErr != nil {
+		logging.Warningf("failed to trace %s to %s: %v", command, netConf.TraceDir, traceErr)
+	}
+}
+
+// targetNetns returns the path of the netns the VF is moved to, the netns override of the network configuration
+// takes precedence over the container netns
+func targetNetns(netConf *ibtypes.NetConf, args *skel.CmdArgs) string {
+	if netConf.NetnsOverride != "" {
+		return netConf.NetnsOverride
+	}
+	return args.Netns
+}
+
+// pluginStage returns the stage a plugin operation failed in, stage when the error doesn't carry one
+func pluginStage(err error, stage string) string {
+	var pErr *plugin.Error
+	if errors.As(err, &pErr) {
+		return pErr.Stage
+	}
+	return stage
+}
+
+// bestEffortDel tears down what can be found from the command args alone, errors are logged and ignored. The VF is
+// only released when the container interface is found in the netns and is the VF of the netconf or a VF of its
+// master, it is identified by the PCI address of the interface and never selected among the free VFs of the PF.
+func bestEffortDel(args *skel.CmdArgs) {
+	rawConf := &ibtypes.NetConf{}
+	if err := json.Unmarshal(args.StdinData, rawConf); err != nil {
+		logging.Infof("cmdDel(): no cached NetConf and failed to parse netconf, nothing to release: %v", err)
+		return
+	}
+	setupLogging(rawConf)
+	logging.Infof("cmdDel(): no cached NetConf for container %s ifname %s, attempting best effort teardown", args.ContainerID, args.IfName)
+
+	if rawConf.IPAM.Type != "" {
+		if err := ipam.ExecDel(rawConf.IPAM.Type, args.StdinData); err != nil {
+			logging.Warningf("cmdDel(): best effort IPAM release failed: %v", err)
+		}
+	}
 
-		return fmt.Errorf("failed to open netns %s: %q", netns, err)
+	netnsPath := targetNetns(rawConf, args)
+	netns, err := ns.GetNS(netnsPath)
+	if err != nil {
+		logging.Infof("cmdDel(): netns %s is not available, nothing to release: %v", netnsPath, err)
+		return
 	}
 	defer netns.Close()
 
-	if err = sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns); err != nil {
+	// release the VF only when it is found in the Pod netns, otherwise it may be in use by another Pod
+	pciAddr := ""
+	if err := netns.Do(func(_ ns.NetNS) error {
+		if _, err := netlink.LinkByName(args.IfName); err != nil {
+			return err
+		}
+		busInfo, err := linkBusInfo(args.IfName)
+		pciAddr = busInfo
 		return err
+	}); err != nil {
+		logging.Infof("cmdDel(): interface %s not found in netns %s, nothing to release: %v", args.IfName, netnsPath, err)
+		return
+	}
+	if _, ok := utils.NormalizePciAddress(pciAddr); !ok {
+		logging.Infof("cmdDel(): interface %s in netns %s is not a PCI device, nothing to release", args.IfName, netnsPath)
+		return
 	}
 
+	netConf, err := config.LoadConfForVF(args.StdinData, pciAddr)
+	if err != nil {
+		logging.Infof("cmdDel(): failed to load netconf for VF %s, nothing to release: %v", pciAddr, err)
+		return
+	}
+	// drop a claim left by the ADD which failed before caching the NetConf
+	defer func() {
+		_ = config.ReleaseVFClaim(netConf.CNIDir, netConf.DeviceID)
+	}()
+
+	// serialize PF wide configuration with concurrent invocations until the VF config is reset
+	unlockPF, err := utils.LockPF(config.DefaultLockDir, netConf.Master)
+	if err != nil {
+		logging.Warningf("cmdDel(): best effort VF release failed: %v", err)
+		return
+	}
+	defer unlockPF()
+
+	netConf.ContIFNames = args.IfName
+	sm := newSriovManager()
+	if err := sm.ReleaseVF(netConf, args.IfName, args.ContainerID, netns); err != nil {
+		logging.Warningf("cmdDel(): best effort VF release failed: %v", err)
+		return
+	}
 	if err := sm.ResetVFConfig(netConf); err != nil {
-		return fmt.Errorf("cmdDel() error reseting VF: %q", err)
+		logging.Warningf("cmdDel(): best effort VF reset failed: %v", err)
 	}
+}
 
-	return nil
+// setupLogging configures logging from the netconf, logging is best effort and never fails the command
+func setupLogging(netConf *ibtypes.NetConf) {
+	if err := logging.SetLogLevel(netConf.LogLevel); err != nil {
+		logging.Warningf("failed to set log level: %v", err)
+	}
+	if err := logging.SetLogFile(netConf.LogFile); err != nil {
+		logging.Warningf("failed to set log file: %v", err)
+	}
 }
 
 func cmdCheck(args *skel.CmdArgs) error {
+	netConf, _, err := config.LoadConfFromCache(args)
+	if err != nil {
+		return err
+	}
+
+	// GUID is not serialized with the cached NetConf, take the cached guid of the Pod. A cache from an older version
+	// has none, take it from the cached cni-args or network configuration unless allocated from the pool.
+	guid := netConf.PodGUID
+	if guid == "" {
+		guid = netConf.AllocatedGUID
+	}
+	if guid == "" && usesStaticGUID(netConf) {
+		guid = netConf.StaticGUID
+	}
+	if guid == "" {
+		guid, err = utils.GUIDForInterface(netConf.Args.CNI["guid"], args.IfName)
+		if err != nil {
+			return fmt.Errorf("invalid cached guid: %w", err)
+		}
+	}
+	guidAddr, err := utils.ParseAndNormalizeGUID(guid)
+	if err != nil {
+		return fmt.Errorf("invalid cached guid: %w", err)
+	}
+	netConf.GUID = guidAddr.String()
+
+	result, err := parsePrevResult(args.StdinData)
+	if err != nil {
+		return err
+	}
+
+	if netConf.IPAM.Type != "" {
+		if err = ipam.ExecCheck(netConf.IPAM.Type, args.StdinData); err != nil {
+			return fmt.Errorf("IPAM plugin type %q check failed: %w", netConf.IPAM.Type, err)
+		}
+	}
+
+	netnsPath := targetNetns(netConf, args)
+	netns, err := ns.GetNS(netnsPath)
+	if err != nil {
+		return fmt.Errorf("failed to open netns %q: %w", netnsPath, err)
+	}
+	defer netns.Close()
+
+	if netConf.NetnsID != "" {
+		if err = plugin.VerifyNetns(netnsPath, netConf.NetnsID); err != nil {
+			return err
+		}
+	}
+
+	// the VF keeps its kernel name in the container when renaming is disabled
+	ifName := args.IfName
+	if netConf.ContIFNames != "" {
+		ifName = netConf.ContIFNames
+	}
+
+	err = netns.Do(func(_ ns.NetNS) error {
+		linkObj, err := netlink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to find interface %q in netns %q: %w", ifName, netnsPath, err)
+		}
+
+		// a GUID mismatch is never repaired, it implies the VF was reconfigured out of band
+		// IPoIB hardware address is 20 bytes, the last 8 bytes are the port GUID
+		hwAddr := linkObj.Attrs().HardwareAddr.String()
+		if len(hwAddr) < 36 {
+			return fmt.Errorf("interface %q has invalid InfiniBand hardware address %q", ifName, hwAddr)
+		}
+		if guid := hwAddr[36:]; !strings.EqualFold(guid, netConf.GUID) {
+			return fmt.Errorf("interface %q guid %q does not match configured guid %q", ifName, guid, netConf.GUID)
+		}
+
+		if linkObj.Attrs().Flags&net.FlagUp == 0 {
+			if !netConf.CheckRepair {
+				return fmt.Errorf("interface %q in netns %q is not up", ifName, netnsPath)
+			}
+			logging.Warningf("cmdCheck(): repairing interface %q in netns %q admin state: setting it up", ifName, netnsPath)
+			if err := netlink.LinkSetUp(linkObj); err != nil {
+				return fmt.Errorf("failed to set interface %q up: %w", ifName, err)
+			}
+		}
+
+		if mtu := linkObj.Attrs().MTU; netConf.MTU != 0 && mtu != netConf.MTU {
+			if !netConf.CheckRepair {
+				return fmt.Errorf("interface %q mtu %d does not match configured mtu %d", ifName, mtu, netConf.MTU)
+			}
+			logging.Warningf("cmdCheck(): repairing interface %q mtu: %d to %d", ifName, mtu, netConf.MTU)
+			if err := netlink.LinkSetMTU(linkObj, netConf.MTU); err != nil {
+				return fmt.Errorf("failed to set interface %q mtu to %d: %w", ifName, netConf.MTU, err)
+			}
+		}
+
+		if netConf.IPAM.Type != "" && result != nil {
+			if err := ip.ValidateExpectedInterfaceIPs(ifName, result.IPs); err != nil {
+				return err
+			}
+			if err := ip.ValidateExpectedRoute(result.Routes); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return checkVfLinkState(netConf)
+}
+
+// checkVfLinkState verifies the VF link state on the PF matches the configured link_state, a drifted link state is
+// reapplied when checkRepair is set. The link state of VFs of PFs in switchdev mode follows the representor.
+func checkVfLinkState(netConf *ibtypes.NetConf) error {
+	state, ok := sriov.LinkStateFromString(netConf.LinkState)
+	if !ok || netConf.Representor != "" {
+		return nil
+	}
+
+	pfLink, err := netlink.LinkByName(netConf.Master)
+	if err != nil {
+		return fmt.Errorf("failed to lookup master %q: %w", netConf.Master, err)
+	}
+	vfs := pfLink.Attrs().Vfs
+	if netConf.VFID >= len(vfs) || vfs[netConf.VFID].LinkState == state {
+		return nil
+	}
+
+	current := sriov.LinkStateToString(vfs[netConf.VFID].LinkState)
+	if !netConf.CheckRepair {
+		return fmt.Errorf("vf %d link state %s does not match configured link_state %s", netConf.VFID, current, netConf.LinkState)
+	}
+	logging.Warningf("cmdCheck(): repairing vf %d link state: %s to %s", netConf.VFID, current, netConf.LinkState)
+	if err := netlink.LinkSetVfState(pfLink, netConf.VFID, state); err != nil {
+		return fmt.Errorf("failed to set vf %d link state to %s: %w", netConf.VFID, netConf.LinkState, err)
+	}
 	return nil
 }
 
+// printVersion writes the plugin build metadata and supported CNI spec versions as JSON
+func printVersion(w io.Writer) error {
+	info := versionInfo{
+		Version:           version,
+		Commit:            commit,
+		Date:              date,
+		SupportedVersions: config.SupportedCNIVersions.SupportedVersions(),
+	}
+	return json.NewEncoder(w).Encode(info)
+}
+
 func main() {
-	skel.PluginMain(cmdAdd, cmdCheck, cmdDel, version.All, "")
+	printVer := flag.Bool("version", false, "print the plugin version and supported CNI spec versions and exit")
+	cleanup := flag.Bool("cleanup-cache", false, "remove the cached NetConfs of attachments whose netns is gone and exit")
+	cniDir := flag.String("cni-dir", config.DefaultCNIDir, "cache directory to clean up or to report the size of")
+	cleanupDryRun := flag.Bool("dry-run", false, "with -cleanup-cache, only list the stale cached NetConfs")
+	cleanupResetVF := flag.Bool("reset-vf", false, "with -cleanup-cache, also reset the VF config of the stale attachments")
+	runSelfTest := flag.Bool("self-test", false, "set up and tear down a VF in a scratch netns and exit")
+	selfTestPF := flag.String("pf", "", "with -self-test, PF of the VF")
+	selfTestVF := flag.Int("vf", 0, "with -self-test, index of the VF")
+	healthListen := flag.String("health-listen", "", "serve the node health as JSON on the address until terminated")
+	flag.Parse()
+	if *printVer {
+		if err := printVersion(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print version: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *cleanup {
+		if err := cleanupCache(os.Stdout, *cniDir, *cleanupDryRun, *cleanupResetVF); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to clean up cache: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *runSelfTest {
+		if err := selfTest(os.Stdout, *selfTestPF, *selfTestVF); err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	if *healthListen != "" {
+		ctx, stopSignals := signalContext(context.Background())
+		defer stopSignals()
+		if err := serveHealth(ctx, *healthListen, *cniDir); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to serve health: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	if os.Getenv("CNI_COMMAND") == debugCommand {
+		if err := printDebugInfo(os.Stdout); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to print debug info: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	skel.PluginMain(withCNIErrorCodes(cmdAdd), cmdCheck, cmdDel, config.SupportedCNIVersions, "")
 }
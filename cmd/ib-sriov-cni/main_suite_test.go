@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func check(e error) {
+	if e != nil {
+		panic(e)
+	}
+}
+
+func TestIbSriovCni(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "ib-sriov-cni Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// create test sys tree
+	err := utils.CreateTmpSysFs()
+	check(err)
+})
+
+var _ = AfterSuite(func() {
+	err := utils.RemoveTmpSysFs()
+	check(err)
+})
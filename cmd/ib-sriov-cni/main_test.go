@@ -0,0 +1,1615 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/plugin"
+	"github.com/Mellanox/ib-sriov-cni/pkg/trace"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types/mocks"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	"github.com/containernetworking/cni/pkg/skel"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	"github.com/containernetworking/plugins/pkg/utils/sysctl"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/vishvananda/netlink"
+)
+
+var _ = Describe("Commands", func() {
+	var (
+		targetNetNS     ns.NetNS
+		cacheDir        string
+		originalDir     string
+		originalLockDir string
+		mocked          *mocks.Manager
+		args            *skel.CmdArgs
+	)
+
+	BeforeEach(func() {
+		var err error
+		targetNetNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+
+		cacheDir, err = ioutil.TempDir("", "ib-sriov-cni-cache-")
+		Expect(err).NotTo(HaveOccurred())
+		originalDir = config.DefaultCNIDir
+		config.DefaultCNIDir = cacheDir
+		originalLockDir = config.DefaultLockDir
+		config.DefaultLockDir = filepath.Join(cacheDir, "locks")
+
+		mocked = &mocks.Manager{}
+		newSriovManager = func() types.Manager { return mocked }
+
+		args = &skel.CmdArgs{
+			ContainerID: "dummycid",
+			Netns:       targetNetNS.Path(),
+			IfName:      "net1",
+			StdinData: []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`),
+		}
+	})
+
+	AfterEach(func() {
+		config.DefaultCNIDir = originalDir
+		config.DefaultLockDir = originalLockDir
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		Expect(targetNetNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNetNS)).To(Succeed())
+	})
+
+	Context("Checking cmdAdd function", func() {
+		It("Assuming successful VF setup", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			err := cmdAdd(args)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+
+			_, err = os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming non-first member of a chain", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.4.0",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}},
+				"prevResult": {
+					"cniVersion": "0.4.0",
+					"interfaces": [{"name": "eth0", "sandbox": "/var/run/netns/pod"}],
+					"ips": [{"version": "4", "address": "10.55.206.2/24", "interface": 0}],
+					"dns": {"nameservers": ["10.55.206.1"]}
+				}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			// capture the printed result
+			out, err := ioutil.TempFile(cacheDir, "stdout")
+			Expect(err).NotTo(HaveOccurred())
+			stdout := os.Stdout
+			os.Stdout = out
+			err = cmdAdd(args)
+			os.Stdout = stdout
+			Expect(out.Close()).To(Succeed())
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := ioutil.ReadFile(out.Name())
+			Expect(err).NotTo(HaveOccurred())
+			result := &current.Result{}
+			Expect(json.Unmarshal(data, result)).To(Succeed())
+			Expect(result.Interfaces).To(HaveLen(2))
+			Expect(result.Interfaces[0].Name).To(Equal("eth0"))
+			Expect(result.Interfaces[1].Name).To(Equal(args.IfName))
+			Expect(result.Interfaces[1].Sandbox).To(Equal(targetNetNS.Path()))
+			Expect(result.IPs).To(HaveLen(1))
+			Expect(*result.IPs[0].Interface).To(Equal(0))
+			Expect(result.DNS.Nameservers).To(Equal([]string{"10.55.206.1"}))
+		})
+		It("Assuming invalid prevResult", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.4.0",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}},
+				"prevResult": {"cniVersion": "0.4.0", "ips": [{"version": "4", "address": "10.55.206.2"}]}
+			}`)
+
+			err := cmdAdd(args)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+		})
+		It("Assuming metrics are enabled", func() {
+			metricsPath := filepath.Join(cacheDir, "metrics.prom")
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"metricsPath": "` + metricsPath + `",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+
+			Expect(cmdAdd(args)).NotTo(Succeed())
+
+			data, err := ioutil.ReadFile(metricsPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`ib_sriov_cni_operation_failures_total{command="add",stage="apply"} 1`))
+		})
+		It("Assuming trace is enabled", func() {
+			traceDir := filepath.Join(cacheDir, "trace")
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"traceDir": "` + traceDir + `",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+
+			Expect(cmdAdd(args)).NotTo(Succeed())
+
+			data, err := ioutil.ReadFile(filepath.Join(traceDir, trace.FileName))
+			Expect(err).NotTo(HaveOccurred())
+			r := trace.Record{}
+			Expect(json.Unmarshal(data, &r)).To(Succeed())
+			Expect(r.Command).To(Equal("add"))
+			Expect(r.ContainerID).To(Equal("dummycid"))
+			Expect(r.Stage).To(Equal("apply"))
+			Expect(r.Error).To(ContainSubstring("mocked failed"))
+			Expect(string(r.Config)).To(ContainSubstring(`"deviceID":"0000:af:06.0"`))
+		})
+		It("Assuming guid allocated from the GUID pool", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidPool": {"rangeStart": "02:00:00:00:00:00:00:00", "rangeEnd": "02:00:00:00:00:00:00:00"}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "02:00:00:00:00:00:00:00" && conf.AllocatedGUID == conf.GUID
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(errors.New("mocked failed"))
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).NotTo(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+
+			_, err := os.Stat(filepath.Join(cacheDir, "guid-pool", "0200000000000000"))
+			Expect(os.IsNotExist(err)).To(BeTrue(), "guid should be released when the VF setup fails")
+		})
+		It("Assuming failed to cache the NetConf", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidPool": {"rangeStart": "02:00:00:00:00:00:00:00", "rangeEnd": "02:00:00:00:00:00:00:00"}
+			}`)
+			// the cached NetConf can't replace a non-empty directory
+			Expect(os.MkdirAll(filepath.Join(cacheDir, "dummycid-net1", "busy"), 0700)).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			err := cmdAdd(args)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("error saving NetConf"))
+			mocked.AssertExpectations(GinkgoT())
+
+			_, err = os.Stat(filepath.Join(cacheDir, "guid-pool", "0200000000000000"))
+			Expect(os.IsNotExist(err)).To(BeTrue(), "guid should be released when caching the NetConf fails")
+		})
+		It("Assuming repeated ADD with the fail cache conflict policy", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"onCacheConflict": "fail",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			// the attachment set up by the first ADD is left as is
+			err := cmdAdd(args)
+			Expect(errors.Is(err, os.ErrExist)).To(BeTrue())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ApplyVFConfig", 1)
+			mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			_, err = os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming repeated ADD overwriting the cached NetConf", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ApplyVFConfig", 2)
+		})
+		It("Assuming VF config fails", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+			Expect(cmdAdd(args)).NotTo(Succeed())
+
+			// the PF lock must be released on failure
+			unlock, err := utils.LockPF(config.DefaultLockDir, "ib0")
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+		})
+		It("Assuming InfiniBand is not configured", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			args.Args = "K8S_POD_NAMESPACE=default;K8S_POD_NAME=pod1"
+
+			err := cmdAdd(args)
+			Expect(errors.Is(err, config.ErrIBNotConfigured)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("pod default/pod1 container dummycid interface net1"))
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+
+			// ib-kubernetes configuring the Pod clears the condition, in-process retries don't help
+			Expect(plugin.IsRetriable(err)).To(BeTrue())
+			Expect(plugin.IsTransient(err)).To(BeFalse())
+
+			err = withCNIErrorCodes(cmdAdd)(args)
+			cniErr, ok := err.(*cnitypes.Error)
+			Expect(ok).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(uint(errCodeIBNotConfigured)))
+			Expect(cniErr.Msg).To(Equal(config.ErrIBNotConfigured.Error()))
+			Expect(cniErr.Details).To(ContainSubstring("pod default/pod1"))
+		})
+		It("Assuming InfiniBand configured value with whitespace and capitals", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": " Configured ", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming InfiniBand status check is skipped", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"skipIBStatusCheck": true,
+				"args": {"cni": {"guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:ef"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming InfiniBand status check is skipped without guid", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"skipIBStatusCheck": true
+			}`)
+
+			err := cmdAdd(args)
+			Expect(errors.Is(err, utils.ErrGUIDMissing)).To(BeTrue())
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+		})
+		It("Assuming InfiniBand annotation override", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"infiniBandAnnotation": "example.com/infiniband",
+				"infiniBandConfigured": "ready",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+
+			err := cmdAdd(args)
+			Expect(errors.Is(err, config.ErrIBNotConfigured)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(`"example.com/infiniband" is "" not "ready"`))
+
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"infiniBandAnnotation": "example.com/infiniband",
+				"infiniBandConfigured": "ready",
+				"args": {"cni": {"example.com/infiniband": "ready", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guids keyed by interface name", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "net0=01:23:45:67:89:ab:cd:ee,net1=01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:ef"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid from CNI_ARGS", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0"
+			}`)
+			args.Args = "IgnoreUnknown=1;mellanox.infiniband.app=configured;guid=01:23:45:67:89:ab:cd:ef"
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:ef"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid from both CNI_ARGS and network configuration args", func() {
+			args.Args = "guid=01:23:45:67:89:ab:cd:ee"
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:ef"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid from the network configuration", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guid": "01:23:45:67:89:ab:cd:f0"
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:f0"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid from both cni-args and the network configuration", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guid": "01:23:45:67:89:ab:cd:f0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:ef"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid from the guid source directory", func() {
+			guidDir := filepath.Join(cacheDir, "guids")
+			Expect(os.Mkdir(guidDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(guidDir, args.ContainerID), []byte("net1=01:23:45:67:89:AB:CD:F1\n"), 0600)).To(Succeed())
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidSource": "` + guidDir + `/",
+				"skipIBStatusCheck": true
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:f1"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid from both cni-args and the guid source directory", func() {
+			guidDir := filepath.Join(cacheDir, "guids")
+			Expect(os.Mkdir(guidDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(guidDir, args.ContainerID), []byte("01:23:45:67:89:ab:cd:f1"), 0600)).To(Succeed())
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidSource": "` + guidDir + `",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.GUID == "01:23:45:67:89:ab:cd:ef"
+			})).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming guid file not written yet", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidSource": "` + cacheDir + `",
+				"skipIBStatusCheck": true
+			}`)
+
+			err := cmdAdd(args)
+			Expect(errors.Is(err, utils.ErrGUIDMissing)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("guid file " + filepath.Join(cacheDir, args.ContainerID) + " does not exist"))
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+		})
+		It("Assuming less guids than interfaces", func() {
+			args.IfName = "net3"
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ee,01:23:45:67:89:ab:cd:ef"}}
+			}`)
+
+			err := cmdAdd(args)
+			Expect(errors.Is(err, utils.ErrGUIDMissing)).To(BeTrue())
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+		})
+		It("Assuming ips capability", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"runtimeConfig": {"ips": ["10.56.217.10/24"]},
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			// up link standing for the VF moved by the mocked SetupVF
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return addUpVeth(args.IfName, "peer1")
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				link, err := netlink.LinkByName(args.IfName)
+				if err != nil {
+					return err
+				}
+				addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+				if err != nil {
+					return err
+				}
+				Expect(addrs).To(HaveLen(1))
+				Expect(addrs[0].IPNet.String()).To(Equal("10.56.217.10/24"))
+				return nil
+			})).To(Succeed())
+		})
+		It("Assuming dual-stack ips capability", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"runtimeConfig": {"ips": ["10.56.217.10/24", "fd00:56:217::10/64"]},
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			// up link standing for the VF moved by the mocked SetupVF, with IPv6 disabled
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				if err := addUpVeth(args.IfName, "peer1"); err != nil {
+					return err
+				}
+				_, err := sysctl.Sysctl("net/ipv6/conf/"+args.IfName+"/disable_ipv6", "1")
+				return err
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				disabled, err := sysctl.Sysctl("net/ipv6/conf/" + args.IfName + "/disable_ipv6")
+				if err != nil {
+					return err
+				}
+				Expect(disabled).To(Equal("0"))
+
+				link, err := netlink.LinkByName(args.IfName)
+				if err != nil {
+					return err
+				}
+				addrs, err := netlink.AddrList(link, netlink.FAMILY_V6)
+				if err != nil {
+					return err
+				}
+				ips := []string{}
+				for _, addr := range addrs {
+					ips = append(ips, addr.IPNet.String())
+				}
+				Expect(ips).To(ContainElement("fd00:56:217::10/64"))
+				return nil
+			})).To(Succeed())
+		})
+		It("Assuming failed to configure interface IPs", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"runtimeConfig": {"ips": ["10.56.217.10/24"]},
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			// up link standing for the VF moved by the mocked SetupVF, already holding the address
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				if err := addUpVeth(args.IfName, "peer1"); err != nil {
+					return err
+				}
+				link, err := netlink.LinkByName(args.IfName)
+				if err != nil {
+					return err
+				}
+				addr, err := netlink.ParseAddr("10.56.217.10/24")
+				if err != nil {
+					return err
+				}
+				return netlink.AddrAdd(link, addr)
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).NotTo(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+
+			_, err := os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming operation timeout exceeded", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"operationTimeout": 50,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			// a wedged SetupVF only returns once the deadline is exceeded
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(
+				func(ctx context.Context, _ *types.NetConf, _, _ string, _ ns.NetNS) error {
+					<-ctx.Done()
+					return ctx.Err()
+				})
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			err := cmdAdd(args)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(context.DeadlineExceeded.Error()))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming transient failure retried", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"addRetryAttempts": 2,
+				"addRetryInterval": 1,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(syscall.EBUSY).Once()
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil).Once()
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ApplyVFConfig", 2)
+		})
+		It("Assuming transient failure not retried", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(syscall.EBUSY)
+
+			err := cmdAdd(args)
+			Expect(errors.Is(err, syscall.EBUSY)).To(BeTrue())
+			Expect(plugin.IsTransient(err)).To(BeTrue())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ApplyVFConfig", 1)
+
+			err = withCNIErrorCodes(cmdAdd)(args)
+			cniErr, ok := err.(*cnitypes.Error)
+			Expect(ok).To(BeTrue())
+			Expect(cniErr.Code).To(Equal(uint(errCodeRetriable)))
+		})
+		It("Assuming permanent failure with retries", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"addRetryAttempts": 2,
+				"addRetryInterval": 1,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+
+			err := cmdAdd(args)
+			Expect(err).To(HaveOccurred())
+			Expect(plugin.IsRetriable(err)).To(BeFalse())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ApplyVFConfig", 1)
+		})
+		It("Assuming failed to apply VF config", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+
+			err := cmdAdd(args)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "SetupVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+		DescribeTable("Assuming requested cniVersion",
+			func(version string, supported bool) {
+				args.StdinData = []byte(`{
+				"cniVersion": "` + version + `",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+				mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+				mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+				// capture the printed result
+				out, err := ioutil.TempFile(cacheDir, "stdout")
+				Expect(err).NotTo(HaveOccurred())
+				stdout := os.Stdout
+				os.Stdout = out
+				err = cmdAdd(args)
+				os.Stdout = stdout
+				Expect(out.Close()).To(Succeed())
+
+				if !supported {
+					Expect(err).To(HaveOccurred())
+					Expect(err.Error()).To(ContainSubstring("unsupported cniVersion"))
+					mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+					return
+				}
+				Expect(err).NotTo(HaveOccurred())
+				data, err := ioutil.ReadFile(out.Name())
+				Expect(err).NotTo(HaveOccurred())
+				result := map[string]interface{}{}
+				Expect(json.Unmarshal(data, &result)).To(Succeed())
+				Expect(result["cniVersion"]).To(Equal(version))
+			},
+			Entry("0.3.1", "0.3.1", true),
+			Entry("0.4.0", "0.4.0", true),
+			Entry("1.0.0", "1.0.0", false),
+		)
+	})
+	Context("Checking cmdDel function", func() {
+		It("Assuming cached NetConf", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			err := cmdDel(args)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+
+			_, err = os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming NetConf cached in the compact format", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"cacheFormat": "compact",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			data, err := ioutil.ReadFile(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(data).NotTo(HavePrefix("{"))
+
+			// DEL reads the cache whatever the format of the network configuration
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0"
+			}`)
+			mocked.On("ReleaseVF", mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.PodGUID == "01:23:45:67:89:ab:cd:ef"
+			}), args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+			Expect(cmdDel(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming teardown verification", func() {
+			metricsPath := filepath.Join(cacheDir, "metrics.prom")
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"verifyDel": true,
+				"metricsPath": "` + metricsPath + `",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+			mocked.On("VerifyVFReset", mock.Anything).Return(errors.New("guid is still the Pod guid"))
+
+			// a failed verification doesn't fail DEL
+			Expect(cmdDel(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+			_, err := os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			data, err := ioutil.ReadFile(metricsPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`ib_sriov_cni_teardown_verifications_total{result="failed"} 1`))
+		})
+		It("Assuming cache directory override", func() {
+			overrideDir := filepath.Join(cacheDir, "override")
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"cniDir": "` + overrideDir + `",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+			_, err := os.Stat(filepath.Join(overrideDir, "dummycid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			_, err = os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+			Expect(cmdDel(args)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+
+			_, err = os.Stat(filepath.Join(overrideDir, "dummycid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming netns override", func() {
+			overrideNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer func() {
+				Expect(overrideNetNS.Close()).To(Succeed())
+				Expect(testutils.UnmountNS(overrideNetNS)).To(Succeed())
+			}()
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"netnsOverride": "` + overrideNetNS.Path() + `",
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			inOverride := mock.MatchedBy(func(netns ns.NetNS) bool { return netns.Path() == overrideNetNS.Path() })
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, inOverride).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, inOverride).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+			// the override is used also when the runtime gives no netns
+			delArgs := *args
+			delArgs.Netns = ""
+			Expect(cmdDel(&delArgs)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming failed to release VF", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(errors.New("mocked failed"))
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			err := cmdDel(args)
+			Expect(err).NotTo(HaveOccurred(), "VF is reclaimed by the force cleanup")
+			mocked.AssertCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+
+			_, err = os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming failed to release VF and force cleanup", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(errors.New("mocked failed"))
+			mocked.On("ResetVFConfig", mock.Anything).Return(errors.New("mocked failed"))
+
+			err := cmdDel(args)
+			Expect(err).To(HaveOccurred())
+
+			_, err = os.Stat(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(err).NotTo(HaveOccurred(), "cache is kept for the DEL retry")
+		})
+		It("Assuming netns is gone", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+			delArgs := *args
+			delArgs.Netns = "/var/run/netns/not-existing-netns"
+			Expect(cmdDel(&delArgs)).To(Succeed())
+			mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			mocked.AssertCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+		})
+		It("Assuming double DEL", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			Expect(cmdDel(args)).To(Succeed())
+			Expect(cmdDel(args)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ReleaseVF", 1)
+			mocked.AssertNumberOfCalls(GinkgoT(), "ResetVFConfig", 1)
+		})
+		It("Assuming recycled netns", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			// replace the cached netns identifier as if the netns path was recycled after ADD
+			cRefPath := filepath.Join(cacheDir, "dummycid-net1")
+			data, err := ioutil.ReadFile(cRefPath)
+			Expect(err).NotTo(HaveOccurred())
+			conf := map[string]interface{}{}
+			Expect(json.Unmarshal(data, &conf)).To(Succeed())
+			Expect(conf["NetnsID"]).NotTo(BeEmpty())
+			conf["NetnsID"] = "0:0"
+			data, err = json.Marshal(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(cRefPath, data, 0600)).To(Succeed())
+
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+			Expect(cmdDel(args)).To(Succeed())
+			mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			mocked.AssertCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+		})
+		Context("Assuming no cached NetConf", func() {
+			var originalLinkBusInfo func(string) (string, error)
+
+			BeforeEach(func() {
+				originalLinkBusInfo = linkBusInfo
+				linkBusInfo = func(name string) (string, error) {
+					if name == args.IfName {
+						return "0000:af:06.1", nil
+					}
+					return "", nil
+				}
+				// network configuration without a deviceID, ADD would pick a free VF of the PF
+				args.StdinData = []byte(`{
+					"cniVersion": "0.3.1",
+					"name": "mynet",
+					"type": "ib-sriov-cni",
+					"master": "ib0"
+				}`)
+			})
+
+			AfterEach(func() {
+				linkBusInfo = originalLinkBusInfo
+			})
+
+			It("Assuming VF in the netns", func() {
+				Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+					return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: args.IfName}, PeerName: "peer1"})
+				})).To(Succeed())
+				mocked.On("ReleaseVF", mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+				mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+				Expect(cmdDel(args)).To(Succeed())
+				mocked.AssertCalled(GinkgoT(), "ReleaseVF", mock.MatchedBy(func(conf *types.NetConf) bool {
+					return conf.DeviceID == "0000:af:06.1" && conf.Master == "ib0"
+				}), args.IfName, args.ContainerID, mock.Anything)
+				mocked.AssertCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+			})
+			It("Assuming another VF than the deviceID in the netns", func() {
+				args.StdinData = []byte(`{
+					"cniVersion": "0.3.1",
+					"name": "mynet",
+					"type": "ib-sriov-cni",
+					"deviceID": "0000:af:06.0"
+				}`)
+				Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+					return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: args.IfName}, PeerName: "peer1"})
+				})).To(Succeed())
+
+				Expect(cmdDel(args)).To(Succeed())
+				mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				mocked.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+			})
+			It("Assuming no VF in the netns", func() {
+				Expect(cmdDel(args)).To(Succeed())
+				mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+				mocked.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+				_, err := os.Stat(filepath.Join(cacheDir, "vf-claims"))
+				Expect(os.IsNotExist(err)).To(BeTrue(), "DEL should not claim a free VF")
+			})
+		})
+		It("Assuming corrupted cached NetConf", func() {
+			cRefPath := filepath.Join(cacheDir, "dummycid-net1")
+			Expect(ioutil.WriteFile(cRefPath, []byte(`{"Master": "ib`), 0600)).To(Succeed())
+
+			err := cmdDel(args)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+	Context("Checking cancelOnSignal function", func() {
+		It("Assuming SIGTERM during setup", func() {
+			sigs := make(chan os.Signal, 1)
+			ctx, cancel := cancelOnSignal(context.Background(), sigs)
+			defer cancel()
+			Expect(ctx.Err()).NotTo(HaveOccurred())
+			sigs <- syscall.SIGTERM
+			Eventually(ctx.Done()).Should(BeClosed())
+		})
+		It("Assuming SIGTERM while configuring the VF", func() {
+			sigs := make(chan os.Signal, 1)
+			ctx, cancel := cancelOnSignal(context.Background(), sigs)
+			defer cancel()
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+				args.Get(1).(*types.NetConf).HostVFConfig = &types.VFConfig{}
+				sigs <- syscall.SIGTERM
+				<-ctx.Done()
+			}).Return(context.Canceled)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			netConf, err := config.LoadConf(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			netConf.GUID = "01:23:45:67:89:ab:cd:ef"
+			p := plugin.NewPlugin(mocked, nil)
+			p.LockDir = config.DefaultLockDir
+			_, err = p.Setup(ctx, netConf, args.IfName, args.ContainerID, targetNetNS)
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			mocked.AssertExpectations(GinkgoT())
+		})
+	})
+	Context("Checking chainResult function", func() {
+		var result *current.Result
+
+		BeforeEach(func() {
+			result = &current.Result{
+				Interfaces: []*current.Interface{{Name: "net1", Mac: "00:00:01:07", Sandbox: "/var/run/netns/pod"}},
+				IPs: []*current.IPConfig{{
+					Version:   "4",
+					Address:   net.IPNet{IP: net.ParseIP("10.56.217.10"), Mask: net.CIDRMask(24, 32)},
+					Interface: current.Int(0),
+				}},
+			}
+		})
+
+		It("Assuming previous interfaces precede the VF interface", func() {
+			prevResult := &current.Result{
+				Interfaces: []*current.Interface{
+					{Name: "eth0", Sandbox: "/var/run/netns/pod"},
+					{Name: "veth1234"},
+				},
+				IPs: []*current.IPConfig{{
+					Version:   "4",
+					Address:   net.IPNet{IP: net.ParseIP("10.55.206.2"), Mask: net.CIDRMask(24, 32)},
+					Interface: current.Int(0),
+				}},
+			}
+			chained := chainResult(prevResult, result)
+			Expect(chained.Interfaces).To(HaveLen(3))
+			Expect(chained.Interfaces[2].Name).To(Equal("net1"))
+			Expect(chained.IPs).To(HaveLen(2))
+			Expect(*chained.IPs[0].Interface).To(Equal(0))
+			Expect(*chained.IPs[1].Interface).To(Equal(2))
+		})
+		It("Assuming previous result reports the VF interface", func() {
+			prevResult := &current.Result{
+				Interfaces: []*current.Interface{
+					{Name: "net1", Sandbox: "/var/run/netns/pod"},
+					{Name: "eth0", Sandbox: "/var/run/netns/pod"},
+				},
+				IPs: []*current.IPConfig{
+					{
+						Version:   "4",
+						Address:   net.IPNet{IP: net.ParseIP("10.56.217.2"), Mask: net.CIDRMask(24, 32)},
+						Interface: current.Int(0),
+					},
+					{
+						Version:   "4",
+						Address:   net.IPNet{IP: net.ParseIP("10.55.206.2"), Mask: net.CIDRMask(24, 32)},
+						Interface: current.Int(1),
+					},
+				},
+			}
+			chained := chainResult(prevResult, result)
+			Expect(chained.Interfaces).To(HaveLen(2))
+			Expect(chained.Interfaces[0].Mac).To(Equal("00:00:01:07"))
+			// the previous IP of the replaced interface is dropped
+			Expect(chained.IPs).To(HaveLen(2))
+			Expect(chained.IPs[0].Address.IP.String()).To(Equal("10.55.206.2"))
+			Expect(*chained.IPs[0].Interface).To(Equal(1))
+			Expect(chained.IPs[1].Address.IP.String()).To(Equal("10.56.217.10"))
+			Expect(*chained.IPs[1].Interface).To(Equal(0))
+		})
+		It("Assuming VF IP of an out of range interface", func() {
+			result.IPs[0].Interface = current.Int(3)
+			chained := chainResult(&current.Result{Interfaces: []*current.Interface{{Name: "eth0"}}}, result)
+			Expect(chained.IPs).To(HaveLen(1))
+			Expect(chained.IPs[0].Interface).To(BeNil())
+		})
+	})
+	Context("Checking printVersion function", func() {
+		It("Assuming default build metadata", func() {
+			buf := &bytes.Buffer{}
+			Expect(printVersion(buf)).To(Succeed())
+
+			info := versionInfo{}
+			Expect(json.Unmarshal(buf.Bytes(), &info)).To(Succeed())
+			Expect(info.Version).To(Equal(version))
+			Expect(info.Commit).To(Equal(commit))
+			Expect(info.Date).To(Equal(date))
+			Expect(info.SupportedVersions).To(ContainElement("0.4.0"))
+		})
+	})
+	Context("Checking printResult function", func() {
+		var result *current.Result
+
+		BeforeEach(func() {
+			result = &current.Result{
+				CNIVersion: current.ImplementedSpecVersion,
+				Interfaces: []*current.Interface{{Name: "net1", Sandbox: "/var/run/netns/test"}},
+			}
+		})
+
+		It("Assuming no result file", func() {
+			buf := &bytes.Buffer{}
+			Expect(printResult(buf, result, "0.3.1")).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`"cniVersion": "0.3.1"`))
+		})
+		It("Assuming result file", func() {
+			dir, err := ioutil.TempDir("", "ib-sriov-cni-result-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			path := filepath.Join(dir, "result.json")
+			Expect(os.Setenv(resultFileEnv, path)).To(Succeed())
+			defer os.Unsetenv(resultFileEnv)
+
+			buf := &bytes.Buffer{}
+			Expect(printResult(buf, result, "0.3.1")).To(Succeed())
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(buf.String()))
+		})
+		It("Assuming result file can't be written", func() {
+			Expect(os.Setenv(resultFileEnv, "/nonexistent/result.json")).To(Succeed())
+			defer os.Unsetenv(resultFileEnv)
+
+			buf := &bytes.Buffer{}
+			Expect(printResult(buf, result, "0.3.1")).To(Succeed())
+			Expect(buf.String()).To(ContainSubstring(`"net1"`))
+		})
+	})
+	Context("Checking collectDebugInfo function", func() {
+		It("Assuming cached NetConf", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			info := collectDebugInfo(args)
+			Expect(info.CachedConf).NotTo(BeNil())
+			Expect(info.PF).To(Equal("ib0"))
+			Expect(info.PciAddress).To(Equal("0000:af:06.0"))
+			Expect(info.Errors).To(HaveKey("link"), "the VF is not in the test netns")
+		})
+		It("Assuming missing cached NetConf", func() {
+			info := collectDebugInfo(args)
+			Expect(info.CachedConf).To(BeNil())
+			Expect(info.Errors).To(HaveKey("cache"))
+		})
+	})
+	Context("Checking cmdCheck function", func() {
+		It("Assuming guid mismatch with checkRepair", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"mtu": 2044,
+				"checkRepair": true,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:ab:cd:ef"}}
+			}`)
+			// link standing for the VF, its hardware address doesn't carry the configured guid
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: args.IfName, MTU: 1500}})
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			Expect(cmdCheck(args)).NotTo(Succeed())
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				link, err := netlink.LinkByName(args.IfName)
+				if err != nil {
+					return err
+				}
+				Expect(link.Attrs().MTU).To(Equal(1500), "nothing is repaired on a guid mismatch")
+				return nil
+			})).To(Succeed())
+		})
+		It("Assuming guid from the guid source directory", func() {
+			guidDir := filepath.Join(cacheDir, "guids")
+			Expect(os.Mkdir(guidDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(guidDir, args.ContainerID), []byte("01:23:45:67:89:ab:cd:f1"), 0600)).To(Succeed())
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidSource": "` + guidDir + `",
+				"skipIBStatusCheck": true
+			}`)
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: args.IfName}})
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+			Expect(cmdAdd(args)).To(Succeed())
+
+			// the guid file is gone once the Pod is running, the cached guid of the Pod is checked
+			Expect(os.RemoveAll(guidDir)).To(Succeed())
+			err := cmdCheck(args)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).NotTo(ContainSubstring("invalid cached guid"))
+			Expect(err.Error()).To(ContainSubstring("invalid InfiniBand hardware address"))
+		})
+	})
+	Context("Checking planAdd function", func() {
+		It("Assuming valid configuration and VF", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"mtu": 4092,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:AB:CD:EF"}}
+			}`)
+			netConf, err := config.LoadConf(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.On("ValidateVF", netConf).Return(nil)
+
+			plan, err := planAdd(mocked, netConf, args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.DryRun).To(BeTrue())
+			Expect(plan.PF).To(Equal("ib0"))
+			Expect(plan.GUID).To(Equal("01:23:45:67:89:ab:cd:ef"))
+			Expect(plan.GUIDSource).To(Equal(guidSourceArgs))
+			Expect(plan.Actions).To(ContainElement("set net1 mtu to 4092"))
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+		})
+		It("Assuming guid from the GUID pool", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"guidPool": {"rangeStart": "02:00:00:00:00:00:00:00", "rangeEnd": "02:00:00:00:00:00:00:00"}
+			}`)
+			netConf, err := config.LoadConf(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.On("ValidateVF", netConf).Return(nil)
+
+			plan, err := planAdd(mocked, netConf, args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(plan.GUIDSource).To(Equal(guidSourcePool))
+			_, err = os.Stat(netConf.GUIDPool.DataDir)
+			Expect(os.IsNotExist(err)).To(BeTrue(), "dry-run should not allocate a guid")
+		})
+		It("Assuming a free VF picked in dry-run", func() {
+			claims := filepath.Join(cacheDir, "vf-claims")
+			Expect(os.MkdirAll(claims, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(claims, "0000:af:06.0"), nil, 0600)).To(Succeed())
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"master": "ib0",
+				"dryRun": true,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "01:23:45:67:89:AB:CD:EF"}}
+			}`)
+			mocked.On("ValidateVF", mock.Anything).Return(nil)
+
+			out := &bytes.Buffer{}
+			Expect(dryRunAdd(out, args)).To(Succeed())
+			plan := &dryRunPlan{}
+			Expect(json.Unmarshal(out.Bytes(), plan)).To(Succeed())
+			Expect(plan.PciAddress).To(Equal("0000:af:06.1"))
+			entries, err := ioutil.ReadDir(claims)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(entries).To(HaveLen(1), "dry-run should neither claim the VF nor release the claims")
+			Expect(entries[0].Name()).To(Equal("0000:af:06.0"))
+		})
+		It("Assuming invalid PF state", func() {
+			netConf, err := config.LoadConf(args.StdinData)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.On("ValidateVF", netConf).Return(errors.New("mocked failed"))
+
+			_, err = planAdd(mocked, netConf, args)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking moveVFToNS function", func() {
+		var (
+			hostNS              ns.NetNS
+			originalLinkBusInfo func(string) (string, error)
+		)
+
+		BeforeEach(func() {
+			var err error
+			hostNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			originalLinkBusInfo = linkBusInfo
+			linkBusInfo = func(name string) (string, error) {
+				if name == args.IfName {
+					return "0000:af:06.0", nil
+				}
+				return "", nil
+			}
+			// link standing for the VF left in the container netns
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: args.IfName}, PeerName: "peer1"})
+			})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			linkBusInfo = originalLinkBusInfo
+			Expect(hostNS.Close()).To(Succeed())
+			Expect(testutils.UnmountNS(hostNS)).To(Succeed())
+		})
+
+		It("Assuming VF left in a container netns", func() {
+			Expect(moveVFToNS("0000:af:06.0", []string{"/var/run/netns/not-existing-netns", targetNetNS.Path()}, nil, hostNS)).To(Succeed())
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				_, err := netlink.LinkByName(args.IfName)
+				Expect(err).To(HaveOccurred())
+				return nil
+			})).To(Succeed())
+			Expect(hostNS.Do(func(_ ns.NetNS) error {
+				links, err := netlink.LinkList()
+				if err != nil {
+					return err
+				}
+				names := []string{}
+				for _, link := range links {
+					names = append(names, link.Attrs().Name)
+				}
+				Expect(names).To(ContainElement(HavePrefix("vfdev")))
+				return nil
+			})).To(Succeed())
+		})
+		It("Assuming VF not in any netns", func() {
+			Expect(moveVFToNS("0000:af:06.1", []string{targetNetNS.Path()}, nil, hostNS)).NotTo(Succeed())
+		})
+		It("Assuming VF in the netns of another attachment", func() {
+			netnsID, err := utils.GetNetnsIDFromFd(targetNetNS.Fd())
+			Expect(err).NotTo(HaveOccurred())
+			cached, err := json.Marshal(&types.NetConf{ContainerID: "othercid", DeviceID: "0000:af:06.0", NetnsID: netnsID})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "othercid-net1"), cached, 0600)).To(Succeed())
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "af:06.0"
+			}`)
+			// only the netns of the command is searched, the netns of other tests are left alone
+			originalNetnsDirs := netnsDirs
+			netnsDirs = nil
+			defer func() { netnsDirs = originalNetnsDirs }()
+
+			Expect(recoverVF(args)).NotTo(Succeed())
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				_, err := netlink.LinkByName(args.IfName)
+				return err
+			})).To(Succeed(), "the VF of another attachment should be left in its netns")
+		})
+	})
+	Context("Checking selfTest function", func() {
+		It("Assuming failed to set up VF", func() {
+			mocked.On("ValidateVF", mock.Anything).Return(nil)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, selfTestIfName, selfTestContainerID, mock.Anything).Return(errors.New("mocked failed"))
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			out := &bytes.Buffer{}
+			Expect(selfTest(out, "ib0", 0)).NotTo(Succeed())
+			Expect(out.String()).To(ContainSubstring("self-test FAILED"))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming VF interface not found after setup", func() {
+			mocked.On("ValidateVF", mock.Anything).Return(nil)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, selfTestIfName, selfTestContainerID, mock.Anything).Return(nil)
+			mocked.On("ReleaseVF", mock.Anything, selfTestIfName, selfTestContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil)
+
+			out := &bytes.Buffer{}
+			err := selfTest(out, "ib0", 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("failed to find interface"))
+			Expect(out.String()).To(ContainSubstring("self-test FAILED"))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming failed to tear down VF", func() {
+			mocked.On("ValidateVF", mock.Anything).Return(nil)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, selfTestIfName, selfTestContainerID, mock.Anything).Return(nil)
+			mocked.On("ReleaseVF", mock.Anything, selfTestIfName, selfTestContainerID, mock.Anything).Return(nil)
+			mocked.On("ResetVFConfig", mock.Anything).Return(errors.New("mocked failed")).Once()
+			mocked.On("ResetVFConfig", mock.Anything).Return(nil).Once()
+
+			out := &bytes.Buffer{}
+			Expect(selfTest(out, "ib0", 0)).NotTo(Succeed())
+			Expect(out.String()).NotTo(ContainSubstring("tore down VF"))
+			// the GUID is reset again once the teardown failed
+			mocked.AssertNumberOfCalls(GinkgoT(), "ResetVFConfig", 2)
+		})
+		It("Assuming VF index out of range", func() {
+			out := &bytes.Buffer{}
+			Expect(selfTest(out, "ib0", 5)).NotTo(Succeed())
+			mocked.AssertNotCalled(GinkgoT(), "SetupVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+	})
+	Context("Checking cleanupCache function", func() {
+		var liveID string
+
+		BeforeEach(func() {
+			var err error
+			liveID, err = utils.GetNetnsID(targetNetNS.Path())
+			Expect(err).NotTo(HaveOccurred())
+			for name, data := range map[string]string{
+				"live-net1":    `{"Master":"ib0","deviceID":"0000:af:06.0","NetnsID":"` + liveID + `"}`,
+				"stale-net1":   `{"Master":"ib0","deviceID":"0000:af:06.1","NetnsID":"0:1"}`,
+				"unknown-net1": `{"Master":"ib0","deviceID":"0000:af:07.0"}`,
+				"invalid-net1": `{"Master":"ib0","devi`,
+			} {
+				Expect(ioutil.WriteFile(filepath.Join(cacheDir, name), []byte(data), 0600)).To(Succeed())
+			}
+		})
+
+		It("Assuming dry-run", func() {
+			out := &bytes.Buffer{}
+			Expect(cleanupCache(out, cacheDir, true, true)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("stale " + filepath.Join(cacheDir, "stale-net1")))
+			Expect(out.String()).To(ContainSubstring("stale " + filepath.Join(cacheDir, "invalid-net1")))
+			Expect(out.String()).NotTo(ContainSubstring("removed"))
+			for _, name := range []string{"live-net1", "stale-net1", "unknown-net1", "invalid-net1"} {
+				_, err := os.Stat(filepath.Join(cacheDir, name))
+				Expect(err).NotTo(HaveOccurred())
+			}
+			mocked.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+		})
+		It("Assuming stale entries with VF reset", func() {
+			mocked.On("ResetVFConfig", mock.MatchedBy(func(conf *types.NetConf) bool {
+				return conf.DeviceID == "0000:af:06.1"
+			})).Return(nil)
+
+			out := &bytes.Buffer{}
+			Expect(cleanupCache(out, cacheDir, false, true)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ResetVFConfig", 1)
+			for _, name := range []string{"stale-net1", "invalid-net1"} {
+				_, err := os.Stat(filepath.Join(cacheDir, name))
+				Expect(os.IsNotExist(err)).To(BeTrue())
+			}
+			for _, name := range []string{"live-net1", "unknown-net1"} {
+				_, err := os.Stat(filepath.Join(cacheDir, name))
+				Expect(err).NotTo(HaveOccurred())
+			}
+		})
+		It("Assuming stale entry of a VF in use", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "stale-net1"),
+				[]byte(`{"Master":"ib0","deviceID":"0000:af:06.0","NetnsID":"0:1"}`), 0600)).To(Succeed())
+
+			out := &bytes.Buffer{}
+			Expect(cleanupCache(out, cacheDir, false, true)).To(Succeed())
+			Expect(out.String()).To(ContainSubstring("skipping VF 0000:af:06.0 reset"))
+			mocked.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+			_, err := os.Stat(filepath.Join(cacheDir, "stale-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
+
+	Context("Checking healthHandler function", func() {
+		It("Assuming cached attachment", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net1"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+
+			rec := httptest.NewRecorder()
+			healthHandler(cacheDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, healthPath, nil))
+			Expect(rec.Code).To(Equal(http.StatusOK))
+			Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+			health := nodeHealth{}
+			Expect(json.Unmarshal(rec.Body.Bytes(), &health)).To(Succeed())
+			Expect(health.CachedNetConfs).To(Equal(1))
+			Expect(health.PFs).To(Equal([]pfHealth{{
+				Name:       "ib0",
+				PciAddress: "0000:af:00.1",
+				TotalVFs:   8,
+				PFUsage:    config.PFUsage{NumVFs: 2, InUse: 1, Free: 1},
+			}}))
+		})
+		It("Assuming unknown path", func() {
+			rec := httptest.NewRecorder()
+			healthHandler(cacheDir).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+			Expect(rec.Code).To(Equal(http.StatusNotFound))
+		})
+		It("Assuming serving until canceled", func() {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			Expect(err).NotTo(HaveOccurred())
+			ctx, cancel := context.WithCancel(context.Background())
+			done := make(chan error, 1)
+			go func() {
+				done <- serveHealthOn(ctx, l, cacheDir)
+			}()
+
+			resp, err := http.Get("http://" + l.Addr().String() + healthPath)
+			Expect(err).NotTo(HaveOccurred())
+			resp.Body.Close()
+			Expect(resp.StatusCode).To(Equal(http.StatusOK))
+
+			cancel()
+			Eventually(done).Should(Receive(BeNil()))
+		})
+	})
+
+	Context("Checking checkGUIDUnique function", func() {
+		var netConf *types.NetConf
+
+		BeforeEach(func() {
+			liveID, err := utils.GetNetnsID(targetNetNS.Path())
+			Expect(err).NotTo(HaveOccurred())
+			for name, data := range map[string]string{
+				"livecid-net1":  `{"deviceID":"0000:af:06.1","NetnsID":"` + liveID + `","ContainerID":"livecid","PodGUID":"02:00:00:00:00:00:00:01"}`,
+				"stalecid-net1": `{"deviceID":"0000:af:06.1","NetnsID":"0:1","ContainerID":"stalecid","PodGUID":"02:00:00:00:00:00:00:02"}`,
+				"dummycid-net1": `{"deviceID":"0000:af:06.0","NetnsID":"0:1","ContainerID":"dummycid","PodGUID":"02:00:00:00:00:00:00:03"}`,
+			} {
+				Expect(ioutil.WriteFile(filepath.Join(cacheDir, name), []byte(data), 0600)).To(Succeed())
+			}
+			netConf = &types.NetConf{CNIDir: cacheDir}
+		})
+
+		It("Assuming guid used by a live attachment", func() {
+			netConf.GUID = "02:00:00:00:00:00:00:01"
+			err := checkGUIDUnique(netConf, args)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("guid 02:00:00:00:00:00:00:01 is already used by container livecid"))
+		})
+		It("Assuming guid used by a stale attachment", func() {
+			netConf.GUID = "02:00:00:00:00:00:00:02"
+			Expect(checkGUIDUnique(netConf, args)).To(Succeed())
+		})
+		It("Assuming guid of a repeated ADD", func() {
+			netConf.GUID = "02:00:00:00:00:00:00:03"
+			Expect(checkGUIDUnique(netConf, args)).To(Succeed())
+		})
+		It("Assuming cmdAdd of a guid in use", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"enforceGUIDUniqueness": true,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "02:00:00:00:00:00:00:01"}}
+			}`)
+
+			err := cmdAdd(args)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("livecid"))
+			mocked.AssertNotCalled(GinkgoT(), "ApplyVFConfig", mock.Anything, mock.Anything)
+		})
+		It("Assuming cmdAdd caches the guid", func() {
+			args.StdinData = []byte(`{
+				"cniVersion": "0.3.1",
+				"name": "mynet",
+				"type": "ib-sriov-cni",
+				"deviceID": "0000:af:06.0",
+				"enforceGUIDUniqueness": true,
+				"args": {"cni": {"mellanox.infiniband.app": "configured", "guid": "02:00:00:00:00:00:00:04"}}
+			}`)
+			mocked.On("ApplyVFConfig", mock.Anything, mock.Anything).Return(nil)
+			mocked.On("SetupVF", mock.Anything, mock.Anything, args.IfName, args.ContainerID, mock.Anything).Return(nil)
+
+			Expect(cmdAdd(args)).To(Succeed())
+			data, err := ioutil.ReadFile(filepath.Join(cacheDir, "dummycid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring(`"ContainerID":"dummycid","PodGUID":"02:00:00:00:00:00:00:04"`))
+		})
+	})
+})
+
+// addUpVeth creates a veth pair with both ends up, it stands for a VF set up in the current netns
+func addUpVeth(name, peer string) error {
+	if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer}); err != nil {
+		return err
+	}
+	for _, n := range []string{name, peer} {
+		link, err := netlink.LinkByName(n)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
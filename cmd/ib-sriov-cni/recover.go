@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/safchain/ethtool"
+	"github.com/vishvananda/netlink"
+)
+
+// netnsDirs are searched for a VF left in a container netns, in addition to the netns of the command
+var netnsDirs = []string{"/var/run/netns", "/run/netns"}
+
+// linkBusInfo returns the bus info of a network device in the current netns, replaced in tests
+var linkBusInfo = ethtool.BusInfo
+
+// recoverVF moves the VF of the network configuration back to the host when a previous invocation left it in a
+// container netns. The netns of the command is searched first, then the netns in netnsDirs. The netns of the cached
+// attachments of other containers are not searched, the VF found there is in use.
+func recoverVF(args *skel.CmdArgs) error {
+	deviceID := config.DeviceID(args.StdinData)
+	if deviceID == "" {
+		return fmt.Errorf("failed to recover VF: no deviceID in netconf")
+	}
+
+	hostNS, err := ns.GetCurrentNS()
+	if err != nil {
+		return fmt.Errorf("failed to recover VF %s: %w", deviceID, err)
+	}
+	defer hostNS.Close()
+
+	candidates := []string{args.Netns}
+	for _, dir := range netnsDirs {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			candidates = append(candidates, filepath.Join(dir, e.Name()))
+		}
+	}
+
+	cached, err := config.ListCachedNetConfs(config.CacheDir(args.StdinData))
+	if err != nil {
+		return fmt.Errorf("failed to recover VF %s: %w", deviceID, err)
+	}
+	attached := map[string]bool{}
+	for _, c := range cached {
+		if c.NetConf != nil && c.NetConf.NetnsID != "" && c.NetConf.ContainerID != args.ContainerID {
+			attached[c.NetConf.NetnsID] = true
+		}
+	}
+
+	logging.Warningf("recoverVF(): VF %s is not on the host, searching %d netns for it", deviceID, len(candidates))
+	return moveVFToNS(deviceID, candidates, attached, hostNS)
+}
+
+// moveVFToNS searches the netns paths for the network device of the VF and moves it to the target netns, the netns
+// of the identifiers in skip are not searched
+func moveVFToNS(pciAddr string, netnsPaths []string, skip map[string]bool, target ns.NetNS) error {
+	seen := map[string]bool{}
+	for _, path := range netnsPaths {
+		if path == "" || seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		netns, err := ns.GetNS(path)
+		if err != nil {
+			continue
+		}
+		if id, err := utils.GetNetnsIDFromFd(netns.Fd()); err != nil || skip[id] {
+			netns.Close()
+			continue
+		}
+		found := false
+		err = netns.Do(func(_ ns.NetNS) error {
+			links, err := netlink.LinkList()
+			if err != nil {
+				return err
+			}
+			for _, link := range links {
+				if busInfo, err := linkBusInfo(link.Attrs().Name); err != nil || busInfo != pciAddr {
+					continue
+				}
+				found = true
+				name := link.Attrs().Name
+				// rename to avoid a name conflict on the target netns, the VF is renamed again on setup
+				tempName := fmt.Sprintf("vfdev%d", link.Attrs().Index)
+				logging.Warningf("recoverVF(): found VF %s as %s in netns %s, moving it back as %s", pciAddr, name, path, tempName)
+				if err := netlink.LinkSetDown(link); err != nil {
+					return fmt.Errorf("failed to set %s down: %w", name, err)
+				}
+				if err := netlink.LinkSetName(link, tempName); err != nil {
+					return fmt.Errorf("failed to rename %s to %s: %w", name, tempName, err)
+				}
+				return netlink.LinkSetNsFd(link, int(target.Fd()))
+			}
+			return nil
+		})
+		netns.Close()
+		if err != nil {
+			return fmt.Errorf("failed to recover VF %s from netns %s: %w", pciAddr, path, err)
+		}
+		if found {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("failed to recover VF %s: not found in any netns", pciAddr)
+}
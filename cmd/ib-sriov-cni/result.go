@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/containernetworking/cni/pkg/types"
+)
+
+// resultFileEnv names a file the ADD result is also written to, e.g. to capture the results of node validation
+// runs. The result is printed to stdout regardless.
+const resultFileEnv = "CNI_RESULT_FILE"
+
+// printResult prints the result in the requested CNI version to w, and to the file of CNI_RESULT_FILE when set.
+// Failing to write the file is logged and doesn't fail the command.
+func printResult(w io.Writer, result types.Result, cniVersion string) error {
+	versioned, err := result.GetAsVersion(cniVersion)
+	if err != nil {
+		return err
+	}
+
+	if path := os.Getenv(resultFileEnv); path != "" {
+		if err := writeResultFile(path, versioned); err != nil {
+			logging.Warningf("printResult(): %v", err)
+		}
+	}
+	return versioned.PrintTo(w)
+}
+
+func writeResultFile(path string, result types.Result) error {
+	var buf bytes.Buffer
+	if err := result.PrintTo(&buf); err != nil {
+		return fmt.Errorf("failed to encode result for %s %s: %w", resultFileEnv, path, err)
+	}
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("failed to write result to %s %s: %w", resultFileEnv, path, err)
+	}
+	return nil
+}
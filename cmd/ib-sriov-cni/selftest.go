@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net"
+	"runtime"
+	"strings"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/plugin"
+	ibtypes "github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+const (
+	// selfTestIfName is the name of the VF in the scratch netns
+	selfTestIfName = "selftest0"
+	// selfTestContainerID identifies the self-test attachment in the logs
+	selfTestContainerID = "ib-sriov-cni-self-test"
+)
+
+// selfTest sets up the VF of the PF in a scratch netns with a generated GUID, verifies the VF interface in the netns
+// and tears it down, reporting each step and the outcome to w. Everything set up is torn down also on failure, a VF
+// left in the scratch netns is returned to the host when the netns is destroyed and the VF GUID is reset also when the
+// teardown fails.
+func selfTest(w io.Writer, pfName string, vfID int) (retErr error) {
+	defer func() {
+		if retErr != nil {
+			fmt.Fprintf(w, "self-test FAILED: %v\n", retErr)
+		} else {
+			fmt.Fprintln(w, "self-test PASSED")
+		}
+	}()
+
+	pciAddr, err := utils.GetPciAddress(pfName, vfID)
+	if err != nil {
+		return err
+	}
+	netConf, err := config.LoadConf([]byte(fmt.Sprintf(`{"name": "self-test", "type": "ib-sriov-cni", "deviceID": %q}`, pciAddr)))
+	if err != nil {
+		return err
+	}
+	if netConf.GUID, err = randomGUID(); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "using VF %d (%s) of PF %s with guid %s\n", netConf.VFID, netConf.DeviceID, netConf.Master, netConf.GUID)
+
+	sm := newSriovManager()
+	if err := sm.ValidateVF(netConf); err != nil {
+		return err
+	}
+
+	scratchNS, closeScratchNS, err := newScratchNetns()
+	if err != nil {
+		return fmt.Errorf("failed to create scratch netns: %w", err)
+	}
+	defer closeScratchNS()
+
+	// Setup undoes the setup when it fails
+	p := plugin.NewPlugin(sm, nil)
+	if _, err := p.Setup(context.Background(), netConf, selfTestIfName, selfTestContainerID, scratchNS); err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "set up VF %s as %s in scratch netns\n", netConf.DeviceID, selfTestIfName)
+
+	tornDown := false
+	defer func() {
+		if tornDown {
+			return
+		}
+		if err := sm.ResetVFConfig(netConf); err != nil {
+			_ = logging.Errorf("selfTest(): failed to reset VF %s guid: %v", netConf.DeviceID, err)
+		}
+	}()
+
+	verifyErr := verifySelfTestLink(scratchNS, netConf)
+	if verifyErr == nil {
+		fmt.Fprintf(w, "verified %s hardware address %s\n", selfTestIfName, netConf.ContIFMAC)
+	}
+
+	if err := p.Teardown(netConf, selfTestIfName, selfTestContainerID, scratchNS); err != nil {
+		if verifyErr != nil {
+			_ = logging.Errorf("selfTest(): %v", err)
+			return verifyErr
+		}
+		return err
+	}
+	tornDown = true
+	fmt.Fprintf(w, "tore down VF %s\n", netConf.DeviceID)
+
+	return verifyErr
+}
+
+// newScratchNetns creates an unnamed netns, it is destroyed when the returned close function is called
+func newScratchNetns() (ns.NetNS, func(), error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	hostNS, err := netns.Get()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get host netns: %w", err)
+	}
+	defer hostNS.Close()
+
+	handle, err := netns.New()
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := netns.Set(hostNS); err != nil {
+		handle.Close()
+		return nil, nil, fmt.Errorf("failed to switch back to host netns: %w", err)
+	}
+
+	// the netns is referred to by the fd of the handle, which keeps it alive until it is closed
+	scratchNS, err := ns.GetNS(fmt.Sprintf("/proc/self/fd/%d", int(handle)))
+	if err != nil {
+		handle.Close()
+		return nil, nil, err
+	}
+	return scratchNS, func() {
+		scratchNS.Close()
+		handle.Close()
+	}, nil
+}
+
+// verifySelfTestLink checks that the VF interface is up in the netns with the configured GUID
+func verifySelfTestLink(netns ns.NetNS, netConf *ibtypes.NetConf) error {
+	return netns.Do(func(_ ns.NetNS) error {
+		linkObj, err := netlink.LinkByName(selfTestIfName)
+		if err != nil {
+			return fmt.Errorf("failed to find interface %q in scratch netns: %w", selfTestIfName, err)
+		}
+		if linkObj.Attrs().Flags&net.FlagUp == 0 {
+			return fmt.Errorf("interface %q in scratch netns is not up", selfTestIfName)
+		}
+		// IPoIB hardware address is 20 bytes, the last 8 bytes are the port GUID
+		hwAddr := linkObj.Attrs().HardwareAddr.String()
+		if len(hwAddr) < 36 || !strings.EqualFold(hwAddr[36:], netConf.GUID) {
+			return fmt.Errorf("interface %q hardware address %q does not carry guid %q", selfTestIfName, hwAddr, netConf.GUID)
+		}
+		return nil
+	})
+}
+
+// randomGUID returns a random locally administered GUID
+func randomGUID() (string, error) {
+	guid := make(net.HardwareAddr, 8)
+	if _, err := rand.Read(guid); err != nil {
+		return "", fmt.Errorf("failed to generate guid: %w", err)
+	}
+	guid[0] = 0x02
+	return guid.String(), nil
+}
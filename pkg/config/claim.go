@@ -0,0 +1,64 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// vfClaimsDir is the directory of the cache directory the VFs picked from their PF are claimed in until the NetConf
+// of their attachment is cached
+const vfClaimsDir = "vf-claims"
+
+// vfClaimTTL bounds the time a VF stays claimed by an ADD which neither cached its NetConf nor released the claim,
+// e.g. a plugin which was killed
+var vfClaimTTL = time.Minute
+
+// claimedVFs returns the pci addresses of the VFs claimed in cacheDir, expired claims are left out and removed when
+// prune is set
+func claimedVFs(cacheDir string, prune bool) (map[string]bool, error) {
+	dir := filepath.Join(cacheDir, vfClaimsDir)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]bool{}, nil
+		}
+		return nil, fmt.Errorf("failed to read VF claims directory %s: %w", dir, err)
+	}
+
+	claimed := map[string]bool{}
+	for _, e := range entries {
+		if time.Since(e.ModTime()) > vfClaimTTL {
+			if prune {
+				_ = os.Remove(filepath.Join(dir, e.Name()))
+			}
+			continue
+		}
+		claimed[e.Name()] = true
+	}
+	return claimed, nil
+}
+
+// claimVF claims the VF in cacheDir so that concurrent invocations don't pick it
+func claimVF(cacheDir, pciAddr string) error {
+	dir := filepath.Join(cacheDir, vfClaimsDir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create VF claims directory %s: %w", dir, err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, pciAddr), nil, 0600); err != nil {
+		return fmt.Errorf("failed to claim VF %s: %w", pciAddr, err)
+	}
+	return nil
+}
+
+// ReleaseVFClaim releases the claim of a VF picked by LoadConf, to be called once the NetConf of the attachment is
+// cached or the ADD failed. Releasing a VF which is not claimed is a no-op.
+func ReleaseVFClaim(cacheDir, pciAddr string) error {
+	path := filepath.Join(cacheDir, vfClaimsDir, pciAddr)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to release VF claim %s: %w", path, err)
+	}
+	return nil
+}
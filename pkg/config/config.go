@@ -2,71 +2,569 @@ package config
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
 	"github.com/Mellanox/ib-sriov-cni/pkg/types"
 	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
 	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types/current"
+	cniversion "github.com/containernetworking/cni/pkg/version"
+	"github.com/containernetworking/plugins/pkg/ns"
 )
 
 var (
 	// DefaultCNIDir used for caching NetConf
 	DefaultCNIDir = "/var/lib/cni/ib-sriov-cni"
+	// DefaultLockDir used for the per PF lock files
+	DefaultLockDir = "/run/ib-sriov-cni/locks"
+	// DefaultTopologyCacheDir used for caching the resolved PF and VF index of the VFs, not kept across reboots
+	DefaultTopologyCacheDir = "/run/ib-sriov-cni/topology"
+	// ErrNetConfCacheNotFound is returned when there is no cached NetConf for the container interface
+	ErrNetConfCacheNotFound = errors.New("cached NetConf not found")
+	// ErrVFNetdevNotFound is returned when the VF network device is not found on the host
+	ErrVFNetdevNotFound = errors.New("VF network device not found on the host")
+	// ErrNoFreeVF is returned when all the VFs of the PF are in use
+	ErrNoFreeVF = errors.New("no free VF")
+	// ErrVFQuotaReached is returned when the plugin uses maxVFsPerPF VFs of the PF already
+	ErrVFQuotaReached = errors.New("VF quota reached")
+	// ErrIBNotConfigured is returned when ib-kubernetes did not configure InfiniBand for the Pod
+	ErrIBNotConfigured = errors.New("InfiniBand is not configured")
+	// SupportedCNIVersions are the CNI spec versions the plugin results can be converted to
+	SupportedCNIVersions = cniversion.PluginSupports("0.1.0", "0.2.0", "0.3.0", "0.3.1", "0.4.0")
+)
+
+const (
+	// CacheVersion is the current version of the cached NetConf schema
+	// version 0: no version field, original VF link state is not recorded
+	// version 1: original VF link state is recorded in HostIFLinkState
+	// version 2: original VF config is recorded in HostVFConfig
+	// version 3: VF pkey table entries of the added pkeys and the original pkey index are recorded
+	// version 4: original VF trust is recorded
+	CacheVersion = 4
+	// ipoibHardwareAddrLen is the length of an IPoIB hardware address: 4 bytes QPN and 16 bytes GID
+	ipoibHardwareAddrLen = 20
+	// minimum and maximum MTU supported by IPoIB interfaces
+	minIPoIBMTU = 1280
+	maxIPoIBMTU = 65520
+	// guidPoolDir is the default directory of GUID pool allocations under the cache directory
+	guidPoolDir = "guid-pool"
+	// DefaultInfiniBandAnnotation is the cni-arg ib-kubernetes sets to DefaultInfiniBandConfigured
+	DefaultInfiniBandAnnotation = "mellanox.infiniband.app"
+	DefaultInfiniBandConfigured = "configured"
 )
 
 // LoadConf parses and validates stdin netconf and returns NetConf object
 func LoadConf(bytes []byte) (*types.NetConf, error) {
+	return loadConf(bytes, "", true)
+}
+
+// LoadConfForDryRun parses and validates stdin netconf as LoadConf does, without claiming the free VF it picks or
+// pruning the VF claims
+func LoadConfForDryRun(bytes []byte) (*types.NetConf, error) {
+	return loadConf(bytes, "", false)
+}
+
+// LoadConfForVF parses and validates stdin netconf for the VF of the given PCI address, which was found outside of
+// the host netns. No free VF is selected and the VF is given a host name derived from its PCI address. The VF must be
+// the deviceID of the netconf or a VF of its master.
+func LoadConfForVF(bytes []byte, deviceID string) (*types.NetConf, error) {
+	return loadConf(bytes, deviceID, false)
+}
+
+// loadConf parses and validates stdin netconf, a non empty deviceID overrides the VF of the netconf. The free VF picked
+// without deviceID is claimed when claim is set.
+func loadConf(bytes []byte, deviceID string, claim bool) (_ *types.NetConf, retErr error) {
+	// report all the fields of the wrong type and out of range values at once, unknown fields are only logged
+	if err := validateNetConf(bytes); err != nil {
+		if errors.Is(err, ErrInvalidNetConf) {
+			return nil, fmt.Errorf("LoadConf(): %w", err)
+		}
+		return nil, fmt.Errorf("LoadConf(): failed to load netconf: %w", err)
+	}
+
+	// the shared settings of the included file are validated once merged underneath the inline ones
+	merged, err := mergeIncludedConfig(bytes)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConf(): %w", err)
+	}
+	if string(merged) != string(bytes) {
+		if err := validateNetConf(merged); err != nil {
+			return nil, fmt.Errorf("LoadConf(): includeConfig: %w", err)
+		}
+		bytes = merged
+	}
+
 	n := &types.NetConf{}
 	if err := json.Unmarshal(bytes, n); err != nil {
-		return nil, fmt.Errorf("LoadConf(): failed to load netconf: %v", err)
+		return nil, fmt.Errorf("LoadConf(): failed to load netconf: %w", err)
+	}
+
+	if deviceID != "" {
+		if addr, ok := utils.NormalizePciAddress(n.DeviceID); n.DeviceID != "" && (!ok || addr != deviceID) {
+			return nil, fmt.Errorf("LoadConf(): VF %s is not the deviceID %s of the netconf", deviceID, n.DeviceID)
+		}
+		n.DeviceID = deviceID
+	}
+
+	// a configuration without cniVersion gets results of the latest implemented spec version
+	if n.CNIVersion == "" {
+		n.CNIVersion = current.ImplementedSpecVersion
+	}
+	if !isSupportedCNIVersion(n.CNIVersion) {
+		return nil, fmt.Errorf("LoadConf(): unsupported cniVersion %q, supported versions are %s",
+			n.CNIVersion, strings.Join(SupportedCNIVersions.SupportedVersions(), ", "))
+	}
+
+	if n.LogLevel != "" {
+		if _, err := logging.ParseLevel(n.LogLevel); err != nil {
+			return nil, fmt.Errorf("LoadConf(): invalid logLevel value: %w", err)
+		}
+	}
+
+	if n.InfiniBandAnnotation == "" {
+		n.InfiniBandAnnotation = DefaultInfiniBandAnnotation
+	}
+	if n.InfiniBandConfigured == "" {
+		n.InfiniBandConfigured = DefaultInfiniBandConfigured
+	}
+
+	if n.CNIDir == "" {
+		n.CNIDir = DefaultCNIDir
+	} else if !filepath.IsAbs(n.CNIDir) {
+		return nil, fmt.Errorf("LoadConf(): invalid cniDir %q, must be an absolute path", n.CNIDir)
+	}
+
+	if n.NetnsOverride != "" {
+		if !filepath.IsAbs(n.NetnsOverride) {
+			return nil, fmt.Errorf("LoadConf(): invalid netnsOverride %q, must be an absolute path", n.NetnsOverride)
+		}
+		netns, err := ns.GetNS(n.NetnsOverride)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): invalid netnsOverride %q: %w", n.NetnsOverride, err)
+		}
+		netns.Close()
+	}
+
+	if n.Master != "" {
+		master, err := normalizeMaster(n.Master)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): %w", err)
+		}
+		n.Master = master
+	}
+
+	// without a VF pciaddr pick a free VF of the given PF
+	if n.DeviceID == "" && n.Master != "" {
+		deviceID, err := selectFreeVF(n.Master, n.CNIDir, n.MaxVFsPerPF, n.EnumerateWorkers, claim)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): %w", err)
+		}
+		n.DeviceID = deviceID
+		// the caller releases the claim of a loaded netconf, the claim of a rejected one is released here
+		if claim {
+			defer func() {
+				if retErr != nil {
+					_ = ReleaseVFClaim(n.CNIDir, deviceID)
+				}
+			}()
+		}
+	}
+
+	if n.VFToPFMap, err = normalizeVFToPFMap(n.VFToPFMap); err != nil {
+		return nil, fmt.Errorf("LoadConf(): %w", err)
 	}
 
 	// DeviceID takes precedence; if we are given a VF pciaddr then work from there
 	if n.DeviceID != "" {
 		// Get rest of the VF information
-		pfName, vfID, err := getVfInfo(n.DeviceID)
+		pfName, vfID, err := getVfInfo(n.DeviceID, n.TopologyCacheTTL, n.VFToPFMap)
 		if err != nil {
-			return nil, fmt.Errorf("LoadConf(): failed to get VF information: %q", err)
+			return nil, fmt.Errorf("LoadConf(): failed to get VF information: %w", err)
+		}
+		if deviceID != "" && n.Master != "" && n.Master != pfName {
+			return nil, fmt.Errorf("LoadConf(): VF %s is a VF of PF %s, not of the master %s of the netconf", deviceID, pfName, n.Master)
 		}
 		n.VFID = vfID
 		n.Master = pfName
 	} else {
-		return nil, fmt.Errorf("LoadConf(): VF pci addr is required")
+		return nil, fmt.Errorf("LoadConf(): VF pci addr or PF name is required")
 	}
 
+	pfPciAddress, err := utils.GetPfPciAddress(n.Master)
+	if err != nil {
+		return nil, fmt.Errorf("LoadConf(): failed to get PF PCI address: %w", err)
+	}
+	n.PFPciAddress = pfPciAddress
+
 	// Get interface name
 	hostIFNames, err := utils.GetVFLinkNames(n.DeviceID)
+	if deviceID != "" && (err != nil || hostIFNames == "") {
+		hostIFNames, err = utils.VFNameFromPciAddress(n.DeviceID), nil
+	}
 	if err != nil || hostIFNames == "" {
-		return nil, fmt.Errorf("LoadConf(): failed to detect VF %s name with error, %q", n.DeviceID, err)
+		return nil, fmt.Errorf("LoadConf(): %w, failed to detect VF %s name with error, %q", ErrVFNetdevNotFound, n.DeviceID, err)
 	}
 
 	n.HostIFNames = hostIFNames
 
+	// validate the host VF name rendered from the template
+	if n.VFNameTemplate != "" {
+		name := utils.RenderVFName(n.VFNameTemplate, n.Master, n.VFID, n.DeviceID)
+		if strings.ContainsAny(name, "{}/ ") {
+			return nil, fmt.Errorf("LoadConf(): invalid vfNameTemplate %q, supported tokens are {pf}, {vf} and {pci}", n.VFNameTemplate)
+		}
+		if len(name) > types.MaxIfNameLen {
+			return nil, fmt.Errorf("LoadConf(): vfNameTemplate %q renders VF name %q longer than %d characters",
+				n.VFNameTemplate, name, types.MaxIfNameLen)
+		}
+	}
+
+	// validate the container interface alias tokens
+	if n.IfAlias != "" && strings.ContainsAny(utils.RenderIfAlias(n.IfAlias, "", "", ""), "{}") {
+		return nil, fmt.Errorf("LoadConf(): invalid ifAlias %q, supported tokens are {containerID}, {podUID} and {guid}", n.IfAlias)
+	}
+
+	// detect PF eswitch mode unless given
+	if n.PFSwitchdev == nil {
+		switchdev := utils.IsSwitchdev(n.Master)
+		n.PFSwitchdev = &switchdev
+	}
+
 	// validate that link state is one of supported values
 	if n.LinkState != "" && n.LinkState != "auto" && n.LinkState != "enable" && n.LinkState != "disable" {
 		return nil, fmt.Errorf("LoadConf(): invalid link_state value: %s", n.LinkState)
 	}
+	// in switchdev mode the VF link follows the admin state of its representor, which can't follow the PF link
+	if n.LinkState == "auto" && *n.PFSwitchdev {
+		return nil, fmt.Errorf("LoadConf(): link_state auto is not supported with PF %s in switchdev mode, "+
+			"the VF link follows its representor, use enable or disable", n.Master)
+	}
+
+	if n.HostAdminState != "" && n.HostAdminState != types.AdminStateUp && n.HostAdminState != types.AdminStateDown {
+		return nil, fmt.Errorf("LoadConf(): invalid hostAdminState %q, supported states are %s and %s",
+			n.HostAdminState, types.AdminStateUp, types.AdminStateDown)
+	}
+
+	switch n.IPoIBMode {
+	case "", types.IPoIBModeConnected:
+		// the VF mode is kept when not set, SetupVF checks that a VF given a large MTU is in connected mode
+	case types.IPoIBModeDatagram:
+		if n.MTU > types.MaxIPoIBDatagramMTU {
+			return nil, fmt.Errorf("LoadConf(): mtu %d is invalid in IPoIB %s mode, the maximum is %d, larger MTUs "+
+				"require ipoibMode=%s", n.MTU, types.IPoIBModeDatagram, types.MaxIPoIBDatagramMTU, types.IPoIBModeConnected)
+		}
+	default:
+		return nil, fmt.Errorf("LoadConf(): invalid ipoibMode %q, supported modes are %s and %s",
+			n.IPoIBMode, types.IPoIBModeDatagram, types.IPoIBModeConnected)
+	}
+
+	if n.PKey != "" {
+		pkey, err := utils.NormalizePKey(n.PKey)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): %w", err)
+		}
+		n.PKey = pkey
+	}
+
+	seenPKeys := map[string]bool{n.PKey: n.PKey != ""}
+	for i, p := range n.PKeys {
+		pkey, err := utils.NormalizePKey(p)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): invalid pkeys: %w", err)
+		}
+		if seenPKeys[pkey] {
+			return nil, fmt.Errorf("LoadConf(): invalid pkeys: duplicate pkey %s", pkey)
+		}
+		seenPKeys[pkey] = true
+		n.PKeys[i] = pkey
+	}
+
+	if n.MaxTxRate != 0 && n.MinTxRate > n.MaxTxRate {
+		return nil, fmt.Errorf("LoadConf(): invalid tx rate minTxRate %d, must not be greater than maxTxRate %d", n.MinTxRate, n.MaxTxRate)
+	}
+
+	// validate that trust is one of supported values
+	if n.Trust != "" && n.Trust != "on" && n.Trust != "off" {
+		return nil, fmt.Errorf("LoadConf(): invalid trust value: %s", n.Trust)
+	}
+
+	switch n.ResetGUIDPolicy {
+	case "":
+		n.ResetGUIDPolicy = types.ResetGUIDOriginal
+	case types.ResetGUIDZero, types.ResetGUIDOriginal, types.ResetGUIDKeep:
+	default:
+		return nil, fmt.Errorf("LoadConf(): invalid resetGUIDPolicy value %q, allowed values are %s, %s and %s",
+			n.ResetGUIDPolicy, types.ResetGUIDZero, types.ResetGUIDOriginal, types.ResetGUIDKeep)
+	}
+
+	switch n.OnNameConflict {
+	case "":
+		n.OnNameConflict = types.NameConflictFail
+	case types.NameConflictFail, types.NameConflictRename:
+	default:
+		return nil, fmt.Errorf("LoadConf(): invalid onNameConflict value %q, allowed values are %s and %s",
+			n.OnNameConflict, types.NameConflictFail, types.NameConflictRename)
+	}
+
+	switch n.OnCacheConflict {
+	case "":
+		n.OnCacheConflict = types.CacheConflictOverwrite
+	case types.CacheConflictOverwrite, types.CacheConflictFail, types.CacheConflictMerge:
+	default:
+		return nil, fmt.Errorf("LoadConf(): invalid onCacheConflict value %q, allowed values are %s, %s and %s",
+			n.OnCacheConflict, types.CacheConflictOverwrite, types.CacheConflictFail, types.CacheConflictMerge)
+	}
+
+	switch n.CacheFormat {
+	case "":
+		n.CacheFormat = types.CacheFormatJSON
+	case types.CacheFormatJSON, types.CacheFormatCompact:
+	default:
+		return nil, fmt.Errorf("LoadConf(): invalid cacheFormat value %q, allowed values are %s and %s",
+			n.CacheFormat, types.CacheFormatJSON, types.CacheFormatCompact)
+	}
+
+	// validate that sysctls can't escape the container interface
+	for key, value := range n.Sysctls {
+		if err := utils.ValidateInterfaceSysctl(key); err != nil {
+			return nil, fmt.Errorf("LoadConf(): %w", err)
+		}
+		if value == "" || strings.ContainsAny(value, "\n") {
+			return nil, fmt.Errorf("LoadConf(): invalid value %q of sysctl %s", value, key)
+		}
+	}
+
+	for _, pf := range n.PFAllowlist {
+		if strings.TrimSpace(pf) == "" {
+			return nil, fmt.Errorf("LoadConf(): pfAllowlist entries must not be empty")
+		}
+	}
+
+	if n.StaticGUID != "" {
+		if n.GUIDPool != nil {
+			return nil, fmt.Errorf("LoadConf(): guid and guidPool are mutually exclusive")
+		}
+		guidAddr, err := utils.ParseAndNormalizeGUID(n.StaticGUID)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): invalid guid: %w", err)
+		}
+		n.StaticGUID = guidAddr.String()
+	}
+
+	if n.GUIDSource != "" && n.GUIDSource != types.GUIDSourceCNIArgs {
+		if !filepath.IsAbs(n.GUIDSource) {
+			return nil, fmt.Errorf("LoadConf(): invalid guidSource %q, must be %s or an absolute path",
+				n.GUIDSource, types.GUIDSourceCNIArgs)
+		}
+		if n.StaticGUID != "" || n.GUIDPool != nil {
+			return nil, fmt.Errorf("LoadConf(): guidSource directory can't be combined with guid or guidPool")
+		}
+		n.GUIDSource = filepath.Clean(n.GUIDSource)
+	}
+
+	// validate the GUID pool range
+	if n.GUIDPool != nil {
+		if _, _, err := utils.ParseGUIDRange(n.GUIDPool.RangeStart, n.GUIDPool.RangeEnd); err != nil {
+			return nil, fmt.Errorf("LoadConf(): %w", err)
+		}
+		if n.GUIDPool.DataDir == "" {
+			n.GUIDPool.DataDir = filepath.Join(n.CNIDir, guidPoolDir)
+		}
+	}
+
+	if n.PostSetupHook != nil && (len(n.PostSetupHook.Command) == 0 || !filepath.IsAbs(n.PostSetupHook.Command[0])) {
+		return nil, fmt.Errorf("LoadConf(): invalid postSetupHook, command must start with the absolute path of an executable")
+	}
+	if n.PostSetupHook != nil && n.PostSetupHook.Timeout < 0 {
+		return nil, fmt.Errorf("LoadConf(): invalid postSetupHook timeout %d, must not be negative", n.PostSetupHook.Timeout)
+	}
+
+	if n.Rings != nil && n.Rings.RX == 0 && n.Rings.TX == 0 {
+		return nil, fmt.Errorf("LoadConf(): invalid rings, rx or tx ring size is required")
+	}
+
+	// mac capability from the runtime overrides the configured mac
+	if n.RuntimeConfig.Mac != "" {
+		n.MAC = n.RuntimeConfig.Mac
+	}
+
+	if n.DeriveMACFromGUID && n.MAC != "" {
+		return nil, fmt.Errorf("LoadConf(): deriveMACFromGUID conflicts with mac %s, set only one of them", n.MAC)
+	}
+
+	// validate and normalize the IPoIB hardware address
+	if n.MAC != "" {
+		hwaddr, err := net.ParseMAC(n.MAC)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): invalid mac address %s: %w", n.MAC, err)
+		}
+		if len(hwaddr) != ipoibHardwareAddrLen {
+			return nil, fmt.Errorf("LoadConf(): invalid mac address %s, IPoIB requires a %d bytes hardware address, got %d bytes",
+				n.MAC, ipoibHardwareAddrLen, len(hwaddr))
+		}
+		n.MAC = hwaddr.String()
+	}
+
+	// validate the static neighbors, IPoIB neighbors have 20 bytes link-layer addresses
+	for i, neighbor := range n.Neighbors {
+		if net.ParseIP(neighbor.IP) == nil {
+			return nil, fmt.Errorf("LoadConf(): invalid neighbor ip %q", neighbor.IP)
+		}
+		lladdr, err := net.ParseMAC(neighbor.LLAddr)
+		if err != nil {
+			return nil, fmt.Errorf("LoadConf(): invalid neighbor %s lladdr %s: %w", neighbor.IP, neighbor.LLAddr, err)
+		}
+		if len(lladdr) != ipoibHardwareAddrLen {
+			return nil, fmt.Errorf("LoadConf(): invalid neighbor %s lladdr %s, IPoIB requires a %d bytes hardware address, got %d bytes",
+				neighbor.IP, neighbor.LLAddr, ipoibHardwareAddrLen, len(lladdr))
+		}
+		n.Neighbors[i].LLAddr = lladdr.String()
+	}
+
+	if n.IPAM.Type != "" && len(n.IPAMAllowlist) > 0 && !isAllowedIPAMType(n.IPAM.Type, n.IPAMAllowlist) {
+		return nil, fmt.Errorf("LoadConf(): IPAM type %q is not in ipamAllowlist %s", n.IPAM.Type, strings.Join(n.IPAMAllowlist, ", "))
+	}
+
+	// validate ips capability, the IPs are assigned directly unless the static IPAM plugin handles them
+	if len(n.RuntimeConfig.IPs) > 0 {
+		if n.IPAM.Type != "" && n.IPAM.Type != "static" {
+			return nil, fmt.Errorf("LoadConf(): ips capability can not be used with IPAM type %q, "+
+				"remove the ipam configuration or use the static IPAM plugin", n.IPAM.Type)
+		}
+		for _, ip := range n.RuntimeConfig.IPs {
+			if _, _, err := net.ParseCIDR(ip); err != nil {
+				return nil, fmt.Errorf("LoadConf(): invalid ip %s from ips capability, expected CIDR notation: %w", ip, err)
+			}
+		}
+	}
 
 	return n, nil
 }
 
-func getVfInfo(vfPci string) (string, int, error) {
+func getVfInfo(vfPci string, cacheTTL int, vfToPF map[string]string) (string, int, error) {
+	// a mapped VF is looked up on the virtfn links of its PF only
+	if addr, ok := utils.NormalizePciAddress(vfPci); ok && vfToPF[addr] != "" {
+		vfID, err := utils.GetVfid(addr, vfToPF[addr])
+		if err != nil {
+			return "", 0, fmt.Errorf("VF %s mapped to PF %s by vfToPFMap: %w", vfPci, vfToPF[addr], err)
+		}
+		return vfToPF[addr], vfID, nil
+	}
 
-	var vfID int
+	cacheDir := ""
+	if cacheTTL > 0 {
+		cacheDir = DefaultTopologyCacheDir
+	}
+	return utils.ResolveVFTopology(vfPci, cacheDir, time.Duration(cacheTTL)*time.Second)
+}
 
-	pf, err := utils.GetPfName(vfPci)
-	if err != nil {
-		return "", vfID, err
+// normalizeVFToPFMap checks that the keys of the vfToPFMap are VF PCI addresses and its values SR-IOV PFs, the
+// returned map is keyed by the PCI addresses in the sysfs format
+func normalizeVFToPFMap(vfToPF map[string]string) (map[string]string, error) {
+	if len(vfToPF) == 0 {
+		return nil, nil
 	}
+	normalized := make(map[string]string, len(vfToPF))
+	for key, pfName := range vfToPF {
+		addr, ok := utils.NormalizePciAddress(strings.TrimSpace(key))
+		if !ok {
+			return nil, fmt.Errorf("invalid vfToPFMap entry %q: not a PCI address", key)
+		}
+		if _, err := utils.GetSriovNumVfs(pfName); err != nil {
+			return nil, fmt.Errorf("invalid vfToPFMap entry %q: PF %q is not a SR-IOV PF: %w", key, pfName, err)
+		}
+		if other, ok := normalized[addr]; ok && other != pfName {
+			return nil, fmt.Errorf("invalid vfToPFMap entry %q: VF %s is mapped to both %s and %s", key, addr, other, pfName)
+		}
+		normalized[addr] = pfName
+	}
+	return normalized, nil
+}
 
-	vfID, err = utils.GetVfid(vfPci, pf)
-	if err != nil {
-		return "", vfID, err
+// CacheDir returns the directory of the cached NetConf of the network configuration, the cniDir of the network
+// configuration when set and DefaultCNIDir otherwise
+func CacheDir(stdinData []byte) string {
+	conf := struct {
+		CNIDir string `json:"cniDir"`
+	}{}
+	// the cniDir may be shared through the included file
+	if merged, err := mergeIncludedConfig(stdinData); err == nil {
+		stdinData = merged
 	}
+	if err := json.Unmarshal(stdinData, &conf); err != nil || conf.CNIDir == "" {
+		return DefaultCNIDir
+	}
+	return conf.CNIDir
+}
+
+// DeviceID returns the deviceID of the network configuration as a normalized PCI address, empty when it is not set
+// or is not a PCI address. It is read when the network configuration fails to load as its VF is not on the host.
+func DeviceID(stdinData []byte) string {
+	conf := struct {
+		DeviceID string `json:"deviceID"`
+	}{}
+	if merged, err := mergeIncludedConfig(stdinData); err == nil {
+		stdinData = merged
+	}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return ""
+	}
+	addr, _ := utils.NormalizePciAddress(conf.DeviceID)
+	return addr
+}
+
+// DryRun returns the dryRun of the network configuration, it is read before the network configuration is loaded as a
+// dry-run ADD loads it without claiming a VF
+func DryRun(stdinData []byte) bool {
+	conf := struct {
+		DryRun bool `json:"dryRun"`
+	}{}
+	if merged, err := mergeIncludedConfig(stdinData); err == nil {
+		stdinData = merged
+	}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return false
+	}
+	return conf.DryRun
+}
+
+// AddRetry returns the addRetryAttempts and addRetryInterval of the network configuration, they are read before the
+// network configuration is loaded as loading it may fail with a transient condition
+func AddRetry(stdinData []byte) (int, time.Duration) {
+	conf := struct {
+		AddRetryAttempts int `json:"addRetryAttempts"`
+		AddRetryInterval int `json:"addRetryInterval"`
+	}{}
+	if merged, err := mergeIncludedConfig(stdinData); err == nil {
+		stdinData = merged
+	}
+	if err := json.Unmarshal(stdinData, &conf); err != nil || conf.AddRetryAttempts < 0 || conf.AddRetryInterval < 0 {
+		return 0, 0
+	}
+	return conf.AddRetryAttempts, time.Duration(conf.AddRetryInterval) * time.Millisecond
+}
 
-	return pf, vfID, nil
+// NetnsOverride returns the netnsOverride of the network configuration, it is read before the cached NetConf is
+// loaded to tell whether DEL has a netns to release the VF from
+func NetnsOverride(stdinData []byte) string {
+	conf := struct {
+		NetnsOverride string `json:"netnsOverride"`
+	}{}
+	if merged, err := mergeIncludedConfig(stdinData); err == nil {
+		stdinData = merged
+	}
+	if err := json.Unmarshal(stdinData, &conf); err != nil {
+		return ""
+	}
+	return conf.NetnsOverride
 }
 
 // LoadConfFromCache retrieves cached NetConf returns it along with a handle for removal
@@ -75,16 +573,212 @@ func LoadConfFromCache(args *skel.CmdArgs) (*types.NetConf, string, error) {
 
 	s := []string{args.ContainerID, args.IfName}
 	cRef := strings.Join(s, "-")
-	cRefPath := filepath.Join(DefaultCNIDir, cRef)
+	cacheDir := CacheDir(args.StdinData)
+	cRefPath := filepath.Join(cacheDir, cRef)
 
 	netConfBytes, err := utils.ReadScratchNetConf(cRefPath)
 	if err != nil {
-		return nil, "", fmt.Errorf("error reading cached NetConf in %s with name %s", DefaultCNIDir, cRef)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, "", fmt.Errorf("%w in %s with name %s", ErrNetConfCacheNotFound, cacheDir, cRef)
+		}
+		return nil, "", fmt.Errorf("error reading cached NetConf in %s with name %s: %w", cacheDir, cRef, err)
 	}
 
 	if err = json.Unmarshal(netConfBytes, netConf); err != nil {
-		return nil, "", fmt.Errorf("failed to parse NetConf: %q", err)
+		return nil, "", fmt.Errorf("failed to parse NetConf: %w", err)
+	}
+
+	if err = migrateCachedNetConf(netConf); err != nil {
+		return nil, "", err
 	}
 
 	return netConf, cRefPath, nil
 }
+
+// CachedNetConf is a NetConf cached in the cache directory
+type CachedNetConf struct {
+	// Path of the cache file, a handle for removal
+	Path string
+	// NetConf is nil when the cache file is not a valid NetConf, Err is set then
+	NetConf *types.NetConf
+	Err     error
+}
+
+// ListCachedNetConfs returns the NetConfs cached in dir, directories and temporary files of cache writes in progress
+// are skipped
+func ListCachedNetConfs(dir string) ([]CachedNetConf, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory %s: %w", dir, err)
+	}
+
+	var cached []CachedNetConf
+	for _, e := range entries {
+		if e.IsDir() || strings.Contains(e.Name(), ".tmp") {
+			continue
+		}
+		c := CachedNetConf{Path: filepath.Join(dir, e.Name())}
+		data, err := utils.ReadScratchNetConf(c.Path)
+		if err == nil {
+			n := &types.NetConf{}
+			if err = json.Unmarshal(data, n); err == nil {
+				err = migrateCachedNetConf(n)
+			}
+			if err == nil {
+				c.NetConf = n
+			}
+		}
+		c.Err = err
+		cached = append(cached, c)
+	}
+	return cached, nil
+}
+
+// migrateCachedNetConf upgrades a cached NetConf written by an older version to the current schema
+func migrateCachedNetConf(n *types.NetConf) error {
+	if n.CacheVersion > CacheVersion {
+		return fmt.Errorf("unsupported cached NetConf version %d, latest supported version is %d", n.CacheVersion, CacheVersion)
+	}
+
+	if n.CacheVersion == 0 {
+		// the original link state was not recorded, leave it empty so it is reset to auto on teardown
+		n.HostIFLinkState = ""
+	}
+
+	n.CacheVersion = CacheVersion
+	return nil
+}
+
+// selectFreeVF returns the pci address of the first VF of the PF which is free and, with claim set, claims it. A VF is
+// in use when its netdevice is not on the host, e.g. it is in a Pod netns, or when a cached NetConf in cacheDir refers
+// to it or it is claimed. With maxVFs set ErrVFQuotaReached is returned once maxVFs VFs of the PF are configured or
+// claimed. The VFs are read on up to workers goroutines. Without claim cacheDir is not changed.
+func selectFreeVF(pfName, cacheDir string, maxVFs, workers int, claim bool) (string, error) {
+	// the VFs in use are counted and the picked VF is claimed under the PF lock, so that concurrent invocations
+	// neither pick the same VF nor exceed maxVFs
+	if claim {
+		unlockPF, err := utils.LockPF(DefaultLockDir, pfName)
+		if err != nil {
+			return "", err
+		}
+		defer unlockPF()
+	}
+
+	vfs, inUse, err := vfUsage(pfName, cacheDir, workers, claim)
+	if err != nil {
+		return "", err
+	}
+	if maxVFs > 0 {
+		if used := usage(vfs, inUse).InUse; used >= maxVFs {
+			return "", fmt.Errorf("%w on PF %s, %d of the maxVFsPerPF %d VFs are in use", ErrVFQuotaReached, pfName, used, maxVFs)
+		}
+	}
+	for _, vf := range vfs {
+		if inUse[vf.PciAddr] {
+			logging.Debugf("selectFreeVF(): VF %d (%s) of PF %s is in use", vf.VFID, vf.PciAddr, pfName)
+			continue
+		}
+		if vf.LinkName == "" {
+			logging.Debugf("selectFreeVF(): VF %d (%s) of PF %s is not on the host", vf.VFID, vf.PciAddr, pfName)
+			continue
+		}
+		if claim {
+			if err := claimVF(cacheDir, vf.PciAddr); err != nil {
+				return "", err
+			}
+		}
+		return vf.PciAddr, nil
+	}
+
+	return "", fmt.Errorf("%w on PF %s, all %d VFs are in use", ErrNoFreeVF, pfName, len(vfs))
+}
+
+// PFUsage is the usage of the VFs of a PF by the plugin
+type PFUsage struct {
+	NumVFs int `json:"numVFs"`
+	// InUse VFs configured by a cached NetConf or claimed
+	InUse int `json:"vfsInUse"`
+	// Free VFs the plugin picks from when DeviceID is not set
+	Free int `json:"freeVFs"`
+}
+
+// GetPFUsage returns the usage of the VFs of the PF by the attachments cached in cacheDir, the VFs are free or in use
+// as the free VF selection of LoadConf sees them. It doesn't change cacheDir.
+func GetPFUsage(pfName, cacheDir string) (PFUsage, error) {
+	vfs, inUse, err := vfUsage(pfName, cacheDir, 0, false)
+	if err != nil {
+		return PFUsage{}, err
+	}
+	return usage(vfs, inUse), nil
+}
+
+// vfEnumerator reads the VFs of a PF for the free VF selection and the PF usage, set with SetVFEnumerator
+var vfEnumerator types.VFEnumerator
+
+// SetVFEnumerator sets the VFEnumerator LoadConf picks free VFs with and GetPFUsage counts the VFs with
+func SetVFEnumerator(e types.VFEnumerator) {
+	vfEnumerator = e
+}
+
+// vfUsage returns the VFs of the PF and the pci addresses of the VFs configured by a NetConf cached in cacheDir or
+// claimed, the expired claims are removed when prune is set
+func vfUsage(pfName, cacheDir string, workers int, prune bool) ([]types.VFState, map[string]bool, error) {
+	if vfEnumerator == nil {
+		return nil, nil, fmt.Errorf("no VF enumerator set to read the VFs of PF %s", pfName)
+	}
+
+	cached, err := ListCachedNetConfs(cacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+	inUse, err := claimedVFs(cacheDir, prune)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range cached {
+		if c.NetConf != nil {
+			inUse[c.NetConf.DeviceID] = true
+		}
+	}
+
+	vfs, err := vfEnumerator.EnumerateVFs(pfName, workers)
+	if err != nil {
+		return nil, nil, err
+	}
+	return vfs, inUse, nil
+}
+
+func usage(vfs []types.VFState, inUse map[string]bool) PFUsage {
+	u := PFUsage{NumVFs: len(vfs)}
+	for _, vf := range vfs {
+		switch {
+		case inUse[vf.PciAddr]:
+			u.InUse++
+		case vf.LinkName != "":
+			u.Free++
+		}
+	}
+	return u
+}
+
+// isAllowedIPAMType returns whether the IPAM plugin type is one of the allowed types
+func isAllowedIPAMType(ipamType string, allowed []string) bool {
+	for _, t := range allowed {
+		if t == ipamType {
+			return true
+		}
+	}
+	return false
+}
+
+func isSupportedCNIVersion(version string) bool {
+	for _, v := range SupportedCNIVersions.SupportedVersions() {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
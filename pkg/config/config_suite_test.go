@@ -3,6 +3,7 @@ package config
 import (
 	"testing"
 
+	"github.com/Mellanox/ib-sriov-cni/pkg/sriov"
 	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -22,6 +23,7 @@ var _ = BeforeSuite(func() {
 	// create test sys tree
 	err := utils.CreateTmpSysFs()
 	check(err)
+	SetVFEnumerator(sriov.NewVFEnumerator())
 })
 
 var _ = AfterSuite(func() {
@@ -1,7 +1,19 @@
 package config
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/skel"
+	"github.com/containernetworking/cni/pkg/types/current"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -22,8 +34,62 @@ var _ = Describe("Config", func() {
             "gateway": "10.55.206.1"
         }
                         }`)
-			_, err := LoadConf(conf)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.InfiniBandAnnotation).To(Equal(DefaultInfiniBandAnnotation))
+			Expect(netConf.InfiniBandConfigured).To(Equal(DefaultInfiniBandConfigured))
+		})
+		It("Assuming correct config file - DeviceID resolves PF, VF index and name", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "Master": "ib3",
+        "VFID": 5
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.Master).To(Equal("ib0"))
+			Expect(n.VFID).To(Equal(1))
+			Expect(n.HostIFNames).To(Equal("ib2"))
+		})
+		It("Assuming correct config file - PF only loaded for a given VF", func() {
+			cacheDir, err := ioutil.TempDir("", "ib-sriov-cni-cache-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(cacheDir)
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "master": "ib0",
+        "cniDir": "` + cacheDir + `"
+                        }`)
+			n, err := LoadConfForVF(conf, "0000:af:06.1")
 			Expect(err).NotTo(HaveOccurred())
+			Expect(n.DeviceID).To(Equal("0000:af:06.1"))
+			Expect(n.Master).To(Equal("ib0"))
+			Expect(n.VFID).To(Equal(1))
+			_, err = os.Stat(filepath.Join(cacheDir, vfClaimsDir))
+			Expect(os.IsNotExist(err)).To(BeTrue(), "no free VF should be claimed")
+		})
+		It("Assuming incorrect config file - PF only loaded for a VF of another PF", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "master": "ib3"
+                        }`)
+			_, err := LoadConfForVF(conf, "0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VF 0000:af:06.1 is a VF of PF ib0, not of the master ib3 of the netconf"))
+		})
+		It("Assuming incorrect config file - loaded for another VF than the DeviceID", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.0"
+                        }`)
+			_, err := LoadConfForVF(conf, "0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VF 0000:af:06.1 is not the deviceID 0000:af:06.0 of the netconf"))
 		})
 		It("Assuming incorrect config file - not existing DeviceID", func() {
 			conf := []byte(`{
@@ -39,37 +105,1247 @@ var _ = Describe("Config", func() {
             ],
             "gateway": "10.55.206.1"
         }
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+		It("Assuming correct config file - valid mtu", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mtu": 4092
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.MTU).To(Equal(4092))
+		})
+		It("Assuming incorrect config file - mtu out of range", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mtu": 1000
                         }`)
 			_, err := LoadConf(conf)
 			Expect(err).To(HaveOccurred())
 		})
-		It("Assuming incorrect config file - broken json", func() {
+		It("Assuming incorrect config file - rings without sizes", func() {
 			conf := []byte(`{
-        "name": "mynet"
-		"type": "ib-sriov-cni",
-		"deviceID": "0000:af:06.1",
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "rings": {}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - post setup hook with relative command", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "postSetupHook": {"command": ["register-endpoint.sh"]}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - unknown fields of a legacy config", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
         "vf": 0,
-        "ipam": {
-            "type": "host-local",
-            "subnet": "10.55.206.0/26",
-            "routes": [
-                { "dst": "0.0.0.0/0" }
-            ],
-            "gateway": "10.55.206.1"
+        "link_sate": "enable",
+        "rings": {"rx": 1024, "tz": 1024}
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.LinkState).To(BeEmpty())
+			Expect(netConf.Rings.RX).To(BeEquivalentTo(1024))
+		})
+		It("Assuming incorrect config file - all invalid fields reported", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mtu": "2044",
+        "maxTxRate": -1,
+        "rings": {"rx": "1024", "tz": 1024},
+        "neighbors": [{"ip": "10.56.217.1", "lladdr": 20}]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(errors.Is(err, ErrInvalidNetConf)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(`field "mtu" has wrong type string, expected integer`))
+			Expect(err.Error()).To(ContainSubstring(`field "maxTxRate" value -1 must not be negative`))
+			Expect(err.Error()).To(ContainSubstring(`field "rings.rx" has wrong type string, expected integer`))
+			Expect(err.Error()).To(ContainSubstring(`field "neighbors.0.lladdr" has wrong type number, expected string`))
+			Expect(err.Error()).NotTo(ContainSubstring("tz"), "unknown fields are not rejected")
+		})
+		It("Assuming correct config file - mixed case field names and IPAM plugin fields", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "Master": "ib0",
+        "deviceID": "0000:af:06.1",
+        "ipam": {"type": "host-local", "subnet": "10.55.206.0/26"},
+        "args": {"cni": {"guid": "01:23:45:67:89:ab:cd:ef"}, "k8s": {"namespace": "default"}}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming correct config file - interface sysctls", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "sysctls": {"net.ipv4.conf.<iface>.arp_ignore": "1", "net.ipv6.neigh.<iface>.retrans_time_ms": "500"}
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.Sysctls).To(HaveLen(2))
+		})
+		It("Assuming incorrect config file - global sysctl", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "sysctls": {"net.ipv4.conf.all.forwarding": "1"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - onNameConflict", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "onNameConflict": "replace"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring(`invalid onNameConflict value "replace"`)))
+		})
+		It("Assuming correct config file - PF allowlist", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pfAllowlist": ["ib0", "0000:af:"]
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.PFAllowlist).To(Equal([]string{"ib0", "0000:af:"}))
+		})
+		It("Assuming incorrect config file - empty PF allowlist entry", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pfAllowlist": ["ib0", " "]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring("pfAllowlist entries must not be empty")))
+		})
+		It("Assuming correct config file - guid pool", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guidPool": {"rangeStart": "02:00:00:00:00:00:00:00", "rangeEnd": "02:00:00:00:00:00:ff:ff"}
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.GUIDPool.DataDir).To(Equal(filepath.Join(DefaultCNIDir, "guid-pool")))
+		})
+		It("Assuming correct config file - static guid", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guid": "0123456789ABCDEF"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.StaticGUID).To(Equal("01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming correct config file - default guid reset policy", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.ResetGUIDPolicy).To(Equal(types.ResetGUIDOriginal))
+		})
+		It("Assuming incorrect config file - invalid guid reset policy", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "resetGUIDPolicy": "random"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - static guid with guid pool", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guid": "01:23:45:67:89:ab:cd:ef",
+        "guidPool": {"rangeStart": "02:00:00:00:00:00:00:00", "rangeEnd": "02:00:00:00:00:00:ff:ff"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - guid pool range", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guidPool": {"rangeStart": "02:00:00:00:00:00:ff:ff", "rangeEnd": "02:00:00:00:00:00:00:00"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - IPoIB mac", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mac": "00:00:00:88:FE:80:00:00:00:00:00:00:01:23:45:67:89:AB:CD:EF"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.MAC).To(Equal("00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming incorrect config file - mac derived from guid and given", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "deriveMACFromGUID": true,
+        "runtimeConfig": {"mac": "00:00:00:88:FE:80:00:00:00:00:00:00:01:23:45:67:89:AB:CD:EF"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring("deriveMACFromGUID conflicts with mac")))
+		})
+		It("Assuming incorrect config file - ethernet mac", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mac": "00:11:22:33:44:55"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("IPoIB requires a 20 bytes hardware address"))
+		})
+		It("Assuming correct config file - static neighbors", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "disableArpNd": true,
+        "neighbors": [{"ip": "10.56.217.1", "lladdr": "00:00:00:88:FE:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"}]
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DisableArpNd).To(BeTrue())
+			Expect(netConf.Neighbors[0].LLAddr).To(Equal("00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming incorrect config file - neighbor with ethernet lladdr", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "neighbors": [{"ip": "10.56.217.1", "lladdr": "00:11:22:33:44:55"}]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - neighbor with invalid ip", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "neighbors": [{"ip": "10.56.217", "lladdr": "00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"}]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - mac and ips capabilities", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "runtimeConfig": {
+            "mac": "00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef",
+            "ips": ["10.56.217.10/24", "fd00::10/64"]
         }
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.MAC).To(Equal("00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"))
+			Expect(n.RuntimeConfig.IPs).To(HaveLen(2))
+		})
+		It("Assuming incorrect config file - ips capability with host-local IPAM", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipam": {"type": "host-local", "subnet": "10.56.217.0/24"},
+        "runtimeConfig": {"ips": ["10.56.217.10/24"]}
                         }`)
 			_, err := LoadConf(conf)
 			Expect(err).To(HaveOccurred())
 		})
-	})
-	Context("Checking getVfInfo function", func() {
-		It("Assuming existing PF", func() {
-			_, _, err := getVfInfo("0000:af:06.0")
+		It("Assuming incorrect config file - ips capability without prefix length", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "runtimeConfig": {"ips": ["10.56.217.10"]}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - detected legacy eswitch mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1"
+                        }`)
+			n, err := LoadConf(conf)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(n.PFSwitchdev).NotTo(BeNil())
+			Expect(*n.PFSwitchdev).To(BeFalse())
 		})
-		It("Assuming not existing PF", func() {
-			_, _, err := getVfInfo("0000:af:07.0")
+		It("Assuming correct config file - pfSwitchdev hint", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pfSwitchdev": true
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(*n.PFSwitchdev).To(BeTrue())
+		})
+		It("Assuming incorrect config file - link_state auto in switchdev mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pfSwitchdev": true,
+        "link_state": "auto"
+                        }`)
+			_, err := LoadConf(conf)
 			Expect(err).To(HaveOccurred())
 		})
-	})
+		It("Assuming correct config file - vf name template", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "vfNameTemplate": "ibvf{pf}_{vf}"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming incorrect config file - vf name template too long", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "vfNameTemplate": "ibvf{pf}_{pci}"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - vf name template unknown token", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "vfNameTemplate": "ib{pfname}"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - connected mode with large mtu", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "connected",
+        "mtu": 65520
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.IPoIBMode).To(Equal(types.IPoIBModeConnected))
+		})
+		It("Assuming correct config file - datagram mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "datagram",
+        "mtu": 4092
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming incorrect config file - datagram mode with large mtu", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "datagram",
+        "mtu": 9000
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring("mtu 9000 is invalid in IPoIB datagram mode, the maximum is 4092")))
+		})
+		It("Assuming correct config file - large mtu without ipoib mode", func() {
+			// the VF may already be in connected mode
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mtu": 9000
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.MTU).To(Equal(9000))
+		})
+		It("Assuming correct config file - datagram mtu without ipoib mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "mtu": 4092
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming correct config file - guid source directory", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guidSource": "/run/guids/"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.GUIDSource).To(Equal("/run/guids"))
+		})
+		It("Assuming correct config file - guid source cni-args", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guidSource": "cni-args"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.GUIDSource).To(Equal(types.GUIDSourceCNIArgs))
+		})
+		It("Assuming incorrect config file - relative guid source", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guidSource": "run/guids"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring(`invalid guidSource "run/guids", must be cni-args or an absolute path`)))
+		})
+		It("Assuming incorrect config file - guid source directory with guid", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "guid": "01:23:45:67:89:ab:cd:ef",
+        "guidSource": "/run/guids"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring("guidSource directory can't be combined with guid or guidPool")))
+		})
+		It("Assuming incorrect config file - unknown cache conflict policy", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "onCacheConflict": "ignore"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring(`invalid onCacheConflict value "ignore"`)))
+		})
+		It("Assuming incorrect config file - unknown cache format", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "cacheFormat": "gob"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring(`invalid cacheFormat value "gob"`)))
+		})
+		It("Assuming correct config file - host admin state", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "hostAdminState": "up"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.HostAdminState).To(Equal(types.AdminStateUp))
+		})
+		It("Assuming incorrect config file - unknown host admin state", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "hostAdminState": "enable"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring(`invalid hostAdminState "enable"`)))
+		})
+		It("Assuming incorrect config file - unknown ipoib mode", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipoibMode": "cm"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring(`invalid ipoibMode "cm"`)))
+		})
+		It("Assuming correct config file - interface alias", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ifAlias": "pod {podUID} guid {guid}"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.IfAlias).To(Equal("pod {podUID} guid {guid}"))
+		})
+		It("Assuming incorrect config file - interface alias unknown token", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ifAlias": "pod {podName}"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(MatchError(ContainSubstring("invalid ifAlias")))
+		})
+		It("Assuming correct config file - decimal pkey", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pkey": "32"
+                        }`)
+			n, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.PKey).To(Equal("0x0020"))
+		})
+		It("Assuming incorrect config file - pkey out of range", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pkey": "0xffff"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - cache directory override", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "cniDir": "/tmp/ib-sriov-cni",
+        "guidPool": {"rangeStart": "02:00:00:00:00:00:00:00", "rangeEnd": "02:00:00:00:00:00:00:ff"}
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.CNIDir).To(Equal("/tmp/ib-sriov-cni"))
+			Expect(netConf.GUIDPool.DataDir).To(Equal("/tmp/ib-sriov-cni/guid-pool"))
+		})
+		It("Assuming incorrect config file - relative cache directory", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "cniDir": "cache"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - netnsOverride is not a netns", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "netnsOverride": "/tmp"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - netnsOverride does not exist", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "netnsOverride": "/var/run/netns/ib-sriov-cni-missing"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - negative operationTimeout", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "operationTimeout": -1
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - invalid link_state", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "link_state": "up"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming incorrect config file - invalid trust", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "trust": "yes"
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - pkeys", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pkey": "0x0001",
+        "pkeys": ["2", "0x7FFF"]
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.PKeys).To(Equal([]string{"0x0002", "0x7fff"}))
+		})
+		It("Assuming incorrect config file - duplicate pkeys", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pkey": "0x0001",
+        "pkeys": ["0x0002", "1"]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("duplicate pkey 0x0001"))
+		})
+		It("Assuming incorrect config file - pkeys out of range", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "pkeys": ["0x8000"]
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - tx rate", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "minTxRate": 1000,
+        "maxTxRate": 10000
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming incorrect config file - minTxRate greater than maxTxRate", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "minTxRate": 10000,
+        "maxTxRate": 1000
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - any IPAM type without ipamAllowlist", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipam": {"type": "my-ipam"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming incorrect config file - IPAM type not in ipamAllowlist", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipamAllowlist": ["host-local", "whereabouts"],
+        "ipam": {"type": "my-ipam"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring(`IPAM type "my-ipam" is not in ipamAllowlist`))
+		})
+		It("Assuming correct config file - IPAM type in ipamAllowlist", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "ipamAllowlist": ["host-local", "whereabouts"],
+        "ipam": {"type": "whereabouts"}
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming incorrect config file - broken json", func() {
+			conf := []byte(`{
+        "name": "mynet"
+		"type": "ib-sriov-cni",
+		"deviceID": "0000:af:06.1",
+        "vf": 0,
+        "ipam": {
+            "type": "host-local",
+            "subnet": "10.55.206.0/26",
+            "routes": [
+                { "dst": "0.0.0.0/0" }
+            ],
+            "gateway": "10.55.206.1"
+        }
+                        }`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming correct config file - no cniVersion", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.CNIVersion).To(Equal(current.ImplementedSpecVersion))
+		})
+		DescribeTable("Checking cniVersion", func(version string, supported bool) {
+			conf := []byte(`{
+        "cniVersion": "` + version + `",
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1"
+                        }`)
+			netConf, err := LoadConf(conf)
+			if !supported {
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("unsupported cniVersion"))
+				return
+			}
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.CNIVersion).To(Equal(version))
+		},
+			Entry("0.3.1", "0.3.1", true),
+			Entry("0.4.0", "0.4.0", true),
+			Entry("1.0.0", "1.0.0", false),
+		)
+	})
+	Context("Checking getVfInfo function", func() {
+		It("Assuming existing PF", func() {
+			_, _, err := getVfInfo("0000:af:06.0", 0, nil)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming not existing PF", func() {
+			_, _, err := getVfInfo("0000:af:07.0", 0, nil)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming VF mapped to its PF", func() {
+			pfName, vfID, err := getVfInfo("0000:af:06.1", 0, map[string]string{"0000:af:06.1": "ib0"})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pfName).To(Equal("ib0"))
+			Expect(vfID).To(Equal(1))
+		})
+		It("Assuming VF mapped to another PF", func() {
+			_, _, err := getVfInfo("0000:af:06.1", 0, map[string]string{"0000:af:06.1": "ib3"})
+			Expect(err).To(MatchError(ContainSubstring("VF 0000:af:06.1 mapped to PF ib3 by vfToPFMap")))
+		})
+	})
+	Context("Checking LoadConf vfToPFMap", func() {
+		It("Assuming VF mapped without domain", func() {
+			netConf, err := LoadConf([]byte(`{"name": "mynet", "type": "ib-sriov-cni", "deviceID": "0000:af:06.1",
+				"vfToPFMap": {"AF:06.1": "ib0"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.Master).To(Equal("ib0"))
+			Expect(netConf.VFID).To(Equal(1))
+			Expect(netConf.VFToPFMap).To(Equal(map[string]string{"0000:af:06.1": "ib0"}))
+		})
+		It("Assuming unmapped VF", func() {
+			netConf, err := LoadConf([]byte(`{"name": "mynet", "type": "ib-sriov-cni", "deviceID": "0000:af:06.0",
+				"vfToPFMap": {"0000:af:06.1": "ib0"}}`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.Master).To(Equal("ib0"))
+			Expect(netConf.VFID).To(Equal(0))
+		})
+		DescribeTable("Assuming invalid entries",
+			func(vfToPFMap, expected string) {
+				_, err := LoadConf([]byte(`{"name": "mynet", "type": "ib-sriov-cni", "deviceID": "0000:af:06.0",
+					"vfToPFMap": ` + vfToPFMap + `}`))
+				Expect(err).To(MatchError(ContainSubstring(expected)))
+			},
+			Entry("not a PCI address", `{"ib1": "ib0"}`, `invalid vfToPFMap entry "ib1": not a PCI address`),
+			Entry("not a SR-IOV PF", `{"0000:af:06.0": "ib1"}`, `PF "ib1" is not a SR-IOV PF`),
+			Entry("VF mapped twice", `{"0000:af:06.0": "ib0", "af:06.0": "ib3"}`, "VF 0000:af:06.0 is mapped to both"),
+		)
+	})
+	Context("Checking LoadConf included config", func() {
+		var (
+			dir         string
+			includePath string
+		)
+
+		BeforeEach(func() {
+			var err error
+			dir, err = ioutil.TempDir("", "ib-sriov-cni-include-")
+			Expect(err).NotTo(HaveOccurred())
+			includePath = filepath.Join(dir, "shared.json")
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dir)).To(Succeed())
+		})
+
+		It("Assuming inline fields override the included ones", func() {
+			Expect(ioutil.WriteFile(includePath, []byte(`{
+        "logLevel": "debug",
+        "pfAllowlist": ["ib0"],
+        "cniDir": "/var/lib/cni/shared"
+                        }`), 0600)).To(Succeed())
+			netConf, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "includeConfig": "` + includePath + `",
+        "CNIDir": "/var/lib/cni/mynet"
+                        }`))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.LogLevel).To(Equal("debug"))
+			Expect(netConf.PFAllowlist).To(Equal([]string{"ib0"}))
+			Expect(netConf.CNIDir).To(Equal("/var/lib/cni/mynet"))
+		})
+		It("Assuming cache directory from the included config", func() {
+			Expect(ioutil.WriteFile(includePath, []byte(`{"cniDir": "/var/lib/cni/shared"}`), 0600)).To(Succeed())
+			Expect(CacheDir([]byte(`{"name": "mynet", "includeConfig": "` + includePath + `"}`))).To(Equal("/var/lib/cni/shared"))
+		})
+		It("Assuming netns override from the included config", func() {
+			Expect(ioutil.WriteFile(includePath, []byte(`{"netnsOverride": "/var/run/netns/shared"}`), 0600)).To(Succeed())
+			Expect(NetnsOverride([]byte(`{"name": "mynet", "includeConfig": "` + includePath + `"}`))).To(Equal("/var/run/netns/shared"))
+			Expect(NetnsOverride([]byte(`{"name": "mynet"}`))).To(BeEmpty())
+		})
+		It("Assuming deviceID from the included config", func() {
+			Expect(ioutil.WriteFile(includePath, []byte(`{"deviceID": "AF:06.1"}`), 0600)).To(Succeed())
+			Expect(DeviceID([]byte(`{"name": "mynet", "includeConfig": "` + includePath + `"}`))).To(Equal("0000:af:06.1"))
+			Expect(DeviceID([]byte(`{"name": "mynet", "master": "ib0"}`))).To(BeEmpty())
+		})
+		It("Assuming missing included config", func() {
+			_, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "includeConfig": "` + includePath + `"
+                        }`))
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+		It("Assuming included config with invalid fields", func() {
+			Expect(ioutil.WriteFile(includePath, []byte(`{"logLevel": 4, "unknown": true}`), 0600)).To(Succeed())
+			_, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "includeConfig": "` + includePath + `"
+                        }`))
+			Expect(errors.Is(err, ErrInvalidNetConf)).To(BeTrue())
+			Expect(err.Error()).To(HaveSuffix(`includeConfig: invalid netconf: field "logLevel" has wrong type number, expected string`))
+		})
+		It("Assuming included config setting ipam", func() {
+			Expect(ioutil.WriteFile(includePath, []byte(`{"ipam": {"type": "host-local"}}`), 0600)).To(Succeed())
+			_, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "includeConfig": "` + includePath + `"
+                        }`))
+			Expect(err).To(MatchError(ContainSubstring("can't set the ipam fields")))
+		})
+		It("Assuming relative included config path", func() {
+			_, err := LoadConf([]byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "deviceID": "0000:af:06.1",
+        "includeConfig": "shared.json"
+                        }`))
+			Expect(err).To(MatchError(ContainSubstring("must be an absolute path")))
+		})
+	})
+	Context("Checking LoadConf free VF selection", func() {
+		var (
+			cacheDir        string
+			originalLockDir string
+			conf            []byte
+		)
+
+		// loadConcurrently runs n LoadConf of conf concurrently
+		loadConcurrently := func(conf []byte, n int) ([]string, []error) {
+			var (
+				mu        sync.Mutex
+				wg        sync.WaitGroup
+				deviceIDs []string
+				errs      []error
+			)
+			for i := 0; i < n; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					netConf, err := LoadConf(conf)
+					mu.Lock()
+					defer mu.Unlock()
+					if err != nil {
+						errs = append(errs, err)
+						return
+					}
+					deviceIDs = append(deviceIDs, netConf.DeviceID)
+				}()
+			}
+			wg.Wait()
+			return deviceIDs, errs
+		}
+
+		BeforeEach(func() {
+			var err error
+			cacheDir, err = ioutil.TempDir("", "ib-sriov-cni-cache-")
+			Expect(err).NotTo(HaveOccurred())
+			originalLockDir = DefaultLockDir
+			DefaultLockDir = filepath.Join(cacheDir, "locks")
+			conf = []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "master": "ib0",
+        "cniDir": "` + cacheDir + `"
+                        }`)
+		})
+
+		AfterEach(func() {
+			DefaultLockDir = originalLockDir
+			Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		})
+
+		It("Assuming free VFs", func() {
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DeviceID).To(Equal("0000:af:06.0"))
+			Expect(netConf.VFID).To(Equal(0))
+		})
+		It("Assuming first VF configured", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net1"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DeviceID).To(Equal("0000:af:06.1"))
+			Expect(netConf.VFID).To(Equal(1))
+		})
+		It("Assuming all VFs configured", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net1"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net2"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.1"}`), 0600)).To(Succeed())
+			_, err := LoadConf(conf)
+			Expect(errors.Is(err, ErrNoFreeVF)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("no free VF on PF ib0, all 2 VFs are in use"))
+		})
+		It("Assuming concurrent picks", func() {
+			deviceIDs, errs := loadConcurrently(conf, 4)
+			Expect(deviceIDs).To(ConsistOf("0000:af:06.0", "0000:af:06.1"))
+			Expect(errs).To(HaveLen(2))
+			for _, err := range errs {
+				Expect(errors.Is(err, ErrNoFreeVF)).To(BeTrue())
+			}
+		})
+		It("Assuming released and expired claims", func() {
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DeviceID).To(Equal("0000:af:06.0"))
+			Expect(ReleaseVFClaim(cacheDir, netConf.DeviceID)).To(Succeed())
+			Expect(ReleaseVFClaim(cacheDir, netConf.DeviceID)).To(Succeed())
+
+			netConf, err = LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DeviceID).To(Equal("0000:af:06.0"))
+			past := time.Now().Add(-2 * vfClaimTTL)
+			Expect(os.Chtimes(filepath.Join(cacheDir, vfClaimsDir, "0000:af:06.0"), past, past)).To(Succeed())
+
+			netConf, err = LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DeviceID).To(Equal("0000:af:06.0"))
+		})
+		It("Assuming invalid config with a free VF", func() {
+			conf = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "master": "ib0", "trust": "maybe", "cniDir": "` + cacheDir + `"}`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("invalid trust value: maybe"))
+			_, err = os.Stat(filepath.Join(cacheDir, vfClaimsDir, "0000:af:06.0"))
+			Expect(os.IsNotExist(err)).To(BeTrue(), "the claim of a rejected netconf should be released")
+		})
+		It("Assuming VF quota reached", func() {
+			conf = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "master": "ib0", "maxVFsPerPF": 1, "cniDir": "` + cacheDir + `"}`)
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net1"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			_, err := LoadConf(conf)
+			Expect(errors.Is(err, ErrVFQuotaReached)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("VF quota reached on PF ib0, 1 of the maxVFsPerPF 1 VFs are in use"))
+		})
+		It("Assuming concurrent picks with a VF quota", func() {
+			conf = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "master": "ib0", "maxVFsPerPF": 1, "cniDir": "` + cacheDir + `"}`)
+			deviceIDs, errs := loadConcurrently(conf, 4)
+			Expect(deviceIDs).To(HaveLen(1))
+			Expect(errs).To(HaveLen(3))
+			for _, err := range errs {
+				Expect(errors.Is(err, ErrVFQuotaReached)).To(BeTrue())
+			}
+		})
+		It("Assuming PF usage", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net1"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			Expect(GetPFUsage("ib0", cacheDir)).To(Equal(PFUsage{NumVFs: 2, InUse: 1, Free: 1}))
+		})
+		It("Assuming PF usage with an expired claim", func() {
+			Expect(claimVF(cacheDir, "0000:af:06.1")).To(Succeed())
+			claim := filepath.Join(cacheDir, vfClaimsDir, "0000:af:06.1")
+			past := time.Now().Add(-2 * vfClaimTTL)
+			Expect(os.Chtimes(claim, past, past)).To(Succeed())
+
+			Expect(GetPFUsage("ib0", cacheDir)).To(Equal(PFUsage{NumVFs: 2, InUse: 0, Free: 2}))
+			// reading the usage leaves the cache directory untouched
+			_, err := os.Stat(claim)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming enumeration worker count", func() {
+			enumerator := &workersRecorder{VFEnumerator: vfEnumerator}
+			SetVFEnumerator(enumerator)
+			defer SetVFEnumerator(enumerator.VFEnumerator)
+
+			conf = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "master": "ib0", "enumerateWorkers": 2, "cniDir": "` + cacheDir + `"}`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.DeviceID).To(Equal("0000:af:06.0"))
+			Expect(enumerator.workers).To(Equal(2))
+		})
+		It("Assuming negative enumeration worker count", func() {
+			conf = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "master": "ib0", "enumerateWorkers": -1, "cniDir": "` + cacheDir + `"}`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming negative VF quota", func() {
+			conf = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "master": "ib0", "maxVFsPerPF": -1, "cniDir": "` + cacheDir + `"}`)
+			_, err := LoadConf(conf)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking LoadConf master normalization", func() {
+		var cacheDir string
+
+		BeforeEach(func() {
+			var err error
+			cacheDir, err = ioutil.TempDir("", "ib-sriov-cni-cache-")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		})
+
+		DescribeTable("master forms",
+			func(master string) {
+				conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "master": ` + strconv.Quote(master) + `,
+        "cniDir": "` + cacheDir + `"
+                        }`)
+				netConf, err := LoadConf(conf)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(netConf.Master).To(Equal("ib0"))
+				Expect(netConf.PFPciAddress).To(Equal("0000:af:00.1"))
+				Expect(netConf.DeviceID).To(Equal("0000:af:06.0"))
+			},
+			Entry("PF name", "ib0"),
+			Entry("PF name with trailing newline", "ib0\n"),
+			Entry("PF name with surrounding spaces", "  ib0 "),
+			Entry("PF pci address", "0000:af:00.1"),
+			Entry("PF pci address without domain", "af:00.1"),
+			Entry("PF pci address in upper case with trailing newline", "0000:AF:00.1\n"),
+		)
+
+		DescribeTable("malformed master",
+			func(master, expected string) {
+				conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "master": ` + strconv.Quote(master) + `,
+        "cniDir": "` + cacheDir + `"
+                        }`)
+				_, err := LoadConf(conf)
+				Expect(err).To(MatchError(ContainSubstring(expected)))
+			},
+			Entry("whitespace only", " \n", "invalid master"),
+			Entry("name too long", "ib0123456789abcdef", "invalid master"),
+			Entry("name with a path separator", "../ib0", "invalid master"),
+			Entry("incomplete pci address", "0000:af:00", "invalid master"),
+			Entry("pci address of no PF", "0000:3b:00.0", "no PF network device with PCI address 0000:3b:00.0"),
+		)
+
+		It("Assuming VF pci address with master", func() {
+			conf := []byte(`{
+        "name": "mynet",
+        "type": "ib-sriov-cni",
+        "master": "0000:af:00.1",
+        "deviceID": "0000:af:06.1"
+                        }`)
+			netConf, err := LoadConf(conf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netConf.Master).To(Equal("ib0"))
+			Expect(netConf.PFPciAddress).To(Equal("0000:af:00.1"))
+			Expect(netConf.VFID).To(Equal(1))
+		})
+	})
+	Context("Checking NetConfFields function", func() {
+		It("Assuming network configuration and cached state fields", func() {
+			fields := NetConfFields()
+			Expect(fields).To(ContainElement("mtu"))
+			Expect(fields).To(ContainElement("pkeys"))
+			Expect(fields).To(ContainElement("ipam"))
+			Expect(fields).NotTo(ContainElement("AddedPKeys"))
+			Expect(fields).NotTo(ContainElement("HostVFConfig"))
+			Expect(fields).NotTo(ContainElement("AllocatedGUID"))
+		})
+	})
+	Context("Checking LoadConfFromCache function", func() {
+		var (
+			cacheDir      string
+			originalDir   string
+			args          *skel.CmdArgs
+			cachedNetConf string
+		)
+
+		BeforeEach(func() {
+			var err error
+			cacheDir, err = ioutil.TempDir("", "ib-sriov-cni-cache-")
+			Expect(err).NotTo(HaveOccurred())
+			originalDir = DefaultCNIDir
+			DefaultCNIDir = cacheDir
+			args = &skel.CmdArgs{ContainerID: "cid", IfName: "net1"}
+			cachedNetConf = filepath.Join(cacheDir, "cid-net1")
+		})
+
+		AfterEach(func() {
+			DefaultCNIDir = originalDir
+			Expect(os.RemoveAll(cacheDir)).To(Succeed())
+		})
+
+		It("Assuming complete cache file", func() {
+			Expect(ioutil.WriteFile(cachedNetConf, []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			n, cRefPath, err := LoadConfFromCache(args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cRefPath).To(Equal(cachedNetConf))
+			Expect(n.Master).To(Equal("ib0"))
+		})
+		It("Assuming old format cache file", func() {
+			Expect(ioutil.WriteFile(cachedNetConf, []byte(`{"Master":"ib0","deviceID":"0000:af:06.0","link_state":"enable"}`), 0600)).To(Succeed())
+			n, _, err := LoadConfFromCache(args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.CacheVersion).To(Equal(CacheVersion))
+			Expect(n.LinkState).To(Equal("enable"))
+			Expect(n.HostIFLinkState).To(Equal(""))
+		})
+		It("Assuming new format cache file", func() {
+			Expect(ioutil.WriteFile(cachedNetConf, []byte(`{"Master":"ib0","deviceID":"0000:af:06.0","link_state":"enable",`+
+				`"HostIFLinkState":"disable","HostIFGUID":"01:23:45:67:89:ab:cd:ef","HostIFMTU":2044,"cacheVersion":1}`), 0600)).To(Succeed())
+			n, _, err := LoadConfFromCache(args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(n.CacheVersion).To(Equal(CacheVersion))
+			Expect(n.HostIFLinkState).To(Equal("disable"))
+			Expect(n.HostIFGUID).To(Equal("01:23:45:67:89:ab:cd:ef"))
+			Expect(n.HostIFMTU).To(Equal(2044))
+		})
+		It("Assuming cache file from a newer version", func() {
+			Expect(ioutil.WriteFile(cachedNetConf, []byte(`{"Master":"ib0","cacheVersion":100}`), 0600)).To(Succeed())
+			_, _, err := LoadConfFromCache(args)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming missing cache file", func() {
+			_, _, err := LoadConfFromCache(args)
+			Expect(errors.Is(err, ErrNetConfCacheNotFound)).To(BeTrue())
+		})
+		It("Assuming cache directory override", func() {
+			overrideDir := filepath.Join(cacheDir, "override")
+			Expect(os.Mkdir(overrideDir, 0700)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(overrideDir, "cid-net1"), []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			args.StdinData = []byte(`{"name": "mynet", "type": "ib-sriov-cni", "cniDir": "` + overrideDir + `"}`)
+			n, cRefPath, err := LoadConfFromCache(args)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cRefPath).To(Equal(filepath.Join(overrideDir, "cid-net1")))
+			Expect(n.Master).To(Equal("ib0"))
+		})
+		It("Assuming cache directory listing", func() {
+			Expect(ioutil.WriteFile(cachedNetConf, []byte(`{"Master":"ib0","deviceID":"0000:af:06.0"}`), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net2"), []byte(`{"Master":"ib0","devi`), 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "cid-net3.tmp123"), []byte(`{}`), 0600)).To(Succeed())
+			Expect(os.Mkdir(filepath.Join(cacheDir, "guid-pool"), 0700)).To(Succeed())
+			cached, err := ListCachedNetConfs(cacheDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(cached).To(HaveLen(2))
+			Expect(cached[0].Path).To(Equal(cachedNetConf))
+			Expect(cached[0].NetConf.Master).To(Equal("ib0"))
+			Expect(cached[1].NetConf).To(BeNil())
+			Expect(cached[1].Err).To(HaveOccurred())
+		})
+		It("Assuming partially written cache file", func() {
+			Expect(ioutil.WriteFile(cachedNetConf, []byte(`{"Master":"ib0","devi`), 0600)).To(Succeed())
+			_, _, err := LoadConfFromCache(args)
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrNetConfCacheNotFound)).To(BeFalse())
+			Expect(err.Error()).To(ContainSubstring("failed to parse NetConf"))
+		})
+	})
 })
+
+// workersRecorder records the worker count the VFs are enumerated with
+type workersRecorder struct {
+	types.VFEnumerator
+	workers int
+}
+
+func (r *workersRecorder) EnumerateVFs(pfName string, workers int) ([]types.VFState, error) {
+	r.workers = workers
+	return r.VFEnumerator.EnumerateVFs(pfName, workers)
+}
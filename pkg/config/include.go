@@ -0,0 +1,81 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// includeConfigField is the netconf field giving the path of the file with the shared settings of the network
+const includeConfigField = "includeconfig"
+
+// notIncludableFields can't be set by the included file: IPAM plugins read the ipam block from the network
+// configuration given by the runtime, the other fields are set by the runtime
+var notIncludableFields = map[string]bool{
+	"ipam":          true,
+	"args":          true,
+	"runtimeconfig": true,
+	"prevresult":    true,
+	"includeconfig": true,
+}
+
+// mergeIncludedConfig returns the network configuration with the top level fields of the file given by its
+// includeConfig field merged underneath, the fields of the network configuration override the included ones.
+// The network configuration is returned unchanged when it has no includeConfig.
+func mergeIncludedConfig(data []byte) ([]byte, error) {
+	inline := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &inline); err != nil {
+		return nil, err
+	}
+
+	var path string
+	for name, value := range inline {
+		if strings.ToLower(name) != includeConfigField {
+			continue
+		}
+		if err := json.Unmarshal(value, &path); err != nil {
+			return nil, fmt.Errorf("invalid includeConfig: %w", err)
+		}
+	}
+	if path == "" {
+		return data, nil
+	}
+	if !filepath.IsAbs(path) {
+		return nil, fmt.Errorf("invalid includeConfig %q, must be an absolute path", path)
+	}
+
+	includedData, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read includeConfig: %w", err)
+	}
+	included := map[string]json.RawMessage{}
+	if err := json.Unmarshal(includedData, &included); err != nil {
+		return nil, fmt.Errorf("failed to parse includeConfig %s: %w", path, err)
+	}
+
+	// fields are matched case-insensitively like encoding/json does
+	inlineNames := map[string]bool{}
+	for name := range inline {
+		inlineNames[strings.ToLower(name)] = true
+	}
+	var rejected []string
+	for name, value := range included {
+		lower := strings.ToLower(name)
+		if notIncludableFields[lower] {
+			rejected = append(rejected, name)
+			continue
+		}
+		if !inlineNames[lower] {
+			inline[name] = value
+		}
+	}
+	if len(rejected) > 0 {
+		sort.Strings(rejected)
+		return nil, fmt.Errorf("includeConfig %s can't set the %s fields", path, strings.Join(rejected, ", "))
+	}
+
+	return json.Marshal(inline)
+}
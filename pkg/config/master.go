@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+)
+
+// normalizeMaster returns the PF network device name of the master of the network configuration. The master may be
+// given as the PF name or the PF PCI address, with or without its domain, and is trimmed of surrounding whitespace
+// left by templating.
+func normalizeMaster(master string) (string, error) {
+	master = strings.TrimSpace(master)
+	if pciAddr, ok := utils.NormalizePciAddress(master); ok {
+		pfName, err := utils.GetVFLinkNames(pciAddr)
+		if err != nil || pfName == "" {
+			return "", fmt.Errorf("no PF network device with PCI address %s: %w", pciAddr, err)
+		}
+		return pfName, nil
+	}
+
+	if master == "" || len(master) > types.MaxIfNameLen || master == "." || master == ".." ||
+		strings.ContainsAny(master, "/: \t\n") {
+		return "", fmt.Errorf("invalid master %q, must be a PF network device name or PCI address", master)
+	}
+	return master, nil
+}
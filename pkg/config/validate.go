@@ -0,0 +1,199 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+)
+
+// ErrInvalidNetConf is returned when fields of the netconf are of the wrong type or out of range
+var ErrInvalidNetConf = errors.New("invalid netconf")
+
+// netConfField is a field of the netconf JSON object
+type netConfField struct {
+	typ reflect.Type
+	// strict reports unknown nested fields, fields owned by other plugins or the runtime are not strict
+	strict bool
+}
+
+// lenientFields are decoded without reporting unknown nested fields: the ipam block is owned by the IPAM plugin,
+// args and runtimeConfig are set by the runtime
+var lenientFields = map[string]bool{"ipam": true, "args": true, "runtimeconfig": true, "prevresult": true}
+
+// fieldRanges validate numeric fields keyed by their lower case name, a zero value means the field is not set
+var fieldRanges = map[string]func(v int) string{
+	"mtu": func(v int) string {
+		if v != 0 && (v < minIPoIBMTU || v > maxIPoIBMTU) {
+			return fmt.Sprintf("must be in range %d-%d", minIPoIBMTU, maxIPoIBMTU)
+		}
+		return ""
+	},
+	"mintxrate":        notNegative,
+	"maxtxrate":        notNegative,
+	"linkuptimeout":    notNegative,
+	"operationtimeout": notNegative,
+	"retryattempts":    notNegative,
+	"retryinterval":    notNegative,
+	"topologycachettl": notNegative,
+	"drainperiod":      notNegative,
+	"tracemaxsize":     notNegative,
+	"maxvfsperpf":      notNegative,
+	"enumerateworkers": notNegative,
+	"addretryattempts": notNegative,
+	"addretryinterval": notNegative,
+}
+
+func notNegative(v int) string {
+	if v < 0 {
+		return "must not be negative"
+	}
+	return ""
+}
+
+// netConfFields returns the fields of the netconf JSON object keyed by their lower case name, the JSON field names
+// are matched case-insensitively like encoding/json does
+func netConfFields() map[string]netConfField {
+	fields := map[string]netConfField{}
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.Anonymous && tag == "" {
+				collect(f.Type)
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if name == "-" || f.PkgPath != "" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			name = strings.ToLower(name)
+			fields[name] = netConfField{typ: f.Type, strict: !lenientFields[name]}
+		}
+	}
+	collect(reflect.TypeOf(types.NetConf{}))
+	return fields
+}
+
+// NetConfFields returns the JSON names of the network configuration fields of the cached NetConf, sorted. The fields
+// without a JSON name are the state recorded by the plugin, e.g. the original VF config.
+func NetConfFields() []string {
+	var names []string
+	var collect func(t reflect.Type)
+	collect = func(t reflect.Type) {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			if f.Anonymous && tag == "" {
+				collect(f.Type)
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if name == "" || name == "-" || f.PkgPath != "" {
+				continue
+			}
+			names = append(names, name)
+		}
+	}
+	collect(reflect.TypeOf(types.NetConf{}))
+	sort.Strings(names)
+	return names
+}
+
+// validateNetConf checks the netconf field by field and returns all the fields of the wrong type and numeric fields
+// out of range at once. Unknown fields are logged as warnings only, deployed configurations may carry stray fields,
+// e.g. of an older version.
+func validateNetConf(data []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(raw))
+	for name := range raw {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	known := netConfFields()
+	var problems []string
+	for _, name := range names {
+		field, ok := known[strings.ToLower(name)]
+		if !ok {
+			logging.Warningf("LoadConf(): ignoring unknown field %q", name)
+			continue
+		}
+
+		value := reflect.New(field.typ)
+		if field.strict {
+			dec := json.NewDecoder(bytes.NewReader(raw[name]))
+			dec.DisallowUnknownFields()
+			if err := dec.Decode(value.Interface()); err != nil && isUnknownFieldError(err) {
+				logging.Warningf("LoadConf(): ignoring %s", fieldProblem(name, err))
+			}
+		}
+		if err := json.Unmarshal(raw[name], value.Interface()); err != nil {
+			problems = append(problems, fieldProblem(name, err))
+			continue
+		}
+
+		if check, ok := fieldRanges[strings.ToLower(name)]; ok {
+			if problem := check(int(value.Elem().Int())); problem != "" {
+				problems = append(problems, fmt.Sprintf("field %q value %d %s", name, value.Elem().Int(), problem))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidNetConf, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// isUnknownFieldError tells whether a decoding error is an unknown field of a decoder which disallows them, the json
+// package has no error type for it
+func isUnknownFieldError(err error) bool {
+	return strings.HasPrefix(err.Error(), "json: unknown field ")
+}
+
+// fieldProblem describes the decoding error of a netconf field
+func fieldProblem(name string, err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		if typeErr.Field != "" {
+			name = name + "." + typeErr.Field
+		}
+		return fmt.Sprintf("field %q has wrong type %s, expected %s", name, typeErr.Value, jsonType(typeErr.Type))
+	}
+	return fmt.Sprintf("field %q is invalid: %s", name, strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// jsonType returns the JSON type a Go type is decoded from
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonType(t.Elem())
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "integer"
+	case reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	default:
+		return "object"
+	}
+}
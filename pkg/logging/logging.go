@@ -0,0 +1,116 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Level type
+type Level uint32
+
+// Supported logging levels, a message is logged when its level is lower or equal to the configured level
+const (
+	PanicLevel Level = iota
+	ErrorLevel
+	WarningLevel
+	InfoLevel
+	DebugLevel
+)
+
+const defaultTimestampFormat = time.RFC3339
+
+var levelNames = []string{"panic", "error", "warning", "info", "debug"}
+
+var (
+	logLevel = ErrorLevel
+	// logs are written to stderr by default, stdout is reserved for the CNI result
+	logWriter io.Writer = os.Stderr
+	logFile   *os.File
+)
+
+func (l Level) String() string {
+	if int(l) < len(levelNames) {
+		return levelNames[l]
+	}
+	return "unknown"
+}
+
+// ParseLevel returns the logging level of a given level name
+func ParseLevel(levelStr string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(levelStr, name) {
+			return Level(i), nil
+		}
+	}
+	return ErrorLevel, fmt.Errorf("unknown log level %q, supported levels: %s", levelStr, strings.Join(levelNames, ", "))
+}
+
+// SetLogLevel sets the logging level, an empty level keeps the current one
+func SetLogLevel(levelStr string) error {
+	if levelStr == "" {
+		return nil
+	}
+	level, err := ParseLevel(levelStr)
+	if err != nil {
+		return err
+	}
+	logLevel = level
+	return nil
+}
+
+// SetLogFile redirects the logs to the given file, an empty filename keeps the current destination
+func SetLogFile(filename string) error {
+	if filename == "" {
+		return nil
+	}
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file %q: %w", filename, err)
+	}
+	if logFile != nil {
+		_ = logFile.Close()
+	}
+	logFile = f
+	logWriter = f
+	return nil
+}
+
+func printf(level Level, format string, a ...interface{}) {
+	if level > logLevel {
+		return
+	}
+	header := "%s [%s] "
+	fmt.Fprintf(logWriter, header, time.Now().Format(defaultTimestampFormat), level)
+	fmt.Fprintf(logWriter, format, a...)
+	fmt.Fprintf(logWriter, "\n")
+}
+
+// Debugf prints a debug level log message
+func Debugf(format string, a ...interface{}) {
+	printf(DebugLevel, format, a...)
+}
+
+// Infof prints an info level log message
+func Infof(format string, a ...interface{}) {
+	printf(InfoLevel, format, a...)
+}
+
+// Warningf prints a warning level log message
+func Warningf(format string, a ...interface{}) {
+	printf(WarningLevel, format, a...)
+}
+
+// Errorf prints an error level log message and returns it as an error
+func Errorf(format string, a ...interface{}) error {
+	printf(ErrorLevel, format, a...)
+	return fmt.Errorf(format, a...)
+}
+
+// Panicf prints a panic level log message and panics
+func Panicf(format string, a ...interface{}) {
+	printf(PanicLevel, format, a...)
+	panic(fmt.Sprintf(format, a...))
+}
@@ -0,0 +1,84 @@
+package logging
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Logging", func() {
+	var (
+		buf           *bytes.Buffer
+		originalLevel Level
+	)
+
+	BeforeEach(func() {
+		buf = &bytes.Buffer{}
+		logWriter = buf
+		originalLevel = logLevel
+	})
+
+	AfterEach(func() {
+		if logFile != nil {
+			_ = logFile.Close()
+			logFile = nil
+		}
+		logWriter = os.Stderr
+		logLevel = originalLevel
+	})
+
+	Context("Checking SetLogLevel function", func() {
+		It("Assuming valid level", func() {
+			Expect(SetLogLevel("debug")).To(Succeed())
+			Expect(logLevel).To(Equal(DebugLevel))
+		})
+		It("Assuming empty level", func() {
+			Expect(SetLogLevel("info")).To(Succeed())
+			Expect(SetLogLevel("")).To(Succeed())
+			Expect(logLevel).To(Equal(InfoLevel))
+		})
+		It("Assuming invalid level", func() {
+			Expect(SetLogLevel("verbose")).NotTo(Succeed())
+		})
+	})
+	Context("Checking log message filtering", func() {
+		It("Assuming message above configured level", func() {
+			Expect(SetLogLevel("error")).To(Succeed())
+			Debugf("debug message")
+			Expect(buf.String()).To(BeEmpty())
+		})
+		It("Assuming message at configured level", func() {
+			Expect(SetLogLevel("warning")).To(Succeed())
+			Warningf("warning %s", "message")
+			Expect(buf.String()).To(ContainSubstring("[warning] warning message"))
+		})
+		It("Assuming error message", func() {
+			err := Errorf("failed %d", 1)
+			Expect(err).To(MatchError("failed 1"))
+			Expect(buf.String()).To(ContainSubstring("[error] failed 1"))
+		})
+	})
+	Context("Checking SetLogFile function", func() {
+		It("Assuming writable file", func() {
+			dir, err := ioutil.TempDir("", "ib-sriov-cni-logging-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+
+			logPath := filepath.Join(dir, "ib-sriov-cni.log")
+			Expect(SetLogFile(logPath)).To(Succeed())
+			Expect(SetLogLevel("info")).To(Succeed())
+			Infof("info message")
+
+			data, err := ioutil.ReadFile(logPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(ContainSubstring("[info] info message"))
+		})
+		It("Assuming not existing directory", func() {
+			Expect(SetLogFile("/not/existing/dir/ib-sriov-cni.log")).NotTo(Succeed())
+		})
+	})
+})
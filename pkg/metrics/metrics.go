@@ -0,0 +1,207 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Stages of a CNI command, a failed command is counted under the stage it failed in
+const (
+	StageConfig  = "config"
+	StageApply   = "apply"
+	StageSetup   = "setup"
+	StageIPAM    = "ipam"
+	StageHook    = "hook"
+	StageCache   = "cache"
+	StageRelease = "release"
+	StageReset   = "reset"
+)
+
+const (
+	operationsTotal = "ib_sriov_cni_operations_total"
+	failuresTotal   = "ib_sriov_cni_operation_failures_total"
+	durationSum     = "ib_sriov_cni_operation_duration_seconds_sum"
+	durationCount   = "ib_sriov_cni_operation_duration_seconds_count"
+	durationFamily  = "ib_sriov_cni_operation_duration_seconds"
+	verifications   = "ib_sriov_cni_teardown_verifications_total"
+
+	// socketTimeout bounds the time spent writing a sample to a metrics socket
+	socketTimeout = 100 * time.Millisecond
+)
+
+// ErrBusy is returned when the metrics file is locked by another plugin invocation, the sample is dropped
+var ErrBusy = errors.New("metrics file is locked")
+
+var families = []struct{ name, kind, help string }{
+	{operationsTotal, "counter", "CNI operations by command and result."},
+	{failuresTotal, "counter", "Failed CNI operations by command and stage."},
+	{durationFamily, "summary", "Duration of CNI operations in seconds."},
+	{verifications, "counter", "Verifications of the VF state after DEL by result."},
+}
+
+// Outcomes of the verification of the VF state after the teardown, recorded with the DEL sample
+const (
+	VerificationPassed = "passed"
+	VerificationFailed = "failed"
+)
+
+// Sample is the outcome of a single CNI command
+type Sample struct {
+	Command  string
+	Stage    string
+	Duration time.Duration
+	Err      error
+	// Verification outcome of the teardown, empty when the teardown was not verified
+	Verification string
+}
+
+// values returns the metric values of the sample keyed by the metric name and labels
+func (s Sample) values() map[string]float64 {
+	result := "success"
+	if s.Err != nil {
+		result = "failure"
+	}
+	values := map[string]float64{
+		fmt.Sprintf("%s{command=%q,result=%q}", operationsTotal, s.Command, result): 1,
+		fmt.Sprintf("%s{command=%q}", durationSum, s.Command):                       s.Duration.Seconds(),
+		fmt.Sprintf("%s{command=%q}", durationCount, s.Command):                     1,
+	}
+	if s.Err != nil {
+		values[fmt.Sprintf("%s{command=%q,stage=%q}", failuresTotal, s.Command, s.Stage)] = 1
+	}
+	if s.Verification != "" {
+		values[fmt.Sprintf("%s{result=%q}", verifications, s.Verification)] = 1
+	}
+	return values
+}
+
+// Record adds the sample to the metrics at path. When path is a unix socket the sample values are written to it,
+// otherwise the metrics file at path is updated. An empty path disables metrics.
+// Record never waits on other plugin invocations, it returns ErrBusy instead.
+func Record(path string, s Sample) error {
+	if path == "" {
+		return nil
+	}
+
+	if fi, err := os.Stat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		return writeSocket(path, s.values())
+	}
+	return updateFile(path, s.values())
+}
+
+func writeSocket(path string, values map[string]float64) error {
+	conn, err := net.DialTimeout("unix", path, socketTimeout)
+	if err != nil {
+		return fmt.Errorf("failed to connect to metrics socket %s: %w", path, err)
+	}
+	defer conn.Close()
+
+	if err := conn.SetWriteDeadline(time.Now().Add(socketTimeout)); err != nil {
+		return err
+	}
+	if _, err := conn.Write(format(values)); err != nil {
+		return fmt.Errorf("failed to write to metrics socket %s: %w", path, err)
+	}
+	return nil
+}
+
+func updateFile(path string, values map[string]float64) error {
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open metrics lock file: %w", err)
+	}
+	defer lock.Close()
+
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		if err == syscall.EWOULDBLOCK {
+			return ErrBusy
+		}
+		return fmt.Errorf("failed to lock metrics file: %w", err)
+	}
+	defer func() { _ = syscall.Flock(int(lock.Fd()), syscall.LOCK_UN) }()
+
+	current := map[string]float64{}
+	data, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read metrics file %s: %w", path, err)
+	}
+	if err == nil {
+		current = parse(data)
+	}
+	for k, v := range values {
+		current[k] += v
+	}
+
+	// write to a temporary file and rename so that a scraper never reads a partial file
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create metrics file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(format(current)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write metrics file: %w", err)
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// parse reads metric values in Prometheus text format, unknown lines are dropped
+func parse(data []byte) map[string]float64 {
+	values := map[string]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		idx := strings.LastIndex(line, " ")
+		if idx < 0 {
+			continue
+		}
+		v, err := strconv.ParseFloat(line[idx+1:], 64)
+		if err != nil {
+			continue
+		}
+		values[line[:idx]] = v
+	}
+	return values
+}
+
+// format writes metric values in Prometheus text format, grouped by metric family
+func format(values map[string]float64) []byte {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	buf := &bytes.Buffer{}
+	for _, f := range families {
+		fmt.Fprintf(buf, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.kind)
+		for _, k := range keys {
+			if strings.HasPrefix(k, f.name) {
+				fmt.Fprintf(buf, "%s %s\n", k, strconv.FormatFloat(values[k], 'g', -1, 64))
+			}
+		}
+	}
+	return buf.Bytes()
+}
@@ -0,0 +1,102 @@
+package metrics
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Metrics", func() {
+	var (
+		dir  string
+		path string
+	)
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ib-sriov-cni-metrics")
+		Expect(err).NotTo(HaveOccurred())
+		path = filepath.Join(dir, "metrics.prom")
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Context("Checking Record function", func() {
+		It("Assuming empty path", func() {
+			Expect(Record("", Sample{Command: "add"})).To(Succeed())
+		})
+		It("Assuming metrics file", func() {
+			Expect(Record(path, Sample{Command: "add", Stage: StageCache, Duration: time.Second})).To(Succeed())
+			Expect(Record(path, Sample{Command: "add", Stage: StageApply, Duration: time.Second, Err: errors.New("failed")})).To(Succeed())
+			Expect(Record(path, Sample{Command: "del", Stage: StageReset, Duration: 500 * time.Millisecond})).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			values := parse(data)
+			Expect(values).To(Equal(map[string]float64{
+				`ib_sriov_cni_operations_total{command="add",result="success"}`:      1,
+				`ib_sriov_cni_operations_total{command="add",result="failure"}`:      1,
+				`ib_sriov_cni_operations_total{command="del",result="success"}`:      1,
+				`ib_sriov_cni_operation_failures_total{command="add",stage="apply"}`: 1,
+				`ib_sriov_cni_operation_duration_seconds_sum{command="add"}`:         2,
+				`ib_sriov_cni_operation_duration_seconds_count{command="add"}`:       2,
+				`ib_sriov_cni_operation_duration_seconds_sum{command="del"}`:         0.5,
+				`ib_sriov_cni_operation_duration_seconds_count{command="del"}`:       1,
+			}))
+			Expect(string(data)).To(ContainSubstring("# TYPE ib_sriov_cni_operations_total counter\n"))
+		})
+		It("Assuming metrics file is locked", func() {
+			lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+			Expect(err).NotTo(HaveOccurred())
+			defer lock.Close()
+			Expect(syscall.Flock(int(lock.Fd()), syscall.LOCK_EX)).To(Succeed())
+
+			Expect(Record(path, Sample{Command: "add"})).To(Equal(ErrBusy))
+			_, err = os.Stat(path)
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+		It("Assuming metrics socket", func() {
+			sockPath := filepath.Join(dir, "metrics.sock")
+			l, err := net.Listen("unix", sockPath)
+			Expect(err).NotTo(HaveOccurred())
+			defer l.Close()
+
+			received := make(chan []byte, 1)
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				data, _ := ioutil.ReadAll(conn)
+				received <- data
+			}()
+
+			Expect(Record(sockPath, Sample{Command: "del", Stage: StageRelease, Err: errors.New("failed")})).To(Succeed())
+			var data []byte
+			Eventually(received).Should(Receive(&data))
+			Expect(parse(data)).To(HaveKeyWithValue(`ib_sriov_cni_operation_failures_total{command="del",stage="release"}`, 1.0))
+		})
+		It("Assuming verified teardown", func() {
+			Expect(Record(path, Sample{Command: "del", Verification: VerificationPassed})).To(Succeed())
+			Expect(Record(path, Sample{Command: "del", Verification: VerificationFailed})).To(Succeed())
+			Expect(Record(path, Sample{Command: "del"})).To(Succeed())
+
+			data, err := ioutil.ReadFile(path)
+			Expect(err).NotTo(HaveOccurred())
+			values := parse(data)
+			Expect(values).To(HaveKeyWithValue(`ib_sriov_cni_teardown_verifications_total{result="passed"}`, 1.0))
+			Expect(values).To(HaveKeyWithValue(`ib_sriov_cni_teardown_verifications_total{result="failed"}`, 1.0))
+			Expect(values).To(HaveKeyWithValue(`ib_sriov_cni_operations_total{command="del",result="success"}`, 3.0))
+		})
+	})
+})
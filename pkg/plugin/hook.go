@@ -0,0 +1,47 @@
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+)
+
+// defaultHookTimeout bounds the hook execution when the hook has no timeout
+const defaultHookTimeout = 10 * time.Second
+
+// runPostSetupHook runs the post setup hook command with the VF details in its environment. The hook is killed when
+// it runs longer than its timeout or ctx is done.
+func runPostSetupHook(ctx context.Context, conf *types.NetConf, ifName, containerID string, netnsPath string) error {
+	hook := conf.PostSetupHook
+	timeout := defaultHookTimeout
+	if hook.Timeout > 0 {
+		timeout = time.Duration(hook.Timeout) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, hook.Command[0], hook.Command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"IB_SRIOV_CNI_IFNAME="+ifName,
+		"IB_SRIOV_CNI_GUID="+conf.GUID,
+		"IB_SRIOV_CNI_CONTAINER_ID="+containerID,
+		"IB_SRIOV_CNI_NETNS="+netnsPath,
+		"IB_SRIOV_CNI_DEVICE_ID="+conf.DeviceID,
+		"IB_SRIOV_CNI_PF="+conf.Master,
+	)
+	logging.Debugf("Setup(): running post setup hook %q", strings.Join(hook.Command, " "))
+	output, err := cmd.CombinedOutput()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("post setup hook %q timed out after %v", hook.Command[0], timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("post setup hook %q failed: %w, output: %q", hook.Command[0], err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
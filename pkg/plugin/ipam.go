@@ -0,0 +1,103 @@
+package plugin
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/invoke"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/pkg/ipam"
+)
+
+// dhcpSocketPath is the unix socket the CNI dhcp daemon listens on
+const dhcpSocketPath = "/run/cni/dhcp.sock"
+
+// ErrIPAMPluginNotFound is returned when the IPAM plugin binary is not installed in CNI_PATH
+var ErrIPAMPluginNotFound = errors.New("IPAM plugin not found")
+
+// IPAM allocates the IPs of the container interface, Setup configures the allocated IPs on the interface
+type IPAM interface {
+	// Add allocates the IPs of the container interface, a nil result means no IPs are allocated
+	Add(conf *types.NetConf) (*current.Result, error)
+	// Del releases the IPs allocated by Add
+	Del(conf *types.NetConf) error
+}
+
+type cniIPAM struct {
+	stdinData []byte
+}
+
+// NewCNIIPAM returns an IPAM delegating to the IPAM plugin of the network configuration
+func NewCNIIPAM(stdinData []byte) IPAM {
+	return &cniIPAM{stdinData: stdinData}
+}
+
+// Add runs the IPAM plugin of the network configuration, it is a no-op when no IPAM plugin is configured
+func (c *cniIPAM) Add(conf *types.NetConf) (*current.Result, error) {
+	if conf.IPAM.Type == "" {
+		return nil, nil
+	}
+
+	if err := findIPAMPlugin(conf.IPAM.Type); err != nil {
+		return nil, fmt.Errorf("failed to set up IPAM plugin type %q from the device %q: %w", conf.IPAM.Type, conf.Master, err)
+	}
+
+	r, err := ipam.ExecAdd(conf.IPAM.Type, c.stdinData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up IPAM plugin type %q from the device %q: %w%s", conf.IPAM.Type, conf.Master, err, ipamHint(conf.IPAM.Type))
+	}
+
+	// Convert the IPAM result into the current Result type
+	result, err := current.NewResultFromResult(r)
+	if err != nil {
+		_ = ipam.ExecDel(conf.IPAM.Type, c.stdinData)
+		return nil, err
+	}
+
+	if len(result.IPs) == 0 {
+		_ = ipam.ExecDel(conf.IPAM.Type, c.stdinData)
+		return nil, errors.New("IPAM plugin returned missing IP config")
+	}
+
+	return result, nil
+}
+
+// Del releases the IPs of the IPAM plugin of the network configuration
+func (c *cniIPAM) Del(conf *types.NetConf) error {
+	if conf.IPAM.Type == "" {
+		return nil
+	}
+
+	if err := findIPAMPlugin(conf.IPAM.Type); err != nil {
+		return fmt.Errorf("failed to release IPAM plugin type %q: %w", conf.IPAM.Type, err)
+	}
+
+	if err := ipam.ExecDel(conf.IPAM.Type, c.stdinData); err != nil {
+		return fmt.Errorf("failed to release IPAM plugin type %q: %w%s", conf.IPAM.Type, err, ipamHint(conf.IPAM.Type))
+	}
+	return nil
+}
+
+// findIPAMPlugin checks that the IPAM plugin binary is installed in one of the CNI_PATH directories, the plugin
+// execution error is hard to read otherwise
+func findIPAMPlugin(ipamType string) error {
+	paths := filepath.SplitList(os.Getenv("CNI_PATH"))
+	if _, err := invoke.FindInPath(ipamType, paths); err != nil {
+		return fmt.Errorf("%w: no %q binary in CNI_PATH %v", ErrIPAMPluginNotFound, ipamType, paths)
+	}
+	return nil
+}
+
+// ipamHint returns a hint to add to IPAM errors when the IPAM plugin requires a daemon which is not reachable
+func ipamHint(ipamType string) string {
+	if ipamType != "dhcp" {
+		return ""
+	}
+	if _, err := os.Stat(dhcpSocketPath); err != nil {
+		return fmt.Sprintf(", dhcp daemon socket %s is not reachable, please make sure the CNI dhcp daemon is running", dhcpSocketPath)
+	}
+	return ""
+}
@@ -0,0 +1,507 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/metrics"
+	"github.com/Mellanox/ib-sriov-cni/pkg/sriov"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/pkg/ipam"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/vishvananda/netlink"
+)
+
+// goneNetnsIPAMTimeout bounds the IPAM release when the container netns is gone, the lease of a gone container is
+// dead and an IPAM plugin which is slow to release it must not hold up the VF reclaim
+var goneNetnsIPAMTimeout = 5 * time.Second
+
+// Error is returned by Setup and Teardown, Stage is the metrics stage the operation failed in
+type Error struct {
+	Stage string
+	Err   error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func stageError(stage string, err error) error {
+	return &Error{Stage: stage, Err: err}
+}
+
+// Plugin sets up and tears down InfiniBand VFs of Pods independently of the CNI command handling
+type Plugin struct {
+	manager types.Manager
+	ipam    IPAM
+	// LockDir is the directory of the per PF lock files serializing concurrent operations on the same PF
+	LockDir string
+}
+
+// NewPlugin returns a Plugin configuring VFs with the manager, a nil ipam skips IP allocation
+func NewPlugin(manager types.Manager, ipam IPAM) *Plugin {
+	return &Plugin{manager: manager, ipam: ipam, LockDir: config.DefaultLockDir}
+}
+
+// Setup configures the VF of conf, moves it to netns as ifName, unless renaming is disabled, configures its IPs and
+// runs the post setup hook. conf.GUID must be set, conf is updated with the VF host state and must be given as is to
+// Teardown. Any failure, including ctx being done before the VF is set up, undoes the setup. A VF configuration
+// which failed part way is reset.
+func (p *Plugin) Setup(ctx context.Context, conf *types.NetConf, ifName, containerID string, netns ns.NetNS) (result *current.Result, retErr error) {
+	var err error
+	conf.NetnsID, err = utils.GetNetnsIDFromFd(netns.Fd())
+	if err != nil {
+		return nil, stageError(metrics.StageConfig, err)
+	}
+
+	// serialize PF wide configuration with concurrent invocations until the VF is set up
+	unlockPF, err := utils.LockPF(p.LockDir, conf.Master)
+	if err != nil {
+		return nil, stageError(metrics.StageApply, err)
+	}
+	pfLocked := true
+	defer func() {
+		if pfLocked {
+			unlockPF()
+		}
+	}()
+
+	// any failure undoes what the setup acquired so far, the flags of acquired are set as the resources are
+	// acquired so that reordering the steps can't leak them
+	var acq acquired
+	defer func() {
+		if retErr == nil || acq == (acquired{}) {
+			return
+		}
+		if !pfLocked {
+			if unlockPF, err = utils.LockPF(p.LockDir, conf.Master); err != nil {
+				_ = logging.Errorf("Setup(): rollback: %v", err)
+			} else {
+				pfLocked = true
+			}
+		}
+		p.rollback(conf, containerID, netns, acq)
+	}()
+
+	if err := p.manager.ApplyVFConfig(ctx, conf); err != nil {
+		// a VF configuration which failed part way, e.g. on a netlink error or on timeout, is reset from the
+		// snapshot ApplyVFConfig takes before changing the VF
+		acq.vfConfig = conf.HostVFConfig != nil
+		return nil, stageError(metrics.StageApply, fmt.Errorf("InfiniBand SRI-OV CNI failed to configure VF %w", err))
+	}
+	acq.vfConfig = true
+
+	// the netns path may have been recycled for a new Pod while the VF was being configured
+	if err := VerifyNetns(netns.Path(), conf.NetnsID); err != nil {
+		return nil, stageError(metrics.StageSetup, err)
+	}
+
+	if err := p.manager.SetupVF(ctx, conf, ifName, containerID, netns); err != nil {
+		// SetupVF may have failed before moving the VF, e.g. on a name conflict with a device of another plugin
+		acq.vfName = vfNameInNetns(conf, netns)
+		return nil, stageError(metrics.StageSetup,
+			fmt.Errorf("failed to set up pod interface %q from the device %q: %w", ifName, conf.Master, err))
+	}
+	unlockPF()
+	pfLocked = false
+
+	// the VF keeps its kernel name when renaming is disabled
+	if conf.ContIFNames != "" {
+		ifName = conf.ContIFNames
+	}
+	acq.vfName = ifName
+
+	result = &current.Result{}
+	if p.ipam != nil {
+		ipamResult, err := p.ipam.Add(conf)
+		if err != nil {
+			return nil, stageError(metrics.StageIPAM, err)
+		}
+		if ipamResult != nil {
+			acq.ipam = true
+			result = ipamResult
+		}
+	}
+	if len(result.IPs) == 0 && len(conf.RuntimeConfig.IPs) > 0 {
+		// use the IPs from the ips capability as is
+		if result.IPs, err = capabilityIPs(conf.RuntimeConfig.IPs); err != nil {
+			return nil, stageError(metrics.StageIPAM, err)
+		}
+	}
+
+	// the interfaces reported by the IPAM plugin are kept, the container interface is added unless reported
+	iface := &current.Interface{
+		Name:    ifName,
+		Mac:     conf.ContIFMAC,
+		Sandbox: netns.Path(),
+	}
+	ifIndex := InterfaceIndex(result, ifName, netns.Path())
+	if ifIndex < 0 {
+		result.Interfaces = append(result.Interfaces, iface)
+		ifIndex = len(result.Interfaces) - 1
+	} else {
+		result.Interfaces[ifIndex] = iface
+	}
+	for _, ipc := range result.IPs {
+		// All addresses apply to the container interface (move from host)
+		ipc.Interface = current.Int(ifIndex)
+	}
+
+	if len(result.IPs) > 0 {
+		if err := netns.Do(func(_ ns.NetNS) error {
+			return configureIface(ctx, conf, ifName, result)
+		}); err != nil {
+			return nil, stageError(metrics.StageIPAM, err)
+		}
+	}
+
+	if conf.PostSetupHook != nil {
+		if err := runPostSetupHook(ctx, conf, ifName, containerID, netns.Path()); err != nil {
+			if !conf.HookBestEffort {
+				return nil, stageError(metrics.StageHook, err)
+			}
+			logging.Warningf("Setup(): %v", err)
+		}
+	}
+
+	return result, nil
+}
+
+// Teardown releases the IPs of the VF, moves it back to the host and resets its config. A nil netns means the
+// container netns is gone, the IPAM release is then best effort and the VF config is reset which brings the VF back
+// to the host.
+func (p *Plugin) Teardown(conf *types.NetConf, ifName, containerID string, netns ns.NetNS) error {
+	// the VF name in the container differs from ifName when renaming is disabled
+	if conf.ContIFNames != "" {
+		ifName = conf.ContIFNames
+	}
+
+	// release IPAM first, this must be done even when the netns is already gone. A missing IPAM plugin can't hold
+	// allocations to release, it doesn't block releasing the VF.
+	if p.ipam != nil && netns == nil {
+		p.releaseGoneNetnsIPAM(conf)
+	} else if p.ipam != nil {
+		if err := p.ipam.Del(conf); errors.Is(err, ErrIPAMPluginNotFound) {
+			logging.Warningf("Teardown(): skipping IPAM release: %v", err)
+		} else if err != nil {
+			return stageError(metrics.StageIPAM, err)
+		}
+	}
+
+	// serialize PF wide configuration with concurrent invocations until the VF config is reset
+	unlockPF, err := utils.LockPF(p.LockDir, conf.Master)
+	if err != nil {
+		return stageError(metrics.StageRelease, err)
+	}
+	defer unlockPF()
+
+	if netns == nil {
+		// the VF may be stuck in the deleted netns
+		if err := p.forceCleanup(conf, fmt.Errorf("container netns is gone")); err != nil {
+			return stageError(metrics.StageRelease, err)
+		}
+		return nil
+	}
+
+	// when the netns path was recycled the VF is not in it, resetting the VF config rebinds it to the host
+	if conf.NetnsID != "" {
+		if nsErr := VerifyNetns(netns.Path(), conf.NetnsID); nsErr != nil {
+			logging.Warningf("Teardown(): skipping VF release: %v", nsErr)
+			if err := p.manager.ResetVFConfig(conf); err != nil {
+				return stageError(metrics.StageReset, fmt.Errorf("cmdDel() error reseting VF: %w", err))
+			}
+			return nil
+		}
+	}
+
+	if err := p.manager.ReleaseVF(conf, ifName, containerID, netns); err != nil {
+		if err := p.forceCleanup(conf, err); err != nil {
+			return stageError(metrics.StageRelease, err)
+		}
+		return nil
+	}
+
+	if err := p.manager.ResetVFConfig(conf); err != nil {
+		return stageError(metrics.StageReset, fmt.Errorf("cmdDel() error reseting VF: %w", err))
+	}
+
+	return nil
+}
+
+// releaseGoneNetnsIPAM releases the IPAM allocation of a container whose netns is gone. The release is still
+// attempted, so that IPAM plugins like host-local free the IPs, but it is best effort and bounded by
+// goneNetnsIPAMTimeout. A release which times out keeps running in the background until the plugin exits.
+func (p *Plugin) releaseGoneNetnsIPAM(conf *types.NetConf) {
+	done := make(chan error, 1)
+	go func() {
+		done <- p.ipam.Del(conf)
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logging.Warningf("Teardown(): container netns is gone, ignoring failed IPAM release: %v", err)
+			return
+		}
+		logging.Debugf("Teardown(): container netns is gone, released IPAM allocation")
+	case <-time.After(goneNetnsIPAMTimeout):
+		logging.Warningf("Teardown(): container netns is gone, skipping IPAM release which did not complete in %v",
+			goneNetnsIPAMTimeout)
+	}
+}
+
+// acquired tracks the resources a Setup acquired
+type acquired struct {
+	// vfConfig the VF config was changed by ApplyVFConfig
+	vfConfig bool
+	// vfName the name of the VF in the container netns, set once the VF was moved there by SetupVF
+	vfName string
+	// ipam IPs were allocated by the IPAM
+	ipam bool
+}
+
+// rollback undoes a failed Setup in the reverse order of the setup: releases the IPAM allocation, moves the VF
+// back to the host and resets the VF config. Every step is attempted regardless of failures of the previous ones,
+// only the resources which were acquired are released.
+func (p *Plugin) rollback(conf *types.NetConf, containerID string, netns ns.NetNS, acq acquired) {
+	if acq.ipam {
+		logging.Infof("Setup(): rollback: releasing IPAM allocation")
+		if err := p.ipam.Del(conf); err != nil {
+			_ = logging.Errorf("Setup(): rollback: %v", err)
+		}
+	}
+
+	if acq.vfName != "" && netns.Do(func(_ ns.NetNS) error {
+		_, err := netlink.LinkByName(acq.vfName)
+		return err
+	}) == nil {
+		logging.Infof("Setup(): rollback: releasing VF %s from the container netns", conf.DeviceID)
+		if err := p.manager.ReleaseVF(conf, acq.vfName, containerID, netns); err != nil {
+			_ = logging.Errorf("Setup(): rollback: failed to release VF %s: %v", conf.DeviceID, err)
+		}
+	}
+
+	if acq.vfConfig {
+		logging.Infof("Setup(): rollback: resetting VF %s config", conf.DeviceID)
+		if err := p.manager.ResetVFConfig(conf); err != nil {
+			_ = logging.Errorf("Setup(): rollback: failed to reset VF %s config: %v", conf.DeviceID, err)
+		}
+	}
+}
+
+// vfNameInNetns returns the name of the VF in the container netns after a failed SetupVF, empty when the VF is not
+// there. The VF is recognized by its PCI address, a device of the interface name may be another plugin's.
+func vfNameInNetns(conf *types.NetConf, netns ns.NetNS) string {
+	var name string
+	if err := netns.Do(func(_ ns.NetNS) error {
+		var err error
+		name, err = sriov.FindVFLink(conf.DeviceID)
+		return err
+	}); err != nil {
+		_ = logging.Errorf("Setup(): rollback: failed to look up VF %s in the container netns: %v", conf.DeviceID, err)
+	}
+	return name
+}
+
+// forceCleanup reclaims a VF which the normal teardown failed to release. The VF netdevice is looked up through the
+// PCI device of the VF: a VF left on the host under another name is renamed back to its host name, a VF which is not
+// on the host is stuck in a container netns and is brought back by the driver rebind of the GUID reset. To rebind a
+// VF of the keep GUID reset policy it is reset to the GUID of the Pod.
+func (p *Plugin) forceCleanup(conf *types.NetConf, reason error) error {
+	logging.Warningf("Teardown(): force cleanup of VF %s of PF %s: %v", conf.DeviceID, conf.Master, reason)
+
+	resetConf := conf
+	if name, err := utils.GetVFLinkNames(conf.DeviceID); err == nil && name != "" {
+		logging.Warningf("Teardown(): force cleanup: VF %s is on the host as %s", conf.DeviceID, name)
+		if conf.HostIFNames != "" && name != conf.HostIFNames {
+			if err := renameHostLink(name, conf.HostIFNames); err != nil {
+				logging.Warningf("Teardown(): force cleanup: failed to rename VF %s back: %v", conf.DeviceID, err)
+			} else {
+				logging.Warningf("Teardown(): force cleanup: VF %s renamed from %s to %s", conf.DeviceID, name, conf.HostIFNames)
+			}
+		}
+	} else {
+		logging.Warningf("Teardown(): force cleanup: VF %s is not on the host, rebinding it", conf.DeviceID)
+		if conf.ResetGUIDPolicy == types.ResetGUIDKeep && conf.PodGUID != "" {
+			rebindConf := *conf
+			rebindConf.ResetGUIDPolicy = types.ResetGUIDOriginal
+			rebindConf.HostIFGUID = conf.PodGUID
+			resetConf = &rebindConf
+		}
+	}
+
+	if err := p.manager.ResetVFConfig(resetConf); err != nil {
+		return fmt.Errorf("force cleanup of VF %s failed: %w", conf.DeviceID, err)
+	}
+	if name, err := utils.GetVFLinkNames(conf.DeviceID); err == nil && name != "" {
+		logging.Warningf("Teardown(): force cleanup: VF %s config reset, VF is on the host as %s", conf.DeviceID, name)
+	} else {
+		logging.Warningf("Teardown(): force cleanup: VF %s config reset, VF is still not on the host", conf.DeviceID)
+	}
+
+	return nil
+}
+
+// renameHostLink brings the link down and renames it, unless the new name is in use on the host
+func renameHostLink(name, newName string) error {
+	if _, err := netlink.LinkByName(newName); err == nil {
+		return fmt.Errorf("name %s is in use on the host", newName)
+	}
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		return fmt.Errorf("failed to lookup link %s: %w", name, err)
+	}
+	if err := netlink.LinkSetDown(link); err != nil {
+		return fmt.Errorf("failed to set link %s down: %w", name, err)
+	}
+	if err := netlink.LinkSetName(link, newName); err != nil {
+		return fmt.Errorf("failed to rename link %s to %s: %w", name, newName, err)
+	}
+	return nil
+}
+
+// VerifyNetns checks that the netns path still refers to the netns with the given identifier
+func VerifyNetns(path, netnsID string) error {
+	curID, err := utils.GetNetnsID(path)
+	if err != nil {
+		return err
+	}
+
+	if curID != netnsID {
+		return fmt.Errorf("netns %s is stale, it refers to netns %s instead of %s, retry with the current Pod netns", path, curID, netnsID)
+	}
+
+	return nil
+}
+
+// InterfaceIndex returns the index of the interface of the name in the sandbox in the result interfaces, -1 when
+// the result has no such interface
+func InterfaceIndex(result *current.Result, name, sandbox string) int {
+	for i, iface := range result.Interfaces {
+		if iface != nil && iface.Name == name && iface.Sandbox == sandbox {
+			return i
+		}
+	}
+	return -1
+}
+
+// capabilityIPs converts the IPs given in CIDR notation by the ips capability to the container interface IP configs
+func capabilityIPs(ips []string) ([]*current.IPConfig, error) {
+	ipConfigs := make([]*current.IPConfig, 0, len(ips))
+	for _, ipStr := range ips {
+		ip, ipNet, err := net.ParseCIDR(ipStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip %s from ips capability: %w", ipStr, err)
+		}
+
+		version := "6"
+		if ip.To4() != nil {
+			version = "4"
+		}
+		ipConfigs = append(ipConfigs, &current.IPConfig{
+			Version:   version,
+			Address:   net.IPNet{IP: ip, Mask: ipNet.Mask},
+			Interface: current.Int(0),
+		})
+	}
+
+	return ipConfigs, nil
+}
+
+// configureIface assigns the result IPs and routes to the interface, it must be called in the container netns.
+// The link is waited to be operationally up first, as IPoIB on-link and gateway routes are not installed and
+// neighbor discovery fails while the link is still coming up. With IPv6 addresses, IPv6 is enabled on the interface.
+// The result routes are verified to be installed afterwards.
+func configureIface(ctx context.Context, conf *types.NetConf, ifName string, result *current.Result) error {
+	hasIPv6 := false
+	for _, ipc := range result.IPs {
+		if ipc.Address.IP.To4() == nil {
+			hasIPv6 = true
+			break
+		}
+	}
+
+	if hasIPv6 {
+		logging.Debugf("configureIface(): enabling IPv6 on %s", ifName)
+		if err := sriov.WriteSysctl(fmt.Sprintf("net/ipv6/conf/%s/disable_ipv6", ifName), "0"); err != nil {
+			return fmt.Errorf("failed to enable IPv6 on interface %q: %w", ifName, err)
+		}
+	}
+
+	if err := sriov.WaitForLinkUp(ctx, conf, ifName); err != nil {
+		return err
+	}
+
+	if err := ipam.ConfigureIface(ifName, result); err != nil {
+		return err
+	}
+
+	return verifyRoutes(ifName, result.Routes)
+}
+
+// verifyRoutes checks that the routes are installed on the interface, it must be called in the container netns
+func verifyRoutes(ifName string, routes []*cnitypes.Route) error {
+	if len(routes) == 0 {
+		return nil
+	}
+
+	linkObj, err := netlink.LinkByName(ifName)
+	if err != nil {
+		return fmt.Errorf("failed to get link %s to verify its routes: %w", ifName, err)
+	}
+	for _, route := range routes {
+		family := netlink.FAMILY_V6
+		if route.Dst.IP.To4() != nil {
+			family = netlink.FAMILY_V4
+		}
+		installed, err := netlink.RouteList(linkObj, family)
+		if err != nil {
+			return fmt.Errorf("failed to list routes of %s: %w", ifName, err)
+		}
+		if hasRoute(installed, route) {
+			continue
+		}
+		if route.GW != nil {
+			return fmt.Errorf("gateway route %s via %s is missing on interface %q", route.Dst.String(), route.GW, ifName)
+		}
+		return fmt.Errorf("route %s is missing on interface %q", route.Dst.String(), ifName)
+	}
+
+	return nil
+}
+
+// hasRoute returns whether route is one of the installed routes of its family, the gateway is only compared when
+// route has one
+func hasRoute(installed []netlink.Route, route *cnitypes.Route) bool {
+	ones, bits := route.Dst.Mask.Size()
+	for i := range installed {
+		r := &installed[i]
+		if route.GW != nil && !route.GW.Equal(r.Gw) {
+			continue
+		}
+		// a default route is listed without destination
+		if r.Dst == nil {
+			if ones == 0 {
+				return true
+			}
+			continue
+		}
+		if o, b := r.Dst.Mask.Size(); o == ones && b == bits && r.Dst.IP.Equal(route.Dst.IP) {
+			return true
+		}
+	}
+	return false
+}
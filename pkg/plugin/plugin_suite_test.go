@@ -0,0 +1,23 @@
+package plugin
+
+import (
+	"testing"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+func TestPlugin(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "Plugin Suite")
+}
+
+var _ = BeforeSuite(func() {
+	// create test sys tree
+	Expect(utils.CreateTmpSysFs()).To(Succeed())
+})
+
+var _ = AfterSuite(func() {
+	Expect(utils.RemoveTmpSysFs()).To(Succeed())
+})
@@ -0,0 +1,535 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/metrics"
+	"github.com/Mellanox/ib-sriov-cni/pkg/sriov"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types/mocks"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	"github.com/containernetworking/cni/pkg/types/current"
+	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/containernetworking/plugins/pkg/testutils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
+	. "github.com/onsi/gomega"
+	"github.com/stretchr/testify/mock"
+	"github.com/vishvananda/netlink"
+)
+
+// fakeIPAM records the IPAM calls of the plugin
+type fakeIPAM struct {
+	result  *current.Result
+	addErr  error
+	delErr  error
+	added   int
+	deleted int
+	// delBlock blocks Del until it is closed when set
+	delBlock chan struct{}
+}
+
+func (f *fakeIPAM) Add(conf *types.NetConf) (*current.Result, error) {
+	f.added++
+	return f.result, f.addErr
+}
+
+func (f *fakeIPAM) Del(conf *types.NetConf) error {
+	f.deleted++
+	if f.delBlock != nil {
+		<-f.delBlock
+	}
+	return f.delErr
+}
+
+var _ = Describe("Plugin", func() {
+	var (
+		targetNetNS ns.NetNS
+		lockDir     string
+		mocked      *mocks.Manager
+		fake        *fakeIPAM
+		p           *Plugin
+		conf        *types.NetConf
+	)
+
+	BeforeEach(func() {
+		var err error
+		targetNetNS, err = testutils.NewNS()
+		Expect(err).NotTo(HaveOccurred())
+		lockDir, err = ioutil.TempDir("", "ib-sriov-cni-locks-")
+		Expect(err).NotTo(HaveOccurred())
+
+		mocked = &mocks.Manager{}
+		fake = &fakeIPAM{}
+		p = NewPlugin(mocked, fake)
+		p.LockDir = lockDir
+		conf = &types.NetConf{Master: "ib0", DeviceID: "0000:af:06.0", GUID: "01:23:45:67:89:ab:cd:ef"}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(lockDir)).To(Succeed())
+		Expect(targetNetNS.Close()).To(Succeed())
+		Expect(testutils.UnmountNS(targetNetNS)).To(Succeed())
+	})
+
+	Context("Checking Setup function", func() {
+		It("Assuming successful setup without IPs", func() {
+			hwaddr := "00:00:01:07:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(
+				func(_ context.Context, c *types.NetConf, _, _ string, _ ns.NetNS) error {
+					c.ContIFMAC = hwaddr
+					return nil
+				})
+
+			result, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal("net1"))
+			Expect(result.Interfaces[0].Mac).To(Equal(hwaddr))
+			Expect(result.Interfaces[0].Sandbox).To(Equal(targetNetNS.Path()))
+			Expect(conf.NetnsID).NotTo(BeEmpty())
+			Expect(fake.added).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming successful setup with renaming disabled", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(
+				func(_ context.Context, c *types.NetConf, _, _ string, _ ns.NetNS) error {
+					c.ContIFNames = "ib1"
+					return nil
+				})
+
+			result, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(1))
+			Expect(result.Interfaces[0].Name).To(Equal("ib1"))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming IPAM result with routes", func() {
+			_, gwNet, err := net.ParseCIDR("0.0.0.0/0")
+			Expect(err).NotTo(HaveOccurred())
+			_, routeNet, err := net.ParseCIDR("10.57.0.0/16")
+			Expect(err).NotTo(HaveOccurred())
+			fake.result = &current.Result{
+				IPs: []*current.IPConfig{{
+					Version: "4",
+					Address: net.IPNet{IP: net.ParseIP("10.56.217.10"), Mask: net.CIDRMask(24, 32)},
+					Gateway: net.ParseIP("10.56.217.1"),
+				}},
+				Routes: []*cnitypes.Route{{Dst: *gwNet}, {Dst: *routeNet, GW: net.ParseIP("10.56.217.254")}},
+			}
+			// up link standing for the VF moved by the mocked SetupVF
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return addUpVeth("net1", "peer1")
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+
+			result, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Routes).To(HaveLen(2))
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return verifyRoutes("net1", result.Routes)
+			})).To(Succeed())
+		})
+		It("Assuming IPAM result with interfaces", func() {
+			fake.result = &current.Result{
+				Interfaces: []*current.Interface{{Name: "ipam0"}},
+				IPs: []*current.IPConfig{{
+					Version:   "4",
+					Address:   net.IPNet{IP: net.ParseIP("10.56.217.10"), Mask: net.CIDRMask(24, 32)},
+					Interface: current.Int(0),
+				}},
+			}
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return addUpVeth("net1", "peer1")
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+
+			result, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Interfaces).To(HaveLen(2))
+			Expect(InterfaceIndex(result, "net1", targetNetNS.Path())).To(Equal(1))
+			Expect(*result.IPs[0].Interface).To(Equal(1))
+		})
+		It("Assuming gateway route is missing", func() {
+			_, routeNet, err := net.ParseCIDR("10.57.0.0/16")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				if err := addUpVeth("net1", "peer1"); err != nil {
+					return err
+				}
+				err := verifyRoutes("net1", []*cnitypes.Route{{Dst: *routeNet, GW: net.ParseIP("10.56.217.254")}})
+				Expect(err).To(HaveOccurred())
+				Expect(err.Error()).To(ContainSubstring("gateway route 10.57.0.0/16 via 10.56.217.254 is missing"))
+				return nil
+			})).To(Succeed())
+		})
+		It("Assuming setup canceled while configuring the VF", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Run(func(args mock.Arguments) {
+				args.Get(1).(*types.NetConf).HostVFConfig = &types.VFConfig{}
+				cancel()
+			}).Return(context.Canceled)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(ctx, conf, "net1", "dummycid", targetNetNS)
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			mocked.AssertExpectations(GinkgoT())
+			mocked.AssertNotCalled(GinkgoT(), "SetupVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			Expect(fake.added).To(Equal(0))
+		})
+		It("Assuming invalid VF config", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(errors.New("invalid link state"))
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+		})
+		It("Assuming VF config failed to set the tx rate", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Run(func(args mock.Arguments) {
+				args.Get(1).(*types.NetConf).HostVFConfig = &types.VFConfig{LinkState: "auto"}
+			}).Return(errors.New("failed to set vf 0 tx rate to min 0 max 100"))
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+			mocked.AssertNotCalled(GinkgoT(), "SetupVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("Assuming VF config failed to set the guid", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Run(func(args mock.Arguments) {
+				args.Get(1).(*types.NetConf).HostVFConfig = &types.VFConfig{GUID: "11:22:33:00:00:aa:bb:cc"}
+			}).Return(errors.New("failed to set vf 0 node guid"))
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming canceled after the VF is moved to the netns", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Run(func(mock.Arguments) {
+				cancel()
+			}).Return(context.Canceled)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(ctx, conf, "net1", "dummycid", targetNetNS)
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			mocked.AssertNumberOfCalls(GinkgoT(), "ResetVFConfig", 1)
+		})
+		It("Assuming failed to set up VF", func() {
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(errors.New("mocked failed"))
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			var pErr *Error
+			Expect(errors.As(err, &pErr)).To(BeTrue())
+			Expect(pErr.Stage).To(Equal(metrics.StageSetup))
+			Expect(fake.added).To(Equal(0))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming post setup hook", func() {
+			dir, err := ioutil.TempDir("", "ib-sriov-cni-hook-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			out := filepath.Join(dir, "out")
+			conf.PostSetupHook = &types.Hook{Command: []string{"/bin/sh", "-c",
+				`echo "$IB_SRIOV_CNI_IFNAME $IB_SRIOV_CNI_GUID $IB_SRIOV_CNI_CONTAINER_ID" > ` + out}}
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+
+			_, err = p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			data, err := ioutil.ReadFile(out)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("net1 01:23:45:67:89:ab:cd:ef dummycid\n"))
+		})
+		It("Assuming post setup hook failed", func() {
+			conf.PostSetupHook = &types.Hook{Command: []string{"/bin/sh", "-c", "echo unreachable; exit 1"}}
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("unreachable"))
+			var pErr *Error
+			Expect(errors.As(err, &pErr)).To(BeTrue())
+			Expect(pErr.Stage).To(Equal(metrics.StageHook))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming post setup hook timed out with best effort hooks", func() {
+			conf.PostSetupHook = &types.Hook{Command: []string{"/bin/sleep", "5"}, Timeout: 100}
+			conf.HookBestEffort = true
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+
+			start := time.Now()
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+			mocked.AssertNotCalled(GinkgoT(), "ResetVFConfig", mock.Anything)
+		})
+		It("Assuming IPAM failed", func() {
+			fake.addErr = errors.New("mocked failed")
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			var pErr *Error
+			Expect(errors.As(err, &pErr)).To(BeTrue())
+			Expect(pErr.Stage).To(Equal(metrics.StageIPAM))
+			Expect(fake.deleted).To(Equal(0), "nothing was allocated")
+			mocked.AssertExpectations(GinkgoT())
+		})
+	})
+
+	Context("Checking Setup rollback", func() {
+		It("Assuming failed to configure the IPs", func() {
+			// no link stands for the VF in the netns
+			fake.result = &current.Result{IPs: []*current.IPConfig{{
+				Version: "4",
+				Address: net.IPNet{IP: net.ParseIP("10.56.217.10"), Mask: net.CIDRMask(24, 32)},
+			}}}
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(fake.deleted).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+			mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("Assuming post setup hook failed with the VF in the netns", func() {
+			fake.result = &current.Result{}
+			conf.PostSetupHook = &types.Hook{Command: []string{"/bin/false"}}
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return addUpVeth("net1", "peer1")
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ReleaseVF", conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(fake.deleted).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming interface name taken by another device in the netns", func() {
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return addUpVeth("net1", "peer1")
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(
+				fmt.Errorf("%w: device net1 already exists", sriov.ErrIfNameConflict))
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(errors.Is(err, sriov.ErrIfNameConflict)).To(BeTrue())
+			// the device is not the VF, it is left in the netns
+			mocked.AssertNotCalled(GinkgoT(), "ReleaseVF", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				_, err := netlink.LinkByName("net1")
+				return err
+			})).To(Succeed())
+		})
+		It("Assuming rollback steps failed", func() {
+			fake.result = &current.Result{}
+			fake.delErr = errors.New("mocked failed")
+			conf.PostSetupHook = &types.Hook{Command: []string{"/bin/false"}}
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return addUpVeth("net1", "peer1")
+			})).To(Succeed())
+			mocked.On("ApplyVFConfig", mock.Anything, conf).Return(nil)
+			mocked.On("SetupVF", mock.Anything, conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ReleaseVF", conf, "net1", "dummycid", targetNetNS).Return(errors.New("mocked failed"))
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			// every step is attempted
+			_, err := p.Setup(context.Background(), conf, "net1", "dummycid", targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(fake.deleted).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+		})
+	})
+
+	Context("Checking Teardown function", func() {
+		It("Assuming successful teardown", func() {
+			mocked.On("ReleaseVF", conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			Expect(p.Teardown(conf, "net1", "dummycid", targetNetNS)).To(Succeed())
+			Expect(fake.deleted).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming successful teardown with renaming disabled", func() {
+			conf.ContIFNames = "ib1"
+			mocked.On("ReleaseVF", conf, "ib1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			Expect(p.Teardown(conf, "net1", "dummycid", targetNetNS)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming netns is gone", func() {
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			Expect(p.Teardown(conf, "net1", "dummycid", nil)).To(Succeed())
+			Expect(fake.deleted).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming netns is gone and VF renamed on the host", func() {
+			// VF netdevice of the sysfs fixture left on the host under another name
+			conf.DeviceID = "0000:af:06.0"
+			conf.HostIFNames = "ib5"
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "ib1"}, PeerName: "ib1p"})).To(Succeed())
+				Expect(p.Teardown(conf, "net1", "dummycid", nil)).To(Succeed())
+				_, err := netlink.LinkByName("ib5")
+				return err
+			})).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming netns is gone and VF not on the host with the keep guid policy", func() {
+			conf.DeviceID = "0000:af:06.3"
+			conf.ResetGUIDPolicy = types.ResetGUIDKeep
+			conf.PodGUID = "01:23:45:67:89:ab:cd:ef"
+			mocked.On("ResetVFConfig", mock.MatchedBy(func(c *types.NetConf) bool {
+				return c.ResetGUIDPolicy == types.ResetGUIDOriginal && c.HostIFGUID == conf.PodGUID
+			})).Return(nil)
+
+			Expect(p.Teardown(conf, "net1", "dummycid", nil)).To(Succeed())
+			Expect(conf.ResetGUIDPolicy).To(Equal(types.ResetGUIDKeep))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming netns is gone and IPAM release failed", func() {
+			fake.delErr = errors.New("mocked failed")
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			Expect(p.Teardown(conf, "net1", "dummycid", nil)).To(Succeed())
+			Expect(fake.deleted).To(Equal(1))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming netns is gone and IPAM release is slow", func() {
+			timeout := goneNetnsIPAMTimeout
+			goneNetnsIPAMTimeout = 50 * time.Millisecond
+			defer func() { goneNetnsIPAMTimeout = timeout }()
+			fake.delBlock = make(chan struct{})
+			defer close(fake.delBlock)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			start := time.Now()
+			Expect(p.Teardown(conf, "net1", "dummycid", nil)).To(Succeed())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming IPAM release failed", func() {
+			fake.delErr = errors.New("mocked failed")
+
+			err := p.Teardown(conf, "net1", "dummycid", targetNetNS)
+			var pErr *Error
+			Expect(errors.As(err, &pErr)).To(BeTrue())
+			Expect(pErr.Stage).To(Equal(metrics.StageIPAM))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming IPAM plugin not installed", func() {
+			Expect(os.Setenv("CNI_PATH", lockDir)).To(Succeed())
+			defer os.Unsetenv("CNI_PATH")
+			conf.IPAM.Type = "bogus-ipam"
+			p = NewPlugin(mocked, NewCNIIPAM(nil))
+			p.LockDir = lockDir
+			mocked.On("ReleaseVF", conf, "net1", "dummycid", targetNetNS).Return(nil)
+			mocked.On("ResetVFConfig", conf).Return(nil)
+
+			Expect(p.Teardown(conf, "net1", "dummycid", targetNetNS)).To(Succeed())
+			mocked.AssertExpectations(GinkgoT())
+		})
+	})
+	Context("Checking CNI IPAM", func() {
+		BeforeEach(func() {
+			Expect(os.Setenv("CNI_PATH", lockDir)).To(Succeed())
+			conf.IPAM.Type = "bogus-ipam"
+		})
+
+		AfterEach(func() {
+			Expect(os.Unsetenv("CNI_PATH")).To(Succeed())
+		})
+
+		It("Assuming IPAM plugin not installed on add", func() {
+			_, err := NewCNIIPAM(nil).Add(conf)
+			Expect(errors.Is(err, ErrIPAMPluginNotFound)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(`no "bogus-ipam" binary in CNI_PATH [` + lockDir + `]`))
+		})
+		It("Assuming IPAM plugin not installed on del", func() {
+			err := NewCNIIPAM(nil).Del(conf)
+			Expect(errors.Is(err, ErrIPAMPluginNotFound)).To(BeTrue())
+		})
+	})
+	Context("Checking Retriable function", func() {
+		DescribeTable("Assuming failure",
+			func(err error, retriable, transient bool) {
+				Expect(IsRetriable(err)).To(Equal(retriable))
+				Expect(IsTransient(err)).To(Equal(transient))
+				Expect(errors.Is(Retriable(err), err)).To(BeTrue())
+				Expect(Retriable(err).Error()).To(Equal(err.Error()))
+			},
+			Entry("InfiniBand not configured", fmt.Errorf("add: %w", config.ErrIBNotConfigured), true, false),
+			Entry("guid missing", fmt.Errorf("add: %w", utils.ErrGUIDMissing), true, false),
+			Entry("no free VF", fmt.Errorf("add: %w", config.ErrNoFreeVF), true, true),
+			Entry("VF quota reached", fmt.Errorf("add: %w", config.ErrVFQuotaReached), true, true),
+			Entry("transient netlink error", fmt.Errorf("add: %w", syscall.EBUSY), true, true),
+			Entry("invalid netconf", fmt.Errorf("add: %w", config.ErrInvalidNetConf), false, false),
+			Entry("permanent netlink error", fmt.Errorf("add: %w", syscall.ENODEV), false, false),
+		)
+		It("Assuming no failure", func() {
+			Expect(Retriable(nil)).To(BeNil())
+			Expect(IsRetriable(nil)).To(BeFalse())
+		})
+		It("Assuming failure already retriable", func() {
+			err := &RetriableError{Err: errors.New("not ready")}
+			Expect(Retriable(err) == err).To(BeTrue())
+			Expect(IsRetriable(fmt.Errorf("add: %w", err))).To(BeTrue())
+			Expect(IsTransient(err)).To(BeFalse())
+		})
+	})
+})
+
+// addUpVeth creates a veth pair with both ends up, it stands for a VF set up in the current netns
+func addUpVeth(name, peer string) error {
+	if err := netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: peer}); err != nil {
+		return err
+	}
+	for _, n := range []string{name, peer} {
+		link, err := netlink.LinkByName(n)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetUp(link); err != nil {
+			return err
+		}
+	}
+	return nil
+}
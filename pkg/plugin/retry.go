@@ -0,0 +1,73 @@
+package plugin
+
+import (
+	"errors"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/config"
+	"github.com/Mellanox/ib-sriov-cni/pkg/sriov"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+)
+
+// RetriableError wraps a failure of ADD which a retry of the whole ADD may get past. Transient is set when the
+// condition may clear within the same invocation, e.g. a VF being released by another Pod, so that retrying with the
+// same arguments helps. The other retriable conditions need the runtime to retry, e.g. with the Pod annotations
+// ib-kubernetes sets meanwhile.
+type RetriableError struct {
+	Err       error
+	Transient bool
+}
+
+func (e *RetriableError) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *RetriableError) Unwrap() error {
+	return e.Err
+}
+
+// retriableErrors are the conditions ib-kubernetes configuring the Pod clears, the runtime retrying ADD gets the
+// new arguments
+var retriableErrors = []error{config.ErrIBNotConfigured, utils.ErrGUIDMissing}
+
+// transientErrors are the conditions which may clear while ADD is retried in-process
+var transientErrors = []error{config.ErrNoFreeVF, config.ErrVFQuotaReached}
+
+// Retriable returns err wrapped in a RetriableError when it is a retriable condition, err is returned as is
+// otherwise
+func Retriable(err error) error {
+	var retriable *RetriableError
+	if err == nil || errors.As(err, &retriable) {
+		return err
+	}
+	if isTransient(err) {
+		return &RetriableError{Err: err, Transient: true}
+	}
+	for _, e := range retriableErrors {
+		if errors.Is(err, e) {
+			return &RetriableError{Err: err}
+		}
+	}
+	return err
+}
+
+// IsRetriable returns whether a retry of the whole ADD may get past err
+func IsRetriable(err error) bool {
+	var retriable *RetriableError
+	return errors.As(Retriable(err), &retriable)
+}
+
+// IsTransient returns whether err may clear when ADD is retried with the same arguments
+func IsTransient(err error) bool {
+	var retriable *RetriableError
+	return errors.As(Retriable(err), &retriable) && retriable.Transient
+}
+
+func isTransient(err error) bool {
+	for _, e := range transientErrors {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return sriov.IsTransientError(err)
+}
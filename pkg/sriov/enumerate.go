@@ -0,0 +1,91 @@
+package sriov
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+)
+
+// defaultEnumerateWorkers is the number of VFs read concurrently when the worker count is not set
+const defaultEnumerateWorkers = 8
+
+type vfEnumerator struct{}
+
+// NewVFEnumerator returns an instance of VFEnumerator reading the VFs from sysfs
+func NewVFEnumerator() types.VFEnumerator {
+	return &vfEnumerator{}
+}
+
+// EnumerateVFs reads the state of all the VFs of the PF reading at most workers VFs concurrently, a worker count
+// which is not positive uses defaultEnumerateWorkers. The states are returned ordered by VF index. The VFs which
+// failed to be read are left out and their errors are aggregated in VF index order.
+func (e *vfEnumerator) EnumerateVFs(pfName string, workers int) ([]types.VFState, error) {
+	numVfs, err := utils.GetSriovNumVfs(pfName)
+	if err != nil {
+		return nil, err
+	}
+	return enumerateVFs(numVfs, workers, func(vf int) (types.VFState, error) {
+		return readVFState(pfName, vf)
+	})
+}
+
+// readVFState reads the state of a VF of the PF from sysfs
+func readVFState(pfName string, vf int) (types.VFState, error) {
+	pciAddr, err := utils.GetPciAddress(pfName, vf)
+	if err != nil {
+		return types.VFState{}, err
+	}
+	state := types.VFState{VFID: vf, PciAddr: pciAddr}
+	// a VF without netdevice is in a Pod netns or bound to another driver
+	if linkName, err := utils.GetVFLinkNames(pciAddr); err == nil {
+		state.LinkName = linkName
+	}
+	return state, nil
+}
+
+// enumerateVFs reads VFs 0 to numVfs-1 with read on at most workers goroutines
+func enumerateVFs(numVfs, workers int, read func(vf int) (types.VFState, error)) ([]types.VFState, error) {
+	if workers <= 0 {
+		workers = defaultEnumerateWorkers
+	}
+	if workers > numVfs {
+		workers = numVfs
+	}
+
+	// each VF has its own slot so that the results don't depend on the order the workers finish in
+	states := make([]types.VFState, numVfs)
+	errs := make([]error, numVfs)
+	vfs := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for vf := range vfs {
+				states[vf], errs[vf] = read(vf)
+			}
+		}()
+	}
+	for vf := 0; vf < numVfs; vf++ {
+		vfs <- vf
+	}
+	close(vfs)
+	wg.Wait()
+
+	result := make([]types.VFState, 0, numVfs)
+	var problems []string
+	for vf := 0; vf < numVfs; vf++ {
+		if errs[vf] != nil {
+			problems = append(problems, fmt.Sprintf("VF %d: %v", vf, errs[vf]))
+			continue
+		}
+		result = append(result, states[vf])
+	}
+	if len(problems) > 0 {
+		return result, fmt.Errorf("failed to read %d of %d VFs: %s", len(problems), numVfs, strings.Join(problems, "; "))
+	}
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package sriov
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("VF enumeration", func() {
+	Context("Checking EnumerateVFs function", func() {
+		It("Assuming existing PF", func() {
+			vfs, err := NewVFEnumerator().EnumerateVFs("ib0", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfs).To(Equal([]types.VFState{
+				{VFID: 0, PciAddr: "0000:af:06.0", LinkName: "ib1"},
+				{VFID: 1, PciAddr: "0000:af:06.1", LinkName: "ib2"},
+			}))
+		})
+		It("Assuming not existing PF", func() {
+			_, err := NewVFEnumerator().EnumerateVFs("ibFake0", 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking enumerateVFs function", func() {
+		It("Assuming no VFs", func() {
+			vfs, err := enumerateVFs(0, 4, func(vf int) (types.VFState, error) {
+				return types.VFState{VFID: vf}, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfs).To(BeEmpty())
+		})
+		It("Assuming more VFs than workers", func() {
+			var running, maxRunning int32
+			vfs, err := enumerateVFs(64, 4, func(vf int) (types.VFState, error) {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return types.VFState{VFID: vf}, nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfs).To(HaveLen(64))
+			for i, vf := range vfs {
+				Expect(vf.VFID).To(Equal(i))
+			}
+			Expect(atomic.LoadInt32(&maxRunning)).To(BeNumerically("<=", 4))
+		})
+		It("Assuming failing VFs", func() {
+			vfs, err := enumerateVFs(8, 8, func(vf int) (types.VFState, error) {
+				if vf%3 == 2 {
+					// the later VFs fail first
+					time.Sleep(time.Duration(8-vf) * time.Millisecond)
+					return types.VFState{}, errors.New("no such device")
+				}
+				return types.VFState{VFID: vf}, nil
+			})
+			Expect(err).To(MatchError("failed to read 2 of 8 VFs: VF 2: no such device; VF 5: no such device"))
+			Expect(vfs).To(HaveLen(6))
+			Expect(vfs[2].VFID).To(Equal(3))
+		})
+	})
+})
+
+// createBenchSysFs creates a sysfs tree of a PF with numVfs VFs which all have a netdevice on the host, and points
+// the utils sysfs directories to it
+func createBenchSysFs(b *testing.B, pfName string, numVfs int) {
+	root, err := ioutil.TempDir("", "ib-sriov-cni-bench-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	netDir, pciDir := filepath.Join(root, "class", "net"), filepath.Join(root, "bus", "pci", "devices")
+	pfDevice := filepath.Join(netDir, pfName, "device")
+	if err := os.MkdirAll(pfDevice, 0755); err != nil {
+		b.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pfDevice, "sriov_numvfs"), []byte(strconv.Itoa(numVfs)), 0644); err != nil {
+		b.Fatal(err)
+	}
+	for vf := 0; vf < numVfs; vf++ {
+		pciAddr := fmt.Sprintf("0000:af:%02x.%d", vf/8, vf%8)
+		if err := os.MkdirAll(filepath.Join(pciDir, pciAddr, "net", fmt.Sprintf("ib%d", vf+1)), 0755); err != nil {
+			b.Fatal(err)
+		}
+		if err := os.Symlink(filepath.Join(pciDir, pciAddr), filepath.Join(pfDevice, fmt.Sprintf("virtfn%d", vf))); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	netDirectory, sysBusPci := utils.NetDirectory, utils.SysBusPci
+	utils.NetDirectory, utils.SysBusPci = netDir, pciDir
+	b.Cleanup(func() {
+		utils.NetDirectory, utils.SysBusPci = netDirectory, sysBusPci
+		os.RemoveAll(root)
+	})
+}
+
+// benchmarkEnumerateVFs enumerates the 64 VFs of a PF from sysfs
+func benchmarkEnumerateVFs(b *testing.B, workers int) {
+	createBenchSysFs(b, "ibbench0", 64)
+	enumerator := NewVFEnumerator()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		vfs, err := enumerator.EnumerateVFs("ibbench0", workers)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(vfs) != 64 {
+			b.Fatalf("enumerated %d of 64 VFs", len(vfs))
+		}
+	}
+}
+
+func BenchmarkEnumerateVFsSerial(b *testing.B) {
+	benchmarkEnumerateVFs(b, 1)
+}
+
+func BenchmarkEnumerateVFsParallel(b *testing.B) {
+	benchmarkEnumerateVFs(b, defaultEnumerateWorkers)
+}
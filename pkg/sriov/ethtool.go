@@ -0,0 +1,81 @@
+package sriov
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+)
+
+const (
+	// siocEthtool is the ethtool ioctl request
+	siocEthtool = 0x8946
+	// ethtool commands getting and setting the ring sizes
+	ethtoolGRingParam = 0x00000010
+	ethtoolSRingParam = 0x00000011
+)
+
+// ethtoolRingParam is struct ethtool_ringparam of linux/ethtool.h
+type ethtoolRingParam struct {
+	cmd               uint32
+	rxMaxPending      uint32
+	rxMiniMaxPending  uint32
+	rxJumboMaxPending uint32
+	txMaxPending      uint32
+	rxPending         uint32
+	rxMiniPending     uint32
+	rxJumboPending    uint32
+	txPending         uint32
+}
+
+// ifreq is struct ifreq of linux/if.h with the ethtool command data
+type ifreq struct {
+	name [syscall.IFNAMSIZ]byte
+	data uintptr
+}
+
+// ethtoolRings runs the ring params ethtool command on the network device of the current netns
+func ethtoolRings(ifName string, param *ethtoolRingParam) error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM, syscall.IPPROTO_IP)
+	if err != nil {
+		return err
+	}
+	defer syscall.Close(fd)
+
+	var req ifreq
+	copy(req.name[:syscall.IFNAMSIZ-1], ifName)
+	req.data = uintptr(unsafe.Pointer(param))
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), siocEthtool, uintptr(unsafe.Pointer(&req))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// EthtoolGetMaxRings using NetlinkManager
+func (n *MyNetlink) EthtoolGetMaxRings(ifName string) (types.Rings, error) {
+	param := ethtoolRingParam{cmd: ethtoolGRingParam}
+	if err := ethtoolRings(ifName, &param); err != nil {
+		return types.Rings{}, fmt.Errorf("failed to get %s ring params: %w", ifName, err)
+	}
+	return types.Rings{RX: param.rxMaxPending, TX: param.txMaxPending}, nil
+}
+
+// EthtoolSetRings using NetlinkManager, zero sizes are left unchanged
+func (n *MyNetlink) EthtoolSetRings(ifName string, rings types.Rings) error {
+	param := ethtoolRingParam{cmd: ethtoolGRingParam}
+	if err := ethtoolRings(ifName, &param); err != nil {
+		return fmt.Errorf("failed to get %s ring params: %w", ifName, err)
+	}
+	param.cmd = ethtoolSRingParam
+	if rings.RX != 0 {
+		param.rxPending = rings.RX
+	}
+	if rings.TX != 0 {
+		param.txPending = rings.TX
+	}
+	if err := ethtoolRings(ifName, &param); err != nil {
+		return fmt.Errorf("failed to set %s ring params: %w", ifName, err)
+	}
+	return nil
+}
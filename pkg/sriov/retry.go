@@ -0,0 +1,96 @@
+package sriov
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+)
+
+const (
+	defaultRetryAttempts = 3
+	defaultRetryInterval = 200 * time.Millisecond
+)
+
+// transientErrors are the netlink errors which may succeed when retried
+var transientErrors = []syscall.Errno{syscall.EBUSY, syscall.EAGAIN, syscall.EINTR}
+
+// IsTransientError returns whether err is a netlink error which may succeed when retried
+func IsTransientError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	for _, e := range transientErrors {
+		if errno == e {
+			return true
+		}
+	}
+	return false
+}
+
+// withRetry runs op and retries it with the NetConf retry settings as long as it fails with a transient error
+func withRetry(conf *types.NetConf, op func() error) error {
+	return withRetryCtx(context.Background(), conf, op)
+}
+
+// withRetryCtx is withRetry which stops retrying once ctx is done, op is not attempted when ctx is already done
+func withRetryCtx(ctx context.Context, conf *types.NetConf, op func() error) error {
+	attempts := conf.RetryAttempts
+	if attempts <= 0 {
+		attempts = defaultRetryAttempts
+	}
+	interval := defaultRetryInterval
+	if conf.RetryInterval > 0 {
+		interval = time.Duration(conf.RetryInterval) * time.Millisecond
+	}
+
+	var err error
+	for i := 1; i <= attempts; i++ {
+		if ctxErr := checkDeadline(ctx); ctxErr != nil {
+			return ctxErr
+		}
+		if err = op(); err == nil || !IsTransientError(err) {
+			return err
+		}
+		if i < attempts {
+			logging.Debugf("withRetry(): attempt %d/%d failed with transient error %v, retrying in %v", i, attempts, err, interval)
+			select {
+			case <-ctx.Done():
+				return checkDeadline(ctx)
+			case <-time.After(interval):
+			}
+		}
+	}
+	return err
+}
+
+// checkDeadline returns an error wrapping the ctx error when ctx is done
+func checkDeadline(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("operation aborted: %w", err)
+	}
+	return nil
+}
+
+// runCtx runs a blocking op, which has no way to be interrupted, in a goroutine and returns once it completes or ctx is
+// done. An op abandoned when ctx is done keeps running in the background until the plugin exits.
+func runCtx(ctx context.Context, op func() error) error {
+	if err := checkDeadline(ctx); err != nil {
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- op()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return checkDeadline(ctx)
+	}
+}
@@ -0,0 +1,87 @@
+package sriov
+
+import (
+	"context"
+	"errors"
+	"syscall"
+	"time"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Retry", func() {
+	Context("Checking withRetry function", func() {
+		var (
+			netconf *types.NetConf
+			calls   int
+		)
+
+		BeforeEach(func() {
+			netconf = &types.NetConf{RetryAttempts: 3, RetryInterval: 1}
+			calls = 0
+		})
+
+		It("Assuming transient error then success", func() {
+			err := withRetry(netconf, func() error {
+				calls++
+				if calls < 3 {
+					return syscall.EBUSY
+				}
+				return nil
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(calls).To(Equal(3))
+		})
+		It("Assuming transient error exhausting attempts", func() {
+			err := withRetry(netconf, func() error {
+				calls++
+				return syscall.EAGAIN
+			})
+			Expect(err).To(Equal(syscall.EAGAIN))
+			Expect(calls).To(Equal(3))
+		})
+		It("Assuming non transient errors", func() {
+			for _, e := range []error{syscall.ENODEV, syscall.EINVAL, errors.New("failed")} {
+				calls = 0
+				err := withRetry(netconf, func() error {
+					calls++
+					return e
+				})
+				Expect(err).To(Equal(e))
+				Expect(calls).To(Equal(1))
+			}
+		})
+		It("Assuming default attempts", func() {
+			netconf.RetryAttempts = 0
+			err := withRetry(netconf, func() error {
+				calls++
+				return syscall.EBUSY
+			})
+			Expect(err).To(HaveOccurred())
+			Expect(calls).To(Equal(defaultRetryAttempts))
+		})
+		It("Assuming deadline exceeded while retrying", func() {
+			netconf.RetryInterval = 1000
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			err := withRetryCtx(ctx, netconf, func() error {
+				calls++
+				return syscall.EBUSY
+			})
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(calls).To(Equal(1))
+		})
+		It("Assuming context already done", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := withRetryCtx(ctx, netconf, func() error {
+				calls++
+				return nil
+			})
+			Expect(errors.Is(err, context.Canceled)).To(BeTrue())
+			Expect(calls).To(Equal(0))
+		})
+	})
+})
@@ -1,17 +1,91 @@
 package sriov
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/Mellanox/sriovnet"
 	"github.com/containernetworking/plugins/pkg/ns"
+	"github.com/safchain/ethtool"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 
+	"github.com/Mellanox/ib-sriov-cni/pkg/logging"
 	"github.com/Mellanox/ib-sriov-cni/pkg/types"
 	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
 )
 
+const (
+	defaultLinkUpTimeout = 5 * time.Second
+	defaultDrainPeriod   = time.Second
+	linkUpPollInterval   = 100 * time.Millisecond
+	// rdmaNetnsModeExclusive is the RDMA subsystem netns mode required to move RDMA devices between namespaces
+	rdmaNetnsModeExclusive = "exclusive"
+	// maxIfNameSuffix is the last numeric suffix tried for a container interface name which is taken
+	maxIfNameSuffix = 99
+	// maxIfAliasLen is the maximum length of a network interface alias (IFALIASZ - 1)
+	maxIfAliasLen = 255
+	// ipoibHwAddrLen is the length of an IPoIB hardware address, its last 8 bytes are the port GUID
+	ipoibHwAddrLen = 20
+)
+
+// writeSysctl writes a sysctl given by its path under /proc/sys
+var writeSysctl = func(path, value string) error {
+	return ioutil.WriteFile(filepath.Join("/proc/sys", path), []byte(value), 0644)
+}
+
+// WriteSysctl writes a sysctl given by its path under /proc/sys in the current netns
+func WriteSysctl(path, value string) error {
+	return writeSysctl(path, value)
+}
+
+// drainSleep waits for the traffic of a VF being released to settle
+var drainSleep = time.Sleep
+
+// linkBusInfo returns the bus info of a network device in the current netns, the PCI address of a VF
+var linkBusInfo = ethtool.BusInfo
+
+// linkExists reports whether a network device with the name exists in the current netns
+var linkExists = func(name string) (bool, error) {
+	_, err := netlink.LinkByName(name)
+	var notFound netlink.LinkNotFoundError
+	if errors.As(err, &notFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+var (
+	// ErrPFNotFound is returned when the PF network device doesn't exist
+	ErrPFNotFound = errors.New("no such PF device")
+	// ErrPFNotInfiniBand is returned when the PF is not an InfiniBand device
+	ErrPFNotInfiniBand = errors.New("PF is not an InfiniBand device")
+	// ErrPFDown is returned when the PF is administratively down
+	ErrPFDown = errors.New("PF is down")
+	// ErrPFSriovNotEnabled is returned when the PF has no VFs
+	ErrPFSriovNotEnabled = errors.New("SR-IOV is not enabled on PF")
+	// ErrPFNotAllowed is returned when the PF is not in the PF allowlist of the netconf
+	ErrPFNotAllowed = errors.New("PF is not allowed")
+	// ErrIfNameConflict is returned when the container interface name is taken in the container netns
+	ErrIfNameConflict = errors.New("interface name conflict")
+	// ErrVFStuck is returned when a VF failed to be released from the Pod netns and could not be recovered
+	ErrVFStuck = errors.New("VF is stuck")
+	// ErrNetnsGone is returned when the Pod netns was torn down while the VF was being set up
+	ErrNetnsGone = errors.New("netns disappeared")
+)
+
 // MyNetlink NetlinkManager
 type MyNetlink struct {
 }
@@ -41,11 +115,84 @@ func (n *MyNetlink) LinkSetName(link netlink.Link, name string) error {
 	return netlink.LinkSetName(link, name)
 }
 
+// LinkSetMTU using NetlinkManager
+func (n *MyNetlink) LinkSetMTU(link netlink.Link, mtu int) error {
+	return netlink.LinkSetMTU(link, mtu)
+}
+
+// LinkSetHardwareAddr using NetlinkManager
+func (n *MyNetlink) LinkSetHardwareAddr(link netlink.Link, hwaddr net.HardwareAddr) error {
+	return netlink.LinkSetHardwareAddr(link, hwaddr)
+}
+
 // LinkSetVfState using NetlinkManager
 func (n *MyNetlink) LinkSetVfState(link netlink.Link, vf int, state uint32) error {
 	return netlink.LinkSetVfState(link, vf, state)
 }
 
+// LinkSetVfTrust using NetlinkManager
+func (n *MyNetlink) LinkSetVfTrust(link netlink.Link, vf int, state bool) error {
+	return netlink.LinkSetVfTrust(link, vf, state)
+}
+
+// LinkGetVfTrust using NetlinkManager, netlink doesn't parse IFLA_VF_TRUST into the link VfInfo
+func (n *MyNetlink) LinkGetVfTrust(link netlink.Link, vf int) (bool, error) {
+	req := nl.NewNetlinkRequest(unix.RTM_GETLINK, unix.NLM_F_ACK)
+	msg := nl.NewIfInfomsg(unix.AF_UNSPEC)
+	msg.Index = int32(link.Attrs().Index)
+	req.AddData(msg)
+	req.AddData(nl.NewRtAttr(unix.IFLA_EXT_MASK, nl.Uint32Attr(nl.RTEXT_FILTER_VF)))
+
+	msgs, err := req.Execute(unix.NETLINK_ROUTE, unix.RTM_NEWLINK)
+	if err != nil {
+		return false, err
+	}
+	if len(msgs) == 0 {
+		return false, fmt.Errorf("no link message for %s", link.Attrs().Name)
+	}
+	return parseVfTrust(msgs[0], vf)
+}
+
+// parseVfTrust returns the trust of the given vf from an RTM_NEWLINK message
+func parseVfTrust(msg []byte, vf int) (bool, error) {
+	if len(msg) < unix.SizeofIfInfomsg {
+		return false, fmt.Errorf("link message too short: %d bytes", len(msg))
+	}
+	attrs, err := nl.ParseRouteAttr(msg[unix.SizeofIfInfomsg:])
+	if err != nil {
+		return false, err
+	}
+	for _, attr := range attrs {
+		if attr.Attr.Type != unix.IFLA_VFINFO_LIST {
+			continue
+		}
+		infos, err := nl.ParseRouteAttr(attr.Value)
+		if err != nil {
+			return false, err
+		}
+		for _, info := range infos {
+			vfAttrs, err := nl.ParseRouteAttr(info.Value)
+			if err != nil {
+				return false, err
+			}
+			for _, vfAttr := range vfAttrs {
+				if vfAttr.Attr.Type != nl.IFLA_VF_TRUST || len(vfAttr.Value) < nl.SizeofVfTrust {
+					continue
+				}
+				if trust := nl.DeserializeVfTrust(vfAttr.Value); int(trust.Vf) == vf {
+					return trust.Setting != 0, nil
+				}
+			}
+		}
+	}
+	return false, fmt.Errorf("no trust reported for vf %d", vf)
+}
+
+// LinkSetVfRate using NetlinkManager
+func (n *MyNetlink) LinkSetVfRate(link netlink.Link, vf, minRate, maxRate int) error {
+	return netlink.LinkSetVfRate(link, vf, minRate, maxRate)
+}
+
 // LinkSetVfPortGUID using NetlinkManager
 func (n *MyNetlink) LinkSetVfPortGUID(link netlink.Link, vf int, portGUID net.HardwareAddr) error {
 	return netlink.LinkSetVfPortGUID(link, vf, portGUID)
@@ -56,12 +203,58 @@ func (n *MyNetlink) LinkSetVfNodeGUID(link netlink.Link, vf int, nodeGUID net.Ha
 	return netlink.LinkSetVfNodeGUID(link, vf, nodeGUID)
 }
 
+// LinkSetARPOff using NetlinkManager
+func (n *MyNetlink) LinkSetARPOff(link netlink.Link) error {
+	return netlink.LinkSetARPOff(link)
+}
+
+// LinkSetAlias using NetlinkManager
+func (n *MyNetlink) LinkSetAlias(link netlink.Link, name string) error {
+	return netlink.LinkSetAlias(link, name)
+}
+
+// NeighSet using NetlinkManager
+func (n *MyNetlink) NeighSet(neigh *netlink.Neigh) error {
+	return netlink.NeighSet(neigh)
+}
+
+// RdmaSystemGetNetnsMode using NetlinkManager
+func (n *MyNetlink) RdmaSystemGetNetnsMode() (string, error) {
+	return netlink.RdmaSystemGetNetnsMode()
+}
+
+// RdmaLinkByName using NetlinkManager
+func (n *MyNetlink) RdmaLinkByName(name string) (*netlink.RdmaLink, error) {
+	return netlink.RdmaLinkByName(name)
+}
+
+// RdmaLinkSetNsFd using NetlinkManager
+func (n *MyNetlink) RdmaLinkSetNsFd(link *netlink.RdmaLink, fd uint32) error {
+	return netlink.RdmaLinkSetNsFd(link, fd)
+}
+
 type pciUtilsImpl struct{}
 
 func (p *pciUtilsImpl) GetSriovNumVfs(ifName string) (int, error) {
 	return utils.GetSriovNumVfs(ifName)
 }
 
+func (p *pciUtilsImpl) GetSriovTotalVfs(ifName string) (int, error) {
+	return utils.GetSriovTotalVfs(ifName)
+}
+
+func (p *pciUtilsImpl) GetPfPciAddress(pfName string) (string, error) {
+	return utils.GetPfPciAddress(pfName)
+}
+
+func (p *pciUtilsImpl) GetLinkSpeed(ifName string) (int, error) {
+	return utils.GetLinkSpeed(ifName)
+}
+
+func (p *pciUtilsImpl) GetVfRepresentor(pfName string, vfID int) (string, error) {
+	return utils.GetVfRepresentor(pfName, vfID)
+}
+
 func (p *pciUtilsImpl) GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, error) {
 	return utils.GetVFLinkNamesFromVFID(pfName, vfID)
 }
@@ -70,6 +263,43 @@ func (p *pciUtilsImpl) GetPciAddress(ifName string, vf int) (string, error) {
 	return utils.GetPciAddress(ifName, vf)
 }
 
+func (p *pciUtilsImpl) IsVfPKeyConfigurable(pfName, vfPciAddress string) bool {
+	return utils.IsVfPKeyConfigurable(pfName, vfPciAddress)
+}
+
+func (p *pciUtilsImpl) SetVfPKey(pfName, vfPciAddress, pkey string) error {
+	return utils.SetVfPKey(pfName, vfPciAddress, pkey)
+}
+
+func (p *pciUtilsImpl) ResetVfPKey(pfName, vfPciAddress, idx string) error {
+	return utils.ResetVfPKey(pfName, vfPciAddress, idx)
+}
+
+func (p *pciUtilsImpl) GetVfPKeyEntry(pfName, vfPciAddress string, entry int) (string, error) {
+	return utils.GetVfPKeyEntry(pfName, vfPciAddress, entry)
+}
+
+func (p *pciUtilsImpl) SetVfPKeyEntry(pfName, vfPciAddress string, entry int, pkey string) error {
+	return utils.SetVfPKeyEntry(pfName, vfPciAddress, entry, pkey)
+}
+
+func (p *pciUtilsImpl) ClearVfPKeyEntry(pfName, vfPciAddress string, entry int) error {
+	return utils.ClearVfPKeyEntry(pfName, vfPciAddress, entry)
+}
+
+// SetVfGUIDSysfs sets the vf node and port guid through the PF sriov sysfs directory
+func (p *pciUtilsImpl) SetVfGUIDSysfs(pfName string, vfID int, guid string) error {
+	return utils.SetVfGUIDSysfs(pfName, vfID, guid)
+}
+
+func (p *pciUtilsImpl) GetIPoIBMode(ifName string) (string, error) {
+	return utils.GetIPoIBMode(ifName)
+}
+
+func (p *pciUtilsImpl) SetIPoIBMode(ifName, mode string) error {
+	return utils.SetIPoIBMode(ifName, mode)
+}
+
 // RebindVf unbind then bind the vf
 func (p *pciUtilsImpl) RebindVf(pfName, vfPciAddress string) error {
 	pfHandle, err := sriovnet.GetPfNetdevHandle(pfName)
@@ -85,7 +315,7 @@ func (p *pciUtilsImpl) RebindVf(pfName, vfPciAddress string) error {
 		}
 	}
 	if !found {
-		return fmt.Errorf("failed to find VF %s for PF %s", vfPciAddress, pfName)
+		return fmt.Errorf("failed to find VF %s for PF %s: %w", vfPciAddress, pfName, utils.ErrVFNotFound)
 	}
 	if err = sriovnet.UnbindVf(pfHandle, vf); err != nil {
 		return err
@@ -99,6 +329,8 @@ func (p *pciUtilsImpl) RebindVf(pfName, vfPciAddress string) error {
 type sriovManager struct {
 	nLink types.NetlinkManager
 	utils types.PciUtils
+	// guidSysfs is set once the kernel rejected the netlink VF GUID attributes, GUIDs are then set through sysfs
+	guidSysfs int32
 }
 
 // NewSriovManager returns an instance of SriovManager
@@ -109,190 +341,1279 @@ func NewSriovManager() types.Manager {
 	}
 }
 
-// SetupVF sets up a VF in Pod netns
-func (s *sriovManager) SetupVF(conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
+// SetupVF sets up a VF in Pod netns. A VF which is already set up in the netns, e.g. by a retried SetupVF, is left as
+// is.
+func (s *sriovManager) SetupVF(ctx context.Context, conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
+	if name, ok := s.alreadySetUp(conf, podifName, netns); ok {
+		logging.Infof("SetupVF(): VF %s is already set up as %s in netns %s", conf.DeviceID, name, netns.Path())
+		conf.ContIFNames = name
+		return nil
+	}
+
 	// Get vf name since it may have been changed after the rebind in ApplyVFConfig which is called before
 	linkName, err := utils.GetVFLinkNames(conf.DeviceID)
 	if err != nil || linkName == "" {
-		return fmt.Errorf("failed to get VF %s name after rebind with error, %q", conf.DeviceID, err)
+		return fmt.Errorf("failed to get VF %s name after rebind with error, %w", conf.DeviceID, err)
 	}
 
 	linkObj, err := s.nLink.LinkByName(linkName)
 	if err != nil {
 		return fmt.Errorf("error getting VF netdevice with name %s", linkName)
 	}
+	logging.Debugf("SetupVF(): setting up VF %s (%s) as %s in netns %s", linkName, conf.DeviceID, podifName, netns.Path())
+
+	// save the VF name to restore it on release, it may be different than the one found on load after the rebind
+	conf.HostIFNames = linkName
+
+	// save the VF MTU to restore it on release
+	conf.HostIFMTU = linkObj.Attrs().MTU
+
+	// the derived hardware address is set and restored like a configured one
+	if conf.DeriveMACFromGUID {
+		hwaddr, err := utils.IPoIBAddressFromGUID(conf.GUID)
+		if err != nil {
+			return fmt.Errorf("failed to derive hardware address of VF %s: %w", conf.DeviceID, err)
+		}
+		conf.MAC = hwaddr.String()
+	}
+
+	// save the VF hardware address to restore it on release
+	if conf.MAC != "" {
+		conf.HostIFMAC = linkObj.Attrs().HardwareAddr.String()
+	}
+
+	var rdmaDev string
+	if conf.RdmaIsolation {
+		rdmaDev, err = s.getRdmaDevice(conf.DeviceID)
+		if err != nil {
+			return err
+		}
+	}
 
 	// tempName used as intermediary name to avoid name conflicts
 	tempName := fmt.Sprintf("vfdev%d", linkObj.Attrs().Index)
 
-	// 1. Set link down
-	if err := s.nLink.LinkSetDown(linkObj); err != nil {
-		return fmt.Errorf("failed to down vf device %q: %v", linkName, err)
+	// the VF keeps its kernel name in the container when renaming is disabled
+	rename := conf.RenameInterface == nil || *conf.RenameInterface
+	if !rename {
+		tempName = linkName
+		podifName = linkName
+	}
+
+	// the name has to be free in the netns before the VF is moved, a failed rename would leave it there as tempName
+	if podifName, err = s.resolveIfNameConflict(conf, netns, podifName, rename); err != nil {
+		return err
+	}
+
+	// 1. Set link down, a VF brought up on the host by hostAdminState is kept up unless it has to be down to be
+	// renamed
+	if rename || conf.HostAdminState != types.AdminStateUp {
+		logging.Debugf("SetupVF(): LinkSetDown %s", linkName)
+		if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetDown(linkObj) }); err != nil {
+			return fmt.Errorf("failed to down vf device %q: %w", linkName, err)
+		}
+	}
+
+	// 1.1 Set IPoIB mode. The mode is set through the sysfs of the host netns, so it is set before the move. It is
+	// also set before the MTU as MTUs larger than the datagram mode maximum require connected mode.
+	if conf.IPoIBMode != "" {
+		if err := s.setIPoIBMode(conf, linkName); err != nil {
+			return err
+		}
+	} else if conf.MTU > types.MaxIPoIBDatagramMTU {
+		if err := s.checkConnectedMode(conf, linkName); err != nil {
+			return err
+		}
 	}
 
 	// 2. Set temp name
-	if err := s.nLink.LinkSetName(linkObj, tempName); err != nil {
-		return fmt.Errorf("error setting temp IF name %s for %s", tempName, linkName)
+	if rename {
+		logging.Debugf("SetupVF(): LinkSetName %s to %s", linkName, tempName)
+		if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetName(linkObj, tempName) }); err != nil {
+			return fmt.Errorf("error setting temp IF name %s for %s", tempName, linkName)
+		}
 	}
 
-	// 3. Change netns
-	if err := s.nLink.LinkSetNsFd(linkObj, int(netns.Fd())); err != nil {
-		return fmt.Errorf("failed to move IF %s to netns: %q", tempName, err)
+	// 3. Change netns, the Pod may have been deleted since the netns was opened
+	if err := verifyNetnsFd(netns); err != nil {
+		return err
+	}
+	logging.Debugf("SetupVF(): LinkSetNsFd %s to netns %s", tempName, netns.Path())
+	if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetNsFd(linkObj, int(netns.Fd())) }); err != nil {
+		return fmt.Errorf("failed to move IF %s to netns: %w", tempName, err)
+	}
+
+	// 3.1 Change RDMA device netns
+	if conf.RdmaIsolation {
+		logging.Debugf("SetupVF(): RdmaLinkSetNsFd %s to netns %s", rdmaDev, netns.Path())
+		rdmaLink, err := s.nLink.RdmaLinkByName(rdmaDev)
+		if err != nil {
+			return fmt.Errorf("failed to get RDMA device %s: %w", rdmaDev, err)
+		}
+		if err := withRetryCtx(ctx, conf, func() error { return s.nLink.RdmaLinkSetNsFd(rdmaLink, uint32(netns.Fd())) }); err != nil {
+			return fmt.Errorf("failed to move RDMA device %s to netns: %w", rdmaDev, err)
+		}
+		conf.RdmaDevice = rdmaDev
 	}
 
+	if err := verifyNetnsFd(netns); err != nil {
+		return err
+	}
 	if err := netns.Do(func(_ ns.NetNS) error {
 		// 4. Set Pod IF name
-		if err := s.nLink.LinkSetName(linkObj, podifName); err != nil {
-			return fmt.Errorf("error setting container interface name %s for %s", linkName, tempName)
+		if rename {
+			logging.Debugf("SetupVF(): LinkSetName %s to %s", tempName, podifName)
+			if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetName(linkObj, podifName) }); err != nil {
+				return fmt.Errorf("error setting container interface name %s for %s", linkName, tempName)
+			}
+		}
+
+		// 4.1 Set the interface alias, cleared on release
+		if conf.IfAlias != "" {
+			alias := utils.RenderIfAlias(conf.IfAlias, cid, conf.Args.CNI["K8S_POD_UID"], conf.GUID)
+			if len(alias) > maxIfAliasLen {
+				return fmt.Errorf("ifAlias %q renders alias longer than %d characters", conf.IfAlias, maxIfAliasLen)
+			}
+			logging.Debugf("SetupVF(): LinkSetAlias %s to %q", podifName, alias)
+			if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetAlias(linkObj, alias) }); err != nil {
+				return fmt.Errorf("error setting container interface %s alias: %w", podifName, err)
+			}
 		}
 
-		// 5. Bring IF up in Pod netns
-		if err := s.nLink.LinkSetUp(linkObj); err != nil {
-			return fmt.Errorf("error bringing interface up in container ns: %q", err)
+		// 4.2 Apply interface sysctls
+		if err := applySysctls(conf, podifName); err != nil {
+			return err
+		}
+
+		// 5. Set hardware address
+		if conf.MAC != "" {
+			hwaddr, err := net.ParseMAC(conf.MAC)
+			if err != nil {
+				return fmt.Errorf("failed to parse mac address %s: %w", conf.MAC, err)
+			}
+			logging.Debugf("SetupVF(): LinkSetHardwareAddr %s to %s", podifName, conf.MAC)
+			if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetHardwareAddr(linkObj, hwaddr) }); err != nil {
+				return fmt.Errorf("error setting container interface %s mac address to %s: %w", podifName, conf.MAC, err)
+			}
+		}
+
+		// save the container interface hardware address, it carries the VF GUID in its last 8 bytes
+		conf.ContIFMAC = linkObj.Attrs().HardwareAddr.String()
+		if conf.MAC != "" {
+			conf.ContIFMAC = conf.MAC
+		}
+
+		// 6. Set MTU
+		if conf.MTU != 0 {
+			logging.Debugf("SetupVF(): LinkSetMTU %s to %d", podifName, conf.MTU)
+			if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetMTU(linkObj, conf.MTU) }); err != nil {
+				return fmt.Errorf("error setting container interface %s mtu to %d: %w", podifName, conf.MTU, err)
+			}
+		}
+
+		// 6.1 Turn ARP and neighbor discovery off
+		if conf.DisableArpNd {
+			logging.Debugf("SetupVF(): LinkSetARPOff %s", podifName)
+			if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetARPOff(linkObj) }); err != nil {
+				return fmt.Errorf("error turning arp off on container interface %s: %w", podifName, err)
+			}
+		}
+
+		// 6.2 Set ring sizes. They are not reverted on release, so no teardown is needed.
+		if conf.Rings != nil {
+			if err := s.setRings(ctx, conf, podifName); err != nil {
+				return err
+			}
+		}
+
+		// 7. Bring IF up in Pod netns
+		logging.Debugf("SetupVF(): LinkSetUp %s", podifName)
+		if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetUp(linkObj) }); err != nil {
+			return fmt.Errorf("error bringing interface up in container ns: %w", err)
+		}
+
+		// 7.1 Install static neighbors, they are flushed when the link goes down so this is done once it is up.
+		// No teardown is needed, the entries are removed with the interface from the Pod netns.
+		for _, neighbor := range conf.Neighbors {
+			lladdr, err := net.ParseMAC(neighbor.LLAddr)
+			if err != nil {
+				return fmt.Errorf("failed to parse neighbor %s lladdr %s: %w", neighbor.IP, neighbor.LLAddr, err)
+			}
+			neigh := &netlink.Neigh{
+				LinkIndex:    linkObj.Attrs().Index,
+				State:        netlink.NUD_PERMANENT,
+				IP:           net.ParseIP(neighbor.IP),
+				HardwareAddr: lladdr,
+			}
+			logging.Debugf("SetupVF(): NeighSet %s lladdr %s on %s", neighbor.IP, neighbor.LLAddr, podifName)
+			if err := withRetryCtx(ctx, conf, func() error { return s.nLink.NeighSet(neigh) }); err != nil {
+				return fmt.Errorf("error setting neighbor %s on container interface %s: %w", neighbor.IP, podifName, err)
+			}
+		}
+
+		// 8. Wait for IF to be operationally up
+		linkUpCtx, cancel := context.WithTimeout(ctx, linkUpTimeout(conf))
+		defer cancel()
+		if err := waitForLinkUp(linkUpCtx, s.nLink, podifName); err != nil {
+			return err
 		}
 
 		return nil
 	}); err != nil {
-		return fmt.Errorf("error setting up interface in container namespace: %q", err)
+		return fmt.Errorf("error setting up interface in container namespace: %w", err)
 	}
 	conf.ContIFNames = podifName
 
 	return nil
 }
 
+// verifyNetnsFd checks that the netns path still refers to the netns of the open fd. The open fd keeps the netns
+// alive, but once the runtime has unmounted the path, e.g. on a Pod deleted during ADD, the netns is destroyed with
+// the last fd and the VF moved to it returns to the host.
+func verifyNetnsFd(netns ns.NetNS) error {
+	fdID, err := utils.GetNetnsIDFromFd(netns.Fd())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNetnsGone, err)
+	}
+	pathID, err := utils.GetNetnsID(netns.Path())
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrNetnsGone, err)
+	}
+	if pathID != fdID {
+		return fmt.Errorf("%w: netns %s refers to netns %s instead of %s", ErrNetnsGone, netns.Path(), pathID, fdID)
+	}
+	return nil
+}
+
+// FindVFLink returns the name of the network device of the current netns with the PCI address, it is empty when the
+// VF is not in the netns. Devices which don't report their bus info are skipped.
+func FindVFLink(pciAddr string) (string, error) {
+	links, err := netlink.LinkList()
+	if err != nil {
+		return "", fmt.Errorf("failed to list links: %w", err)
+	}
+	for _, link := range links {
+		if busInfo, err := linkBusInfo(link.Attrs().Name); err == nil && busInfo == pciAddr {
+			return link.Attrs().Name, nil
+		}
+	}
+	return "", nil
+}
+
+// alreadySetUp reports whether the VF is already in the netns in its desired state and returns its name there. The
+// VF is found by its PCI address and must carry the GUID in the last 8 bytes of its hardware address, it must be up
+// with the configured name, hardware address and MTU. The host state of the VF which SetupVF records for the release
+// is filled in when conf lacks it, e.g. for an ADD retried by a new plugin process.
+func (s *sriovManager) alreadySetUp(conf *types.NetConf, podifName string, netns ns.NetNS) (string, bool) {
+	guid, err := net.ParseMAC(conf.GUID)
+	if err != nil {
+		return "", false
+	}
+	rename := conf.RenameInterface == nil || *conf.RenameInterface
+
+	var name string
+	var hwaddr net.HardwareAddr
+	if err := netns.Do(func(_ ns.NetNS) error {
+		if name, err = FindVFLink(conf.DeviceID); err != nil {
+			return err
+		}
+		if name == "" || (rename && name != podifName) {
+			return fmt.Errorf("VF %s is not in the netns as %s", conf.DeviceID, podifName)
+		}
+		linkObj, err := s.nLink.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		attrs := linkObj.Attrs()
+		if len(attrs.HardwareAddr) != ipoibHwAddrLen || !bytes.Equal(attrs.HardwareAddr[ipoibHwAddrLen-len(guid):], guid) {
+			return fmt.Errorf("device %s is not VF %s", name, conf.DeviceID)
+		}
+		if attrs.Flags&net.FlagUp == 0 || (conf.MTU != 0 && attrs.MTU != conf.MTU) {
+			return fmt.Errorf("VF %s is not set up", conf.DeviceID)
+		}
+		hwaddr = attrs.HardwareAddr
+		return nil
+	}); err != nil {
+		return "", false
+	}
+
+	mac := conf.MAC
+	if conf.DeriveMACFromGUID {
+		derived, err := utils.IPoIBAddressFromGUID(conf.GUID)
+		if err != nil {
+			return "", false
+		}
+		mac = derived.String()
+	}
+	if mac != "" && !strings.EqualFold(mac, hwaddr.String()) {
+		return "", false
+	}
+	if err := s.fillHostState(conf, name, rename, mac); err != nil {
+		logging.Warningf("SetupVF(): VF %s is in the netns as %s, setting it up again: %v", conf.DeviceID, name, err)
+		return "", false
+	}
+	conf.MAC = mac
+	conf.ContIFMAC = hwaddr.String()
+	return name, true
+}
+
+// fillHostState records the host state of a VF already set up in the netns which conf lacks. The VF is no longer on
+// the host so the state is reconstructed: the kernel name of a VF which was renamed and the MTU it had on the host
+// are unknown, the release falls back to the name derived from the PCI address and to the PF MTU the VF netdevice
+// inherits on creation. The host hardware address is the one the VF GUID gives.
+func (s *sriovManager) fillHostState(conf *types.NetConf, name string, rename bool, mac string) error {
+	if conf.HostIFNames == "" {
+		conf.HostIFNames = name
+		if rename {
+			conf.HostIFNames = utils.VFNameFromPciAddress(conf.DeviceID)
+		}
+	}
+	if conf.HostIFMTU == 0 {
+		pfLink, err := s.nLink.LinkByName(conf.Master)
+		if err != nil {
+			return fmt.Errorf("failed to lookup PF %q: %w", conf.Master, err)
+		}
+		conf.HostIFMTU = pfLink.Attrs().MTU
+	}
+	if mac != "" && conf.HostIFMAC == "" {
+		hwaddr, err := utils.IPoIBAddressFromGUID(conf.GUID)
+		if err != nil {
+			return err
+		}
+		conf.HostIFMAC = hwaddr.String()
+	}
+	if conf.RdmaIsolation && conf.RdmaDevice == "" {
+		rdmaDev, err := utils.GetRdmaDeviceName(conf.DeviceID)
+		if err != nil {
+			return err
+		}
+		conf.RdmaDevice = rdmaDev
+	}
+	return nil
+}
+
+// setIPoIBMode sets the configured IPoIB mode of the VF netdevice, saving its mode to restore it on release
+func (s *sriovManager) setIPoIBMode(conf *types.NetConf, linkName string) error {
+	mode, err := s.utils.GetIPoIBMode(linkName)
+	if err != nil {
+		return err
+	}
+	conf.HostIFIPoIBMode = mode
+	if mode == conf.IPoIBMode {
+		return nil
+	}
+	logging.Debugf("SetupVF(): setting IPoIB mode of %s from %s to %s", linkName, mode, conf.IPoIBMode)
+	return s.utils.SetIPoIBMode(linkName, conf.IPoIBMode)
+}
+
+// setHostAdminState brings the VF netdevice on the host to the configured host admin state, the netdevice is looked up
+// as the rebind recreates it
+func (s *sriovManager) setHostAdminState(ctx context.Context, conf *types.NetConf) error {
+	linkName, err := utils.GetVFLinkNames(conf.DeviceID)
+	if err != nil || linkName == "" {
+		return fmt.Errorf("failed to get VF %s name after rebind with error, %w", conf.DeviceID, err)
+	}
+	vfLink, err := s.nLink.LinkByName(linkName)
+	if err != nil {
+		return fmt.Errorf("failed to lookup vf %q: %w", linkName, err)
+	}
+
+	switch conf.HostAdminState {
+	case types.AdminStateUp:
+		logging.Debugf("ApplyVFConfig(): LinkSetUp %s", linkName)
+		if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetUp(vfLink) }); err != nil {
+			return fmt.Errorf("failed to bring vf %d netdevice %s up on the host: %w", conf.VFID, linkName, err)
+		}
+	case types.AdminStateDown:
+		logging.Debugf("ApplyVFConfig(): LinkSetDown %s", linkName)
+		if err := withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetDown(vfLink) }); err != nil {
+			return fmt.Errorf("failed to bring vf %d netdevice %s down on the host: %w", conf.VFID, linkName, err)
+		}
+	}
+	return nil
+}
+
+// checkConnectedMode checks that the VF whose mode is kept is in connected mode, the kernel rejects MTUs larger than
+// the datagram mode maximum with an opaque error
+func (s *sriovManager) checkConnectedMode(conf *types.NetConf, linkName string) error {
+	mode, err := s.utils.GetIPoIBMode(linkName)
+	if err != nil {
+		return fmt.Errorf("failed to read IPoIB mode of %s: %w", linkName, err)
+	}
+	if mode != types.IPoIBModeConnected {
+		return fmt.Errorf("mtu %d requires ipoibMode=%s, VF %s is in %s mode", conf.MTU, types.IPoIBModeConnected, linkName, mode)
+	}
+	return nil
+}
+
+// resolveIfNameConflict returns the name the VF gets in the netns: podifName when no device in the netns has it, or
+// with the rename policy the name with the first free "-<n>" suffix
+func (s *sriovManager) resolveIfNameConflict(conf *types.NetConf, netns ns.NetNS, podifName string, rename bool) (string, error) {
+	name := podifName
+	err := netns.Do(func(_ ns.NetNS) error {
+		exists, err := linkExists(podifName)
+		if err != nil {
+			return fmt.Errorf("failed to look up interface %s in netns %s: %w", podifName, netns.Path(), err)
+		}
+		if !exists {
+			return nil
+		}
+		if conf.OnNameConflict != types.NameConflictRename || !rename {
+			return fmt.Errorf("%w: device %s already exists in netns %s", ErrIfNameConflict, podifName, netns.Path())
+		}
+
+		for i := 1; i <= maxIfNameSuffix; i++ {
+			suffix := "-" + strconv.Itoa(i)
+			candidate := podifName
+			if len(candidate)+len(suffix) > types.MaxIfNameLen {
+				candidate = candidate[:types.MaxIfNameLen-len(suffix)]
+			}
+			candidate += suffix
+			exists, err := linkExists(candidate)
+			if err != nil {
+				return fmt.Errorf("failed to look up interface %s in netns %s: %w", candidate, netns.Path(), err)
+			}
+			if !exists {
+				logging.Infof("SetupVF(): device %s already exists in netns %s, setting up VF %s as %s",
+					podifName, netns.Path(), conf.DeviceID, candidate)
+				name = candidate
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: device %s and its %d suffixed alternatives already exist in netns %s",
+			ErrIfNameConflict, podifName, maxIfNameSuffix, netns.Path())
+	})
+	return name, err
+}
+
+// setRings validates the configured ring sizes against the maximum sizes of the container interface and sets them,
+// it must be called in the container netns
+func (s *sriovManager) setRings(ctx context.Context, conf *types.NetConf, podifName string) error {
+	maxRings, err := s.nLink.EthtoolGetMaxRings(podifName)
+	if err != nil {
+		return fmt.Errorf("failed to get container interface %s maximum ring sizes: %w", podifName, err)
+	}
+	if conf.Rings.RX > maxRings.RX {
+		return fmt.Errorf("rx ring size %d exceeds container interface %s maximum rx ring size %d",
+			conf.Rings.RX, podifName, maxRings.RX)
+	}
+	if conf.Rings.TX > maxRings.TX {
+		return fmt.Errorf("tx ring size %d exceeds container interface %s maximum tx ring size %d",
+			conf.Rings.TX, podifName, maxRings.TX)
+	}
+
+	logging.Debugf("SetupVF(): EthtoolSetRings %s to rx %d tx %d", podifName, conf.Rings.RX, conf.Rings.TX)
+	if err := withRetryCtx(ctx, conf, func() error { return s.nLink.EthtoolSetRings(podifName, *conf.Rings) }); err != nil {
+		return fmt.Errorf("error setting container interface %s ring sizes: %w", podifName, err)
+	}
+	return nil
+}
+
+// applySysctls writes the configured sysctls of the container interface in a sorted order,
+// it must be called in the container netns
+func applySysctls(conf *types.NetConf, podifName string) error {
+	keys := make([]string, 0, len(conf.Sysctls))
+	for key := range conf.Sysctls {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		path := utils.RenderInterfaceSysctl(key, podifName)
+		logging.Debugf("SetupVF(): setting sysctl %s to %s", path, conf.Sysctls[key])
+		if err := writeSysctl(path, conf.Sysctls[key]); err != nil {
+			return fmt.Errorf("failed to set sysctl %s to %s: %w", path, conf.Sysctls[key], err)
+		}
+	}
+	return nil
+}
+
+// getRdmaDevice returns the RDMA device of the VF, it fails if the RDMA subsystem is not in exclusive netns mode
+func (s *sriovManager) getRdmaDevice(pciAddr string) (string, error) {
+	mode, err := s.nLink.RdmaSystemGetNetnsMode()
+	if err != nil {
+		return "", fmt.Errorf("failed to get RDMA subsystem netns mode: %w", err)
+	}
+	if mode != rdmaNetnsModeExclusive {
+		return "", fmt.Errorf("RDMA subsystem netns mode is %q, rdmaIsolation requires %q mode "+
+			"(e.g. \"rdma system set netns %s\")", mode, rdmaNetnsModeExclusive, rdmaNetnsModeExclusive)
+	}
+
+	rdmaDev, err := utils.GetRdmaDeviceName(pciAddr)
+	if err != nil {
+		return "", fmt.Errorf("failed to get RDMA device of VF %s: %w", pciAddr, err)
+	}
+
+	return rdmaDev, nil
+}
+
+// linkUpTimeout returns how long the container interface is waited to be operationally up
+func linkUpTimeout(conf *types.NetConf) time.Duration {
+	if conf.LinkUpTimeout > 0 {
+		return time.Duration(conf.LinkUpTimeout) * time.Millisecond
+	}
+	return defaultLinkUpTimeout
+}
+
+// WaitForLinkUp polls the operational state of a link in the current netns until it is up, it fails after the
+// network link up timeout
+func WaitForLinkUp(ctx context.Context, conf *types.NetConf, ifName string) error {
+	ctx, cancel := context.WithTimeout(ctx, linkUpTimeout(conf))
+	defer cancel()
+	return waitForLinkUp(ctx, &MyNetlink{}, ifName)
+}
+
+// waitForLinkUp polls the link operational state until it is up or ctx is done
+func waitForLinkUp(ctx context.Context, nLink types.NetlinkManager, ifName string) error {
+	ticker := time.NewTicker(linkUpPollInterval)
+	defer ticker.Stop()
+
+	for {
+		linkObj, err := nLink.LinkByName(ifName)
+		if err != nil {
+			return fmt.Errorf("failed to get link %s while waiting for it to be up: %w", ifName, err)
+		}
+
+		// links which don't report their operational state are considered up
+		state := linkObj.Attrs().OperState
+		if state == netlink.OperUp || state == netlink.OperUnknown {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timeout waiting for link %s to be up, operational state is %s: %w", ifName, state, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
 // ReleaseVF reset a VF from Pod netns and return it to init netns
 func (s *sriovManager) ReleaseVF(conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
 
 	initns, err := ns.GetCurrentNS()
 	if err != nil {
-		return fmt.Errorf("failed to get init netns: %v", err)
+		return fmt.Errorf("failed to get init netns: %w", err)
 	}
 
 	if len(conf.ContIFNames) < 1 && len(conf.ContIFNames) != len(conf.HostIFNames) {
 		return fmt.Errorf("number of interface names mismatch ContIFNames: %d HostIFNames: %d", len(conf.ContIFNames), len(conf.HostIFNames))
 	}
 
-	return netns.Do(func(_ ns.NetNS) error {
+	// use the templated VF name unless it is taken on the host, then fall back to the VF original name
+	hostIFName := conf.HostIFNames
+	if conf.VFNameTemplate != "" {
+		name := utils.RenderVFName(conf.VFNameTemplate, conf.Master, conf.VFID, conf.DeviceID)
+		if _, err := s.nLink.LinkByName(name); err != nil {
+			hostIFName = name
+		} else {
+			logging.Warningf("ReleaseVF(): templated VF name %s is already in use on the host, using %s instead", name, conf.HostIFNames)
+		}
+	}
+
+	// the VF original name may have been taken on the host while it was in the Pod netns
+	if hostIFName == conf.HostIFNames {
+		if _, err := s.nLink.LinkByName(hostIFName); err == nil {
+			hostIFName = utils.VFNameFromPciAddress(conf.DeviceID)
+			logging.Warningf("ReleaseVF(): VF name %s is already in use on the host, using %s instead", conf.HostIFNames, hostIFName)
+		}
+	}
+
+	logging.Debugf("ReleaseVF(): releasing VF %s (%s) from netns %s", podifName, conf.DeviceID, netns.Path())
+	notInNetns := false
+	err = netns.Do(func(_ ns.NetNS) error {
 
 		// get VF device
 		linkObj, err := s.nLink.LinkByName(podifName)
 		if err != nil {
-			return fmt.Errorf("failed to get netlink device with name %s: %q", podifName, err)
+			notInNetns = true
+			return fmt.Errorf("failed to get netlink device with name %s: %w", podifName, err)
 		}
 
 		// shutdown VF device
-		if err = s.nLink.LinkSetDown(linkObj); err != nil {
-			return fmt.Errorf("failed to set link %s down: %q", podifName, err)
+		logging.Debugf("ReleaseVF(): LinkSetDown %s", podifName)
+		if err = withRetry(conf, func() error { return s.nLink.LinkSetDown(linkObj) }); err != nil {
+			return fmt.Errorf("failed to set link %s down: %w", podifName, err)
+		}
+
+		// let the in-flight traffic settle before the VF is moved
+		if conf.DrainOnDel {
+			s.drainVF(conf, podifName)
+		}
+
+		// restore VF MTU
+		if conf.MTU != 0 && conf.HostIFMTU != 0 {
+			logging.Debugf("ReleaseVF(): LinkSetMTU %s to %d", podifName, conf.HostIFMTU)
+			if err = withRetry(conf, func() error { return s.nLink.LinkSetMTU(linkObj, conf.HostIFMTU) }); err != nil {
+				return fmt.Errorf("failed to restore link %s mtu to %d: %w", podifName, conf.HostIFMTU, err)
+			}
+		}
+
+		// restore VF hardware address
+		if conf.MAC != "" && conf.HostIFMAC != "" {
+			hwaddr, err := net.ParseMAC(conf.HostIFMAC)
+			if err != nil {
+				return fmt.Errorf("failed to parse original mac address %s: %w", conf.HostIFMAC, err)
+			}
+			logging.Debugf("ReleaseVF(): LinkSetHardwareAddr %s to %s", podifName, conf.HostIFMAC)
+			if err = withRetry(conf, func() error { return s.nLink.LinkSetHardwareAddr(linkObj, hwaddr) }); err != nil {
+				return fmt.Errorf("failed to restore link %s mac address to %s: %w", podifName, conf.HostIFMAC, err)
+			}
+		}
+
+		// clear VF alias
+		if conf.IfAlias != "" {
+			logging.Debugf("ReleaseVF(): LinkSetAlias %s to \"\"", podifName)
+			if err = withRetry(conf, func() error { return s.nLink.LinkSetAlias(linkObj, "") }); err != nil {
+				return fmt.Errorf("failed to clear link %s alias: %w", podifName, err)
+			}
 		}
 
 		// rename VF device
-		err = s.nLink.LinkSetName(linkObj, conf.HostIFNames)
+		logging.Debugf("ReleaseVF(): LinkSetName %s to %s", podifName, hostIFName)
+		err = withRetry(conf, func() error { return s.nLink.LinkSetName(linkObj, hostIFName) })
 		if err != nil {
-			return fmt.Errorf("failed to rename link %s to host name %s: %q", podifName, conf.HostIFNames, err)
+			return fmt.Errorf("failed to rename link %s to host name %s: %w", podifName, hostIFName, err)
 		}
 
 		// move VF device to init netns
-		if err = s.nLink.LinkSetNsFd(linkObj, int(initns.Fd())); err != nil {
-			return fmt.Errorf("failed to move interface %s to init netns: %v", conf.HostIFNames, err)
+		logging.Debugf("ReleaseVF(): LinkSetNsFd %s to init netns", hostIFName)
+		if err = withRetry(conf, func() error { return s.nLink.LinkSetNsFd(linkObj, int(initns.Fd())) }); err != nil {
+			return fmt.Errorf("failed to move interface %s to init netns: %w", hostIFName, err)
+		}
+
+		// move VF RDMA device to init netns
+		if conf.RdmaIsolation && conf.RdmaDevice != "" {
+			logging.Debugf("ReleaseVF(): RdmaLinkSetNsFd %s to init netns", conf.RdmaDevice)
+			rdmaLink, err := s.nLink.RdmaLinkByName(conf.RdmaDevice)
+			if err != nil {
+				return fmt.Errorf("failed to get RDMA device %s: %w", conf.RdmaDevice, err)
+			}
+			if err = withRetry(conf, func() error { return s.nLink.RdmaLinkSetNsFd(rdmaLink, uint32(initns.Fd())) }); err != nil {
+				return fmt.Errorf("failed to move RDMA device %s to init netns: %w", conf.RdmaDevice, err)
+			}
 		}
 
 		return nil
 	})
+	if err != nil {
+		if !notInNetns {
+			// the VF is left in the Pod netns, possibly half released
+			return s.recoverVF(conf, err)
+		}
+		return err
+	}
+
+	// restore VF IPoIB mode on the host the VF was moved to, the VF is released so a failure only leaves it in the
+	// configured mode
+	if conf.IPoIBMode != "" && conf.HostIFIPoIBMode != "" && conf.HostIFIPoIBMode != conf.IPoIBMode {
+		logging.Debugf("ReleaseVF(): restoring IPoIB mode of %s to %s", hostIFName, conf.HostIFIPoIBMode)
+		if err := s.utils.SetIPoIBMode(hostIFName, conf.HostIFIPoIBMode); err != nil {
+			logging.Warningf("ReleaseVF(): %v", err)
+		}
+	}
+
+	return nil
+}
+
+// drainVF waits for the drain period of the configuration, bounded by the operation timeout so that DEL doesn't hang
+func (s *sriovManager) drainVF(conf *types.NetConf, podifName string) {
+	period := defaultDrainPeriod
+	if conf.DrainPeriod > 0 {
+		period = time.Duration(conf.DrainPeriod) * time.Millisecond
+	}
+	if timeout := time.Duration(conf.OperationTimeout) * time.Millisecond; timeout > 0 && period > timeout {
+		logging.Warningf("ReleaseVF(): drain period %v of %s exceeds the operation timeout, draining for %v",
+			period, podifName, timeout)
+		period = timeout
+	}
+	logging.Debugf("ReleaseVF(): draining %s for %v", podifName, period)
+	drainSleep(period)
+	logging.Debugf("ReleaseVF(): drained %s", podifName)
 }
 
-// ApplyVFConfig configure a VF with parameters given in NetConf
-func (s *sriovManager) ApplyVFConfig(conf *types.NetConf) error {
+// recoverVF brings a VF which failed to be released from the Pod netns back to the host by rebinding its driver.
+// The VF is stuck when the rebind fails or the VF netdevice is not found on the host after it.
+func (s *sriovManager) recoverVF(conf *types.NetConf, reason error) error {
+	logging.Warningf("ReleaseVF(): recovering VF %s of PF %s by rebinding it: %v", conf.DeviceID, conf.Master, reason)
+	if err := s.utils.RebindVf(conf.Master, conf.DeviceID); err != nil {
+		_ = logging.Errorf("ReleaseVF(): VF %s of PF %s is stuck, failed to rebind it: %v", conf.DeviceID, conf.Master, err)
+		return fmt.Errorf("%w: failed to release VF %s: %w, failed to rebind it: %w", ErrVFStuck, conf.DeviceID, reason, err)
+	}
 
-	pfLink, err := s.nLink.LinkByName(conf.Master)
+	name, err := utils.GetVFLinkNames(conf.DeviceID)
+	if err != nil || name == "" {
+		_ = logging.Errorf("ReleaseVF(): VF %s of PF %s is stuck, not found on the host after rebind: %v", conf.DeviceID, conf.Master, err)
+		return fmt.Errorf("%w: failed to release VF %s: %w, not found on the host after rebind", ErrVFStuck, conf.DeviceID, reason)
+	}
+
+	logging.Infof("ReleaseVF(): VF %s recovered on the host as %s", conf.DeviceID, name)
+	return nil
+}
+
+// ApplyVFConfig configure a VF with parameters given in NetConf. conf.HostVFConfig is recorded before the VF is
+// changed, a failure with conf.HostVFConfig set may have changed the VF part way and is undone by ResetVFConfig.
+func (s *sriovManager) ApplyVFConfig(ctx context.Context, conf *types.NetConf) error {
+	logging.Debugf("ApplyVFConfig(): configuring VF %d (%s) of PF %s with guid %s", conf.VFID, conf.DeviceID, conf.Master, conf.GUID)
+
+	pfLink, err := s.lookupPF(conf)
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
-	}
-
-	// Set link state
-	if conf.LinkState != "" {
-		var state uint32
-		switch conf.LinkState {
-		case "auto":
-			state = netlink.VF_LINK_STATE_AUTO
-		case "enable":
-			state = netlink.VF_LINK_STATE_ENABLE
-		case "disable":
-			state = netlink.VF_LINK_STATE_DISABLE
-		default:
+		return err
+	}
+
+	if !utils.IsValidGUID(conf.GUID) {
+		return fmt.Errorf("invalid guid %s", conf.GUID)
+	}
+
+	// snapshot the VF config before changing anything, a retried ApplyVFConfig keeps the first snapshot. The
+	// snapshot is set once complete so that a reset never restores a partial one.
+	if conf.HostVFConfig == nil {
+		vfLink, err := s.nLink.LinkByName(conf.HostIFNames)
+		if err != nil {
+			return fmt.Errorf("failed to lookup vf %q: %w", conf.HostIFNames, err)
+		}
+		// IPoIB hardware address is 20 bytes, the last 8 bytes are the port GUID
+		hwAddr := vfLink.Attrs().HardwareAddr.String()
+		if len(hwAddr) < 36 {
+			return fmt.Errorf("vf %q hardware address %q carries no guid", conf.HostIFNames, hwAddr)
+		}
+		snapshot := &types.VFConfig{GUID: hwAddr[36:]}
+		if vfs := pfLink.Attrs().Vfs; conf.VFID < len(vfs) {
+			vf := vfs[conf.VFID]
+			snapshot.LinkState = LinkStateToString(vf.LinkState)
+			snapshot.MinTxRate = int(vf.MinTxRate)
+			snapshot.MaxTxRate = int(vf.MaxTxRate)
+		}
+		conf.HostVFConfig = snapshot
+	}
+	conf.HostIFGUID = conf.HostVFConfig.GUID
+
+	// Set link state, in switchdev mode the VF link follows the representor admin state
+	if conf.PFSwitchdev != nil && *conf.PFSwitchdev {
+		if err := s.applyRepresentorConfig(conf); err != nil {
+			return err
+		}
+	} else if conf.LinkState != "" {
+		state, ok := LinkStateFromString(conf.LinkState)
+		if !ok {
 			// the value should have been validated earlier, return error if we somehow got here
-			return fmt.Errorf("unknown link state %s when setting it for vf %d: %v", conf.LinkState, conf.VFID, err)
+			return fmt.Errorf("unknown link state %s when setting it for vf %d", conf.LinkState, conf.VFID)
 		}
-		if err = s.nLink.LinkSetVfState(pfLink, conf.VFID, state); err != nil {
-			return fmt.Errorf("failed to set vf %d link state to %d: %v", conf.VFID, state, err)
+		// save the VF link state to restore it on release
+		conf.HostIFLinkState = conf.HostVFConfig.LinkState
+		logging.Debugf("ApplyVFConfig(): LinkSetVfState vf %d to %s", conf.VFID, conf.LinkState)
+		if err = withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetVfState(pfLink, conf.VFID, state) }); err != nil {
+			return fmt.Errorf("failed to set vf %d link state to %d: %w", conf.VFID, state, err)
 		}
 	}
 
-	// Set link guid
-	if !utils.IsValidGUID(conf.GUID) {
-		return fmt.Errorf("invalid guid %s", conf.GUID)
+	// Set link trust, recording the original trust to restore it on release
+	if conf.Trust != "" {
+		if conf.HostVFConfig.Trust == "" {
+			if orig, err := s.nLink.LinkGetVfTrust(pfLink, conf.VFID); err != nil {
+				logging.Warningf("ApplyVFConfig(): failed to read vf %d trust, it is turned off on release: %v", conf.VFID, err)
+			} else if orig {
+				conf.HostVFConfig.Trust = "on"
+			} else {
+				conf.HostVFConfig.Trust = "off"
+			}
+		}
+		trust := conf.Trust == "on"
+		logging.Debugf("ApplyVFConfig(): LinkSetVfTrust vf %d to %s", conf.VFID, conf.Trust)
+		if err = withRetryCtx(ctx, conf, func() error { return s.nLink.LinkSetVfTrust(pfLink, conf.VFID, trust) }); err != nil {
+			return fmt.Errorf("failed to set vf %d trust to %s: %w", conf.VFID, conf.Trust, err)
+		}
 	}
-	// save link guid
-	vfLink, err := s.nLink.LinkByName(conf.HostIFNames)
-	if err != nil {
-		return fmt.Errorf("failed to lookup vf %q: %v", conf.HostIFNames, err)
+
+	// Set link tx rate, the rates must not exceed the PF speed when it is known
+	if conf.MinTxRate != 0 || conf.MaxTxRate != 0 {
+		if speed, err := s.utils.GetLinkSpeed(conf.Master); err != nil {
+			logging.Warningf("ApplyVFConfig(): not validating vf %d tx rate: %v", conf.VFID, err)
+		} else if speed > 0 && (conf.MinTxRate > speed || conf.MaxTxRate > speed) {
+			return fmt.Errorf("vf %d tx rate min %d max %d Mbps exceeds PF %s speed %d Mbps",
+				conf.VFID, conf.MinTxRate, conf.MaxTxRate, conf.Master, speed)
+		}
+		logging.Debugf("ApplyVFConfig(): LinkSetVfRate vf %d to min %d max %d", conf.VFID, conf.MinTxRate, conf.MaxTxRate)
+		if err = withRetryCtx(ctx, conf, func() error {
+			return s.nLink.LinkSetVfRate(pfLink, conf.VFID, conf.MinTxRate, conf.MaxTxRate)
+		}); err != nil {
+			return fmt.Errorf("failed to set vf %d tx rate to min %d max %d: %w", conf.VFID, conf.MinTxRate, conf.MaxTxRate, err)
+		}
 	}
 
-	conf.HostIFGUID = vfLink.Attrs().HardwareAddr.String()[36:]
+	if err := checkDeadline(ctx); err != nil {
+		return err
+	}
 
-	// Set link guid
-	if err := s.setVfGUID(conf, pfLink, conf.GUID); err != nil {
+	// Set link pkey, when the PF doesn't expose VFs pkey configuration the pkey is left to the subnet manager
+	if conf.PKey != "" && s.utils.IsVfPKeyConfigurable(conf.Master, conf.DeviceID) {
+		if conf.HostVFConfig.PKeyIndex == "" {
+			idx, err := s.utils.GetVfPKeyEntry(conf.Master, conf.DeviceID, 0)
+			if err != nil {
+				return fmt.Errorf("failed to get vf %d pkey: %w", conf.VFID, err)
+			}
+			conf.HostVFConfig.PKeyIndex = idx
+		}
+		logging.Debugf("ApplyVFConfig(): setting vf %d pkey to %s", conf.VFID, conf.PKey)
+		if err := s.utils.SetVfPKey(conf.Master, conf.DeviceID, conf.PKey); err != nil {
+			return fmt.Errorf("failed to set vf %d pkey to %s: %w", conf.VFID, conf.PKey, err)
+		}
+	}
+
+	// Add the VF to the additional partitions in the unmapped entries of its pkey table, entries mapped by someone else
+	// are skipped. The added pkeys are recorded with their entry as they are added to remove them on reset.
+	if len(conf.PKeys) > 0 && s.utils.IsVfPKeyConfigurable(conf.Master, conf.DeviceID) {
+		entry := 0
+		if len(conf.AddedPKeys) > 0 {
+			entry = addedPKeyEntry(conf, len(conf.AddedPKeys)-1)
+		}
+		for _, pkey := range conf.PKeys[len(conf.AddedPKeys):] {
+			var err error
+			if entry, err = s.freePKeyEntry(conf, entry+1); err != nil {
+				return fmt.Errorf("failed to add vf %d to pkey %s: %w", conf.VFID, pkey, err)
+			}
+			logging.Debugf("ApplyVFConfig(): adding vf %d to pkey %s in pkey table entry %d", conf.VFID, pkey, entry)
+			if err := s.utils.SetVfPKeyEntry(conf.Master, conf.DeviceID, entry, pkey); err != nil {
+				return fmt.Errorf("failed to add vf %d to pkey %s: %w", conf.VFID, pkey, err)
+			}
+			conf.AddedPKeys = append(conf.AddedPKeys, pkey)
+			conf.AddedPKeyEntries = append(conf.AddedPKeyEntries, entry)
+		}
+	}
+
+	// Set link guid, the driver rebind may hang on a wedged device
+	if err := runCtx(ctx, func() error { return s.setVfGUID(conf, pfLink, conf.GUID) }); err != nil {
 		return err
 	}
 
+	// Set the host admin state once the rebind recreated the VF netdevice, some drivers and firmware versions
+	// require the VF netdevice to be brought up on the host before it is usable in the container. It is not
+	// restored: ReleaseVF brings the VF down on its way back.
+	if conf.HostAdminState != "" {
+		if err := s.setHostAdminState(ctx, conf); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyRepresentorConfig brings the VF representor up unless link_state is disable and records its admin state.
+// The VF netdev and its GUID are resolved from the PF virtfn links as in legacy mode, only the link state goes
+// through the representor, which has no equivalent of link_state auto.
+func (s *sriovManager) applyRepresentorConfig(conf *types.NetConf) error {
+	if conf.LinkState == "auto" {
+		return fmt.Errorf("link state auto is not supported for vf %d of PF %s in switchdev mode", conf.VFID, conf.Master)
+	}
+
+	rep, err := s.utils.GetVfRepresentor(conf.Master, conf.VFID)
+	if err != nil {
+		return fmt.Errorf("failed to get representor of vf %d: %w", conf.VFID, err)
+	}
+
+	repLink, err := s.nLink.LinkByName(rep)
+	if err != nil {
+		return fmt.Errorf("failed to lookup representor %q of vf %d: %w", rep, conf.VFID, err)
+	}
+
+	conf.Representor = rep
+	conf.RepresentorUp = repLink.Attrs().Flags&net.FlagUp != 0
+
+	if conf.LinkState == "disable" {
+		logging.Debugf("ApplyVFConfig(): LinkSetDown representor %s of vf %d", rep, conf.VFID)
+		if err = withRetry(conf, func() error { return s.nLink.LinkSetDown(repLink) }); err != nil {
+			return fmt.Errorf("failed to set representor %s down: %w", rep, err)
+		}
+		return nil
+	}
+
+	logging.Debugf("ApplyVFConfig(): LinkSetUp representor %s of vf %d", rep, conf.VFID)
+	if err = withRetry(conf, func() error { return s.nLink.LinkSetUp(repLink) }); err != nil {
+		return fmt.Errorf("failed to set representor %s up: %w", rep, err)
+	}
+
+	return nil
+}
+
+// resetRepresentorConfig restores the VF representor admin state recorded by applyRepresentorConfig
+func (s *sriovManager) resetRepresentorConfig(conf *types.NetConf) error {
+	repLink, err := s.nLink.LinkByName(conf.Representor)
+	if err != nil {
+		return fmt.Errorf("failed to lookup representor %q of vf %d: %w", conf.Representor, conf.VFID, err)
+	}
+
+	if conf.RepresentorUp {
+		logging.Debugf("ResetVFConfig(): LinkSetUp representor %s of vf %d", conf.Representor, conf.VFID)
+		err = withRetry(conf, func() error { return s.nLink.LinkSetUp(repLink) })
+	} else {
+		logging.Debugf("ResetVFConfig(): LinkSetDown representor %s of vf %d", conf.Representor, conf.VFID)
+		err = withRetry(conf, func() error { return s.nLink.LinkSetDown(repLink) })
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore representor %s admin state: %w", conf.Representor, err)
+	}
+
 	return nil
 }
 
+// ValidateVF validates the PF and VF state ApplyVFConfig requires, it doesn't change any state
+func (s *sriovManager) ValidateVF(conf *types.NetConf) error {
+	_, err := s.lookupPF(conf)
+	return err
+}
+
+// lookupPF returns the PF link of the VF after validating the PF can configure the VF
+func (s *sriovManager) lookupPF(conf *types.NetConf) (netlink.Link, error) {
+	if err := s.checkPFAllowed(conf); err != nil {
+		return nil, err
+	}
+
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		return nil, fmt.Errorf("%w %q: %w", ErrPFNotFound, conf.Master, err)
+	}
+
+	numVfs, err := s.validatePF(conf.Master, pfLink)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.ValidateVfIndex(conf.Master, conf.VFID, numVfs); err != nil {
+		return nil, fmt.Errorf("invalid VF %s: %w", conf.DeviceID, err)
+	}
+
+	return pfLink, nil
+}
+
+// checkPFAllowed checks that the PF name or its pci address matches the PF allowlist, when the allowlist is set
+func (s *sriovManager) checkPFAllowed(conf *types.NetConf) error {
+	if len(conf.PFAllowlist) == 0 {
+		return nil
+	}
+	for _, allowed := range conf.PFAllowlist {
+		if allowed == conf.Master {
+			return nil
+		}
+	}
+
+	// configurations cached before the PF PCI address was resolved by LoadConf don't have it
+	pciAddr := conf.PFPciAddress
+	if pciAddr == "" {
+		var err error
+		if pciAddr, err = s.utils.GetPfPciAddress(conf.Master); err != nil {
+			return fmt.Errorf("%w %q: %w", ErrPFNotAllowed, conf.Master, err)
+		}
+	}
+	for _, allowed := range conf.PFAllowlist {
+		if strings.HasPrefix(pciAddr, allowed) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w %q (%s): it is not in pfAllowlist %v", ErrPFNotAllowed, conf.Master, pciAddr, conf.PFAllowlist)
+}
+
+// validatePF checks that the PF is an up InfiniBand device with SR-IOV enabled and returns its number of VFs
+func (s *sriovManager) validatePF(pfName string, pfLink netlink.Link) (int, error) {
+	attrs := pfLink.Attrs()
+	if attrs.EncapType == "ether" {
+		return 0, fmt.Errorf("%w %q: device is Ethernet, use sriov-cni", ErrPFNotInfiniBand, pfName)
+	}
+	if attrs.EncapType != "infiniband" {
+		return 0, fmt.Errorf("%w %q: link type is %q", ErrPFNotInfiniBand, pfName, attrs.EncapType)
+	}
+
+	if attrs.Flags&net.FlagUp == 0 {
+		return 0, fmt.Errorf("%w %q: bring it up with \"ip link set %s up\"", ErrPFDown, pfName, pfName)
+	}
+
+	numVfs, err := s.utils.GetSriovNumVfs(pfName)
+	if err == nil && numVfs > 0 {
+		return numVfs, nil
+	}
+
+	// tell a device which is not SR-IOV capable from a PF without VFs
+	totalVfs, totalErr := s.utils.GetSriovTotalVfs(pfName)
+	switch {
+	case totalErr != nil:
+		return 0, fmt.Errorf("%w %q: device is not SR-IOV capable: %w", ErrPFSriovNotEnabled, pfName, totalErr)
+	case totalVfs == 0:
+		return 0, fmt.Errorf("%w %q: device supports no VFs (sriov_totalvfs=0), enable SR-IOV in the device firmware",
+			ErrPFSriovNotEnabled, pfName)
+	case err != nil:
+		return 0, fmt.Errorf("%w %q: %w", ErrPFSriovNotEnabled, pfName, err)
+	}
+	return 0, fmt.Errorf("%w %q: no VFs are configured in sriov_numvfs (sriov_totalvfs=%d)", ErrPFSriovNotEnabled, pfName, totalVfs)
+}
+
 // ResetVFConfig reset a VF with default values
 func (s *sriovManager) ResetVFConfig(conf *types.NetConf) error {
+	logging.Debugf("ResetVFConfig(): resetting VF %d (%s) of PF %s, guid reset policy %q original guid %s",
+		conf.VFID, conf.DeviceID, conf.Master, conf.ResetGUIDPolicy, conf.HostIFGUID)
 
 	pfLink, err := s.nLink.LinkByName(conf.Master)
 	if err != nil {
-		return fmt.Errorf("failed to lookup master %q: %v", conf.Master, err)
+		return fmt.Errorf("failed to lookup master %q: %w", conf.Master, err)
 	}
+	baseline := vfBaseline(conf)
 
-	// Reset link state to `auto`
-	if conf.LinkState != "" {
+	// Reset link state to the recorded original state or to `auto` if it was not recorded
+	if conf.Representor != "" {
+		if err := s.resetRepresentorConfig(conf); err != nil {
+			return err
+		}
+	} else if conf.LinkState != "" {
 		// While resetting to `auto` can be a reasonable thing to do regardless of whether it was explicitly
 		// specified in the network definition, reset only when link_state was explicitly specified, to
 		// accommodate for drivers / NICs that don't support the netlink command (e.g. igb driver)
-		if err = s.nLink.LinkSetVfState(pfLink, conf.VFID, 0); err != nil {
-			return fmt.Errorf("failed to set link state to auto for vf %d: %v", conf.VFID, err)
+		state := uint32(netlink.VF_LINK_STATE_AUTO)
+		stateName := "auto"
+		if st, ok := LinkStateFromString(baseline.LinkState); ok {
+			state = st
+			stateName = baseline.LinkState
+		}
+		logging.Debugf("ResetVFConfig(): LinkSetVfState vf %d to %s", conf.VFID, stateName)
+		if err = withRetry(conf, func() error { return s.nLink.LinkSetVfState(pfLink, conf.VFID, state) }); err != nil {
+			return fmt.Errorf("failed to set link state to %s for vf %d: %w", stateName, conf.VFID, err)
 		}
 	}
 
-	// Reset link guid
-	// if the host guid is all zeros which is invalid guid replace it with all F guid
-	// This happen when create a VF it guid is all zeros
-	if utils.IsAllZeroGUID(conf.HostIFGUID) {
-		conf.HostIFGUID = "FF:FF:FF:FF:FF:FF:FF:FF"
+	// Restore link trust when it was set by us, a cache without the original trust falls back to turning it off
+	if conf.Trust != "" {
+		trust := baseline.Trust
+		if trust == "" && conf.Trust == "on" {
+			trust = "off"
+		}
+		if trust != "" && trust != conf.Trust {
+			logging.Debugf("ResetVFConfig(): LinkSetVfTrust vf %d to %s", conf.VFID, trust)
+			if err = withRetry(conf, func() error { return s.nLink.LinkSetVfTrust(pfLink, conf.VFID, trust == "on") }); err != nil {
+				return fmt.Errorf("failed to set trust to %s for vf %d: %w", trust, conf.VFID, err)
+			}
+		}
 	}
 
-	if err := s.setVfGUID(conf, pfLink, conf.HostIFGUID); err != nil {
-		return err
+	// Restore link tx rate
+	if conf.MinTxRate != 0 || conf.MaxTxRate != 0 {
+		logging.Debugf("ResetVFConfig(): LinkSetVfRate vf %d to min %d max %d", conf.VFID, baseline.MinTxRate, baseline.MaxTxRate)
+		if err = withRetry(conf, func() error {
+			return s.nLink.LinkSetVfRate(pfLink, conf.VFID, baseline.MinTxRate, baseline.MaxTxRate)
+		}); err != nil {
+			return fmt.Errorf("failed to restore tx rate for vf %d: %w", conf.VFID, err)
+		}
+	}
+
+	// Remove the VF from the partitions added by us, last added first. The entries were unmapped before.
+	for len(conf.AddedPKeys) > 0 {
+		i := len(conf.AddedPKeys) - 1
+		entry := addedPKeyEntry(conf, i)
+		logging.Debugf("ResetVFConfig(): removing vf %d from pkey %s in pkey table entry %d", conf.VFID, conf.AddedPKeys[i], entry)
+		if err := s.utils.ClearVfPKeyEntry(conf.Master, conf.DeviceID, entry); err != nil {
+			return fmt.Errorf("failed to remove vf %d from pkey %s: %w", conf.VFID, conf.AddedPKeys[i], err)
+		}
+		conf.AddedPKeys = conf.AddedPKeys[:i]
+		if i < len(conf.AddedPKeyEntries) {
+			conf.AddedPKeyEntries = conf.AddedPKeyEntries[:i]
+		}
+	}
+
+	// Reset link pkey to the recorded original partition, or to the default partition if it was not recorded
+	if conf.PKey != "" && s.utils.IsVfPKeyConfigurable(conf.Master, conf.DeviceID) {
+		logging.Debugf("ResetVFConfig(): resetting vf %d pkey", conf.VFID)
+		if err := s.utils.ResetVfPKey(conf.Master, conf.DeviceID, baseline.PKeyIndex); err != nil {
+			return fmt.Errorf("failed to reset vf %d pkey: %w", conf.VFID, err)
+		}
+	}
+
+	// Reset link guid according to the reset policy, a cache from an older version has no policy and restores the
+	// original guid
+	if conf.ResetGUIDPolicy != types.ResetGUIDKeep {
+		// if no host guid was recorded (e.g. cache from an older version) treat it as administratively unset
+		if conf.HostIFGUID == "" || conf.ResetGUIDPolicy == types.ResetGUIDZero {
+			conf.HostIFGUID = "00:00:00:00:00:00:00:00"
+		}
+
+		// if the host guid is all zeros which is invalid guid replace it with all F guid
+		// This happen when create a VF it guid is all zeros
+		if utils.IsAllZeroGUID(conf.HostIFGUID) {
+			conf.HostIFGUID = "FF:FF:FF:FF:FF:FF:FF:FF"
+		}
+
+		if err := s.setVfGUID(conf, pfLink, conf.HostIFGUID); err != nil {
+			return err
+		}
+	} else {
+		logging.Debugf("ResetVFConfig(): keeping vf %d guid", conf.VFID)
+	}
+
+	// Release the GUID allocated from the GUID pool
+	if conf.AllocatedGUID != "" && conf.GUIDPool != nil {
+		logging.Debugf("ResetVFConfig(): releasing guid %s to the GUID pool", conf.AllocatedGUID)
+		if err := utils.ReleaseGUID(conf.GUIDPool.DataDir, conf.AllocatedGUID, conf.DeviceID); err != nil {
+			return fmt.Errorf("failed to release guid %s: %w", conf.AllocatedGUID, err)
+		}
 	}
 
 	return nil
 }
 
+// addedPKeyEntry returns the VF pkey table entry the i-th added pkey is mapped in, a cache from an older version
+// mapped the added pkeys in the entries from 1 on
+func addedPKeyEntry(conf *types.NetConf, i int) int {
+	if i < len(conf.AddedPKeyEntries) {
+		return conf.AddedPKeyEntries[i]
+	}
+	return i + 1
+}
+
+// freePKeyEntry returns the first unmapped entry of the VF pkey table from entry on
+func (s *sriovManager) freePKeyEntry(conf *types.NetConf, entry int) (int, error) {
+	for ; ; entry++ {
+		idx, err := s.utils.GetVfPKeyEntry(conf.Master, conf.DeviceID, entry)
+		if err != nil {
+			return 0, fmt.Errorf("no unmapped entry in the pkey table of vf %d: %w", conf.VFID, err)
+		}
+		if utils.IsVfPKeyEntryFree(idx) {
+			return entry, nil
+		}
+		logging.Debugf("ApplyVFConfig(): skipping pkey table entry %d of vf %d mapped to index %s", entry, conf.VFID, idx)
+	}
+}
+
+// vfBaseline returns the VF config ResetVFConfig restores: the snapshot taken by ApplyVFConfig, or for a cache from an
+// older version the original values recorded for the configured settings, with no tx rate limit
+func vfBaseline(conf *types.NetConf) *types.VFConfig {
+	if conf.HostVFConfig != nil {
+		return conf.HostVFConfig
+	}
+	return &types.VFConfig{LinkState: conf.HostIFLinkState, GUID: conf.HostIFGUID}
+}
+
+// VerifyVFReset reads the VF state back after ResetVFConfig and checks that the VF is on the host with the guid of
+// the guid reset policy, and that the link state and tx rate it reset are back to their original values
+func (s *sriovManager) VerifyVFReset(conf *types.NetConf) error {
+	var problems []string
+
+	linkName, err := utils.GetVFLinkNames(conf.DeviceID)
+	if err != nil || linkName == "" {
+		problems = append(problems, fmt.Sprintf("VF netdevice is not on the host: %v", err))
+	} else if vfLink, err := s.nLink.LinkByName(linkName); err != nil {
+		problems = append(problems, fmt.Sprintf("failed to lookup VF netdevice %s: %v", linkName, err))
+	} else if problem := verifyVFGUID(conf, vfLink.Attrs().HardwareAddr.String()); problem != "" {
+		problems = append(problems, problem)
+	}
+
+	pfLink, err := s.nLink.LinkByName(conf.Master)
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("failed to lookup master %q: %v", conf.Master, err))
+	} else if vfs := pfLink.Attrs().Vfs; conf.VFID < len(vfs) {
+		vf := vfs[conf.VFID]
+		baseline := vfBaseline(conf)
+		if conf.Representor == "" && conf.LinkState != "" {
+			expected := baseline.LinkState
+			if _, ok := LinkStateFromString(expected); !ok {
+				expected = "auto"
+			}
+			if actual := LinkStateToString(vf.LinkState); actual != expected {
+				problems = append(problems, fmt.Sprintf("link state is %s, expected %s", actual, expected))
+			}
+		}
+		if (conf.MinTxRate != 0 || conf.MaxTxRate != 0) &&
+			(int(vf.MinTxRate) != baseline.MinTxRate || int(vf.MaxTxRate) != baseline.MaxTxRate) {
+			problems = append(problems, fmt.Sprintf("tx rate is %d-%d Mbps, expected %d-%d Mbps",
+				vf.MinTxRate, vf.MaxTxRate, baseline.MinTxRate, baseline.MaxTxRate))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("VF %d (%s) of PF %s was not reset: %s", conf.VFID, conf.DeviceID, conf.Master,
+			strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// verifyVFGUID checks the guid of the IPoIB hardware address of the released VF against the guid reset policy, a
+// guid which was administratively unset only has to differ from the Pod guid
+func verifyVFGUID(conf *types.NetConf, hwAddr string) string {
+	// IPoIB hardware address is 20 bytes, the last 8 bytes are the port GUID
+	if len(hwAddr) < 36 {
+		return fmt.Sprintf("VF hardware address %q carries no guid", hwAddr)
+	}
+	guid := hwAddr[36:]
+
+	switch {
+	case conf.ResetGUIDPolicy == types.ResetGUIDKeep:
+		if conf.GUID != "" && !strings.EqualFold(guid, conf.GUID) {
+			return fmt.Sprintf("guid is %s, expected the kept guid %s", guid, conf.GUID)
+		}
+	case conf.HostIFGUID == "" || strings.EqualFold(conf.HostIFGUID, "FF:FF:FF:FF:FF:FF:FF:FF"):
+		if conf.GUID != "" && strings.EqualFold(guid, conf.GUID) {
+			return fmt.Sprintf("guid is still the Pod guid %s", guid)
+		}
+	case !strings.EqualFold(guid, conf.HostIFGUID):
+		return fmt.Sprintf("guid is %s, expected the original guid %s", guid, conf.HostIFGUID)
+	}
+	return ""
+}
+
+// LinkStateFromString returns the netlink VF link state of a link_state value
+func LinkStateFromString(state string) (uint32, bool) {
+	switch state {
+	case "auto":
+		return netlink.VF_LINK_STATE_AUTO, true
+	case "enable":
+		return netlink.VF_LINK_STATE_ENABLE, true
+	case "disable":
+		return netlink.VF_LINK_STATE_DISABLE, true
+	}
+	return 0, false
+}
+
+// LinkStateToString returns the link_state value of a netlink VF link state, an unknown state is reported as auto
+func LinkStateToString(state uint32) string {
+	switch state {
+	case netlink.VF_LINK_STATE_ENABLE:
+		return "enable"
+	case netlink.VF_LINK_STATE_DISABLE:
+		return "disable"
+	}
+	return "auto"
+}
+
 func (s *sriovManager) setVfGUID(conf *types.NetConf, pfLink netlink.Link, guidAddr string) error {
 	guid, err := net.ParseMAC(guidAddr)
 	if err != nil {
-		return fmt.Errorf("failed to parse guid %s: %v", guidAddr, err)
+		return fmt.Errorf("failed to parse guid %s: %w", guidAddr, err)
 	}
-	if err = s.nLink.LinkSetVfNodeGUID(pfLink, conf.VFID, guid); err != nil {
-		return fmt.Errorf("failed to add node guid %s: %v", guid, err)
+	if atomic.LoadInt32(&s.guidSysfs) == 0 {
+		err = s.setVfGUIDNetlink(conf, pfLink, guid)
+		if errors.Is(err, syscall.EOPNOTSUPP) {
+			logging.Infof("setVfGUID(): netlink VF GUID attributes are not supported, setting GUIDs through sysfs: %v", err)
+			atomic.StoreInt32(&s.guidSysfs, 1)
+		} else if err != nil {
+			return err
+		}
 	}
-	if err = s.nLink.LinkSetVfPortGUID(pfLink, conf.VFID, guid); err != nil {
-		return fmt.Errorf("failed to add port guid %s: %v", guid, err)
+	if atomic.LoadInt32(&s.guidSysfs) == 1 {
+		logging.Debugf("setVfGUID(): SetVfGUIDSysfs vf %d to %s", conf.VFID, guid)
+		if err = s.utils.SetVfGUIDSysfs(conf.Master, conf.VFID, guid.String()); err != nil {
+			return err
+		}
 	}
 	// unbind vf then bind it to apply the guid
+	logging.Debugf("setVfGUID(): rebinding vf %s", conf.DeviceID)
 	if err = s.utils.RebindVf(conf.Master, conf.DeviceID); err != nil {
 		return err
 	}
 	return nil
 }
+
+// setVfGUIDNetlink sets the VF node and port GUID through the netlink VF GUID attributes
+func (s *sriovManager) setVfGUIDNetlink(conf *types.NetConf, pfLink netlink.Link, guid net.HardwareAddr) error {
+	logging.Debugf("setVfGUID(): LinkSetVfNodeGUID and LinkSetVfPortGUID vf %d to %s", conf.VFID, guid)
+	if err := withRetry(conf, func() error { return s.nLink.LinkSetVfNodeGUID(pfLink, conf.VFID, guid) }); err != nil {
+		return fmt.Errorf("failed to add node guid %s: %w", guid, err)
+	}
+	if err := withRetry(conf, func() error { return s.nLink.LinkSetVfPortGUID(pfLink, conf.VFID, guid) }); err != nil {
+		return fmt.Errorf("failed to add port guid %s: %w", guid, err)
+	}
+	return nil
+}
@@ -1,18 +1,29 @@
 package sriov
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/Mellanox/ib-sriov-cni/pkg/types"
 	"github.com/Mellanox/ib-sriov-cni/pkg/types/mocks"
+	"github.com/Mellanox/ib-sriov-cni/pkg/utils"
 
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/containernetworking/plugins/pkg/testutils"
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 	"github.com/stretchr/testify/mock"
 	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
 )
 
 // FakeLink is a dummy netlink struct used during testing
@@ -32,6 +43,39 @@ func (l *FakeLink) Type() string {
 	return "FakeLink"
 }
 
+// tearDownNetns unmounts and removes the netns path as the runtime does on Pod deletion, the netns lives on while it
+// is open
+func tearDownNetns(netns ns.NetNS) error {
+	if err := syscall.Unmount(netns.Path(), syscall.MNT_DETACH); err != nil {
+		return err
+	}
+	return os.Remove(netns.Path())
+}
+
+// addVFToNetns adds a device standing for the VF of the PCI address to the netns and returns a func removing it
+func addVFToNetns(netns ns.NetNS, name, pciAddr string) func() {
+	orig := linkBusInfo
+	linkBusInfo = func(ifName string) (string, error) {
+		if ifName == name {
+			return pciAddr, nil
+		}
+		return orig(ifName)
+	}
+	Expect(netns.Do(func(_ ns.NetNS) error {
+		return netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: name}, PeerName: name + "p"})
+	})).To(Succeed())
+	return func() {
+		linkBusInfo = orig
+		Expect(netns.Do(func(_ ns.NetNS) error {
+			link, err := netlink.LinkByName(name)
+			if err != nil {
+				return err
+			}
+			return netlink.LinkDel(link)
+		})).To(Succeed())
+	}
+}
+
 var _ = Describe("Sriov", func() {
 
 	Context("Checking ApplyVFConfig function", func() {
@@ -51,11 +95,14 @@ var _ = Describe("Sriov", func() {
 		It("ApplyVFConfig with valid GUID", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
 			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
 			hostGuid := "11:22:33:00:00:aa:bb:cc"
 			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
 			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
 				HardwareAddr: gid,
 			}}
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
@@ -67,249 +114,2165 @@ var _ = Describe("Sriov", func() {
 			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
-			err = sm.ApplyVFConfig(netconf)
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).NotTo(HaveOccurred())
 			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
 		})
-		It("ApplyVFConfig with invalid GUID - wrong characters", func() {
+		It("ApplyVFConfig with host admin state", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{}}
-			netconf.GUID = "invalid GUID"
+			// the PF and the VF are both the fake link
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.HostAdminState = types.AdminStateUp
 
+			// the rebind recreates the VF netdevice, it is looked up again by its PCI address
+			var calls []string
+			mockedNetLinkManger.On("LinkByName", "ib1").Run(func(mock.Arguments) {
+				calls = append(calls, "LinkByName ib1")
+			}).Return(fakeLink, nil)
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetUp", fakeLink).Run(func(mock.Arguments) {
+				calls = append(calls, "LinkSetUp")
+			}).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Run(func(mock.Arguments) {
+				calls = append(calls, "RebindVf")
+			}).Return(nil)
 
-			sm := sriovManager{nLink: mockedNetLinkManger}
-			err := sm.ApplyVFConfig(netconf)
-			Expect(err).To(HaveOccurred())
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			Expect(sm.ApplyVFConfig(context.Background(), netconf)).To(Succeed())
+			Expect(calls).To(Equal([]string{"RebindVf", "LinkByName ib1", "LinkSetUp"}))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetDown", mock.Anything)
 		})
-		It("ApplyVFConfig with invalid GUID - wrong length", func() {
+		It("ApplyVFConfig with operation deadline exceeded during rebind", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{}}
-			netconf.GUID = "00:11:22:33:44:55:66"
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			// rebind of a wedged device
+			rebind := make(chan struct{})
+			defer close(rebind)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Run(func(mock.Arguments) {
+				<-rebind
+			}).Return(nil)
 
-			sm := sriovManager{nLink: mockedNetLinkManger}
-			err := sm.ApplyVFConfig(netconf)
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			start := time.Now()
+			err = sm.ApplyVFConfig(ctx, netconf)
 			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, context.DeadlineExceeded)).To(BeTrue())
+			Expect(time.Since(start)).To(BeNumerically("<", time.Second))
 		})
-		It("ApplyVFConfig with invalid GUID - all zeros guid", func() {
+		It("ApplyVFConfig failing to set host admin state", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{}}
-			netconf.GUID = "00:00:00:00:00:00:00:00"
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.HostAdminState = types.AdminStateDown
 
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetDown", fakeLink).Return(errors.New("operation not supported"))
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 
-			sm := sriovManager{nLink: mockedNetLinkManger}
-			err := sm.ApplyVFConfig(netconf)
-			Expect(err).To(HaveOccurred())
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(MatchError("failed to bring vf 0 netdevice ib1 down on the host: operation not supported"))
 		})
-		It("ApplyVFConfig with invalid GUID - invalid guid address", func() {
+		It("ApplyVFConfig with valid GUID without netlink VF GUID support", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{}}
-			netconf.GUID = "00:AF-3B-0123:21:3322"
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(syscall.EOPNOTSUPP)
+			mockedPciUtils.On("SetVfGUIDSysfs", netconf.Master, netconf.VFID, netconf.GUID).Return(nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 
-			sm := sriovManager{nLink: mockedNetLinkManger}
-			err := sm.ApplyVFConfig(netconf)
-			Expect(err).To(HaveOccurred())
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			Expect(sm.ApplyVFConfig(context.Background(), netconf)).To(Succeed())
+			// the netlink VF GUID support is detected once
+			Expect(sm.ApplyVFConfig(context.Background(), netconf)).To(Succeed())
+			mockedNetLinkManger.AssertNumberOfCalls(GinkgoT(), "LinkSetVfNodeGUID", 1)
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfPortGUID", mock.Anything, mock.Anything, mock.Anything)
+			mockedPciUtils.AssertNumberOfCalls(GinkgoT(), "SetVfGUIDSysfs", 2)
 		})
-		It("ApplyVFConfig check guid - failed to get vf link", func() {
+		It("ApplyVFConfig with valid GUID failing to set the node guid", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
 			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
 
-			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
-			mockedNetLinkManger.On("LinkByName", netconf.HostIFNames).Return(nil, errors.New("mocked failed"))
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(syscall.EPERM)
 
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
-			err := sm.ApplyVFConfig(netconf)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal(`failed to lookup vf "ibFake5": mocked failed`))
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, syscall.EPERM)).To(BeTrue())
+			mockedPciUtils.AssertNotCalled(GinkgoT(), "SetVfGUIDSysfs", mock.Anything, mock.Anything, mock.Anything)
 		})
-		It("ApplyVFConfig check guid - failed to set node guid", func() {
+		It("ApplyVFConfig with valid GUID and pkey", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
-
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
 			hostGuid := "11:22:33:00:00:aa:bb:cc"
 			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
 			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
 				HardwareAddr: gid,
 			}}
-
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PKey = "0x0001"
 
-			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
-			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 
-			sm := sriovManager{nLink: mockedNetLinkManger}
-			err = sm.ApplyVFConfig(netconf)
-			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal(`failed to add node guid 01:23:45:67:89:ab:cd:ef: mocked failed`))
-			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
+			mockedPciUtils.On("IsVfPKeyConfigurable", netconf.Master, netconf.DeviceID).Return(true)
+			mockedPciUtils.On("GetVfPKeyEntry", netconf.Master, netconf.DeviceID, 0).Return("1", nil)
+			mockedPciUtils.On("SetVfPKey", netconf.Master, netconf.DeviceID, "0x0001").Return(nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedPciUtils.AssertExpectations(GinkgoT())
 		})
-		It("ApplyVFConfig check guid - failed to set port guid", func() {
+		It("ApplyVFConfig with additional pkeys", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
-
-			hostGuid := "11:22:33:00:00:aa:bb:cc"
-			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
 			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
 				HardwareAddr: gid,
 			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PKeys = []string{"0x0002", "0x0003"}
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("IsVfPKeyConfigurable", netconf.Master, netconf.DeviceID).Return(true)
+			// entry 2 is mapped by someone else
+			mockedPciUtils.On("GetVfPKeyEntry", netconf.Master, netconf.DeviceID, 1).Return("none", nil)
+			mockedPciUtils.On("GetVfPKeyEntry", netconf.Master, netconf.DeviceID, 2).Return("4", nil)
+			mockedPciUtils.On("GetVfPKeyEntry", netconf.Master, netconf.DeviceID, 3).Return("none", nil)
+			mockedPciUtils.On("SetVfPKeyEntry", netconf.Master, netconf.DeviceID, 1, "0x0002").Return(nil)
+			mockedPciUtils.On("SetVfPKeyEntry", netconf.Master, netconf.DeviceID, 3, "0x0003").Return(nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.AddedPKeys).To(Equal([]string{"0x0002", "0x0003"}))
+			Expect(netconf.AddedPKeyEntries).To(Equal([]int{1, 3}))
+			mockedPciUtils.AssertNotCalled(GinkgoT(), "SetVfPKeyEntry", netconf.Master, netconf.DeviceID, 2, mock.Anything)
+			mockedPciUtils.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with additional pkeys - failed to add pkey", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PKeys = []string{"0x0002", "0x0003"}
 
-			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
-			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
-			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			mockedPciUtils.On("IsVfPKeyConfigurable", netconf.Master, netconf.DeviceID).Return(true)
+			mockedPciUtils.On("GetVfPKeyEntry", netconf.Master, netconf.DeviceID, mock.AnythingOfType("int")).Return("none", nil)
+			mockedPciUtils.On("SetVfPKeyEntry", netconf.Master, netconf.DeviceID, 1, "0x0002").Return(nil)
+			mockedPciUtils.On("SetVfPKeyEntry", netconf.Master, netconf.DeviceID, 2, "0x0003").Return(errors.New("mocked failed"))
 
-			sm := sriovManager{nLink: mockedNetLinkManger}
-			err = sm.ApplyVFConfig(netconf)
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal(`failed to add port guid 01:23:45:67:89:ab:cd:ef: mocked failed`))
-			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
+			Expect(netconf.AddedPKeys).To(Equal([]string{"0x0002"}), "added pkeys are recorded to be removed on reset")
 		})
-		It("ApplyVFConfig check guid - failed to rebind after set guid", func() {
+		It("ApplyVFConfig with pkey - failed to set pkey", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
 			mockedPciUtils := &mocks.PciUtils{}
-
-			hostGuid := "11:22:33:00:00:aa:bb:cc"
-			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
 			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
 				HardwareAddr: gid,
 			}}
-
 			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PKey = "0x0002"
 
-			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
-			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
-			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 
-			mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(errors.New("mocked failed"))
+			mockedPciUtils.On("IsVfPKeyConfigurable", netconf.Master, netconf.DeviceID).Return(true)
+			mockedPciUtils.On("GetVfPKeyEntry", netconf.Master, netconf.DeviceID, 0).Return("0", nil)
+			mockedPciUtils.On("SetVfPKey", netconf.Master, netconf.DeviceID, "0x0002").Return(errors.New("mocked failed"))
 
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
-			err = sm.ApplyVFConfig(netconf)
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).To(HaveOccurred())
-			Expect(err.Error()).To(Equal("mocked failed"))
-			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
 		})
-	})
-	Context("Checking SetupVF function", func() {
-		var (
-			podifName string
-			contID    string
-			netconf   *types.NetConf
-		)
+		It("ApplyVFConfig with link state", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
-		BeforeEach(func() {
-			podifName = "net1"
-			contID = "dummycid"
-			netconf = &types.NetConf{
-				Master:      "ib0",
-				DeviceID:    "0000:af:06.0",
-				VFID:        0,
-				HostIFNames: "ib1",
-				ContIFNames: "net1",
-			}
-		})
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "enable"
 
-		It("Assuming existing interface", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfState", fakeLink, netconf.VFID, uint32(netlink.VF_LINK_STATE_ENABLE)).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with link state - records original link state", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
-				Index: 1000,
-				Name:  "dummylink",
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+				Vfs:          []netlink.VfInfo{{ID: 0, LinkState: netlink.VF_LINK_STATE_DISABLE}},
 			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "enable"
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(nil)
-			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
-			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
-			mocked.On("LinkSetUp", fakeLink).Return(nil)
-			sm := sriovManager{nLink: mocked}
-			err = sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfState", fakeLink, netconf.VFID, uint32(netlink.VF_LINK_STATE_ENABLE)).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFLinkState).To(Equal("disable"))
 		})
-		It("Assuming non existing interface", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
-			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
+		It("ApplyVFConfig with link state - failed to set link state", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(nil, errors.New("not fount"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp, HardwareAddr: gid}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "disable"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfState", fakeLink, netconf.VFID, uint32(netlink.VF_LINK_STATE_DISABLE)).Return(errors.New("mocked failed"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).To(HaveOccurred())
+			// the VF may have been changed part way, the snapshot to reset it from is recorded
+			Expect(netconf.HostVFConfig).ToNot(BeNil())
+			Expect(netconf.HostVFConfig.GUID).To(Equal("11:22:33:00:00:aa:bb:cc"))
 		})
-		It("Assuming existing interface not able to set down", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
-			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
+		It("ApplyVFConfig with PF in switchdev mode", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
-				Index: 1000,
-				Name:  "dummylink",
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
 			}}
+			repLink := &FakeLink{netlink.LinkAttrs{Name: "pf0vf0"}}
+			switchdev := true
+			netconf.PFSwitchdev = &switchdev
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "enable"
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(errors.New("failed"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.SetupVF(netconf, podifName, contID, targetNetNS)
-			Expect(err).To(HaveOccurred())
-		})
-		It("Assuming failed to change name", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
+			mockedNetLinkManger.On("LinkByName", "pf0vf0").Return(repLink, nil)
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetUp", repLink).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("GetVfRepresentor", netconf.Master, netconf.VFID).Return("pf0vf0", nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
 			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
+			Expect(netconf.Representor).To(Equal("pf0vf0"))
+			Expect(netconf.RepresentorUp).To(BeFalse())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfState", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig with trust", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{
-				Index: 1000,
-				Name:  "dummylink",
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
 			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.Trust = "on"
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkGetVfTrust", fakeLink, netconf.VFID).Return(false, nil)
+			mockedNetLinkManger.On("LinkSetVfTrust", fakeLink, netconf.VFID, true).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostVFConfig.Trust).To(Equal("off"))
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with tx rate", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.MinTxRate = 1000
+			netconf.MaxTxRate = 10000
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfRate", fakeLink, netconf.VFID, 1000, 10000).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("GetLinkSpeed", netconf.Master).Return(100000, nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ApplyVFConfig with tx rate - exceeds PF speed", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.MaxTxRate = 200000
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedPciUtils.On("GetLinkSpeed", netconf.Master).Return(100000, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("exceeds PF ibFake0 speed 100000 Mbps"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfRate", fakeLink, netconf.VFID, 0, 200000)
+		})
+		It("ApplyVFConfig with trust - failed to set trust", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp, HardwareAddr: gid}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.Trust = "off"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkGetVfTrust", fakeLink, netconf.VFID).Return(false, errors.New("mocked failed"))
+			mockedNetLinkManger.On("LinkSetVfTrust", fakeLink, netconf.VFID, false).Return(errors.New("mocked failed"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(netconf.HostVFConfig).ToNot(BeNil())
+			Expect(netconf.HostVFConfig.GUID).To(Equal("11:22:33:00:00:aa:bb:cc"))
+		})
+		It("ApplyVFConfig with not existing PF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(nil, errors.New("Link not found"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFNotFound)).To(BeTrue())
+		})
+		It("ApplyVFConfig with ethernet PF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "ether", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFNotInfiniBand)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("device is Ethernet, use sriov-cni"))
+		})
+		It("ApplyVFConfig with PF down", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband"}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFDown)).To(BeTrue())
+		})
+		It("ApplyVFConfig with PF without VFs", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(0, nil)
+			mockedPciUtils.On("GetSriovTotalVfs", netconf.Master).Return(8, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFSriovNotEnabled)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("sriov_totalvfs=8"))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfNodeGUID", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ApplyVFConfig with PF without SR-IOV support", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(0, nil)
+			mockedPciUtils.On("GetSriovTotalVfs", netconf.Master).Return(0, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFSriovNotEnabled)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("sriov_totalvfs=0"))
+		})
+		It("ApplyVFConfig with PF which is not SR-IOV capable", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(0, errors.New("no sriov_numvfs"))
+			mockedPciUtils.On("GetSriovTotalVfs", netconf.Master).Return(0, errors.New("no sriov_totalvfs"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFSriovNotEnabled)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("device is not SR-IOV capable"))
+		})
+		It("ApplyVFConfig with PF not in the allowlist", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PFAllowlist = []string{"ib0", "0000:3b:"}
+
+			mockedPciUtils.On("GetPfPciAddress", netconf.Master).Return("0000:af:00.1", nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFNotAllowed)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring(`"ibFake0" (0000:af:00.1)`))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkByName", mock.Anything)
+		})
+		It("ApplyVFConfig with PF pci address in the allowlist", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband"}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PFAllowlist = []string{"ib0", "0000:af:"}
+
+			mockedPciUtils.On("GetPfPciAddress", netconf.Master).Return("0000:af:00.1", nil)
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			// the PF is allowed and checked next
+			Expect(errors.Is(err, ErrPFDown)).To(BeTrue())
+		})
+		It("ApplyVFConfig with PF pci address resolved by LoadConf", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PFAllowlist = []string{"0000:3b:"}
+			netconf.PFPciAddress = "0000:3b:00.0"
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband"}}
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(errors.Is(err, ErrPFDown)).To(BeTrue())
+			mockedPciUtils.AssertNotCalled(GinkgoT(), "GetPfPciAddress", mock.Anything)
+		})
+		It("ApplyVFConfig with VF index out of range", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.VFID = 2
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(MatchError(ContainSubstring("VF index 2 is out of range, PF ibFake0 has 2 VFs configured")))
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkByName", netconf.HostIFNames)
+		})
+		It("ApplyVFConfig with invalid GUID - wrong characters", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "invalid GUID"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("ApplyVFConfig with invalid GUID - wrong length", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "00:11:22:33:44:55:66"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("ApplyVFConfig with invalid GUID - all zeros guid", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "00:00:00:00:00:00:00:00"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("ApplyVFConfig with invalid GUID - invalid guid address", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "00:AF-3B-0123:21:3322"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+		})
+		It("ApplyVFConfig check guid - failed to get vf link", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", netconf.Master).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkByName", netconf.HostIFNames).Return(nil, errors.New("mocked failed"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`failed to lookup vf "ibFake5": mocked failed`))
+		})
+		It("ApplyVFConfig check guid - vf hardware address without guid", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`vf "ibFake5" hardware address "" carries no guid`))
+			Expect(netconf.HostVFConfig).To(BeNil())
+		})
+		It("ApplyVFConfig check guid - failed to set node guid", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			hostGuid := "11:22:33:00:00:aa:bb:cc"
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`failed to add node guid 01:23:45:67:89:ab:cd:ef: mocked failed`))
+			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
+		})
+		It("ApplyVFConfig check guid - failed to set port guid", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			hostGuid := "11:22:33:00:00:aa:bb:cc"
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.Anything, mock.Anything).Return(errors.New("mocked failed"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal(`failed to add port guid 01:23:45:67:89:ab:cd:ef: mocked failed`))
+			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
+		})
+		It("ApplyVFConfig check guid - failed to rebind after set guid", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("GetSriovNumVfs", netconf.Master).Return(2, nil)
+
+			hostGuid := "11:22:33:00:00:aa:bb:cc"
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:" + hostGuid)
+			Expect(err).ToNot(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				EncapType:    "infiniband",
+				Flags:        net.FlagUp,
+				HardwareAddr: gid,
+			}}
+
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.Anything, mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(errors.New("mocked failed"))
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err = sm.ApplyVFConfig(context.Background(), netconf)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(Equal("mocked failed"))
+			Expect(netconf.HostIFGUID).To(Equal(hostGuid))
+		})
+	})
+	Context("Checking SetupVF function", func() {
+		var (
+			podifName string
+			contID    string
+			netconf   *types.NetConf
+		)
+
+		BeforeEach(func() {
+			podifName = "net1"
+			contID = "dummycid"
+			netconf = &types.NetConf{
+				Master:      "ib0",
+				DeviceID:    "0000:af:06.0",
+				VFID:        0,
+				HostIFNames: "ib1",
+				ContIFNames: "net1",
+			}
+		})
+
+		It("Assuming existing interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming netns torn down before the VF is moved", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked := &mocks.NetlinkManager{}
+			mocked.On("LinkByName", podifName).Return(nil, netlink.LinkNotFoundError{})
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			sm := sriovManager{nLink: mocked}
+
+			// the runtime deleted the Pod after its netns was opened
+			Expect(tearDownNetns(targetNetNS)).To(Succeed())
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(errors.Is(err, ErrNetnsGone)).To(BeTrue())
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetNsFd", fakeLink, mock.Anything)
+		})
+		It("Assuming netns torn down after the VF is moved", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked := &mocks.NetlinkManager{}
+			mocked.On("LinkByName", podifName).Return(nil, netlink.LinkNotFoundError{})
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil).Run(func(_ mock.Arguments) {
+				Expect(tearDownNetns(targetNetNS)).To(Succeed())
+			})
+			sm := sriovManager{nLink: mocked}
+
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(errors.Is(err, ErrNetnsGone)).To(BeTrue())
+			Expect(err).To(MatchError(ContainSubstring(targetNetNS.Path())))
+			// only the temp name was set, the netns was not entered
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkSetName", 1)
+		})
+		It("Assuming SetupVF called twice", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.MTU = 2044
+			hwaddr, err := net.ParseMAC("00:00:01:07:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef")
+			Expect(err).NotTo(HaveOccurred())
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index:        1000,
+				Name:         "dummylink",
+				HardwareAddr: hwaddr,
+				MTU:          2044,
+				Flags:        net.FlagUp,
+			}}
+
+			mocked := &mocks.NetlinkManager{}
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetMTU", fakeLink, 2044).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			Expect(sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkSetNsFd", 1)
+
+			// the retried SetupVF finds the VF set up in the netns
+			removeVF := addVFToNetns(targetNetNS, podifName, netconf.DeviceID)
+			netconf.ContIFMAC = ""
+			Expect(sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkSetNsFd", 1)
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkSetUp", 1)
+			Expect(netconf.ContIFNames).To(Equal(podifName))
+			Expect(netconf.ContIFMAC).To(Equal(hwaddr.String()))
+
+			// a VF whose MTU differs is set up again
+			removeVF()
+			netconf.MTU = 4092
+			mocked.On("LinkSetMTU", fakeLink, 4092).Return(nil)
+			Expect(sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)).To(Succeed())
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkSetNsFd", 2)
+		})
+		It("Assuming SetupVF retried with a fresh NetConf", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			// the hardware address derived from the GUID
+			hwaddr, err := net.ParseMAC("00:00:00:00:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef")
+			Expect(err).NotTo(HaveOccurred())
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index:        1000,
+				Name:         podifName,
+				HardwareAddr: hwaddr,
+				MTU:          4092,
+				Flags:        net.FlagUp,
+			}}
+			pfLink := &FakeLink{netlink.LinkAttrs{Name: "ib0", MTU: 2044}}
+			mocked := &mocks.NetlinkManager{}
+			mocked.On("LinkByName", "ib0").Return(pfLink, nil)
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			sm := sriovManager{nLink: mocked}
+
+			// the VF was set up by a previous plugin process, the retry has none of the host state it recorded
+			defer addVFToNetns(targetNetNS, podifName, "0000:af:06.0")()
+			fresh := &types.NetConf{
+				Master:            "ib0",
+				DeviceID:          "0000:af:06.0",
+				GUID:              "01:23:45:67:89:ab:cd:ef",
+				MTU:               4092,
+				DeriveMACFromGUID: true,
+				RdmaIsolation:     true,
+			}
+			Expect(sm.SetupVF(context.Background(), fresh, podifName, contID, targetNetNS)).To(Succeed())
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetNsFd", mock.Anything, mock.Anything)
+			Expect(fresh.ContIFNames).To(Equal(podifName))
+			Expect(fresh.HostIFNames).To(Equal("ib0000af060"))
+			Expect(fresh.HostIFMTU).To(Equal(2044))
+			Expect(fresh.MAC).To(Equal("00:00:00:00:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"))
+			Expect(fresh.HostIFMAC).To(Equal(fresh.MAC))
+			Expect(fresh.RdmaDevice).To(Equal("mlx5_2"))
+		})
+		It("Assuming interface name taken in the netns", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				return netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: podifName}})
+			})).To(Succeed())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(errors.Is(err, ErrIfNameConflict)).To(BeTrue())
+			Expect(err.Error()).To(ContainSubstring("device net1 already exists"))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetDown", mock.Anything)
+		})
+		It("Assuming interface name taken in the netns with rename policy", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				for _, name := range []string{podifName, podifName + "-1"} {
+					if err := netlink.LinkAdd(&netlink.Bridge{LinkAttrs: netlink.LinkAttrs{Name: name}}); err != nil {
+						return err
+					}
+				}
+				return nil
+			})).To(Succeed())
+			mocked := &mocks.NetlinkManager{}
+			netconf.OnNameConflict = types.NameConflictRename
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.ContIFNames).To(Equal("net1-2"))
+			mocked.AssertCalled(GinkgoT(), "LinkSetName", fakeLink, "net1-2")
+		})
+		It("Assuming operation deadline exceeded", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			sm := sriovManager{nLink: mocked}
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err = sm.SetupVF(ctx, netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("operation aborted"))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetDown", mock.Anything)
+		})
+		It("Assuming existing interface renamed after rebind", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.HostIFNames = "ib5"
+
+			mocked.On("LinkByName", "ib1").Return(fakeLink, nil)
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFNames).To(Equal("ib1"))
+		})
+		It("Assuming interface sysctls", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.Sysctls = map[string]string{
+				"net.ipv4.conf.<iface>.arp_ignore":   "1",
+				"net.ipv4.conf.<iface>.arp_announce": "2",
+			}
+			written := []string{}
+			originalWriteSysctl := writeSysctl
+			defer func() { writeSysctl = originalWriteSysctl }()
+			writeSysctl = func(path, value string) error {
+				written = append(written, path+"="+value)
+				return nil
+			}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(written).To(Equal([]string{
+				"net/ipv4/conf/" + podifName + "/arp_announce=2",
+				"net/ipv4/conf/" + podifName + "/arp_ignore=1",
+			}))
+		})
+		It("Assuming failing interface sysctl", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.Sysctls = map[string]string{"net.ipv4.conf.<iface>.arp_ignore": "1"}
+			originalWriteSysctl := writeSysctl
+			defer func() { writeSysctl = originalWriteSysctl }()
+			writeSysctl = func(path, value string) error { return errors.New("mocked failed") }
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetUp", fakeLink)
+		})
+		It("Assuming interface becoming operationally up", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index:     1000,
+				Name:      "dummylink",
+				OperState: netlink.OperDown,
+			}}
+			upLink := &FakeLink{netlink.LinkAttrs{
+				Index:     1000,
+				Name:      "dummylink",
+				OperState: netlink.OperUp,
+			}}
+
+			mocked.On("LinkByName", "ib1").Return(fakeLink, nil)
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil).Once()
+			mocked.On("LinkByName", podifName).Return(upLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkByName", 3)
+		})
+		It("Assuming interface not becoming operationally up", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index:     1000,
+				Name:      "dummylink",
+				OperState: netlink.OperDown,
+			}}
+			netconf.LinkUpTimeout = 300
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("timeout waiting for link net1 to be up"))
+		})
+		It("Assuming existing interface with mtu", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+				MTU:   2044,
+			}}
+			netconf.MTU = 4092
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetMTU", fakeLink, 4092).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFMTU).To(Equal(2044))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with mac", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			origHwaddr, _ := net.ParseMAC("00:00:01:07:fe:80:00:00:00:00:00:00:00:00:00:00:00:00:00:00")
+			hwaddr, _ := net.ParseMAC("00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef")
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index:        1000,
+				Name:         "dummylink",
+				HardwareAddr: origHwaddr,
+			}}
+			netconf.MAC = hwaddr.String()
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetHardwareAddr", fakeLink, hwaddr).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFMAC).To(Equal(origHwaddr.String()))
+			Expect(netconf.ContIFMAC).To(Equal(hwaddr.String()))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with mac derived from guid", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			origHwaddr, _ := net.ParseMAC("00:00:01:07:fe:80:00:00:00:00:00:00:00:00:00:00:00:00:00:00")
+			hwaddr, _ := net.ParseMAC("00:00:00:00:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef")
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index:        1000,
+				Name:         "dummylink",
+				HardwareAddr: origHwaddr,
+			}}
+			netconf.GUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.DeriveMACFromGUID = true
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetHardwareAddr", fakeLink, hwaddr).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.MAC).To(Equal(hwaddr.String()))
+			Expect(netconf.HostIFMAC).To(Equal(origHwaddr.String()))
+			Expect(netconf.ContIFMAC).To(Equal(hwaddr.String()))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with arp off and static neighbors", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			lladdr, _ := net.ParseMAC("00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef")
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.DisableArpNd = true
+			netconf.Neighbors = []types.Neighbor{{IP: "10.56.217.1", LLAddr: lladdr.String()}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetARPOff", fakeLink).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			mocked.On("NeighSet", mock.MatchedBy(func(neigh *netlink.Neigh) bool {
+				return neigh.LinkIndex == 1000 && neigh.State == netlink.NUD_PERMANENT &&
+					neigh.IP.Equal(net.ParseIP("10.56.217.1")) && neigh.HardwareAddr.String() == lladdr.String()
+			})).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with alias", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.IfAlias = "pod {podUID} container {containerID}"
+			netconf.Args.CNI = map[string]string{"K8S_POD_UID": "a1b2"}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetAlias", fakeLink, "pod a1b2 container "+contID).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with IPoIB mode", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.IPoIBMode = types.IPoIBModeConnected
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mockedPciUtils.On("GetIPoIBMode", "ib1").Return(types.IPoIBModeDatagram, nil)
+			mockedPciUtils.On("SetIPoIBMode", "ib1", types.IPoIBModeConnected).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFIPoIBMode).To(Equal(types.IPoIBModeDatagram))
+			mocked.AssertExpectations(GinkgoT())
+			mockedPciUtils.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface failing to set IPoIB mode", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.IPoIBMode = types.IPoIBModeConnected
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mockedPciUtils.On("GetIPoIBMode", "ib1").Return(types.IPoIBModeDatagram, nil)
+			mockedPciUtils.On("SetIPoIBMode", "ib1", types.IPoIBModeConnected).Return(errors.New("operation not supported"))
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(MatchError("operation not supported"))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetNsFd", mock.Anything, mock.Anything)
+		})
+		It("Assuming large mtu with VF in datagram mode", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			mocked := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.MTU = 9000
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mockedPciUtils.On("GetIPoIBMode", "ib1").Return(types.IPoIBModeDatagram, nil)
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(MatchError("mtu 9000 requires ipoibMode=connected, VF ib1 is in datagram mode"))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetNsFd", mock.Anything, mock.Anything)
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetMTU", mock.Anything, mock.Anything)
+		})
+		It("Assuming large mtu with VF in connected mode", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			mocked := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.MTU = 9000
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mockedPciUtils.On("GetIPoIBMode", "ib1").Return(types.IPoIBModeConnected, nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetMTU", fakeLink, 9000).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mockedPciUtils.AssertNotCalled(GinkgoT(), "SetIPoIBMode", mock.Anything, mock.Anything)
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with too long alias", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.IfAlias = strings.Repeat("{containerID}", 5)
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, strings.Repeat("c", 64), targetNetNS)
+			Expect(err).To(MatchError(ContainSubstring("longer than 255 characters")))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetAlias", mock.Anything, mock.Anything)
+		})
+		It("Assuming existing interface with ring sizes", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.Rings = &types.Rings{RX: 4096}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("EthtoolGetMaxRings", podifName).Return(types.Rings{RX: 8192, TX: 8192}, nil)
+			mocked.On("EthtoolSetRings", podifName, types.Rings{RX: 4096}).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming ring size exceeds the interface maximum", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.Rings = &types.Rings{RX: 4096, TX: 16384}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("EthtoolGetMaxRings", podifName).Return(types.Rings{RX: 8192, TX: 8192}, nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("tx ring size 16384 exceeds"))
+			mocked.AssertNotCalled(GinkgoT(), "EthtoolSetRings", mock.Anything, mock.Anything)
+		})
+		It("Assuming existing interface with renaming disabled", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			rename := false
+			netconf.RenameInterface = &rename
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.ContIFNames).To(Equal("ib1"))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetName", fakeLink, mock.Anything)
+		})
+		It("Assuming VF brought up on the host with renaming disabled", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			rename := false
+			netconf.RenameInterface = &rename
+			netconf.HostAdminState = types.AdminStateUp
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			Expect(sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)).To(Succeed())
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetDown", fakeLink)
+		})
+		It("Assuming failed to set mac", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.MAC = "00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetHardwareAddr", fakeLink, mock.Anything).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetUp", fakeLink)
+		})
+		It("Assuming existing interface with rdma isolation", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			rdmaLink := &netlink.RdmaLink{Attrs: netlink.RdmaLinkAttrs{Name: "mlx5_2"}}
+			netconf.RdmaIsolation = true
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("RdmaSystemGetNetnsMode").Return("exclusive", nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("RdmaLinkByName", "mlx5_2").Return(rdmaLink, nil)
+			mocked.On("RdmaLinkSetNsFd", rdmaLink, uint32(targetNetNS.Fd())).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.RdmaDevice).To(Equal("mlx5_2"))
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming rdma isolation with rdma subsystem in shared mode", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.RdmaIsolation = true
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("RdmaSystemGetNetnsMode").Return("shared", nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("exclusive"))
+			mocked.AssertNotCalled(GinkgoT(), "LinkSetDown", fakeLink)
+		})
+		It("Assuming failed to set mtu", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+				MTU:   2044,
+			}}
+			netconf.MTU = 4092
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetMTU", fakeLink, 4092).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming transient error moving interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+			netconf.RetryInterval = 1
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(syscall.EBUSY).Once()
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil).Once()
+			mocked.On("LinkSetUp", fakeLink).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertNumberOfCalls(GinkgoT(), "LinkSetNsFd", 2)
+		})
+		It("Assuming non existing interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(nil, errors.New("not fount"))
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming existing interface not able to set down", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming failed to change name", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
 			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(errors.New("failed"))
 			sm := sriovManager{nLink: mocked}
-			err = sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming failed to move interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.Anything).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked}
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking ReleaseVF function", func() {
+		var (
+			podifName string
+			contID    string
+			netconf   *types.NetConf
+		)
+
+		BeforeEach(func() {
+			podifName = "net1"
+			contID = "dummycid"
+			netconf = &types.NetConf{
+				Master:      "ib0",
+				DeviceID:    "0000:af:06.0",
+				VFID:        0,
+				HostIFNames: "ib1",
+				ContIFNames: "net1",
+			}
+		})
+		It("Assuming existing interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("Assuming existing interface with alias", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.IfAlias = "pod {podUID}"
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetAlias", fakeLink, "").Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with IPoIB mode", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.IPoIBMode = types.IPoIBModeConnected
+			netconf.HostIFIPoIBMode = types.IPoIBModeDatagram
+
+			mocked.On("LinkByName", "net1").Return(fakeLink, nil)
+			mocked.On("LinkByName", "ib1").Return(nil, netlink.LinkNotFoundError{})
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, "ib1").Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			hostNS, err := ns.GetCurrentNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer hostNS.Close()
+			hostNetnsID, err := utils.GetNetnsIDFromFd(hostNS.Fd())
+			Expect(err).NotTo(HaveOccurred())
+			var modeNetnsID string
+			mockedPciUtils.On("SetIPoIBMode", "ib1", types.IPoIBModeDatagram).Run(func(mock.Arguments) {
+				// the VF is on the host once released, its mode is set there
+				curNS, err := ns.GetCurrentNS()
+				Expect(err).NotTo(HaveOccurred())
+				defer curNS.Close()
+				modeNetnsID, err = utils.GetNetnsIDFromFd(curNS.Fd())
+				Expect(err).NotTo(HaveOccurred())
+			}).Return(nil)
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mockedPciUtils.AssertExpectations(GinkgoT())
+			Expect(modeNetnsID).To(Equal(hostNetnsID))
+		})
+		Context("Assuming drain on release", func() {
+			var slept []time.Duration
+
+			BeforeEach(func() {
+				slept = nil
+				drainSleep = func(d time.Duration) { slept = append(slept, d) }
+				netconf.DrainOnDel = true
+			})
+
+			AfterEach(func() {
+				drainSleep = time.Sleep
+			})
+
+			DescribeTable("drain period",
+				func(drainPeriod, operationTimeout int, expected time.Duration) {
+					targetNetNS, err := testutils.NewNS()
+					Expect(err).NotTo(HaveOccurred())
+					defer targetNetNS.Close()
+					mocked := &mocks.NetlinkManager{}
+					fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+					netconf.DrainPeriod = drainPeriod
+					netconf.OperationTimeout = operationTimeout
+
+					mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+					mocked.On("LinkSetDown", fakeLink).Return(nil).Run(func(mock.Arguments) {
+						Expect(slept).To(BeEmpty())
+					})
+					mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil).Run(func(mock.Arguments) {
+						Expect(slept).To(HaveLen(1))
+					})
+					mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+					sm := sriovManager{nLink: mocked}
+					err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(slept).To(Equal([]time.Duration{expected}))
+				},
+				Entry("default", 0, 0, time.Second),
+				Entry("configured", 200, 0, 200*time.Millisecond),
+				Entry("within the operation timeout", 200, 500, 200*time.Millisecond),
+				Entry("bounded by the operation timeout", 2000, 500, 500*time.Millisecond),
+			)
+		})
+		It("Assuming existing interface with host name taken", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, "ib0000af060").Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with host name available", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+
+			mocked.On("LinkByName", netconf.HostIFNames).Return(nil, errors.New("not found"))
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with vf name template", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.VFNameTemplate = "ibvf{pf}_{vf}"
+
+			mocked.On("LinkByName", "ibvfib0_0").Return(nil, errors.New("not found"))
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, "ibvfib0_0").Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with vf name template taken", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.VFNameTemplate = "ibvf{pf}_{vf}"
+
+			mocked.On("LinkByName", "ibvfib0_0").Return(fakeLink, nil)
+			mocked.On("LinkByName", netconf.HostIFNames).Return(nil, errors.New("not found"))
+			mocked.On("LinkByName", podifName).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, netconf.HostIFNames).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with mtu to restore", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf.MTU = 4092
+			netconf.HostIFMTU = 2044
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetMTU", fakeLink, 2044).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with rdma device to restore", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			rdmaLink := &netlink.RdmaLink{Attrs: netlink.RdmaLinkAttrs{Name: "mlx5_2"}}
+			netconf.RdmaIsolation = true
+			netconf.RdmaDevice = "mlx5_2"
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("RdmaLinkByName", "mlx5_2").Return(rdmaLink, nil)
+			mocked.On("RdmaLinkSetNsFd", rdmaLink, mock.AnythingOfType("uint32")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming existing interface with mac to restore", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			origHwaddr, _ := net.ParseMAC("00:00:01:07:fe:80:00:00:00:00:00:00:00:00:00:00:00:00:00:00")
+			netconf.MAC = "00:00:00:88:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"
+			netconf.HostIFMAC = origHwaddr.String()
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetHardwareAddr", fakeLink, origHwaddr).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mocked.AssertExpectations(GinkgoT())
+		})
+		It("Assuming non existing interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(nil, errors.New("not found"))
+			sm := sriovManager{nLink: mocked}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming failed to set interface down", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(errors.New("failed"))
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
 			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrVFStuck)).To(BeTrue())
 		})
 		It("Assuming failed to move interface", func() {
 			var targetNetNS ns.NetNS
@@ -321,40 +2284,65 @@ var _ = Describe("Sriov", func() {
 			}()
 			Expect(err).NotTo(HaveOccurred())
 			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{
-				Index: 1000,
-				Name:  "dummylink",
-			}}
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(errors.New("failed"))
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrVFStuck)).To(BeTrue())
+		})
+		It("Assuming existing interface", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
 
 			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 			mocked.On("LinkSetDown", fakeLink).Return(nil)
 			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
-			mocked.On("LinkSetNsFd", fakeLink, mock.Anything).Return(errors.New("failed"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.SetupVF(netconf, podifName, contID, targetNetNS)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(errors.New("failed"))
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(errors.New("failed"))
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
 			Expect(err).To(HaveOccurred())
+			Expect(errors.Is(err, ErrVFStuck)).To(BeTrue())
 		})
-	})
-	Context("Checking ReleaseVF function", func() {
-		var (
-			podifName string
-			contID    string
-			netconf   *types.NetConf
-		)
+		It("Assuming failed to move interface recovered by rebind", func() {
+			var targetNetNS ns.NetNS
+			targetNetNS, err := testutils.NewNS()
+			defer func() {
+				if targetNetNS != nil {
+					targetNetNS.Close()
+				}
+			}()
+			Expect(err).NotTo(HaveOccurred())
+			mocked := &mocks.NetlinkManager{}
+			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
 
-		BeforeEach(func() {
-			podifName = "net1"
-			contID = "dummycid"
-			netconf = &types.NetConf{
-				Master:      "ib0",
-				DeviceID:    "0000:af:06.0",
-				VFID:        0,
-				HostIFNames: "ib1",
-				ContIFNames: "net1",
-			}
+			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mocked.On("LinkSetDown", fakeLink).Return(nil)
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
+			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(errors.New("failed"))
+			mockedPciUtils := &mocks.PciUtils{}
+			mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(nil)
+			sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			Expect(err).NotTo(HaveOccurred())
+			mockedPciUtils.AssertExpectations(GinkgoT())
 		})
-		It("Assuming existing interface", func() {
+		It("Assuming failed to set interface up after moving", func() {
 			var targetNetNS ns.NetNS
 			targetNetNS, err := testutils.NewNS()
 			defer func() {
@@ -364,135 +2352,319 @@ var _ = Describe("Sriov", func() {
 			}()
 			Expect(err).NotTo(HaveOccurred())
 			mocked := &mocks.NetlinkManager{}
-			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{
+				Index: 1000,
+				Name:  "dummylink",
+			}}
 
 			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 			mocked.On("LinkSetDown", fakeLink).Return(nil)
 			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
 			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
+			mocked.On("LinkSetUp", fakeLink).Return(errors.New("failed"))
 			sm := sriovManager{nLink: mocked}
-			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
+			err = sm.SetupVF(context.Background(), netconf, podifName, contID, targetNetNS)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking ValidateVF function", func() {
+		It("Assuming valid PF and VF", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			netconf := &types.NetConf{Master: "ib0", DeviceID: "0000:af:06.0", VFID: 0}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband", Flags: net.FlagUp}}
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(fakeLink, nil)
+			mockedPciUtils.On("GetSriovNumVfs", "ib0").Return(2, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			Expect(sm.ValidateVF(netconf)).To(Succeed())
+			mockedNetLinkManger.AssertNumberOfCalls(GinkgoT(), "LinkByName", 1)
+		})
+		It("Assuming PF is down", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			netconf := &types.NetConf{Master: "ib0", DeviceID: "0000:af:06.0", VFID: 0}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{EncapType: "infiniband"}}
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ValidateVF(netconf)
+			Expect(errors.Is(err, ErrPFDown)).To(BeTrue())
+		})
+	})
+	Context("Checking ResetVFConfig function", func() {
+		var (
+			netconf *types.NetConf
+		)
+
+		BeforeEach(func() {
+			netconf = &types.NetConf{
+				Master:      "i4",
+				DeviceID:    "0000:af:06.0",
+				VFID:        0,
+				HostIFNames: "i1",
+			}
+		})
+
+		It("ResetVFConfig with valid GUID", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+		})
+		It("ResetVFConfig with zero GUID reset policy", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.ResetGUIDPolicy = types.ResetGUIDZero
+			unsetGUID, _ := net.ParseMAC("ff:ff:ff:ff:ff:ff:ff:ff")
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, 0, unsetGUID).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, 0, unsetGUID).Return(nil)
+			mockedPciUtils.On("RebindVf", "i4", "0000:af:06.0").Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			Expect(sm.ResetVFConfig(netconf)).To(Succeed())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig with keep GUID reset policy", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.ResetGUIDPolicy = types.ResetGUIDKeep
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			Expect(sm.ResetVFConfig(netconf)).To(Succeed())
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfNodeGUID", mock.Anything, mock.Anything, mock.Anything)
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfPortGUID", mock.Anything, mock.Anything, mock.Anything)
+			mockedPciUtils.AssertNotCalled(GinkgoT(), "RebindVf", mock.Anything, mock.Anything)
+		})
+		It("ResetVFConfig with GUID allocated from the GUID pool", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			dataDir, err := ioutil.TempDir("", "ib-sriov-cni-guid-pool")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dataDir)
+			netconf.GUIDPool = &types.GUIDPool{RangeStart: "02:00:00:00:00:00:00:00", RangeEnd: "02:00:00:00:00:00:00:00", DataDir: dataDir}
+			netconf.AllocatedGUID, err = utils.AllocateGUID(dataDir, netconf.GUIDPool.RangeStart, netconf.GUIDPool.RangeEnd, netconf.DeviceID, "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			Expect(sm.ResetVFConfig(netconf)).To(Succeed())
+
+			_, err = utils.AllocateGUID(dataDir, netconf.GUIDPool.RangeStart, netconf.GUIDPool.RangeEnd, "0000:af:06.1", "cid/1")
+			Expect(err).NotTo(HaveOccurred(), "released guid should be allocatable")
+		})
+		It("ResetVFConfig with pkey", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.PKey = "0x0001"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("IsVfPKeyConfigurable", netconf.Master, netconf.DeviceID).Return(true)
+			netconf.HostVFConfig = &types.VFConfig{PKeyIndex: "1"}
+			mockedPciUtils.On("ResetVfPKey", netconf.Master, netconf.DeviceID, "1").Return(nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedPciUtils.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig with added pkeys", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.AddedPKeys = []string{"0x0002", "0x0003"}
+			netconf.AddedPKeyEntries = []int{1, 3}
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("ClearVfPKeyEntry", netconf.Master, netconf.DeviceID, 3).Return(nil)
+			mockedPciUtils.On("ClearVfPKeyEntry", netconf.Master, netconf.DeviceID, 1).Return(nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.AddedPKeys).To(BeEmpty())
+			mockedPciUtils.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig with link state", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "enable"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfState", fakeLink, netconf.VFID, uint32(netlink.VF_LINK_STATE_AUTO)).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig with link state and recorded original link state", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "enable"
+			netconf.HostIFLinkState = "disable"
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfState", fakeLink, netconf.VFID, uint32(netlink.VF_LINK_STATE_DISABLE)).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
 		})
-		It("Assuming non existing interface", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
-			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
+		It("ResetVFConfig with representor", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(nil, errors.New("not found"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
-			Expect(err).To(HaveOccurred())
-		})
-		It("Assuming failed to set interface down", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
-			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
-			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			repLink := &FakeLink{netlink.LinkAttrs{Name: "pf0vf0", Flags: net.FlagUp}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.LinkState = "enable"
+			netconf.Representor = "pf0vf0"
+			netconf.RepresentorUp = false
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(errors.New("failed"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
-			Expect(err).To(HaveOccurred())
-		})
-		It("Assuming failed to move interface", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
-			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
-			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			mockedNetLinkManger.On("LinkByName", "pf0vf0").Return(repLink, nil)
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetDown", repLink).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(nil)
-			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(errors.New("failed"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
-			Expect(err).To(HaveOccurred())
-		})
-		It("Assuming existing interface", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
-			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
-			fakeLink := &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(nil)
-			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
-			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(errors.New("failed"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.ReleaseVF(netconf, podifName, contID, targetNetNS)
-			Expect(err).To(HaveOccurred())
-		})
-		It("Assuming failed to set interface up after moving", func() {
-			var targetNetNS ns.NetNS
-			targetNetNS, err := testutils.NewNS()
-			defer func() {
-				if targetNetNS != nil {
-					targetNetNS.Close()
-				}
-			}()
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
-			mocked := &mocks.NetlinkManager{}
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfState", mock.Anything, mock.Anything, mock.Anything)
+		})
+		It("ResetVFConfig with trust enabled", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
 
-			fakeLink := &FakeLink{netlink.LinkAttrs{
-				Index: 1000,
-				Name:  "dummylink",
-			}}
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.Trust = "on"
 
-			mocked.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
-			mocked.On("LinkSetDown", fakeLink).Return(nil)
-			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(nil)
-			mocked.On("LinkSetNsFd", fakeLink, mock.AnythingOfType("int")).Return(nil)
-			mocked.On("LinkSetUp", fakeLink).Return(errors.New("failed"))
-			sm := sriovManager{nLink: mocked}
-			err = sm.SetupVF(netconf, podifName, contID, targetNetNS)
-			Expect(err).To(HaveOccurred())
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfTrust", fakeLink, netconf.VFID, false).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
 		})
-	})
-	Context("Checking ResetVFConfig function", func() {
-		var (
-			netconf *types.NetConf
-		)
+		It("ResetVFConfig with tx rate", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
 
-		BeforeEach(func() {
-			netconf = &types.NetConf{
-				Master:      "i4",
-				DeviceID:    "0000:af:06.0",
-				VFID:        0,
-				HostIFNames: "i1",
-			}
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.MaxTxRate = 10000
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfRate", fakeLink, netconf.VFID, 0, 0).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
 		})
+		It("ResetVFConfig restores the original trust", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
 
-		It("ResetVFConfig with valid GUID", func() {
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.Trust = "off"
+			netconf.HostVFConfig = &types.VFConfig{Trust: "on"}
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfTrust", fakeLink, netconf.VFID, true).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertExpectations(GinkgoT())
+		})
+		It("ResetVFConfig with trust disabled", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
 			mockedPciUtils := &mocks.PciUtils{}
 
 			fakeLink := &FakeLink{netlink.LinkAttrs{}}
 			netconf.HostIFGUID = "01:23:45:67:89:ab:cd:ef"
+			netconf.Trust = "off"
 
 			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
 			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
@@ -503,6 +2675,7 @@ var _ = Describe("Sriov", func() {
 			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
 			err := sm.ResetVFConfig(netconf)
 			Expect(err).NotTo(HaveOccurred())
+			mockedNetLinkManger.AssertNotCalled(GinkgoT(), "LinkSetVfTrust", fakeLink, netconf.VFID, false)
 		})
 		It("ResetVFConfig with GUID all zeros", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
@@ -522,6 +2695,24 @@ var _ = Describe("Sriov", func() {
 			Expect(err).NotTo(HaveOccurred())
 			Expect(netconf.HostIFGUID).To(Equal("FF:FF:FF:FF:FF:FF:FF:FF"))
 		})
+		It("ResetVFConfig without recorded GUID", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+
+			fakeLink := &FakeLink{netlink.LinkAttrs{}}
+			netconf.HostIFGUID = ""
+
+			mockedNetLinkManger.On("LinkByName", mock.AnythingOfType("string")).Return(fakeLink, nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", fakeLink, mock.AnythingOfType("int"), mock.Anything).Return(nil)
+
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+			err := sm.ResetVFConfig(netconf)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(netconf.HostIFGUID).To(Equal("FF:FF:FF:FF:FF:FF:FF:FF"))
+		})
 		It("ResetVFConfig with invalid GUID", func() {
 			mockedNetLinkManger := &mocks.NetlinkManager{}
 			mockedPciUtils := &mocks.PciUtils{}
@@ -536,4 +2727,303 @@ var _ = Describe("Sriov", func() {
 			Expect(err).To(HaveOccurred())
 		})
 	})
+	Context("Checking VF reuse", func() {
+		It("Assuming two sequential Pods on the same VF index", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedPciUtils := &mocks.PciUtils{}
+			gid, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).ToNot(HaveOccurred())
+
+			// the VF was configured out-of-band before the first Pod got it
+			baseline := netlink.VfInfo{ID: 0, LinkState: netlink.VF_LINK_STATE_ENABLE, MaxTxRate: 5000}
+			pfLink := &FakeLink{netlink.LinkAttrs{
+				EncapType: "infiniband",
+				Flags:     net.FlagUp,
+				Vfs:       []netlink.VfInfo{baseline},
+			}}
+			vfLink := &FakeLink{netlink.LinkAttrs{HardwareAddr: gid}}
+			vfState := func() netlink.VfInfo { return pfLink.Attrs().Vfs[0] }
+
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(pfLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib1").Return(vfLink, nil)
+			mockedNetLinkManger.On("LinkSetVfState", pfLink, 0, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				pfLink.Attrs().Vfs[0].LinkState = args.Get(2).(uint32)
+			})
+			mockedNetLinkManger.On("LinkSetVfRate", pfLink, 0, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+				pfLink.Attrs().Vfs[0].MinTxRate = uint32(args.Int(2))
+				pfLink.Attrs().Vfs[0].MaxTxRate = uint32(args.Int(3))
+			})
+			mockedNetLinkManger.On("LinkGetVfTrust", pfLink, 0).Return(false, nil)
+			mockedNetLinkManger.On("LinkSetVfTrust", pfLink, 0, mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfNodeGUID", pfLink, 0, mock.Anything).Return(nil)
+			mockedNetLinkManger.On("LinkSetVfPortGUID", pfLink, 0, mock.Anything).Return(nil)
+			mockedPciUtils.On("GetSriovNumVfs", "ib0").Return(2, nil)
+			mockedPciUtils.On("GetLinkSpeed", "ib0").Return(100000, nil)
+			mockedPciUtils.On("RebindVf", mock.AnythingOfType("string"), mock.AnythingOfType("string")).Return(nil)
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: mockedPciUtils}
+
+			podA := &types.NetConf{Master: "ib0", DeviceID: "0000:af:06.0", VFID: 0, HostIFNames: "ib1",
+				GUID: "01:23:45:67:89:ab:cd:ef", LinkState: "disable", Trust: "on", MinTxRate: 1000, MaxTxRate: 10000}
+			Expect(sm.ApplyVFConfig(context.Background(), podA)).To(Succeed())
+			Expect(vfState().LinkState).To(Equal(uint32(netlink.VF_LINK_STATE_DISABLE)))
+			Expect(vfState().MinTxRate).To(Equal(uint32(1000)))
+			Expect(podA.HostVFConfig).To(Equal(&types.VFConfig{LinkState: "enable", MaxTxRate: 5000, GUID: "11:22:33:00:00:aa:bb:cc",
+				Trust: "off"}))
+
+			// the snapshot is persisted with the cached NetConf and restored on DEL
+			data, err := json.Marshal(podA)
+			Expect(err).NotTo(HaveOccurred())
+			cachedA := &types.NetConf{}
+			Expect(json.Unmarshal(data, cachedA)).To(Succeed())
+			Expect(sm.ResetVFConfig(cachedA)).To(Succeed())
+			Expect(vfState()).To(Equal(baseline))
+
+			// the second Pod configures the GUID only and gets the VF config the first Pod found
+			podB := &types.NetConf{Master: "ib0", DeviceID: "0000:af:06.0", VFID: 0, HostIFNames: "ib1",
+				GUID: "01:23:45:67:89:ab:cd:00"}
+			Expect(sm.ApplyVFConfig(context.Background(), podB)).To(Succeed())
+			Expect(vfState()).To(Equal(baseline))
+			// the trust is read only when the network sets it
+			Expect(podB.HostVFConfig).To(Equal(&types.VFConfig{LinkState: "enable", MaxTxRate: 5000, GUID: "11:22:33:00:00:aa:bb:cc"}))
+		})
+	})
+	Context("Checking VerifyVFReset function", func() {
+		var (
+			netconf *types.NetConf
+			vfLink  *FakeLink
+			pfLink  *FakeLink
+		)
+
+		BeforeEach(func() {
+			netconf = &types.NetConf{
+				Master:          "ib0",
+				DeviceID:        "0000:af:06.0",
+				VFID:            0,
+				GUID:            "01:23:45:67:89:ab:cd:ef",
+				HostIFGUID:      "11:22:33:00:00:aa:bb:cc",
+				ResetGUIDPolicy: types.ResetGUIDOriginal,
+				LinkState:       "enable",
+				HostIFLinkState: "disable",
+			}
+			hwAddr, err := net.ParseMAC("00:00:04:a5:fe:80:00:00:00:00:00:00:11:22:33:00:00:aa:bb:cc")
+			Expect(err).NotTo(HaveOccurred())
+			vfLink = &FakeLink{netlink.LinkAttrs{Name: "ib1", HardwareAddr: hwAddr}}
+			pfLink = &FakeLink{netlink.LinkAttrs{Name: "ib0", Vfs: []netlink.VfInfo{
+				{ID: 0, LinkState: netlink.VF_LINK_STATE_DISABLE},
+			}}}
+		})
+
+		It("Assuming VF reset", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedNetLinkManger.On("LinkByName", "ib1").Return(vfLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(pfLink, nil)
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: &mocks.PciUtils{}}
+			Expect(sm.VerifyVFReset(netconf)).To(Succeed())
+		})
+		It("Assuming VF guid and link state not reset", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedNetLinkManger.On("LinkByName", "ib1").Return(vfLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(pfLink, nil)
+			netconf.HostIFGUID = "11:22:33:00:00:aa:bb:dd"
+			netconf.HostIFLinkState = ""
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: &mocks.PciUtils{}}
+			err := sm.VerifyVFReset(netconf)
+			Expect(err).To(MatchError("VF 0 (0000:af:06.0) of PF ib0 was not reset: " +
+				"guid is 11:22:33:00:00:aa:bb:cc, expected the original guid 11:22:33:00:00:aa:bb:dd; " +
+				"link state is disable, expected auto"))
+		})
+		It("Assuming Pod guid kept", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedNetLinkManger.On("LinkByName", "ib1").Return(vfLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(pfLink, nil)
+			netconf.ResetGUIDPolicy = types.ResetGUIDKeep
+			netconf.GUID = "11:22:33:00:00:AA:BB:CC"
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: &mocks.PciUtils{}}
+			Expect(sm.VerifyVFReset(netconf)).To(Succeed())
+		})
+		It("Assuming administratively unset guid is still the Pod guid", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedNetLinkManger.On("LinkByName", "ib1").Return(vfLink, nil)
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(pfLink, nil)
+			netconf.ResetGUIDPolicy = types.ResetGUIDZero
+			netconf.HostIFGUID = "FF:FF:FF:FF:FF:FF:FF:FF"
+			netconf.GUID = "11:22:33:00:00:aa:bb:cc"
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: &mocks.PciUtils{}}
+			Expect(sm.VerifyVFReset(netconf)).To(MatchError(ContainSubstring("guid is still the Pod guid")))
+		})
+		It("Assuming VF netdevice not on the host", func() {
+			mockedNetLinkManger := &mocks.NetlinkManager{}
+			mockedNetLinkManger.On("LinkByName", "ib0").Return(pfLink, nil)
+			netconf.DeviceID = "0000:af:07.0"
+
+			sm := sriovManager{nLink: mockedNetLinkManger, utils: &mocks.PciUtils{}}
+			Expect(sm.VerifyVFReset(netconf)).To(MatchError(ContainSubstring("VF netdevice is not on the host")))
+		})
+	})
+	Context("Checking netlink call sequence", func() {
+		var (
+			targetNetNS ns.NetNS
+			mocked      *mocks.NetlinkManager
+			fakeLink    *FakeLink
+			netconf     *types.NetConf
+			calls       []string
+			failAt      int
+		)
+
+		record := func(name string) error {
+			calls = append(calls, name)
+			if len(calls)-1 == failAt {
+				return errors.New("injected failure")
+			}
+			return nil
+		}
+
+		BeforeEach(func() {
+			var err error
+			targetNetNS, err = testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+
+			calls = []string{}
+			fakeLink = &FakeLink{netlink.LinkAttrs{Index: 1000, Name: "dummylink"}}
+			netconf = &types.NetConf{
+				Master:      "ib0",
+				DeviceID:    "0000:af:06.0",
+				VFID:        0,
+				HostIFNames: "ib1",
+				ContIFNames: "net1",
+			}
+
+			mocked = &mocks.NetlinkManager{}
+			mocked.On("LinkSetDown", fakeLink).Return(func(netlink.Link) error { return record("LinkSetDown") })
+			mocked.On("LinkSetName", fakeLink, mock.Anything).Return(func(_ netlink.Link, name string) error { return record("LinkSetName " + name) })
+			mocked.On("LinkSetNsFd", fakeLink, mock.Anything).Return(func(netlink.Link, int) error { return record("LinkSetNsFd") })
+			mocked.On("LinkSetUp", fakeLink).Return(func(netlink.Link) error { return record("LinkSetUp") })
+		})
+
+		AfterEach(func() {
+			Expect(targetNetNS.Close()).To(Succeed())
+		})
+
+		setupSequence := []string{"LinkSetDown", "LinkSetName vfdev1000", "LinkSetNsFd", "LinkSetName net1", "LinkSetUp"}
+		DescribeTable("SetupVF failing at each netlink call",
+			func(fail int) {
+				failAt = fail
+				mocked.On("LinkByName", mock.Anything).Return(fakeLink, nil)
+
+				sm := sriovManager{nLink: mocked}
+				err := sm.SetupVF(context.Background(), netconf, "net1", "dummycid", targetNetNS)
+				if fail < 0 {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(calls).To(Equal(setupSequence))
+					return
+				}
+				Expect(err).To(HaveOccurred())
+				Expect(calls).To(Equal(setupSequence[:fail+1]), "no netlink call should be made after a failure")
+			},
+			Entry("no failure", -1),
+			Entry("set link down", 0),
+			Entry("set temp name", 1),
+			Entry("move to netns", 2),
+			Entry("set pod interface name", 3),
+			Entry("set link up", 4),
+		)
+
+		releaseSequence := []string{"LinkSetDown", "LinkSetName ib1", "LinkSetNsFd"}
+		DescribeTable("ReleaseVF failing at each netlink call",
+			func(fail int) {
+				failAt = fail
+				mocked.On("LinkByName", netconf.HostIFNames).Return(nil, errors.New("not found"))
+				mocked.On("LinkByName", "net1").Return(fakeLink, nil)
+				mockedPciUtils := &mocks.PciUtils{}
+				mockedPciUtils.On("RebindVf", netconf.Master, netconf.DeviceID).Return(errors.New("failed"))
+
+				sm := sriovManager{nLink: mocked, utils: mockedPciUtils}
+				err := sm.ReleaseVF(netconf, "net1", "dummycid", targetNetNS)
+				if fail < 0 {
+					Expect(err).NotTo(HaveOccurred())
+					Expect(calls).To(Equal(releaseSequence))
+					return
+				}
+				Expect(err).To(HaveOccurred())
+				Expect(calls).To(Equal(releaseSequence[:fail+1]), "no netlink call should be made after a failure")
+			},
+			Entry("no failure", -1),
+			Entry("set link down", 0),
+			Entry("restore host name", 1),
+			Entry("move to init netns", 2),
+		)
+	})
+
+	Context("Checking parseVfTrust function", func() {
+		linkMsg := func(trusts ...bool) []byte {
+			list := nl.NewRtAttr(unix.IFLA_VFINFO_LIST, nil)
+			for i, trust := range trusts {
+				vfTrust := nl.VfTrust{Vf: uint32(i)}
+				if trust {
+					vfTrust.Setting = 1
+				}
+				info := list.AddRtAttr(nl.IFLA_VF_INFO, nil)
+				info.AddRtAttr(nl.IFLA_VF_TRUST, vfTrust.Serialize())
+			}
+			return append(nl.NewIfInfomsg(unix.AF_UNSPEC).Serialize(), list.Serialize()...)
+		}
+		It("returns the trust of the VF", func() {
+			trust, err := parseVfTrust(linkMsg(false, true), 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trust).To(BeTrue())
+			trust, err = parseVfTrust(linkMsg(false, true), 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(trust).To(BeFalse())
+		})
+		It("fails when the VF is not reported", func() {
+			_, err := parseVfTrust(linkMsg(true), 1)
+			Expect(err).To(HaveOccurred())
+		})
+		It("fails on a short message", func() {
+			_, err := parseVfTrust([]byte{0}, 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking FindVFLink function", func() {
+		It("Assuming no VF in the netns", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+				// virtual devices have no PCI address
+				Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1"}, PeerName: "peer1"})).To(Succeed())
+				name, err := FindVFLink("0000:af:06.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(name).To(BeEmpty())
+				return nil
+			})).To(Succeed())
+		})
+		It("Assuming VF in the netns", func() {
+			targetNetNS, err := testutils.NewNS()
+			Expect(err).NotTo(HaveOccurred())
+			defer targetNetNS.Close()
+			defer func(orig func(string) (string, error)) { linkBusInfo = orig }(linkBusInfo)
+			linkBusInfo = func(name string) (string, error) {
+				if name == "vfdev7" {
+					return "0000:af:06.0", nil
+				}
+				return "", nil
+			}
+
+			Expect(targetNetNS.Do(func(_ ns.NetNS) error {
+				defer GinkgoRecover()
+				Expect(netlink.LinkAdd(&netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "net1"}, PeerName: "vfdev7"})).To(Succeed())
+				name, err := FindVFLink("0000:af:06.0")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(name).To(Equal("vfdev7"))
+				return nil
+			})).To(Succeed())
+		})
+	})
 })
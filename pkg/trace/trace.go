@@ -0,0 +1,122 @@
+package trace
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// FileName is the name of the trace file in the trace directory
+	FileName = "trace.jsonl"
+	// DefaultMaxSize is the size in bytes the trace file is pruned at when the max size is not set
+	DefaultMaxSize = 1 << 20
+)
+
+// Record is a single CNI invocation written to the trace as a JSON line
+type Record struct {
+	Time        time.Time `json:"time"`
+	Command     string    `json:"command"`
+	ContainerID string    `json:"containerID"`
+	Netns       string    `json:"netns,omitempty"`
+	IfName      string    `json:"ifName"`
+	// Args is the CNI_ARGS of the invocation
+	Args string `json:"args,omitempty"`
+	// Config is the resolved network configuration
+	Config     json.RawMessage `json:"config,omitempty"`
+	DurationMs int64           `json:"durationMs"`
+	// Stage and Error are set for a failed invocation
+	Stage string `json:"stage,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// Append adds the record to the trace file of dir. Once the trace file exceeds maxSize bytes the oldest records are
+// pruned down to half of it, a maxSize which is not positive uses DefaultMaxSize. An empty dir disables the trace.
+// Concurrent invocations are serialized on a lock file of dir.
+func Append(dir string, maxSize int64, r Record) error {
+	if dir == "" {
+		return nil
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to serialize trace record: %w", err)
+	}
+	line = append(line, '\n')
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create trace directory %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, FileName)
+	lock, err := os.OpenFile(path+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace lock file: %w", err)
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return fmt.Errorf("failed to lock trace file: %w", err)
+	}
+	defer func() { _ = syscall.Flock(int(lock.Fd()), syscall.LOCK_UN) }()
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open trace file %s: %w", path, err)
+	}
+	if _, err := f.Write(line); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write trace file %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("failed to write trace file %s: %w", path, err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to stat trace file %s: %w", path, err)
+	}
+	if fi.Size() <= maxSize {
+		return nil
+	}
+	return prune(path, maxSize/2)
+}
+
+// prune keeps the newest records of the trace file which fit in size bytes, the newest record is always kept
+func prune(path string, size int64) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read trace file %s: %w", path, err)
+	}
+	var lines [][]byte
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		lines = append(lines, append(scanner.Bytes(), '\n'))
+	}
+	start, kept := len(lines), int64(0)
+	for start > 0 && (start == len(lines) || kept+int64(len(lines[start-1])) <= size) {
+		start--
+		kept += int64(len(lines[start]))
+	}
+
+	// write to a temporary file and rename so that a reader never sees a partially pruned trace
+	tmp, err := ioutil.TempFile(filepath.Dir(path), FileName+".tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create trace file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(bytes.Join(lines[start:], nil)); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write trace file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
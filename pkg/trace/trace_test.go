@@ -0,0 +1,87 @@
+package trace
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// readTrace returns the records of the trace file of dir
+func readTrace(dir string) []Record {
+	data, err := ioutil.ReadFile(filepath.Join(dir, FileName))
+	Expect(err).NotTo(HaveOccurred())
+	var records []Record
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		r := Record{}
+		Expect(json.Unmarshal(scanner.Bytes(), &r)).To(Succeed())
+		records = append(records, r)
+	}
+	return records
+}
+
+var _ = Describe("Trace", func() {
+	var dir string
+
+	BeforeEach(func() {
+		var err error
+		dir, err = ioutil.TempDir("", "ib-sriov-cni-trace")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dir)).To(Succeed())
+	})
+
+	Context("Checking Append function", func() {
+		It("Assuming empty dir", func() {
+			Expect(Append("", 0, Record{Command: "add"})).To(Succeed())
+		})
+		It("Assuming records appended", func() {
+			now := time.Now().UTC().Truncate(time.Second)
+			Expect(Append(dir, 0, Record{Time: now, Command: "add", ContainerID: "cid", IfName: "net1",
+				Config: json.RawMessage(`{"deviceID":"0000:af:06.0"}`), DurationMs: 12})).To(Succeed())
+			Expect(Append(dir, 0, Record{Time: now, Command: "del", ContainerID: "cid", IfName: "net1",
+				Stage: "release", Error: "failed"})).To(Succeed())
+
+			records := readTrace(dir)
+			Expect(records).To(HaveLen(2))
+			Expect(records[0].Time).To(Equal(now))
+			Expect(records[0].Command).To(Equal("add"))
+			Expect(string(records[0].Config)).To(Equal(`{"deviceID":"0000:af:06.0"}`))
+			Expect(records[0].DurationMs).To(Equal(int64(12)))
+			Expect(records[1].Command).To(Equal("del"))
+			Expect(records[1].Error).To(Equal("failed"))
+		})
+		It("Assuming trace file exceeds the max size", func() {
+			for i := 0; i < 20; i++ {
+				Expect(Append(dir, 1024, Record{Command: "add", ContainerID: strings.Repeat("c", i+1), IfName: "net1"})).To(Succeed())
+			}
+			fi, err := os.Stat(filepath.Join(dir, FileName))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(fi.Size()).To(BeNumerically("<=", 1024))
+
+			// the newest records are kept
+			records := readTrace(dir)
+			Expect(len(records)).To(BeNumerically("<", 20))
+			Expect(records[len(records)-1].ContainerID).To(Equal(strings.Repeat("c", 20)))
+			for i := 1; i < len(records); i++ {
+				Expect(len(records[i].ContainerID)).To(Equal(len(records[i-1].ContainerID) + 1))
+			}
+		})
+		It("Assuming record larger than the max size", func() {
+			Expect(Append(dir, 16, Record{Command: "add", ContainerID: "cid1"})).To(Succeed())
+			Expect(Append(dir, 16, Record{Command: "add", ContainerID: "cid2"})).To(Succeed())
+			records := readTrace(dir)
+			Expect(records).To(HaveLen(1))
+			Expect(records[0].ContainerID).To(Equal("cid2"))
+		})
+	})
+})
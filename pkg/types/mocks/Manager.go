@@ -2,6 +2,7 @@
 
 package mocks
 
+import context "context"
 import mock "github.com/stretchr/testify/mock"
 import ns "github.com/containernetworking/plugins/pkg/ns"
 import types "github.com/Mellanox/ib-sriov-cni/pkg/types"
@@ -11,13 +12,13 @@ type Manager struct {
 	mock.Mock
 }
 
-// ApplyVFConfig provides a mock function with given fields: conf
-func (_m *Manager) ApplyVFConfig(conf *types.NetConf) error {
-	ret := _m.Called(conf)
+// ApplyVFConfig provides a mock function with given fields: ctx, conf
+func (_m *Manager) ApplyVFConfig(ctx context.Context, conf *types.NetConf) error {
+	ret := _m.Called(ctx, conf)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*types.NetConf) error); ok {
-		r0 = rf(conf)
+	if rf, ok := ret.Get(0).(func(context.Context, *types.NetConf) error); ok {
+		r0 = rf(ctx, conf)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -53,13 +54,41 @@ func (_m *Manager) ResetVFConfig(conf *types.NetConf) error {
 	return r0
 }
 
-// SetupVF provides a mock function with given fields: conf, podifName, cid, netns
-func (_m *Manager) SetupVF(conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
-	ret := _m.Called(conf, podifName, cid, netns)
+// SetupVF provides a mock function with given fields: ctx, conf, podifName, cid, netns
+func (_m *Manager) SetupVF(ctx context.Context, conf *types.NetConf, podifName string, cid string, netns ns.NetNS) error {
+	ret := _m.Called(ctx, conf, podifName, cid, netns)
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(*types.NetConf, string, string, ns.NetNS) error); ok {
-		r0 = rf(conf, podifName, cid, netns)
+	if rf, ok := ret.Get(0).(func(context.Context, *types.NetConf, string, string, ns.NetNS) error); ok {
+		r0 = rf(ctx, conf, podifName, cid, netns)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// ValidateVF provides a mock function with given fields: conf
+func (_m *Manager) ValidateVF(conf *types.NetConf) error {
+	ret := _m.Called(conf)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.NetConf) error); ok {
+		r0 = rf(conf)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// VerifyVFReset provides a mock function with given fields: conf
+func (_m *Manager) VerifyVFReset(conf *types.NetConf) error {
+	ret := _m.Called(conf)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*types.NetConf) error); ok {
+		r0 = rf(conf)
 	} else {
 		r0 = ret.Error(0)
 	}
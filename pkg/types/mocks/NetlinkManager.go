@@ -5,12 +5,48 @@ package mocks
 import mock "github.com/stretchr/testify/mock"
 import net "net"
 import netlink "github.com/vishvananda/netlink"
+import types "github.com/Mellanox/ib-sriov-cni/pkg/types"
 
 // NetlinkManager is an autogenerated mock type for the NetlinkManager type
 type NetlinkManager struct {
 	mock.Mock
 }
 
+// EthtoolGetMaxRings provides a mock function with given fields: _a0
+func (_m *NetlinkManager) EthtoolGetMaxRings(_a0 string) (types.Rings, error) {
+	ret := _m.Called(_a0)
+
+	var r0 types.Rings
+	if rf, ok := ret.Get(0).(func(string) types.Rings); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Get(0).(types.Rings)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// EthtoolSetRings provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) EthtoolSetRings(_a0 string, _a1 types.Rings) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, types.Rings) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LinkByName provides a mock function with given fields: _a0
 func (_m *NetlinkManager) LinkByName(_a0 string) (netlink.Link, error) {
 	ret := _m.Called(_a0)
@@ -34,6 +70,34 @@ func (_m *NetlinkManager) LinkByName(_a0 string) (netlink.Link, error) {
 	return r0, r1
 }
 
+// LinkSetARPOff provides a mock function with given fields: _a0
+func (_m *NetlinkManager) LinkSetARPOff(_a0 netlink.Link) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LinkSetAlias provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) LinkSetAlias(_a0 netlink.Link, _a1 string) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link, string) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LinkSetDown provides a mock function with given fields: _a0
 func (_m *NetlinkManager) LinkSetDown(_a0 netlink.Link) error {
 	ret := _m.Called(_a0)
@@ -48,6 +112,34 @@ func (_m *NetlinkManager) LinkSetDown(_a0 netlink.Link) error {
 	return r0
 }
 
+// LinkSetHardwareAddr provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) LinkSetHardwareAddr(_a0 netlink.Link, _a1 net.HardwareAddr) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link, net.HardwareAddr) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// LinkSetMTU provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) LinkSetMTU(_a0 netlink.Link, _a1 int) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link, int) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LinkSetName provides a mock function with given fields: _a0, _a1
 func (_m *NetlinkManager) LinkSetName(_a0 netlink.Link, _a1 string) error {
 	ret := _m.Called(_a0, _a1)
@@ -118,6 +210,20 @@ func (_m *NetlinkManager) LinkSetVfPortGUID(_a0 netlink.Link, _a1 int, _a2 net.H
 	return r0
 }
 
+// LinkSetVfRate provides a mock function with given fields: _a0, _a1, _a2, _a3
+func (_m *NetlinkManager) LinkSetVfRate(_a0 netlink.Link, _a1 int, _a2 int, _a3 int) error {
+	ret := _m.Called(_a0, _a1, _a2, _a3)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link, int, int, int) error); ok {
+		r0 = rf(_a0, _a1, _a2, _a3)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // LinkSetVfState provides a mock function with given fields: _a0, _a1, _a2
 func (_m *NetlinkManager) LinkSetVfState(_a0 netlink.Link, _a1 int, _a2 uint32) error {
 	ret := _m.Called(_a0, _a1, _a2)
@@ -131,3 +237,110 @@ func (_m *NetlinkManager) LinkSetVfState(_a0 netlink.Link, _a1 int, _a2 uint32)
 
 	return r0
 }
+
+// LinkGetVfTrust provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) LinkGetVfTrust(_a0 netlink.Link, _a1 int) (bool, error) {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(netlink.Link, int) bool); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(netlink.Link, int) error); ok {
+		r1 = rf(_a0, _a1)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// LinkSetVfTrust provides a mock function with given fields: _a0, _a1, _a2
+func (_m *NetlinkManager) LinkSetVfTrust(_a0 netlink.Link, _a1 int, _a2 bool) error {
+	ret := _m.Called(_a0, _a1, _a2)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(netlink.Link, int, bool) error); ok {
+		r0 = rf(_a0, _a1, _a2)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// NeighSet provides a mock function with given fields: _a0
+func (_m *NetlinkManager) NeighSet(_a0 *netlink.Neigh) error {
+	ret := _m.Called(_a0)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*netlink.Neigh) error); ok {
+		r0 = rf(_a0)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RdmaLinkByName provides a mock function with given fields: _a0
+func (_m *NetlinkManager) RdmaLinkByName(_a0 string) (*netlink.RdmaLink, error) {
+	ret := _m.Called(_a0)
+
+	var r0 *netlink.RdmaLink
+	if rf, ok := ret.Get(0).(func(string) *netlink.RdmaLink); ok {
+		r0 = rf(_a0)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*netlink.RdmaLink)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(_a0)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RdmaLinkSetNsFd provides a mock function with given fields: _a0, _a1
+func (_m *NetlinkManager) RdmaLinkSetNsFd(_a0 *netlink.RdmaLink, _a1 uint32) error {
+	ret := _m.Called(_a0, _a1)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(*netlink.RdmaLink, uint32) error); ok {
+		r0 = rf(_a0, _a1)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// RdmaSystemGetNetnsMode provides a mock function with given fields:
+func (_m *NetlinkManager) RdmaSystemGetNetnsMode() (string, error) {
+	ret := _m.Called()
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func() string); ok {
+		r0 = rf()
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func() error); ok {
+		r1 = rf()
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
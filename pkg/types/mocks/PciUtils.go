@@ -9,6 +9,62 @@ type PciUtils struct {
 	mock.Mock
 }
 
+// ClearVfPKeyEntry provides a mock function with given fields: pfName, vfPciAddress, entry
+func (_m *PciUtils) ClearVfPKeyEntry(pfName string, vfPciAddress string, entry int) error {
+	ret := _m.Called(pfName, vfPciAddress, entry)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, int) error); ok {
+		r0 = rf(pfName, vfPciAddress, entry)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetIPoIBMode provides a mock function with given fields: ifName
+func (_m *PciUtils) GetIPoIBMode(ifName string) (string, error) {
+	ret := _m.Called(ifName)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(ifName)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(ifName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetLinkSpeed provides a mock function with given fields: ifName
+func (_m *PciUtils) GetLinkSpeed(ifName string) (int, error) {
+	ret := _m.Called(ifName)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(ifName)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(ifName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetPciAddress provides a mock function with given fields: ifName, vf
 func (_m *PciUtils) GetPciAddress(ifName string, vf int) (string, error) {
 	ret := _m.Called(ifName, vf)
@@ -30,6 +86,27 @@ func (_m *PciUtils) GetPciAddress(ifName string, vf int) (string, error) {
 	return r0, r1
 }
 
+// GetPfPciAddress provides a mock function with given fields: pfName
+func (_m *PciUtils) GetPfPciAddress(pfName string) (string, error) {
+	ret := _m.Called(pfName)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(pfName)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(pfName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetSriovNumVfs provides a mock function with given fields: ifName
 func (_m *PciUtils) GetSriovNumVfs(ifName string) (int, error) {
 	ret := _m.Called(ifName)
@@ -51,6 +128,27 @@ func (_m *PciUtils) GetSriovNumVfs(ifName string) (int, error) {
 	return r0, r1
 }
 
+// GetSriovTotalVfs provides a mock function with given fields: ifName
+func (_m *PciUtils) GetSriovTotalVfs(ifName string) (int, error) {
+	ret := _m.Called(ifName)
+
+	var r0 int
+	if rf, ok := ret.Get(0).(func(string) int); ok {
+		r0 = rf(ifName)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(ifName)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // GetVFLinkNamesFromVFID provides a mock function with given fields: pfName, vfID
 func (_m *PciUtils) GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, error) {
 	ret := _m.Called(pfName, vfID)
@@ -74,6 +172,41 @@ func (_m *PciUtils) GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, e
 	return r0, r1
 }
 
+// GetVfRepresentor provides a mock function with given fields: pfName, vfID
+func (_m *PciUtils) GetVfRepresentor(pfName string, vfID int) (string, error) {
+	ret := _m.Called(pfName, vfID)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, int) string); ok {
+		r0 = rf(pfName, vfID)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, int) error); ok {
+		r1 = rf(pfName, vfID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// IsVfPKeyConfigurable provides a mock function with given fields: pfName, vfPciAddress
+func (_m *PciUtils) IsVfPKeyConfigurable(pfName string, vfPciAddress string) bool {
+	ret := _m.Called(pfName, vfPciAddress)
+
+	var r0 bool
+	if rf, ok := ret.Get(0).(func(string, string) bool); ok {
+		r0 = rf(pfName, vfPciAddress)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	return r0
+}
+
 // RebindVf provides a mock function with given fields: pfName, vfPciAddress
 func (_m *PciUtils) RebindVf(pfName string, vfPciAddress string) error {
 	ret := _m.Called(pfName, vfPciAddress)
@@ -87,3 +220,94 @@ func (_m *PciUtils) RebindVf(pfName string, vfPciAddress string) error {
 
 	return r0
 }
+
+// ResetVfPKey provides a mock function with given fields: pfName, vfPciAddress, idx
+func (_m *PciUtils) ResetVfPKey(pfName string, vfPciAddress string, idx string) error {
+	ret := _m.Called(pfName, vfPciAddress, idx)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(pfName, vfPciAddress, idx)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// GetVfPKeyEntry provides a mock function with given fields: pfName, vfPciAddress, entry
+func (_m *PciUtils) GetVfPKeyEntry(pfName string, vfPciAddress string, entry int) (string, error) {
+	ret := _m.Called(pfName, vfPciAddress, entry)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string, string, int) string); ok {
+		r0 = rf(pfName, vfPciAddress, entry)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string, string, int) error); ok {
+		r1 = rf(pfName, vfPciAddress, entry)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetIPoIBMode provides a mock function with given fields: ifName, mode
+func (_m *PciUtils) SetIPoIBMode(ifName string, mode string) error {
+	ret := _m.Called(ifName, mode)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(ifName, mode)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetVfGUIDSysfs provides a mock function with given fields: pfName, vfID, guid
+func (_m *PciUtils) SetVfGUIDSysfs(pfName string, vfID int, guid string) error {
+	ret := _m.Called(pfName, vfID, guid)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, int, string) error); ok {
+		r0 = rf(pfName, vfID, guid)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetVfPKey provides a mock function with given fields: pfName, vfPciAddress, pkey
+func (_m *PciUtils) SetVfPKey(pfName string, vfPciAddress string, pkey string) error {
+	ret := _m.Called(pfName, vfPciAddress, pkey)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, string) error); ok {
+		r0 = rf(pfName, vfPciAddress, pkey)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetVfPKeyEntry provides a mock function with given fields: pfName, vfPciAddress, entry, pkey
+func (_m *PciUtils) SetVfPKeyEntry(pfName string, vfPciAddress string, entry int, pkey string) error {
+	ret := _m.Called(pfName, vfPciAddress, entry, pkey)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string, int, string) error); ok {
+		r0 = rf(pfName, vfPciAddress, entry, pkey)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
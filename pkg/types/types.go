@@ -1,10 +1,70 @@
 package types
 
 import (
+	"context"
+	"net"
+
 	"github.com/containernetworking/cni/pkg/types"
 	"github.com/containernetworking/plugins/pkg/ns"
 	"github.com/vishvananda/netlink"
-	"net"
+)
+
+// VF GUID reset policies of NetConf.ResetGUIDPolicy
+const (
+	// ResetGUIDZero administratively unsets the VF GUID
+	ResetGUIDZero = "zero"
+	// ResetGUIDOriginal restores the VF GUID found before the VF was configured
+	ResetGUIDOriginal = "original"
+	// ResetGUIDKeep leaves the VF GUID configured for the Pod
+	ResetGUIDKeep = "keep"
+)
+
+// Container interface name conflict policies of NetConf.OnNameConflict
+const (
+	// NameConflictFail fails the setup when the interface name is taken in the container netns
+	NameConflictFail = "fail"
+	// NameConflictRename sets the VF up under the interface name with the first free "-<n>" suffix
+	NameConflictRename = "rename"
+)
+
+// Policies of NetConf.OnCacheConflict when the NetConf of the attachment is already cached, e.g. on a repeated ADD
+const (
+	// CacheConflictOverwrite replaces the cached NetConf, logging the changed fields
+	CacheConflictOverwrite = "overwrite"
+	// CacheConflictFail fails the ADD
+	CacheConflictFail = "fail"
+	// CacheConflictMerge keeps the cached fields the new NetConf doesn't set, logging the changed fields
+	CacheConflictMerge = "merge"
+)
+
+// Formats of the cached NetConf files of NetConf.CacheFormat
+const (
+	// CacheFormatJSON writes the NetConf JSON as is
+	CacheFormatJSON = "json"
+	// CacheFormatCompact writes the NetConf JSON gzip compressed
+	CacheFormatCompact = "compact"
+)
+
+// IPoIB modes of NetConf.IPoIBMode
+const (
+	// IPoIBModeDatagram sends over unreliable datagram QPs, the MTU is bounded by the InfiniBand MTU
+	IPoIBModeDatagram = "datagram"
+	// IPoIBModeConnected sends over reliable connected QPs, which allow MTUs up to 65520
+	IPoIBModeConnected = "connected"
+	// MaxIPoIBDatagramMTU is the maximum MTU of IPoIB datagram mode: the 4K InfiniBand MTU less the IPoIB header
+	MaxIPoIBDatagramMTU = 4092
+)
+
+// GUIDSourceCNIArgs is the default NetConf.GUIDSource, the guid is read from cni-args only
+const GUIDSourceCNIArgs = "cni-args"
+
+// MaxIfNameLen is the maximum length of a network interface name (IFNAMSIZ - 1)
+const MaxIfNameLen = 15
+
+// Host admin states of NetConf.HostAdminState
+const (
+	AdminStateUp   = "up"
+	AdminStateDown = "down"
 )
 
 // NetConf extends types.NetConf for ib-sriov-cni
@@ -13,23 +73,218 @@ type NetConf struct {
 	Master      string
 	DeviceID    string `json:"deviceID"` // PCI address of a VF in valid sysfs format
 	VFID        int
-	HostIFNames string            // VF netdevice name(s)
-	HostIFGUID  string            // VF netdevice GUID
-	ContIFNames string            // VF names after in the container; used during deletion
-	GUID        string `json:"-"` // VF Guid is allowed only read from cni-args of network attachment
-	PKey        string `json:"pkey"`
-	LinkState   string `json:"link_state,omitempty"` // auto|enable|disable
-	Args        struct {
+	HostIFNames string // VF netdevice name(s)
+	// PFAllowlist names or PCI address prefixes of the PFs the VF may belong to, any PF when empty
+	PFAllowlist []string `json:"pfAllowlist,omitempty"`
+	// IPAMAllowlist IPAM plugin types the network configuration may use, any type when empty
+	IPAMAllowlist []string `json:"ipamAllowlist,omitempty"`
+	// PFPciAddress PCI address of the PF, resolved from Master by LoadConf
+	PFPciAddress string
+	// MaxVFsPerPF VFs of the PF the plugin picks free VFs up to when DeviceID is not set, no limit when zero
+	MaxVFsPerPF int `json:"maxVFsPerPF,omitempty"`
+	// EnumerateWorkers VFs of the PF read concurrently when picking a free VF, a default count when zero
+	EnumerateWorkers int `json:"enumerateWorkers,omitempty"`
+	// VFToPFMap PF names of VF PCI addresses, overrides the PF resolved from sysfs for the mapped VFs
+	VFToPFMap map[string]string `json:"vfToPFMap,omitempty"`
+	// VFNameTemplate host VF netdevice name used on release, supports {pf}, {vf} and {pci} tokens
+	VFNameTemplate string `json:"vfNameTemplate,omitempty"`
+	HostIFGUID     string // VF netdevice GUID
+	ContIFNames    string // VF names after in the container; used during deletion
+	ContIFMAC      string // VF hardware address in the container; reported in the result
+	GUID           string `json:"-"` // VF Guid is allowed only read from cni-args of network attachment
+	// StaticGUID VF GUID of the network configuration, used when cni-args have no guid
+	StaticGUID string `json:"guid,omitempty"`
+	// InfiniBandAnnotation cni-arg set by ib-kubernetes once the guid is configured, and its expected value
+	InfiniBandAnnotation string `json:"infiniBandAnnotation,omitempty"`
+	InfiniBandConfigured string `json:"infiniBandConfigured,omitempty"`
+	// ResetGUIDPolicy GUID the VF is reset to when released: zero, original or keep
+	ResetGUIDPolicy string `json:"resetGUIDPolicy,omitempty"`
+	// SkipIBStatusCheck uses the guid cni-arg without the InfiniBand annotation, for guids provisioned out-of-band
+	SkipIBStatusCheck bool `json:"skipIBStatusCheck,omitempty"`
+	// GUIDSource cni-args or the directory an external agent writes the guid of each container to, in a file named
+	// after the container id, read when cni-args have no guid
+	GUIDSource string `json:"guidSource,omitempty"`
+	// EnforceGUIDUniqueness fails the ADD when the VF GUID is used by another live attachment of the node
+	EnforceGUIDUniqueness bool `json:"enforceGUIDUniqueness,omitempty"`
+	// GUIDPool allocates the VF GUID when it is not given in cni-args
+	GUIDPool *GUIDPool `json:"guidPool,omitempty"`
+	// ContainerID and PodGUID of the attachment, cached for the GUID uniqueness check
+	ContainerID string
+	PodGUID     string
+	// AllocatedGUID GUID allocated from the GUID pool; released during deletion
+	AllocatedGUID string
+	PKey          string `json:"pkey"`
+	// PKeys additional partitions the VF is a member of, besides PKey
+	PKeys []string `json:"pkeys,omitempty"`
+	// AddedPKeys pkeys added to the unmapped entries of the VF pkey table from its second entry on; removed during
+	// deletion
+	AddedPKeys []string
+	// AddedPKeyEntries VF pkey table entries the AddedPKeys are mapped in, entries 1 on for a cache from an older
+	// version
+	AddedPKeyEntries []int
+	LinkState        string `json:"link_state,omitempty"` // auto|enable|disable
+	// HostVFConfig VF config before ApplyVFConfig changed it, restored during deletion so that the next Pod getting
+	// the VF doesn't inherit the config of this one
+	HostVFConfig *VFConfig `json:",omitempty"`
+	// HostIFLinkState VF link state before applying the configured link state; used during deletion
+	HostIFLinkState string
+	Trust           string `json:"trust,omitempty"` // on|off
+	MTU             int    `json:"mtu,omitempty"`
+	HostIFMTU       int    // VF netdevice MTU before applying the configured MTU; used during deletion
+	MAC             string `json:"mac,omitempty"` // 20 bytes IPoIB hardware address
+	HostIFMAC       string // VF netdevice hardware address before applying the configured MAC; used during deletion
+	// HostAdminState admin state the VF netdevice is brought to on the host before it is moved: up or down, the
+	// admin state is kept when empty
+	HostAdminState string `json:"hostAdminState,omitempty"`
+	// DisableArpNd turns ARP and neighbor discovery off on the container interface
+	DisableArpNd bool `json:"disableArpNd,omitempty"`
+	// DeriveMACFromGUID sets the container interface hardware address derived from the VF GUID, exclusive with MAC
+	DeriveMACFromGUID bool `json:"deriveMACFromGUID,omitempty"`
+	// Neighbors are static neighbor entries installed on the container interface
+	Neighbors []Neighbor `json:"neighbors,omitempty"`
+	// RenameInterface renames the VF to the requested interface name in the container, defaults to true
+	RenameInterface *bool `json:"renameInterface,omitempty"`
+	// IfAlias alias of the container interface, supports {containerID}, {podUID} and {guid} tokens
+	IfAlias string `json:"ifAlias,omitempty"`
+	// IPoIBMode of the container interface: datagram or connected, the VF mode is kept when empty
+	IPoIBMode string `json:"ipoibMode,omitempty"`
+	// HostIFIPoIBMode VF IPoIB mode before applying the configured mode; used during deletion
+	HostIFIPoIBMode string
+	// OnNameConflict policy when the interface name is taken in the container netns: fail or rename
+	OnNameConflict string `json:"onNameConflict,omitempty"`
+	// OnCacheConflict policy when the NetConf of the attachment is already cached: overwrite, fail or merge
+	OnCacheConflict string `json:"onCacheConflict,omitempty"`
+	// CacheFormat of the cached NetConf file: json or compact
+	CacheFormat string `json:"cacheFormat,omitempty"`
+	// MinTxRate and MaxTxRate (Mbps) limit the VF transmit rate, zero means no limit
+	MinTxRate int `json:"minTxRate,omitempty"`
+	MaxTxRate int `json:"maxTxRate,omitempty"`
+	// Rings sizes of the container interface rx and tx rings, not reverted when the VF is released
+	Rings *Rings `json:"rings,omitempty"`
+	// RdmaIsolation moves the VF RDMA device to the Pod netns, requires the RDMA subsystem in exclusive netns mode
+	RdmaIsolation bool   `json:"rdmaIsolation,omitempty"`
+	RdmaDevice    string // VF RDMA device name; used during deletion
+	LogLevel      string `json:"logLevel,omitempty"` // panic|error|warning|info|debug
+	LogFile       string `json:"logFile,omitempty"`
+	// IncludeConfig file with settings shared by networks, merged underneath the fields of the network configuration
+	IncludeConfig string `json:"includeConfig,omitempty"`
+	// CNIDir directory of the cached NetConf, overrides the default cache directory
+	CNIDir string `json:"cniDir,omitempty"`
+	// TopologyCacheTTL (seconds) the resolved PF and VF index of the VF are cached on disk for, zero disables it
+	TopologyCacheTTL int `json:"topologyCacheTTL,omitempty"`
+	// MetricsPath file or unix socket to record operation metrics to, in Prometheus text format
+	MetricsPath string `json:"metricsPath,omitempty"`
+	// TraceDir directory to trace the invocations to, TraceMaxSize (bytes) is the size the trace is pruned at
+	TraceDir     string `json:"traceDir,omitempty"`
+	TraceMaxSize int    `json:"traceMaxSize,omitempty"`
+	// RetryAttempts and RetryInterval (milliseconds) control retries of netlink operations failing with transient errors
+	RetryAttempts int `json:"retryAttempts,omitempty"`
+	RetryInterval int `json:"retryInterval,omitempty"`
+	// LinkUpTimeout (milliseconds) to wait for the VF to be operationally up in the Pod netns
+	LinkUpTimeout int `json:"linkUpTimeout,omitempty"`
+	// OperationTimeout (milliseconds) bounds the VF configuration and setup of ADD and the drain of DEL, zero means
+	// no bound
+	OperationTimeout int `json:"operationTimeout,omitempty"`
+	// AddRetryAttempts and AddRetryInterval (milliseconds) control in-process retries of ADD failing with a
+	// transient condition, zero attempts means ADD is not retried
+	AddRetryAttempts int `json:"addRetryAttempts,omitempty"`
+	AddRetryInterval int `json:"addRetryInterval,omitempty"`
+	// DrainOnDel brings the container interface down and waits DrainPeriod (milliseconds) before releasing the VF
+	DrainOnDel  bool `json:"drainOnDel,omitempty"`
+	DrainPeriod int  `json:"drainPeriod,omitempty"`
+	// Sysctls applied to the container interface, keys use the <iface> placeholder for the interface name
+	Sysctls map[string]string `json:"sysctls,omitempty"`
+	// PostSetupHook command run once the VF is set up, a failing hook fails the setup unless HookBestEffort is set
+	PostSetupHook  *Hook `json:"postSetupHook,omitempty"`
+	HookBestEffort bool  `json:"hookBestEffort,omitempty"`
+	// DryRun validates the configuration and the VF state on ADD without configuring the VF
+	DryRun bool `json:"dryRun,omitempty"`
+	// VerifyDel reads the VF state back after DEL and logs a warning when the VF was not reset
+	VerifyDel bool `json:"verifyDel,omitempty"`
+	// CheckRepair reapplies a drifted MTU or link state on CHECK instead of failing it
+	CheckRepair bool `json:"checkRepair,omitempty"`
+	// NetnsOverride path of a persistent netns the VF is moved to instead of the container netns
+	NetnsOverride string `json:"netnsOverride,omitempty"`
+	// NetnsID identifier of the Pod netns the VF was moved to; used to detect recycled netns paths
+	NetnsID string
+	// PFSwitchdev skips the PF eswitch mode detection when set
+	PFSwitchdev *bool `json:"pfSwitchdev,omitempty"`
+	// Representor VF representor net device name when the PF is in switchdev mode; used during deletion
+	Representor string
+	// RepresentorUp VF representor admin state before applying the configuration; used during deletion
+	RepresentorUp bool
+	// CacheVersion of the cached NetConf schema, set when caching the NetConf
+	CacheVersion int `json:"cacheVersion,omitempty"`
+	// RuntimeConfig is set by the runtime for the capabilities declared in the network configuration
+	RuntimeConfig struct {
+		IPs []string `json:"ips,omitempty"`
+		Mac string   `json:"mac,omitempty"`
+	} `json:"runtimeConfig,omitempty"`
+	Args struct {
 		CNI map[string]string `json:"cni"`
 	} `json:"args"`
 }
 
+// VFConfig is the PF side configuration of a VF
+type VFConfig struct {
+	LinkState string `json:"linkState,omitempty"`
+	MinTxRate int    `json:"minTxRate,omitempty"`
+	MaxTxRate int    `json:"maxTxRate,omitempty"`
+	GUID      string `json:"guid,omitempty"`
+	// Trust is read only when the network sets the trust, empty when it wasn't read
+	Trust string `json:"trust,omitempty"`
+	// PKeyIndex PF pkey table index the first entry of the VF pkey table is mapped to
+	PKeyIndex string `json:"pkeyIndex,omitempty"`
+}
+
+// GUIDPool is a range of GUIDs the plugin allocates VF GUIDs from
+type GUIDPool struct {
+	RangeStart string `json:"rangeStart"`
+	RangeEnd   string `json:"rangeEnd"`
+	// DataDir directory the allocations are persisted in, shared by all networks using the pool
+	DataDir string `json:"dataDir,omitempty"`
+}
+
+// Hook is a command run by the plugin, given with its arguments
+type Hook struct {
+	Command []string `json:"command"`
+	// Timeout (milliseconds) the command is killed after
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Rings are the ring sizes of a network device, a zero size is left unchanged
+type Rings struct {
+	RX uint32 `json:"rx,omitempty"`
+	TX uint32 `json:"tx,omitempty"`
+}
+
+// Neighbor is a static neighbor entry of the container interface
+type Neighbor struct {
+	IP     string `json:"ip"`
+	LLAddr string `json:"lladdr"` // 20 bytes IPoIB hardware address
+}
+
+// VFState is the host state of a VF read when enumerating the VFs of a PF
+type VFState struct {
+	VFID    int
+	PciAddr string
+	// LinkName is the name of the VF netdevice, empty when the VF netdevice is not on the host
+	LinkName string
+}
+
+// VFEnumerator reads the host state of the VFs of a PF
+type VFEnumerator interface {
+	EnumerateVFs(pfName string, workers int) ([]VFState, error)
+}
+
 // Manager provides interface invoke sriov nic related operations
 type Manager interface {
-	SetupVF(conf *NetConf, podifName string, cid string, netns ns.NetNS) error
+	SetupVF(ctx context.Context, conf *NetConf, podifName string, cid string, netns ns.NetNS) error
 	ReleaseVF(conf *NetConf, podifName string, cid string, netns ns.NetNS) error
 	ResetVFConfig(conf *NetConf) error
-	ApplyVFConfig(conf *NetConf) error
+	VerifyVFReset(conf *NetConf) error
+	ApplyVFConfig(ctx context.Context, conf *NetConf) error
+	ValidateVF(conf *NetConf) error
 }
 
 // mocked netlink interface
@@ -42,15 +297,41 @@ type NetlinkManager interface {
 	LinkSetDown(netlink.Link) error
 	LinkSetNsFd(netlink.Link, int) error
 	LinkSetName(netlink.Link, string) error
+	LinkSetMTU(netlink.Link, int) error
+	LinkSetHardwareAddr(netlink.Link, net.HardwareAddr) error
 	LinkSetVfState(netlink.Link, int, uint32) error
+	LinkSetVfTrust(netlink.Link, int, bool) error
+	LinkGetVfTrust(netlink.Link, int) (bool, error)
+	LinkSetVfRate(netlink.Link, int, int, int) error
 	LinkSetVfPortGUID(netlink.Link, int, net.HardwareAddr) error
 	LinkSetVfNodeGUID(netlink.Link, int, net.HardwareAddr) error
+	LinkSetARPOff(netlink.Link) error
+	LinkSetAlias(netlink.Link, string) error
+	NeighSet(*netlink.Neigh) error
+	EthtoolGetMaxRings(string) (Rings, error)
+	EthtoolSetRings(string, Rings) error
+	RdmaSystemGetNetnsMode() (string, error)
+	RdmaLinkByName(string) (*netlink.RdmaLink, error)
+	RdmaLinkSetNsFd(*netlink.RdmaLink, uint32) error
 }
 
 // PciUtils is interface to help in SR-IOV functions
 type PciUtils interface {
 	GetSriovNumVfs(ifName string) (int, error)
+	GetSriovTotalVfs(ifName string) (int, error)
+	GetPfPciAddress(pfName string) (string, error)
+	GetLinkSpeed(ifName string) (int, error)
+	GetVfRepresentor(pfName string, vfID int) (string, error)
 	GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, error)
 	GetPciAddress(ifName string, vf int) (string, error)
 	RebindVf(pfName, vfPciAddress string) error
+	SetVfGUIDSysfs(pfName string, vfID int, guid string) error
+	GetIPoIBMode(ifName string) (string, error)
+	SetIPoIBMode(ifName, mode string) error
+	IsVfPKeyConfigurable(pfName, vfPciAddress string) bool
+	SetVfPKey(pfName, vfPciAddress, pkey string) error
+	ResetVfPKey(pfName, vfPciAddress, idx string) error
+	GetVfPKeyEntry(pfName, vfPciAddress string, entry int) (string, error)
+	SetVfPKeyEntry(pfName, vfPciAddress string, entry int, pkey string) error
+	ClearVfPKeyEntry(pfName, vfPciAddress string, entry int) error
 }
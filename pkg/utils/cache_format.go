@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// netConfSerializer converts the JSON of a cached NetConf to the content of its cache file and back
+type netConfSerializer interface {
+	encode(netConf []byte) ([]byte, error)
+	decode(data []byte) ([]byte, error)
+	// detect returns whether the cache file content is in the format of the serializer
+	detect(data []byte) bool
+}
+
+// netConfSerializers are the serializers of the cache formats, by the order their format is detected in. A cache file
+// is read in the format it was written in whatever the format of the network configuration.
+var netConfSerializers = []struct {
+	format string
+	netConfSerializer
+}{
+	{"compact", compactSerializer{}},
+	{"json", jsonSerializer{}},
+}
+
+// jsonSerializer writes the NetConf JSON as is
+type jsonSerializer struct{}
+
+func (jsonSerializer) encode(netConf []byte) ([]byte, error) {
+	return netConf, nil
+}
+
+func (jsonSerializer) decode(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (jsonSerializer) detect(data []byte) bool {
+	return !(compactSerializer{}).detect(data)
+}
+
+// compactSerializer writes the NetConf JSON gzip compressed. The JSON is kept rather than a binary encoding of the
+// NetConf so that the cached NetConf has the same fields whatever the format, e.g. gob drops the zero values of
+// pointer fields.
+type compactSerializer struct{}
+
+// gzipMagic starts the gzip compressed cache files, a JSON NetConf starts with "{"
+var gzipMagic = []byte{0x1f, 0x8b}
+
+func (compactSerializer) encode(netConf []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestSpeed)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(netConf); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (compactSerializer) decode(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func (compactSerializer) detect(data []byte) bool {
+	return bytes.HasPrefix(data, gzipMagic)
+}
+
+// encodeNetConf returns the cache file content of the NetConf JSON, gzip compressed when compact is set
+func encodeNetConf(netConf []byte, compact bool) ([]byte, error) {
+	if compact {
+		return compactSerializer{}.encode(netConf)
+	}
+	return jsonSerializer{}.encode(netConf)
+}
+
+// decodeNetConf returns the NetConf JSON of the cache file content, detecting the format it was written in
+func decodeNetConf(data []byte) ([]byte, error) {
+	for _, s := range netConfSerializers {
+		if s.detect(data) {
+			netConf, err := s.decode(data)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode cached NetConf in %s format: %w", s.format, err)
+			}
+			return netConf, nil
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/Mellanox/ib-sriov-cni/pkg/types"
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+// cachedNetConf is a NetConf as cached by ADD
+func cachedNetConf() *types.NetConf {
+	conf := &types.NetConf{
+		Master:          "ib0",
+		DeviceID:        "0000:af:06.0",
+		VFID:            0,
+		HostIFNames:     "ib1",
+		HostIFGUID:      "11:22:33:00:00:aa:bb:cc",
+		ContIFNames:     "net1",
+		ContIFMAC:       "00:00:01:07:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef",
+		PKey:            "0x8001",
+		LinkState:       "enable",
+		MTU:             4092,
+		HostIFMTU:       2044,
+		CNIDir:          "/var/lib/cni/ib-sriov-cni",
+		NetnsID:         "4:4026532448",
+		ContainerID:     "6f5a55c4f268489888f0f46bb0b1c845",
+		PodGUID:         "01:23:45:67:89:ab:cd:ef",
+		OnNameConflict:  types.NameConflictFail,
+		OnCacheConflict: types.CacheConflictOverwrite,
+		CacheVersion:    1,
+		HostVFConfig:    &types.VFConfig{LinkState: "auto", GUID: "11:22:33:00:00:aa:bb:cc"},
+	}
+	conf.CNIVersion = "0.4.0"
+	conf.Name = "ib-sriov-network"
+	conf.Type = "ib-sriov"
+	conf.IPAM.Type = "whereabouts"
+	conf.Args.CNI = map[string]string{
+		"guid":                    "01:23:45:67:89:ab:cd:ef",
+		"mellanox.infiniband.app": "configured",
+		"K8S_POD_NAMESPACE":       "default",
+		"K8S_POD_NAME":            "pod1",
+	}
+	return conf
+}
+
+var _ = Describe("Cache format", func() {
+	var dataDir string
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "ib-sriov-cni-cache-")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	Context("Checking SaveNetConfPolicy function", func() {
+		It("Assuming compact format", func() {
+			_, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]string{"Master": "ib0"},
+				nil, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			raw, err := ioutil.ReadFile(filepath.Join(dataDir, "cid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(raw).To(HavePrefix(string(gzipMagic)))
+
+			data, err := ReadScratchNetConf(filepath.Join(dataDir, "cid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"Master":"ib0"}`))
+		})
+		It("Assuming compact format merged into a JSON cached entry", func() {
+			path := filepath.Join(dataDir, "cid-net1")
+			Expect(ioutil.WriteFile(path, []byte(`{"Master":"ib0","pkey":"0x1"}`), 0600)).To(Succeed())
+
+			conflict, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]string{"Master": "ib1"},
+				[]string{"pkey"}, true)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflict.Changes).To(Equal([]string{`Master: "ib0" -> "ib1"`}))
+
+			data, err := ReadScratchNetConf(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"Master":"ib1","pkey":"0x1"}`))
+		})
+		It("Assuming JSON format over a compact cached entry", func() {
+			_, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]string{"Master": "ib0"},
+				nil, true)
+			Expect(err).NotTo(HaveOccurred())
+
+			conflict, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]string{"Master": "ib0"},
+				nil, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflict.Changes).To(BeEmpty())
+
+			raw, err := ioutil.ReadFile(filepath.Join(dataDir, "cid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(raw)).To(Equal(`{"Master":"ib0"}`))
+		})
+	})
+	Context("Checking ReadScratchNetConf function", func() {
+		It("Assuming corrupted compact cached entry", func() {
+			path := filepath.Join(dataDir, "cid-net1")
+			Expect(ioutil.WriteFile(path, append(gzipMagic, 0x08, 0x00), 0600)).To(Succeed())
+
+			_, err := ReadScratchNetConf(path)
+			Expect(err).To(MatchError(ContainSubstring("failed to decode cached NetConf in compact format")))
+		})
+	})
+})
+
+// benchmarkSaveNetConf caches a NetConf as ADD does, gzip compressed when compact is set
+func benchmarkSaveNetConf(b *testing.B, compact bool) {
+	dataDir, err := ioutil.TempDir("", "ib-sriov-cni-cache-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	conf := cachedNetConf()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := SaveNetConfPolicy("cid", dataDir, "net1", conf, nil, compact); err != nil {
+			b.Fatal(err)
+		}
+		// each iteration caches a new attachment
+		b.StopTimer()
+		if err := os.Remove(filepath.Join(dataDir, "cid-net1")); err != nil {
+			b.Fatal(err)
+		}
+		b.StartTimer()
+	}
+}
+
+// benchmarkReadNetConf reads a NetConf cached gzip compressed when compact is set as DEL does
+func benchmarkReadNetConf(b *testing.B, compact bool) {
+	dataDir, err := ioutil.TempDir("", "ib-sriov-cni-cache-")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dataDir)
+
+	if _, err := SaveNetConfPolicy("cid", dataDir, "net1", cachedNetConf(), nil, compact); err != nil {
+		b.Fatal(err)
+	}
+	path := filepath.Join(dataDir, "cid-net1")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ReadScratchNetConf(path); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if fi, err := os.Stat(path); err == nil {
+		b.ReportMetric(float64(fi.Size()), "bytes/file")
+	}
+}
+
+func BenchmarkSaveNetConfJSON(b *testing.B) {
+	benchmarkSaveNetConf(b, false)
+}
+
+func BenchmarkSaveNetConfCompact(b *testing.B) {
+	benchmarkSaveNetConf(b, true)
+}
+
+func BenchmarkReadNetConfJSON(b *testing.B) {
+	benchmarkReadNetConf(b, false)
+}
+
+func BenchmarkReadNetConfCompact(b *testing.B) {
+	benchmarkReadNetConf(b, true)
+}
@@ -0,0 +1,159 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"math"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const guidPoolLockFile = "lock"
+
+// ParseGUIDRange parses a GUID pool range, rangeStart must not be greater than rangeEnd
+func ParseGUIDRange(rangeStart, rangeEnd string) (uint64, uint64, error) {
+	start, err := ParseAndNormalizeGUID(rangeStart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid GUID pool rangeStart: %w", err)
+	}
+	end, err := ParseAndNormalizeGUID(rangeEnd)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid GUID pool rangeEnd: %w", err)
+	}
+	s, e := binary.BigEndian.Uint64(start), binary.BigEndian.Uint64(end)
+	if s > e {
+		return 0, 0, fmt.Errorf("invalid GUID pool range %s-%s: rangeStart is greater than rangeEnd", start, end)
+	}
+	return s, e, nil
+}
+
+// AllocateGUID allocates a GUID from the pool range for owner, allocations are persisted in dataDir.
+// The first candidate is derived from seed so that the same owner gets the same GUID across invocations when free,
+// a GUID already allocated to owner is returned as is. Concurrent invocations are serialized through a file lock.
+func AllocateGUID(dataDir, rangeStart, rangeEnd, owner, seed string) (string, error) {
+	start, end, err := ParseGUIDRange(rangeStart, rangeEnd)
+	if err != nil {
+		return "", err
+	}
+
+	unlock, err := lockGUIDPool(dataDir)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	allocated, err := readGUIDAllocations(dataDir)
+	if err != nil {
+		return "", err
+	}
+	for guid, o := range allocated {
+		if o == owner {
+			return guid, nil
+		}
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(seed))
+	offset := h.Sum64()
+	span := end - start
+	if span != math.MaxUint64 {
+		offset %= span + 1
+	}
+
+	for i := uint64(0); ; i++ {
+		candidate := start + offset + i
+		if span != math.MaxUint64 {
+			candidate = start + (offset+i)%(span+1)
+		}
+		guid := guidFromUint64(candidate)
+		if _, used := allocated[guid]; !used {
+			if err := ioutil.WriteFile(filepath.Join(dataDir, guidFileName(guid)), []byte(owner), 0600); err != nil {
+				return "", fmt.Errorf("failed to persist GUID %s allocation: %w", guid, err)
+			}
+			return guid, nil
+		}
+		if i == span {
+			break
+		}
+	}
+
+	return "", fmt.Errorf("GUID pool %s-%s is exhausted", guidFromUint64(start), guidFromUint64(end))
+}
+
+// ReleaseGUID releases a GUID allocated to owner back to the pool, releasing a GUID which is not allocated
+// to owner is a no-op
+func ReleaseGUID(dataDir, guid, owner string) error {
+	addr, err := ParseAndNormalizeGUID(guid)
+	if err != nil {
+		return err
+	}
+	guid = addr.String()
+
+	unlock, err := lockGUIDPool(dataDir)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	path := filepath.Join(dataDir, guidFileName(guid))
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read GUID %s allocation: %w", guid, err)
+	}
+	if strings.TrimSpace(string(data)) != owner {
+		return nil
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to release GUID %s: %w", guid, err)
+	}
+	return nil
+}
+
+func lockGUIDPool(dataDir string) (func(), error) {
+	if err := os.MkdirAll(dataDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create GUID pool directory %s: %w", dataDir, err)
+	}
+	return lockFile(filepath.Join(dataDir, guidPoolLockFile))
+}
+
+// readGUIDAllocations returns the allocated GUIDs of the pool mapped to their owners
+func readGUIDAllocations(dataDir string) (map[string]string, error) {
+	files, err := ioutil.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GUID pool directory %s: %w", dataDir, err)
+	}
+	allocated := map[string]string{}
+	for _, f := range files {
+		if f.Name() == guidPoolLockFile {
+			continue
+		}
+		addr, err := ParseAndNormalizeGUID(f.Name())
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dataDir, f.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read GUID %s allocation: %w", addr, err)
+		}
+		allocated[addr.String()] = strings.TrimSpace(string(data))
+	}
+	return allocated, nil
+}
+
+func guidFromUint64(v uint64) string {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return net.HardwareAddr(b).String()
+}
+
+// guidFileName is the bare hex form of a GUID, ':' is avoided in file names
+func guidFileName(guid string) string {
+	return strings.Replace(guid, ":", "", -1)
+}
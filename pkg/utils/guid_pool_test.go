@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"sync"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("GUID pool", func() {
+	var dataDir string
+
+	BeforeEach(func() {
+		var err error
+		dataDir, err = ioutil.TempDir("", "ib-sriov-cni-guid-pool")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(dataDir)).To(Succeed())
+	})
+
+	Context("Checking ParseGUIDRange function", func() {
+		It("Assuming valid range", func() {
+			start, end, err := ParseGUIDRange("02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:ff")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(end - start).To(Equal(uint64(0xff)))
+		})
+		It("Assuming rangeStart greater than rangeEnd", func() {
+			_, _, err := ParseGUIDRange("02:00:00:00:00:00:00:ff", "02:00:00:00:00:00:00:00")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming invalid guid", func() {
+			_, _, err := ParseGUIDRange("02:00", "02:00:00:00:00:00:00:00")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking AllocateGUID function", func() {
+		It("Assuming same seed allocates the same guid", func() {
+			guid, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:ff:ff", "0000:af:06.0", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ReleaseGUID(dataDir, guid, "0000:af:06.0")).To(Succeed())
+
+			again, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:ff:ff", "0000:af:06.0", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(Equal(guid))
+		})
+		It("Assuming owner has an allocated guid", func() {
+			guid, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:0f", "0000:af:06.0", "cid1/0")
+			Expect(err).NotTo(HaveOccurred())
+
+			again, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:0f", "0000:af:06.0", "cid2/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(again).To(Equal(guid))
+		})
+		It("Assuming colliding seeds", func() {
+			first, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:01", "0000:af:06.0", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+			second, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:01", "0000:af:06.1", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(second).NotTo(Equal(first))
+		})
+		It("Assuming exhausted pool", func() {
+			_, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:00", "0000:af:06.0", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+
+			_, err = AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:00", "0000:af:06.1", "cid/1")
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("exhausted"))
+		})
+		It("Assuming released guid is reused", func() {
+			guid, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:00", "0000:af:06.0", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ReleaseGUID(dataDir, guid, "0000:af:06.0")).To(Succeed())
+
+			reused, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:00", "0000:af:06.1", "cid/1")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(reused).To(Equal(guid))
+		})
+		It("Assuming concurrent allocations", func() {
+			var wg sync.WaitGroup
+			guids := make([]string, 8)
+			for i := range guids {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					defer GinkgoRecover()
+					guid, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:07",
+						string(rune('a'+i)), "cid/0")
+					Expect(err).NotTo(HaveOccurred())
+					guids[i] = guid
+				}(i)
+			}
+			wg.Wait()
+
+			unique := map[string]bool{}
+			for _, guid := range guids {
+				unique[guid] = true
+			}
+			Expect(unique).To(HaveLen(len(guids)))
+		})
+	})
+	Context("Checking ReleaseGUID function", func() {
+		It("Assuming guid allocated to another owner", func() {
+			guid, err := AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:00", "0000:af:06.0", "cid/0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(ReleaseGUID(dataDir, guid, "0000:af:06.1")).To(Succeed())
+
+			_, err = AllocateGUID(dataDir, "02:00:00:00:00:00:00:00", "02:00:00:00:00:00:00:00", "0000:af:06.1", "cid/1")
+			Expect(err).To(HaveOccurred(), "guid is still allocated to its owner")
+		})
+		It("Assuming guid not allocated", func() {
+			Expect(ReleaseGUID(dataDir, "02:00:00:00:00:00:00:00", "0000:af:06.0")).To(Succeed())
+		})
+	})
+})
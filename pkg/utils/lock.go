@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// LockPF takes an exclusive advisory lock serializing operations on the PF across plugin invocations, it blocks
+// until the lock is acquired. The returned function releases the lock.
+func LockPF(lockDir, pfName string) (func(), error) {
+	if err := os.MkdirAll(lockDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory %s: %w", lockDir, err)
+	}
+	return lockFile(filepath.Join(lockDir, pfName+".lock"))
+}
+
+// LockGUIDs takes an exclusive advisory lock serializing the GUID uniqueness checks of the node across plugin
+// invocations, it blocks until the lock is acquired. The returned function releases the lock.
+func LockGUIDs(lockDir string) (func(), error) {
+	if err := os.MkdirAll(lockDir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory %s: %w", lockDir, err)
+	}
+	return lockFile(filepath.Join(lockDir, "guids.lock"))
+}
+
+// lockFile takes an exclusive flock on path, creating it if needed
+func lockFile(path string) (func(), error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Lock", func() {
+	var lockDir string
+
+	BeforeEach(func() {
+		var err error
+		lockDir, err = ioutil.TempDir("", "ib-sriov-cni-locks")
+		Expect(err).NotTo(HaveOccurred())
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(lockDir)).To(Succeed())
+	})
+
+	Context("Checking LockPF function", func() {
+		It("Assuming concurrent operations on the same PF", func() {
+			var (
+				wg      sync.WaitGroup
+				holders int32
+				maxSeen int32
+			)
+			for i := 0; i < 8; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					defer GinkgoRecover()
+					unlock, err := LockPF(lockDir, "ib0")
+					Expect(err).NotTo(HaveOccurred())
+					defer unlock()
+
+					n := atomic.AddInt32(&holders, 1)
+					for {
+						m := atomic.LoadInt32(&maxSeen)
+						if n <= m || atomic.CompareAndSwapInt32(&maxSeen, m, n) {
+							break
+						}
+					}
+					time.Sleep(5 * time.Millisecond)
+					atomic.AddInt32(&holders, -1)
+				}()
+			}
+			wg.Wait()
+			Expect(maxSeen).To(Equal(int32(1)), "only one goroutine should hold the PF lock at a time")
+		})
+		It("Assuming operations on different PFs", func() {
+			unlock, err := LockPF(lockDir, "ib0")
+			Expect(err).NotTo(HaveOccurred())
+			defer unlock()
+
+			done := make(chan struct{})
+			go func() {
+				defer GinkgoRecover()
+				unlockOther, err := LockPF(lockDir, "ib3")
+				Expect(err).NotTo(HaveOccurred())
+				unlockOther()
+				close(done)
+			}()
+			Eventually(done).Should(BeClosed())
+			Expect(filepath.Join(lockDir, "ib0.lock")).To(BeAnExistingFile())
+		})
+		It("Assuming lock is released", func() {
+			unlock, err := LockPF(lockDir, "ib0")
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+
+			unlock, err = LockPF(lockDir, "ib0")
+			Expect(err).NotTo(HaveOccurred())
+			unlock()
+		})
+	})
+})
@@ -38,10 +38,31 @@ var ts = tmpSysFs{
 		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/net/ib2",
 		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib3",
 		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib4",
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/pf0vf0",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/infiniband/mlx5_0",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov/0",
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/infiniband/mlx5_2",
+		"sys/class/infiniband/mlx5_0/ports/1/pkeys",
+		"sys/class/infiniband/mlx5_0/iov/0000:af:06.0/ports/1/pkey_idx",
+		"sys/class/infiniband/mlx5_0/iov/0000:af:06.1/ports/1/pkey_idx",
 	},
 	fileList: map[string][]byte{
-		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov_numvfs": []byte("2"),
-		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/sriov_numvfs": []byte("0"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov_numvfs":              []byte("2"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov_totalvfs":            []byte("8"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov/0/node":              []byte("00:00:00:00:00:00:00:00"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/sriov/0/port":              []byte("00:00:00:00:00:00:00:00"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/ib0/speed":             []byte("100000"),
+		"sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.0/net/ib1/mode":              []byte("datagram\n"),
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/sriov_numvfs":              []byte("0"),
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib3/phys_switch_id":    []byte("e4c3a10003b5910c"),
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/pf0vf0/phys_switch_id": []byte("e4c3a10003b5910c"),
+		"sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/pf0vf0/phys_port_name": []byte("pf0vf0"),
+		"sys/class/infiniband/mlx5_0/ports/1/pkeys/0":                                []byte("0xffff"),
+		"sys/class/infiniband/mlx5_0/ports/1/pkeys/1":                                []byte("0x8001"),
+		"sys/class/infiniband/mlx5_0/iov/0000:af:06.0/ports/1/pkey_idx/0":            []byte("0"),
+		"sys/class/infiniband/mlx5_0/iov/0000:af:06.0/ports/1/pkey_idx/1":            []byte("none"),
+		"sys/class/infiniband/mlx5_0/iov/0000:af:06.0/ports/1/pkey_idx/2":            []byte("1"),
+		"sys/class/infiniband/mlx5_0/iov/0000:af:06.1/ports/1/pkey_idx/0":            []byte("0"),
 	},
 	netSymlinks: map[string]string{
 		"sys/class/net/ib0": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1/net/ib0",
@@ -49,6 +70,8 @@ var ts = tmpSysFs{
 		"sys/class/net/ib2": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:06.1/net/ib2",
 		"sys/class/net/ib3": "sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib3",
 		"sys/class/net/ib4": "sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/ib4",
+
+		"sys/class/net/pf0vf0": "sys/devices/pci0000:00/0000:00:02.0/0000:05:00.0/net/pf0vf0",
 	},
 	devSymlinks: map[string]string{
 		"sys/class/net/ib0/device": "sys/devices/pci0000:ae/0000:ae:00.0/0000:af:00.1",
@@ -116,6 +139,7 @@ func CreateTmpSysFs() error {
 
 	SysBusPci = filepath.Join(ts.dirRoot, SysBusPci)
 	NetDirectory = filepath.Join(ts.dirRoot, NetDirectory)
+	InfinibandDirectory = filepath.Join(ts.dirRoot, InfinibandDirectory)
 	return nil
 }
 
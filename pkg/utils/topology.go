@@ -0,0 +1,116 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// VFTopology is the PF of a VF and the VF index on the PF
+type VFTopology struct {
+	PfName string `json:"pfName"`
+	VFID   int    `json:"vfID"`
+	// NumVfs is the sriov_numvfs of the PF the topology was resolved with
+	NumVfs int `json:"numVfs"`
+	// Expires is when a topology cached on disk is resolved again, zero for a topology cached in-process only
+	Expires time.Time `json:"expires,omitempty"`
+}
+
+// topologyCache caches the resolved topologies of the invocation keyed by VF pci address
+var topologyCache = struct {
+	sync.Mutex
+	entries map[string]VFTopology
+}{entries: map[string]VFTopology{}}
+
+// ResolveVFTopology returns the PF name and the VF index of the VF pci address. Resolving walks the virtfn links of
+// the PF in sysfs, the result is cached for the invocation and, when cacheDir is set, on disk for ttl for the
+// following invocations. A cached topology is used only as long as the VFs of the PF were not recreated: the
+// sriov_numvfs of the PF must be unchanged and the virtfn link of the VF index must still refer to the VF.
+func ResolveVFTopology(pciAddr, cacheDir string, ttl time.Duration) (string, int, error) {
+	topologyCache.Lock()
+	defer topologyCache.Unlock()
+
+	if t, ok := topologyCache.entries[pciAddr]; ok && topologyValid(pciAddr, t) {
+		return t.PfName, t.VFID, nil
+	}
+	if cacheDir != "" {
+		if t, err := readCachedTopology(cacheDir, pciAddr); err == nil && time.Now().Before(t.Expires) &&
+			topologyValid(pciAddr, t) {
+			topologyCache.entries[pciAddr] = t
+			return t.PfName, t.VFID, nil
+		}
+	}
+
+	pfName, err := GetPfName(pciAddr)
+	if err != nil {
+		return "", 0, err
+	}
+	numVfs, err := GetSriovNumVfs(pfName)
+	if err != nil {
+		return "", 0, err
+	}
+	vfID, err := GetVfid(pciAddr, pfName)
+	if err != nil {
+		return "", 0, err
+	}
+
+	t := VFTopology{PfName: pfName, VFID: vfID, NumVfs: numVfs}
+	topologyCache.entries[pciAddr] = t
+	if cacheDir != "" && ttl > 0 {
+		t.Expires = time.Now().Add(ttl)
+		// the topology is resolved again by the next invocation when it can't be cached
+		_ = writeCachedTopology(cacheDir, pciAddr, t)
+	}
+	return pfName, vfID, nil
+}
+
+// topologyValid checks that the VFs of the PF of the cached topology were not recreated since it was resolved
+func topologyValid(pciAddr string, t VFTopology) bool {
+	numVfs, err := GetSriovNumVfs(t.PfName)
+	if err != nil || numVfs != t.NumVfs {
+		return false
+	}
+	vfPciAddr, err := GetPciAddress(t.PfName, t.VFID)
+	return err == nil && vfPciAddr == pciAddr
+}
+
+func readCachedTopology(cacheDir, pciAddr string) (VFTopology, error) {
+	t := VFTopology{}
+	data, err := ioutil.ReadFile(filepath.Join(cacheDir, pciAddr))
+	if err != nil {
+		return t, err
+	}
+	if err := json.Unmarshal(data, &t); err != nil {
+		return t, fmt.Errorf("failed to parse cached topology of VF %s: %w", pciAddr, err)
+	}
+	return t, nil
+}
+
+// writeCachedTopology writes the topology through a temporary file so that concurrent invocations never read a
+// partially written topology
+func writeCachedTopology(cacheDir, pciAddr string, t VFTopology) error {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(cacheDir, 0700); err != nil {
+		return fmt.Errorf("failed to create topology cache directory %s: %w", cacheDir, err)
+	}
+	tmp, err := ioutil.TempFile(cacheDir, "."+pciAddr+"-")
+	if err != nil {
+		return fmt.Errorf("failed to cache topology of VF %s: %w", pciAddr, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to cache topology of VF %s: %w", pciAddr, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to cache topology of VF %s: %w", pciAddr, err)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(cacheDir, pciAddr))
+}
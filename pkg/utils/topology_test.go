@@ -0,0 +1,117 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+)
+
+var _ = Describe("Topology", func() {
+	var cacheDir string
+
+	BeforeEach(func() {
+		var err error
+		cacheDir, err = ioutil.TempDir("", "ib-sriov-cni-topology")
+		Expect(err).NotTo(HaveOccurred())
+		topologyCache.entries = map[string]VFTopology{}
+	})
+
+	AfterEach(func() {
+		Expect(os.RemoveAll(cacheDir)).To(Succeed())
+	})
+
+	Context("Checking ResolveVFTopology function", func() {
+		It("Assuming existing VF", func() {
+			pfName, vfID, err := ResolveVFTopology("0000:af:06.1", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pfName).To(Equal("ib0"))
+			Expect(vfID).To(Equal(1))
+			Expect(topologyCache.entries).To(HaveKeyWithValue("0000:af:06.1", VFTopology{PfName: "ib0", VFID: 1, NumVfs: 2}))
+		})
+		It("Assuming not existing VF", func() {
+			_, _, err := ResolveVFTopology("0000:af:07.0", cacheDir, time.Minute)
+			Expect(err).To(HaveOccurred())
+			Expect(topologyCache.entries).To(BeEmpty())
+		})
+		It("Assuming topology cached on disk", func() {
+			_, _, err := ResolveVFTopology("0000:af:06.0", cacheDir, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			t, err := readCachedTopology(cacheDir, "0000:af:06.0")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(t.PfName).To(Equal("ib0"))
+			Expect(t.Expires).To(BeTemporally(">", time.Now()))
+
+			// the next invocation finds the topology on disk
+			topologyCache.entries = map[string]VFTopology{}
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "0000:af:06.0"),
+				[]byte(`{"pfName":"ib0","vfID":0,"numVfs":2,"expires":"2100-01-01T00:00:00Z"}`), 0600)).To(Succeed())
+			pfName, vfID, err := ResolveVFTopology("0000:af:06.0", cacheDir, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(pfName).To(Equal("ib0"))
+			Expect(vfID).To(Equal(0))
+			Expect(topologyCache.entries["0000:af:06.0"].Expires.Year()).To(Equal(2100))
+		})
+		It("Assuming expired topology cached on disk", func() {
+			Expect(ioutil.WriteFile(filepath.Join(cacheDir, "0000:af:06.0"),
+				[]byte(`{"pfName":"ib0","vfID":1,"numVfs":2,"expires":"2000-01-01T00:00:00Z"}`), 0600)).To(Succeed())
+			_, vfID, err := ResolveVFTopology("0000:af:06.0", cacheDir, time.Minute)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfID).To(Equal(0))
+		})
+		It("Assuming VFs of the PF recreated", func() {
+			// the VF index is stale
+			topologyCache.entries["0000:af:06.0"] = VFTopology{PfName: "ib0", VFID: 1, NumVfs: 2}
+			_, vfID, err := ResolveVFTopology("0000:af:06.0", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(vfID).To(Equal(0))
+
+			// the number of VFs changed
+			topologyCache.entries["0000:af:06.0"] = VFTopology{PfName: "ib0", VFID: 0, NumVfs: 4}
+			_, _, err = ResolveVFTopology("0000:af:06.0", "", 0)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(topologyCache.entries["0000:af:06.0"].NumVfs).To(Equal(2))
+		})
+	})
+})
+
+// benchmarkResolveVFTopology resolves the topology of a VF of the test sysfs, with cached false every resolution
+// walks the virtfn links of the PF
+func benchmarkResolveVFTopology(b *testing.B, cached bool) {
+	netDir, pciDir, ibDir := NetDirectory, SysBusPci, InfinibandDirectory
+	NetDirectory, SysBusPci, InfinibandDirectory = "/sys/class/net", "/sys/bus/pci/devices", "/sys/class/infiniband"
+	defer func() {
+		NetDirectory, SysBusPci, InfinibandDirectory = netDir, pciDir, ibDir
+	}()
+	if err := CreateTmpSysFs(); err != nil {
+		b.Fatal(err)
+	}
+	defer func() {
+		if err := RemoveTmpSysFs(); err != nil {
+			b.Fatal(err)
+		}
+	}()
+
+	topologyCache.entries = map[string]VFTopology{}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			topologyCache.entries = map[string]VFTopology{}
+		}
+		if _, _, err := ResolveVFTopology("0000:af:06.1", "", 0); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkResolveVFTopologyUncached(b *testing.B) {
+	benchmarkResolveVFTopology(b, false)
+}
+
+func BenchmarkResolveVFTopologyCached(b *testing.B) {
+	benchmarkResolveVFTopology(b, true)
+}
@@ -1,36 +1,94 @@
 package utils
 
 import (
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
+	"syscall"
 )
 
 var (
 	sriovConfigured = "/sriov_numvfs"
+	// sriovTotalVfs is the maximum number of VFs of a SR-IOV capable device
+	sriovTotalVfs = "sriov_totalvfs"
 	// NetDirectory sysfs net directory
 	NetDirectory = "/sys/class/net"
 	// SysBusPci is sysfs pci device directory
 	SysBusPci = "/sys/bus/pci/devices"
+	// InfinibandDirectory sysfs infiniband directory
+	InfinibandDirectory = "/sys/class/infiniband"
+	// pciAddress matches a PCI address with or without its domain
+	pciAddress = regexp.MustCompile(`^([0-9a-fA-F]{4}:)?[0-9a-fA-F]{2}:[0-9a-fA-F]{2}\.[0-7]$`)
+	// interfaceSysctl matches the sysctls of a single interface, the interface is given by the sysctl placeholder
+	interfaceSysctl = regexp.MustCompile(`^net\.ipv[46]\.(conf|neigh)\.<iface>\.[a-z0-9_]+$`)
+	// ErrGUIDMissing is returned when no guid is given for the interface
+	ErrGUIDMissing = errors.New("no guid found")
+	// ErrVFNotFound is returned when the VF is not found on the PF
+	ErrVFNotFound = errors.New("VF not found")
 )
 
+const (
+	// minimum and maximum pkey value, the most significant bit is the membership bit
+	minPKey = 0x0001
+	maxPKey = 0x7fff
+	// VFs port used for pkey configuration
+	ibPort = 1
+	// pkeyIdxNone unmaps a VF pkey table entry
+	pkeyIdxNone = "none"
+	// SysctlIfacePlaceholder is replaced with the container interface name in sysctl keys
+	SysctlIfacePlaceholder = "<iface>"
+)
+
+// GetSriovTotalVfs returns the maximum number of VFs the PF supports, it fails when the PF is not SR-IOV capable
+func GetSriovTotalVfs(ifName string) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(NetDirectory, ifName, "device", sriovTotalVfs))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the sriov_totalvfs of device %q: %w", ifName, err)
+	}
+	totalVfs, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse the sriov_totalvfs of device %q: %w", ifName, err)
+	}
+	return totalVfs, nil
+}
+
+// ListSriovPFs returns the names of the SR-IOV capable netdevices sorted by name
+func ListSriovPFs() ([]string, error) {
+	entries, err := ioutil.ReadDir(NetDirectory)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", NetDirectory, err)
+	}
+	var pfs []string
+	for _, e := range entries {
+		if _, err := os.Stat(filepath.Join(NetDirectory, e.Name(), "device", sriovTotalVfs)); err == nil {
+			pfs = append(pfs, e.Name())
+		}
+	}
+	sort.Strings(pfs)
+	return pfs, nil
+}
+
 // GetSriovNumVfs takes in a PF name(ifName) as string and returns number of VF configured as int
 func GetSriovNumVfs(ifName string) (int, error) {
 	var vfTotal int
 
 	sriovFile := filepath.Join(NetDirectory, ifName, "device", sriovConfigured)
 	if _, err := os.Lstat(sriovFile); err != nil {
-		return vfTotal, fmt.Errorf("failed to open the sriov_numfs of device %q: %v", ifName, err)
+		return vfTotal, fmt.Errorf("failed to open the sriov_numfs of device %q: %w", ifName, err)
 	}
 
 	data, err := ioutil.ReadFile(sriovFile)
 	if err != nil {
-		return vfTotal, fmt.Errorf("failed to read the sriov_numfs of device %q: %v", ifName, err)
+		return vfTotal, fmt.Errorf("failed to read the sriov_numfs of device %q: %w", ifName, err)
 	}
 
 	if len(data) == 0 {
@@ -40,12 +98,37 @@ func GetSriovNumVfs(ifName string) (int, error) {
 	sriovNumfs := strings.TrimSpace(string(data))
 	vfTotal, err = strconv.Atoi(sriovNumfs)
 	if err != nil {
-		return vfTotal, fmt.Errorf("failed to convert sriov_numfs(byte value) to int of device %q: %v", ifName, err)
+		return vfTotal, fmt.Errorf("failed to convert sriov_numfs(byte value) to int of device %q: %w", ifName, err)
 	}
 
 	return vfTotal, nil
 }
 
+// GetLinkSpeed returns the link speed of a net device in Mbps, the speed is -1 when it is unknown
+func GetLinkSpeed(ifName string) (int, error) {
+	speedFile := filepath.Join(NetDirectory, ifName, "speed")
+	data, err := ioutil.ReadFile(speedFile)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the speed of device %q: %w", ifName, err)
+	}
+
+	speed, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert the speed of device %q to int: %w", ifName, err)
+	}
+
+	return speed, nil
+}
+
+// ValidateVfIndex checks that the VF index is within numVfs, the number of VFs configured for the PF
+func ValidateVfIndex(pfName string, vfID, numVfs int) error {
+	if vfID < 0 || vfID >= numVfs {
+		return fmt.Errorf("VF index %d is out of range, PF %s has %d VFs configured", vfID, pfName, numVfs)
+	}
+
+	return nil
+}
+
 // GetVfid takes in VF's PCI address(addr) and pfName as string and returns VF's ID as int
 func GetVfid(addr string, pfName string) (int, error) {
 	var id int
@@ -68,7 +151,7 @@ func GetVfid(addr string, pfName string) (int, error) {
 			return vf, nil
 		}
 	}
-	return id, fmt.Errorf("unable to get VF ID with PF: %s and VF pci address %v", pfName, addr)
+	return id, fmt.Errorf("unable to get VF ID with PF: %s and VF pci address %v: %w", pfName, addr, ErrVFNotFound)
 }
 
 // GetPfName returns PF net device name of a given VF pci address
@@ -91,13 +174,35 @@ func GetPfName(vf string) (string, error) {
 	return strings.TrimSpace(files[0].Name()), nil
 }
 
+// GetPfPciAddress returns the pci address of the PF net device
+func GetPfPciAddress(pfName string) (string, error) {
+	pciinfo, err := os.Readlink(filepath.Join(NetDirectory, pfName, "device"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read the pci device of %q: %w", pfName, err)
+	}
+	return filepath.Base(pciinfo), nil
+}
+
+// NormalizePciAddress returns the PCI address in the sysfs format, lower case and with its domain, and false when
+// the value is not a PCI address
+func NormalizePciAddress(s string) (string, bool) {
+	if !pciAddress.MatchString(s) {
+		return "", false
+	}
+	s = strings.ToLower(s)
+	if strings.Count(s, ":") == 1 {
+		s = "0000:" + s
+	}
+	return s, true
+}
+
 // GetPciAddress takes in a interface(ifName) and VF id and returns returns its pci addr as string
 func GetPciAddress(ifName string, vf int) (string, error) {
 	var pciaddr string
 	vfDir := filepath.Join(NetDirectory, ifName, "device", fmt.Sprintf("virtfn%d", vf))
 	dirInfo, err := os.Lstat(vfDir)
 	if err != nil {
-		return pciaddr, fmt.Errorf("can't get the symbolic link of virtfn%d dir of the device %q: %v", vf, ifName, err)
+		return pciaddr, fmt.Errorf("can't get the symbolic link of virtfn%d dir of the device %q: %w", vf, ifName, err)
 	}
 
 	if (dirInfo.Mode() & os.ModeSymlink) == 0 {
@@ -106,7 +211,7 @@ func GetPciAddress(ifName string, vf int) (string, error) {
 
 	pciinfo, err := os.Readlink(vfDir)
 	if err != nil {
-		return pciaddr, fmt.Errorf("can't read the symbolic link of virtfn%d dir of the device %q: %v", vf, ifName, err)
+		return pciaddr, fmt.Errorf("can't read the symbolic link of virtfn%d dir of the device %q: %w", vf, ifName, err)
 	}
 
 	pciaddr = filepath.Base(pciinfo)
@@ -123,7 +228,7 @@ func GetVFLinkNames(pciAddr string) (string, error) {
 
 	fInfos, err := ioutil.ReadDir(vfDir)
 	if err != nil {
-		return "", fmt.Errorf("failed to read net dir of the device %s: %v", pciAddr, err)
+		return "", fmt.Errorf("failed to read net dir of the device %s: %w", pciAddr, err)
 	}
 
 	if len(fInfos) == 0 {
@@ -138,6 +243,47 @@ func GetVFLinkNames(pciAddr string) (string, error) {
 	return names[0], nil
 }
 
+// VFNameFromPciAddress returns a deterministic VF network interface name derived from its PCI address
+func VFNameFromPciAddress(pciAddr string) string {
+	return "ib" + strings.NewReplacer(":", "", ".", "").Replace(pciAddr)
+}
+
+// RenderVFName returns a VF network interface name from a template, substituting the {pf}, {vf} and {pci} tokens
+// with the PF name, the VF index and the VF PCI address without separators
+func RenderVFName(template, pfName string, vfID int, pciAddr string) string {
+	return strings.NewReplacer(
+		"{pf}", pfName,
+		"{vf}", strconv.Itoa(vfID),
+		"{pci}", strings.NewReplacer(":", "", ".", "").Replace(pciAddr),
+	).Replace(template)
+}
+
+// RenderIfAlias returns an interface alias from a template, substituting the {containerID}, {podUID} and {guid}
+// tokens with the container ID, the Pod UID and the VF GUID
+func RenderIfAlias(template, containerID, podUID, guid string) string {
+	return strings.NewReplacer(
+		"{containerID}", containerID,
+		"{podUID}", podUID,
+		"{guid}", guid,
+	).Replace(template)
+}
+
+// ValidateInterfaceSysctl validates that a sysctl key is scoped to the container interface, only the
+// net.ipv4 and net.ipv6 conf and neigh sysctls of the <iface> placeholder are allowed
+func ValidateInterfaceSysctl(key string) error {
+	if !interfaceSysctl.MatchString(key) {
+		return fmt.Errorf("sysctl %q is not allowed, only net.ipv4.conf, net.ipv6.conf, net.ipv4.neigh and "+
+			"net.ipv6.neigh sysctls of the %s interface are allowed e.g. net.ipv4.conf.%s.arp_ignore",
+			key, SysctlIfacePlaceholder, SysctlIfacePlaceholder)
+	}
+	return nil
+}
+
+// RenderInterfaceSysctl returns the sysctl path of a sysctl key for the given interface
+func RenderInterfaceSysctl(key, ifName string) string {
+	return strings.Replace(strings.Replace(key, ".", "/", -1), SysctlIfacePlaceholder, ifName, 1)
+}
+
 // GetVFLinkNamesFromVFID returns VF's network interface name given it's PF name as string and VF id as int
 func GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, error) {
 	var names []string
@@ -148,7 +294,7 @@ func GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, error) {
 
 	fInfos, err := ioutil.ReadDir(vfDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read the virtfn%d dir of the device %q: %v", vfID, pfName, err)
+		return nil, fmt.Errorf("failed to read the virtfn%d dir of the device %q: %w", vfID, pfName, err)
 	}
 
 	names = make([]string, 0)
@@ -159,54 +305,178 @@ func GetVFLinkNamesFromVFID(pfName string, vfID int) ([]string, error) {
 	return names, nil
 }
 
+// GetNetnsID returns a stable identifier of the network namespace bind mounted at the given path, the identifier
+// changes when the path is recycled for a new network namespace
+func GetNetnsID(path string) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(path, &st); err != nil {
+		return "", fmt.Errorf("failed to stat netns %s: %w", path, err)
+	}
+	return netnsID(&st), nil
+}
+
+// GetNetnsIDFromFd returns the identifier of an open network namespace, see GetNetnsID
+func GetNetnsIDFromFd(fd uintptr) (string, error) {
+	var st syscall.Stat_t
+	if err := syscall.Fstat(int(fd), &st); err != nil {
+		return "", fmt.Errorf("failed to stat netns fd %d: %w", fd, err)
+	}
+	return netnsID(&st), nil
+}
+
+func netnsID(st *syscall.Stat_t) string {
+	return fmt.Sprintf("%d:%d", st.Dev, st.Ino)
+}
+
 // SaveNetConf takes in container ID, data dir and Pod interface name as string and a json encoded struct Conf
 // and save this Conf in data dir
 func SaveNetConf(cid, dataDir, podIfName string, conf interface{}) error {
+	_, err := SaveNetConfPolicy(cid, dataDir, podIfName, conf, nil, false)
+	return err
+}
+
+// CacheConflict is the cached NetConf SaveNetConfPolicy found for the attachment
+type CacheConflict struct {
+	Path string
+	// Changes are the top level fields the saved NetConf changed, sorted by field, as "<field>: <old> -> <new>"
+	Changes []string
+}
+
+// SaveNetConfPolicy is SaveNetConf reporting a NetConf already cached for the attachment, which is overwritten. The
+// mergeFields of the cached NetConf are kept when the saved NetConf doesn't set them. The returned conflict is nil
+// when no NetConf was cached. The NetConf JSON is written gzip compressed when compact is set.
+func SaveNetConfPolicy(cid, dataDir, podIfName string, conf interface{}, mergeFields []string, compact bool) (*CacheConflict, error) {
 	netConfBytes, err := json.Marshal(conf)
 	if err != nil {
-		return fmt.Errorf("error serializing delegate netconf: %v", err)
+		return nil, fmt.Errorf("error serializing delegate netconf: %w", err)
 	}
 
 	s := []string{cid, podIfName}
 	cRef := strings.Join(s, "-")
 
+	var conflict *CacheConflict
+	path := filepath.Join(dataDir, cRef)
+	if cached, err := ioutil.ReadFile(path); err == nil {
+		// a cached NetConf which can't be decoded is reported as not valid JSON
+		if decoded, err := decodeNetConf(cached); err == nil {
+			cached = decoded
+		}
+		if len(mergeFields) > 0 {
+			netConfBytes = mergeNetConf(cached, netConfBytes, mergeFields)
+		}
+		conflict = &CacheConflict{Path: path, Changes: netConfChanges(cached, netConfBytes)}
+	}
+
+	data, err := encodeNetConf(netConfBytes, compact)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing delegate netconf: %w", err)
+	}
+
 	// save the rendered netconf for cmdDel
-	if err = saveScratchNetConf(cRef, dataDir, netConfBytes); err != nil {
-		return err
+	if err = saveScratchNetConf(cRef, dataDir, data); err != nil {
+		return nil, err
 	}
 
-	return nil
+	return conflict, nil
+}
+
+// mergeNetConf returns the saved NetConf with the top level mergeFields of the cached NetConf it doesn't set, the saved
+// NetConf is returned as is when the cached one is not a JSON object
+func mergeNetConf(cached, saved []byte, mergeFields []string) []byte {
+	cachedFields := map[string]json.RawMessage{}
+	fields := map[string]json.RawMessage{}
+	if json.Unmarshal(cached, &cachedFields) != nil || json.Unmarshal(saved, &fields) != nil {
+		return saved
+	}
+	for _, name := range mergeFields {
+		if _, ok := fields[name]; !ok {
+			if value, ok := cachedFields[name]; ok {
+				fields[name] = value
+			}
+		}
+	}
+	merged, err := json.Marshal(fields)
+	if err != nil {
+		return saved
+	}
+	return merged
+}
+
+// netConfChanges returns the top level fields which differ between the cached and the saved NetConf
+func netConfChanges(cached, saved []byte) []string {
+	oldFields := map[string]json.RawMessage{}
+	newFields := map[string]json.RawMessage{}
+	if json.Unmarshal(cached, &oldFields) != nil {
+		return []string{"the cached NetConf is not valid JSON"}
+	}
+	if json.Unmarshal(saved, &newFields) != nil {
+		return nil
+	}
+
+	var changes []string
+	for name, value := range newFields {
+		if old, ok := oldFields[name]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: unset -> %s", name, value))
+		} else if string(old) != string(value) {
+			changes = append(changes, fmt.Sprintf("%s: %s -> %s", name, old, value))
+		}
+	}
+	for name, old := range oldFields {
+		if _, ok := newFields[name]; !ok {
+			changes = append(changes, fmt.Sprintf("%s: %s -> unset", name, old))
+		}
+	}
+	sort.Strings(changes)
+	return changes
 }
 
 func saveScratchNetConf(containerID, dataDir string, netconf []byte) error {
 	if err := os.MkdirAll(dataDir, 0700); err != nil {
-		return fmt.Errorf("failed to create the sriov data directory(%q): %v", dataDir, err)
+		return fmt.Errorf("failed to create the sriov data directory(%q): %w", dataDir, err)
 	}
 
 	path := filepath.Join(dataDir, containerID)
 
-	err := ioutil.WriteFile(path, netconf, 0600)
+	// write to a temporary file and rename it so readers never see a partially written file
+	tmpFile, err := ioutil.TempFile(dataDir, containerID+".tmp")
 	if err != nil {
-		return fmt.Errorf("failed to write container data in the path(%q): %v", path, err)
+		return fmt.Errorf("failed to create temporary file for container data in the path(%q): %w", path, err)
 	}
+	tmpPath := tmpFile.Name()
+	defer os.Remove(tmpPath)
 
-	return err
+	if _, err = tmpFile.Write(netconf); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write container data in the path(%q): %w", tmpPath, err)
+	}
+
+	if err = os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write container data in the path(%q): %w", path, err)
+	}
+
+	return nil
 }
 
-// ReadScratchNetConf takes in container ID, Pod interface name and data dir as string and returns a pointer to Conf
+// ReadScratchNetConf takes in container ID, Pod interface name and data dir as string and returns a pointer to Conf.
+// The NetConf JSON is returned whatever the cache format it was written in.
 func ReadScratchNetConf(cRefPath string) ([]byte, error) {
 	data, err := ioutil.ReadFile(cRefPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read container data in the path(%q): %v", cRefPath, err)
+		return nil, fmt.Errorf("failed to read container data in the path(%q): %w", cRefPath, err)
 	}
 
-	return data, err
+	return decodeNetConf(data)
 }
 
 // CleanCachedNetConf removed cached NetConf from disk
 func CleanCachedNetConf(cRefPath string) error {
 	if err := os.Remove(cRefPath); err != nil {
-		return fmt.Errorf("error removing NetConf file %s: %q", cRefPath, err)
+		return fmt.Errorf("error removing NetConf file %s: %w", cRefPath, err)
 	}
 	return nil
 }
@@ -225,3 +495,342 @@ func IsValidGUID(guid string) bool {
 func IsAllZeroGUID(guid string) bool {
 	return guid == "00:00:00:00:00:00:00:00"
 }
+
+// ParseAndNormalizeGUID parses a GUID given as colon separated hex, dash separated hex or bare 16 hex digits
+// and returns it as an 8 bytes hardware address
+func ParseAndNormalizeGUID(guid string) (net.HardwareAddr, error) {
+	s := strings.TrimSpace(guid)
+	switch {
+	case len(s) == 23 && isSeparatedHex(s, ':'):
+		s = strings.Replace(s, ":", "", -1)
+	case len(s) == 23 && isSeparatedHex(s, '-'):
+		s = strings.Replace(s, "-", "", -1)
+	case len(s) != 16:
+		return nil, fmt.Errorf("invalid guid %q: expected 8 bytes in colon separated, dash separated or bare hex form", guid)
+	}
+
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 8 {
+		return nil, fmt.Errorf("invalid guid %q: not a hex value", guid)
+	}
+
+	return net.HardwareAddr(b), nil
+}
+
+// isSeparatedHex tells whether the bytes of s are separated by sep, a separator is every third character and only there
+func isSeparatedHex(s string, sep byte) bool {
+	for i := 0; i < len(s); i++ {
+		if (i%3 == 2) != (s[i] == sep) {
+			return false
+		}
+	}
+	return true
+}
+
+// IPoIBAddressFromGUID returns the 20 bytes IPoIB hardware address derived from a GUID: 4 zero bytes in place of the
+// flags and QPN, which the driver keeps when the address is set, the fe:80:00:00:00:00:00:00 link-local subnet
+// prefix and the 8 bytes of the GUID, e.g. 01:23:45:67:89:ab:cd:ef gives
+// 00:00:00:00:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef
+func IPoIBAddressFromGUID(guid string) (net.HardwareAddr, error) {
+	b, err := ParseAndNormalizeGUID(guid)
+	if err != nil {
+		return nil, err
+	}
+	hwaddr := net.HardwareAddr{0, 0, 0, 0, 0xfe, 0x80, 0, 0, 0, 0, 0, 0}
+	return append(hwaddr, b...), nil
+}
+
+// GUIDForInterface selects the GUID of a Pod interface from the guid cni-arg. The arg is either a single GUID,
+// a comma separated list keyed by interface name (e.g. "net1=<guid>,net2=<guid>") or a comma separated list
+// indexed by the interface ordinal taken from the interface name suffix (e.g. net1 is the first GUID)
+func GUIDForInterface(guids, ifName string) (string, error) {
+	if !strings.Contains(guids, ",") && !strings.Contains(guids, "=") {
+		return strings.TrimSpace(guids), nil
+	}
+
+	entries := strings.Split(guids, ",")
+	if strings.Contains(guids, "=") {
+		for _, entry := range entries {
+			kv := strings.SplitN(entry, "=", 2)
+			if len(kv) != 2 {
+				return "", fmt.Errorf("invalid guid entry %q: expected <interface name>=<guid>", entry)
+			}
+			if strings.TrimSpace(kv[0]) == ifName {
+				return strings.TrimSpace(kv[1]), nil
+			}
+		}
+		return "", fmt.Errorf("%w for interface %s in %q", ErrGUIDMissing, ifName, guids)
+	}
+
+	ordinal, err := strconv.Atoi(strings.TrimLeft(ifName, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ_-."))
+	if err != nil || ordinal < 1 {
+		return "", fmt.Errorf("failed to get the ordinal of interface %s to select one of %d guids", ifName, len(entries))
+	}
+	if ordinal > len(entries) {
+		return "", fmt.Errorf("%w for interface %s, it requires at least %d guids, got %d guids", ErrGUIDMissing, ifName, ordinal,
+			len(entries))
+	}
+
+	return strings.TrimSpace(entries[ordinal-1]), nil
+}
+
+// ParseCNIArgs parses the CNI_ARGS environment variable format, semicolon separated KEY=VALUE pairs.
+// Values may contain '=' e.g. a guid list keyed by interface name.
+func ParseCNIArgs(args string) (map[string]string, error) {
+	result := map[string]string{}
+	for _, pair := range strings.Split(args, ";") {
+		if pair == "" {
+			continue
+		}
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("invalid CNI_ARGS pair %q, expected KEY=VALUE", pair)
+		}
+		result[kv[0]] = kv[1]
+	}
+	return result, nil
+}
+
+// NormalizePKey parses a pkey given in decimal or 0x prefixed hex and returns it in 0x prefixed hex form
+func NormalizePKey(pkey string) (string, error) {
+	s := strings.TrimSpace(pkey)
+	base := 10
+	if strings.HasPrefix(s, "0x") || strings.HasPrefix(s, "0X") {
+		s = s[2:]
+		base = 16
+	}
+
+	value, err := strconv.ParseUint(s, base, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid pkey %q: %w", pkey, err)
+	}
+
+	if value < minPKey || value > maxPKey {
+		return "", fmt.Errorf("invalid pkey %q: must be in range 0x%04x-0x%04x", pkey, minPKey, maxPKey)
+	}
+
+	return fmt.Sprintf("0x%04x", value), nil
+}
+
+// GetIBDevName returns the InfiniBand device name of a given PF net device
+func GetIBDevName(pfName string) (string, error) {
+	ibDir := filepath.Join(NetDirectory, pfName, "device", "infiniband")
+	fInfos, err := ioutil.ReadDir(ibDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read infiniband dir of the device %q: %w", pfName, err)
+	}
+
+	if len(fInfos) == 0 {
+		return "", fmt.Errorf("no infiniband device found for the device %q", pfName)
+	}
+
+	return fInfos[0].Name(), nil
+}
+
+// IsSwitchdev checks if the PF eswitch is in switchdev mode, in legacy mode the PF has no switch id
+func IsSwitchdev(pfName string) bool {
+	switchID, err := ioutil.ReadFile(filepath.Join(NetDirectory, pfName, "phys_switch_id"))
+	return err == nil && strings.TrimSpace(string(switchID)) != ""
+}
+
+// GetVfRepresentor returns the representor net device name of a VF of a PF in switchdev mode
+func GetVfRepresentor(pfName string, vfID int) (string, error) {
+	pfSwitchID, err := ioutil.ReadFile(filepath.Join(NetDirectory, pfName, "phys_switch_id"))
+	if err != nil || strings.TrimSpace(string(pfSwitchID)) == "" {
+		return "", fmt.Errorf("failed to get switch id of the device %q, is it in switchdev mode: %w", pfName, err)
+	}
+
+	fInfos, err := ioutil.ReadDir(NetDirectory)
+	if err != nil {
+		return "", fmt.Errorf("failed to read net directory %s: %w", NetDirectory, err)
+	}
+
+	portName := regexp.MustCompile(fmt.Sprintf(`^pf\d+vf%d$`, vfID))
+	for _, f := range fInfos {
+		if f.Name() == pfName {
+			continue
+		}
+		switchID, err := ioutil.ReadFile(filepath.Join(NetDirectory, f.Name(), "phys_switch_id"))
+		if err != nil || strings.TrimSpace(string(switchID)) != strings.TrimSpace(string(pfSwitchID)) {
+			continue
+		}
+		physPortName, err := ioutil.ReadFile(filepath.Join(NetDirectory, f.Name(), "phys_port_name"))
+		if err == nil && portName.MatchString(strings.TrimSpace(string(physPortName))) {
+			return f.Name(), nil
+		}
+	}
+
+	return "", fmt.Errorf("no representor found for VF %d of the device %q", vfID, pfName)
+}
+
+// GetRdmaDeviceName returns the RDMA device name of a given PCI address
+func GetRdmaDeviceName(pciAddr string) (string, error) {
+	rdmaDir := filepath.Join(SysBusPci, pciAddr, "infiniband")
+	fInfos, err := ioutil.ReadDir(rdmaDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read infiniband dir of the device %s: %w", pciAddr, err)
+	}
+
+	if len(fInfos) == 0 {
+		return "", fmt.Errorf("no RDMA device found for the device %s", pciAddr)
+	}
+
+	return fInfos[0].Name(), nil
+}
+
+// SetVfGUIDSysfs sets the VF node and port GUID through the sriov sysfs directory of the PF, which the mlx5 driver
+// exposes on kernels without the netlink VF GUID attributes
+func SetVfGUIDSysfs(pfName string, vfID int, guid string) error {
+	vfDir := filepath.Join(NetDirectory, pfName, "device", "sriov", strconv.Itoa(vfID))
+	for _, file := range []string{"node", "port"} {
+		if err := ioutil.WriteFile(filepath.Join(vfDir, file), []byte(guid), 0644); err != nil {
+			return fmt.Errorf("failed to set VF %d %s guid of PF %s to %s: %w", vfID, file, pfName, guid, err)
+		}
+	}
+	return nil
+}
+
+// GetIPoIBMode returns the IPoIB mode of the network device, datagram or connected
+func GetIPoIBMode(ifName string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(NetDirectory, ifName, "mode"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read IPoIB mode of %s: %w", ifName, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// SetIPoIBMode sets the IPoIB mode of the network device, the device has to be down
+func SetIPoIBMode(ifName, mode string) error {
+	if err := ioutil.WriteFile(filepath.Join(NetDirectory, ifName, "mode"), []byte(mode), 0644); err != nil {
+		return fmt.Errorf("failed to set IPoIB mode of %s to %s: %w", ifName, mode, err)
+	}
+	return nil
+}
+
+// IsVfPKeyConfigurable checks if the PF exposes VFs pkey configuration through sysfs
+func IsVfPKeyConfigurable(pfName, vfPciAddress string) bool {
+	ibDev, err := GetIBDevName(pfName)
+	if err != nil {
+		return false
+	}
+
+	_, err = os.Stat(vfPKeyIdxDir(ibDev, vfPciAddress))
+	return err == nil
+}
+
+// SetVfPKey sets the VF pkey to the PF pkey table entry matching the given pkey
+func SetVfPKey(pfName, vfPciAddress, pkey string) error {
+	ibDev, err := GetIBDevName(pfName)
+	if err != nil {
+		return err
+	}
+
+	idx, err := getPKeyIndex(ibDev, pkey)
+	if err != nil {
+		return err
+	}
+
+	return setVfPKeyIndex(ibDev, vfPciAddress, 0, strconv.Itoa(idx))
+}
+
+// ResetVfPKey maps the first entry of the VF pkey table back to the PF pkey table index idx, to the default partition
+// which is the first entry in the PF pkey table when idx is empty
+func ResetVfPKey(pfName, vfPciAddress, idx string) error {
+	ibDev, err := GetIBDevName(pfName)
+	if err != nil {
+		return err
+	}
+
+	if idx == "" {
+		idx = "0"
+	}
+	return setVfPKeyIndex(ibDev, vfPciAddress, 0, idx)
+}
+
+// GetVfPKeyEntry returns the PF pkey table index the entry of the VF pkey table is mapped to, "none" when the entry is
+// not mapped. It fails when the VF pkey table has no such entry.
+func GetVfPKeyEntry(pfName, vfPciAddress string, entry int) (string, error) {
+	ibDev, err := GetIBDevName(pfName)
+	if err != nil {
+		return "", err
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(vfPKeyIdxDir(ibDev, vfPciAddress), strconv.Itoa(entry)))
+	if err != nil {
+		return "", fmt.Errorf("failed to read pkey table entry %d of VF %s: %w", entry, vfPciAddress, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// IsVfPKeyEntryFree returns whether the PF pkey table index read from a VF pkey table entry leaves the entry unmapped
+func IsVfPKeyEntryFree(idx string) bool {
+	return idx == pkeyIdxNone
+}
+
+// SetVfPKeyEntry maps the entry of the VF pkey table to the PF pkey table entry matching the given pkey, which
+// makes the VF a member of the partition in addition to the partition of its first entry
+func SetVfPKeyEntry(pfName, vfPciAddress string, entry int, pkey string) error {
+	ibDev, err := GetIBDevName(pfName)
+	if err != nil {
+		return err
+	}
+
+	idx, err := getPKeyIndex(ibDev, pkey)
+	if err != nil {
+		return err
+	}
+
+	return setVfPKeyIndex(ibDev, vfPciAddress, entry, strconv.Itoa(idx))
+}
+
+// ClearVfPKeyEntry unmaps the entry of the VF pkey table, removing the VF from its partition
+func ClearVfPKeyEntry(pfName, vfPciAddress string, entry int) error {
+	ibDev, err := GetIBDevName(pfName)
+	if err != nil {
+		return err
+	}
+
+	return setVfPKeyIndex(ibDev, vfPciAddress, entry, pkeyIdxNone)
+}
+
+func vfPKeyIdxDir(ibDev, vfPciAddress string) string {
+	return filepath.Join(InfinibandDirectory, ibDev, "iov", vfPciAddress, "ports", strconv.Itoa(ibPort), "pkey_idx")
+}
+
+func getPKeyIndex(ibDev, pkey string) (int, error) {
+	want, err := strconv.ParseUint(strings.TrimPrefix(pkey, "0x"), 16, 16)
+	if err != nil {
+		return 0, fmt.Errorf("invalid pkey %q: %w", pkey, err)
+	}
+
+	pkeysDir := filepath.Join(InfinibandDirectory, ibDev, "ports", strconv.Itoa(ibPort), "pkeys")
+	fInfos, err := ioutil.ReadDir(pkeysDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pkeys of the device %q: %w", ibDev, err)
+	}
+
+	for _, f := range fInfos {
+		data, err := ioutil.ReadFile(filepath.Join(pkeysDir, f.Name()))
+		if err != nil {
+			continue
+		}
+		value, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimSpace(string(data)), "0x"), 16, 16)
+		if err != nil {
+			continue
+		}
+		// ignore the membership bit
+		if value&maxPKey == want {
+			return strconv.Atoi(f.Name())
+		}
+	}
+
+	return 0, fmt.Errorf("pkey %s not found in the pkey table of the device %q", pkey, ibDev)
+}
+
+func setVfPKeyIndex(ibDev, vfPciAddress string, entry int, idx string) error {
+	pkeyIdxFile := filepath.Join(vfPKeyIdxDir(ibDev, vfPciAddress), strconv.Itoa(entry))
+	if err := ioutil.WriteFile(pkeyIdxFile, []byte(idx), 0644); err != nil {
+		return fmt.Errorf("failed to set pkey index %s of entry %d for VF %s: %w", idx, entry, vfPciAddress, err)
+	}
+	return nil
+}
@@ -1,7 +1,13 @@
 package utils
 
 import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/ginkgo/extensions/table"
 	. "github.com/onsi/gomega"
 )
 
@@ -18,6 +24,108 @@ var _ = Describe("Utils", func() {
 			Expect(err).To(HaveOccurred(), "Not existing sriov interface should return an error")
 		})
 	})
+	Context("Checking GetSriovTotalVfs function", func() {
+		It("Assuming SR-IOV capable interface", func() {
+			Expect(GetSriovTotalVfs("ib0")).To(Equal(8))
+		})
+		It("Assuming interface without SR-IOV capability", func() {
+			_, err := GetSriovTotalVfs("ib3")
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+	})
+	Context("Checking ListSriovPFs function", func() {
+		It("Assuming SR-IOV capable interfaces", func() {
+			Expect(ListSriovPFs()).To(Equal([]string{"ib0"}))
+		})
+	})
+	Context("Checking GetPfPciAddress function", func() {
+		It("Assuming existing interface", func() {
+			Expect(GetPfPciAddress("ib0")).To(Equal("0000:af:00.1"))
+		})
+		It("Assuming not existing interface", func() {
+			_, err := GetPfPciAddress("enp175s0f2")
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+	})
+	Context("Checking SetVfGUIDSysfs function", func() {
+		It("Assuming PF exposing the VF GUIDs", func() {
+			Expect(SetVfGUIDSysfs("ib0", 0, "01:23:45:67:89:ab:cd:ef")).To(Succeed())
+			for _, file := range []string{"node", "port"} {
+				data, err := ioutil.ReadFile(filepath.Join(NetDirectory, "ib0", "device", "sriov", "0", file))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(data)).To(Equal("01:23:45:67:89:ab:cd:ef"))
+			}
+		})
+		It("Assuming PF not exposing the VF GUIDs", func() {
+			err := SetVfGUIDSysfs("ib0", 1, "01:23:45:67:89:ab:cd:ef")
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+	})
+	Context("Checking IPoIB mode functions", func() {
+		It("Assuming IPoIB interface", func() {
+			Expect(GetIPoIBMode("ib1")).To(Equal("datagram"))
+			Expect(SetIPoIBMode("ib1", "connected")).To(Succeed())
+			Expect(GetIPoIBMode("ib1")).To(Equal("connected"))
+		})
+		It("Assuming not IPoIB interface", func() {
+			_, err := GetIPoIBMode("ib2")
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+	})
+	Context("Checking ParseCNIArgs function", func() {
+		It("Assuming valid CNI_ARGS", func() {
+			args, err := ParseCNIArgs("IgnoreUnknown=1;guid=net1=01:23:45:67:89:ab:cd:ef,net2=01:23:45:67:89:ab:cd:ee;")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(args).To(Equal(map[string]string{
+				"IgnoreUnknown": "1",
+				"guid":          "net1=01:23:45:67:89:ab:cd:ef,net2=01:23:45:67:89:ab:cd:ee",
+			}))
+		})
+		It("Assuming empty CNI_ARGS", func() {
+			args, err := ParseCNIArgs("")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(args).To(BeEmpty())
+		})
+		It("Assuming pair without value", func() {
+			_, err := ParseCNIArgs("IgnoreUnknown=1;guid")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking ValidateInterfaceSysctl function", func() {
+		It("Assuming interface sysctls", func() {
+			Expect(ValidateInterfaceSysctl("net.ipv4.conf.<iface>.arp_ignore")).To(Succeed())
+			Expect(ValidateInterfaceSysctl("net.ipv6.conf.<iface>.accept_ra")).To(Succeed())
+			Expect(ValidateInterfaceSysctl("net.ipv4.neigh.<iface>.base_reachable_time_ms")).To(Succeed())
+		})
+		It("Assuming sysctls escaping the interface", func() {
+			Expect(ValidateInterfaceSysctl("net.ipv4.conf.all.arp_ignore")).NotTo(Succeed())
+			Expect(ValidateInterfaceSysctl("net.ipv4.ip_forward")).NotTo(Succeed())
+			Expect(ValidateInterfaceSysctl("net.ipv4.conf.<iface>/../all.arp_ignore")).NotTo(Succeed())
+			Expect(ValidateInterfaceSysctl("kernel.<iface>.panic")).NotTo(Succeed())
+		})
+		It("Assuming rendered sysctl path", func() {
+			Expect(RenderInterfaceSysctl("net.ipv4.conf.<iface>.arp_ignore", "net1")).To(Equal("net/ipv4/conf/net1/arp_ignore"))
+		})
+	})
+	Context("Checking ValidateVfIndex function", func() {
+		It("Assuming VF index within numvfs", func() {
+			Expect(ValidateVfIndex("ib0", 1, 2)).To(Succeed())
+		})
+		It("Assuming VF index equal to numvfs", func() {
+			err := ValidateVfIndex("ib0", 2, 2)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("VF index 2"))
+			Expect(err.Error()).To(ContainSubstring("has 2 VFs"))
+		})
+		It("Assuming VF index 0 on PF with numvfs 0", func() {
+			err := ValidateVfIndex("ib3", 0, 0)
+			Expect(err).To(HaveOccurred())
+			Expect(err.Error()).To(ContainSubstring("has 0 VFs"))
+		})
+		It("Assuming negative VF index", func() {
+			Expect(ValidateVfIndex("ib0", -1, 2)).NotTo(Succeed())
+		})
+	})
 	Context("Checking GetVfid function", func() {
 		It("Assuming existing interface", func() {
 			result, err := GetVfid("0000:af:06.0", "ib0")
@@ -27,6 +135,12 @@ var _ = Describe("Utils", func() {
 		It("Assuming not existing interface", func() {
 			_, err := GetVfid("0000:af:06.0", "enp175s0f2")
 			Expect(err).To(HaveOccurred(), "Not existing interface should return an error")
+			Expect(errors.Is(err, os.ErrNotExist)).To(BeTrue())
+		})
+		It("Assuming not existing vf", func() {
+			_, err := GetVfid("0000:af:07.0", "ib0")
+			Expect(err).To(HaveOccurred(), "Not existing VF should return an error")
+			Expect(errors.Is(err, ErrVFNotFound)).To(BeTrue())
 		})
 	})
 	Context("Checking GetPfName function", func() {
@@ -66,4 +180,338 @@ var _ = Describe("Utils", func() {
 			Expect(err).To(HaveOccurred(), "Not existing VF should return an error")
 		})
 	})
+	Context("Checking IsSwitchdev function", func() {
+		It("Assuming PF in switchdev mode", func() {
+			Expect(IsSwitchdev("ib3")).To(BeTrue())
+		})
+		It("Assuming PF in legacy mode", func() {
+			Expect(IsSwitchdev("ib0")).To(BeFalse())
+		})
+	})
+	Context("Checking GetVfRepresentor function", func() {
+		It("Assuming existing representor", func() {
+			Expect(GetVfRepresentor("ib3", 0)).To(Equal("pf0vf0"))
+		})
+		It("Assuming not existing representor", func() {
+			_, err := GetVfRepresentor("ib3", 1)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming PF in legacy mode", func() {
+			_, err := GetVfRepresentor("ib0", 0)
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking GetRdmaDeviceName function", func() {
+		It("Assuming existing vf with RDMA device", func() {
+			Expect(GetRdmaDeviceName("0000:af:06.0")).To(Equal("mlx5_2"))
+		})
+		It("Assuming existing vf without RDMA device", func() {
+			_, err := GetRdmaDeviceName("0000:af:06.1")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking VFNameFromPciAddress function", func() {
+		It("Assuming valid pci address", func() {
+			Expect(VFNameFromPciAddress("0000:af:06.0")).To(Equal("ib0000af060"))
+		})
+	})
+	Context("Checking RenderVFName function", func() {
+		It("Assuming template with all tokens", func() {
+			Expect(RenderVFName("ib{pf}_{vf}", "ib0", 1, "0000:af:06.1")).To(Equal("ibib0_1"))
+			Expect(RenderVFName("vf{pci}", "ib0", 1, "0000:af:06.1")).To(Equal("vf0000af061"))
+		})
+		It("Assuming template without tokens", func() {
+			Expect(RenderVFName("ibvf", "ib0", 1, "0000:af:06.1")).To(Equal("ibvf"))
+		})
+	})
+	Context("Checking NormalizePciAddress function", func() {
+		It("Assuming pci address with domain", func() {
+			pciAddr, ok := NormalizePciAddress("0000:AF:06.1")
+			Expect(ok).To(BeTrue())
+			Expect(pciAddr).To(Equal("0000:af:06.1"))
+		})
+		It("Assuming pci address without domain", func() {
+			pciAddr, ok := NormalizePciAddress("af:06.1")
+			Expect(ok).To(BeTrue())
+			Expect(pciAddr).To(Equal("0000:af:06.1"))
+		})
+		It("Assuming network device name", func() {
+			_, ok := NormalizePciAddress("ib0")
+			Expect(ok).To(BeFalse())
+		})
+		It("Assuming invalid function", func() {
+			_, ok := NormalizePciAddress("0000:af:06.8")
+			Expect(ok).To(BeFalse())
+		})
+	})
+	Context("Checking IPoIBAddressFromGUID function", func() {
+		It("Assuming valid guid", func() {
+			hwaddr, err := IPoIBAddressFromGUID("01:23:45:67:89:AB:CD:EF")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(hwaddr.String()).To(Equal("00:00:00:00:fe:80:00:00:00:00:00:00:01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming invalid guid", func() {
+			_, err := IPoIBAddressFromGUID("01:23:45:67")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking RenderIfAlias function", func() {
+		It("Assuming template with all tokens", func() {
+			Expect(RenderIfAlias("{podUID}/{containerID} {guid}", "dummycid", "a1b2", "01:23:45:67:89:ab:cd:ef")).
+				To(Equal("a1b2/dummycid 01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming template without tokens", func() {
+			Expect(RenderIfAlias("storage-net", "dummycid", "a1b2", "")).To(Equal("storage-net"))
+		})
+	})
+	Context("Checking ParseAndNormalizeGUID function", func() {
+		It("Assuming colon separated guid", func() {
+			guid, err := ParseAndNormalizeGUID("01:23:45:67:89:AB:CD:EF")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(guid.String()).To(Equal("01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming dash separated guid", func() {
+			guid, err := ParseAndNormalizeGUID("01-23-45-67-89-ab-cd-ef")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(guid.String()).To(Equal("01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming bare hex guid", func() {
+			guid, err := ParseAndNormalizeGUID("0123456789abcdef")
+			Expect(err).NotTo(HaveOccurred())
+			Expect(guid.String()).To(Equal("01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming mixed separators guid", func() {
+			_, err := ParseAndNormalizeGUID("01:23-45:67:89:ab:cd:ef")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming wrong length guid", func() {
+			_, err := ParseAndNormalizeGUID("01:23:45:67:89:ab:cd")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming non hex guid", func() {
+			_, err := ParseAndNormalizeGUID("0123456789abcdeg")
+			Expect(err).To(HaveOccurred())
+		})
+		DescribeTable("Assuming misplaced separators",
+			func(guid string) {
+				_, err := ParseAndNormalizeGUID(guid)
+				Expect(err).To(MatchError(ContainSubstring("invalid guid")))
+			},
+			Entry("three digits first byte", "012:34:56:78:9a:bc:de:f"),
+			Entry("single digit last byte", "01:23:45:67:89:ab:cde:f"),
+			Entry("separators in pairs", "01:23:45:67::89ab:cd:ef"),
+			Entry("dash separators misplaced", "0123-45-67-89-ab-cd-e-f"),
+		)
+	})
+	Context("Checking GUIDForInterface function", func() {
+		It("Assuming single guid", func() {
+			Expect(GUIDForInterface("01:23:45:67:89:ab:cd:ef", "net2")).To(Equal("01:23:45:67:89:ab:cd:ef"))
+		})
+		It("Assuming guids keyed by interface name", func() {
+			Expect(GUIDForInterface("net1=01:23:45:67:89:ab:cd:ef, net2=01:23:45:67:89:ab:cd:f0", "net2")).To(
+				Equal("01:23:45:67:89:ab:cd:f0"))
+		})
+		It("Assuming guids keyed by interface name without the interface", func() {
+			_, err := GUIDForInterface("net1=01:23:45:67:89:ab:cd:ef", "net2")
+			Expect(errors.Is(err, ErrGUIDMissing)).To(BeTrue())
+		})
+		It("Assuming guids indexed by interface ordinal", func() {
+			Expect(GUIDForInterface("01:23:45:67:89:ab:cd:ef,01:23:45:67:89:ab:cd:f0", "net2")).To(
+				Equal("01:23:45:67:89:ab:cd:f0"))
+		})
+		It("Assuming less guids than the interface ordinal", func() {
+			_, err := GUIDForInterface("01:23:45:67:89:ab:cd:ef,01:23:45:67:89:ab:cd:f0", "net3")
+			Expect(errors.Is(err, ErrGUIDMissing)).To(BeTrue())
+		})
+		It("Assuming guids list and interface name without ordinal", func() {
+			_, err := GUIDForInterface("01:23:45:67:89:ab:cd:ef,01:23:45:67:89:ab:cd:f0", "eth")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking GetLinkSpeed function", func() {
+		It("Assuming existing interface", func() {
+			Expect(GetLinkSpeed("ib0")).To(Equal(100000))
+		})
+		It("Assuming interface without speed", func() {
+			_, err := GetLinkSpeed("ib3")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking NormalizePKey function", func() {
+		It("Assuming hex pkey", func() {
+			Expect(NormalizePKey("0x7FFF")).To(Equal("0x7fff"))
+		})
+		It("Assuming decimal pkey", func() {
+			Expect(NormalizePKey("10")).To(Equal("0x000a"))
+		})
+		It("Assuming zero pkey", func() {
+			_, err := NormalizePKey("0x0")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming pkey with membership bit", func() {
+			_, err := NormalizePKey("0x8001")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming invalid pkey", func() {
+			_, err := NormalizePKey("pkey")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking SetVfPKey function", func() {
+		It("Assuming existing pkey", func() {
+			Expect(IsVfPKeyConfigurable("ib0", "0000:af:06.0")).To(BeTrue())
+			err := SetVfPKey("ib0", "0000:af:06.0", "0x0001")
+			Expect(err).NotTo(HaveOccurred())
+			data, err := ioutil.ReadFile(filepath.Join(vfPKeyIdxDir("mlx5_0", "0000:af:06.0"), "0"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("1"))
+
+			err = ResetVfPKey("ib0", "0000:af:06.0", "")
+			Expect(err).NotTo(HaveOccurred())
+			data, err = ioutil.ReadFile(filepath.Join(vfPKeyIdxDir("mlx5_0", "0000:af:06.0"), "0"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("0"))
+		})
+		It("Assuming pkey reset to the previous index", func() {
+			Expect(SetVfPKey("ib0", "0000:af:06.0", "0x0001")).To(Succeed())
+			Expect(GetVfPKeyEntry("ib0", "0000:af:06.0", 0)).To(Equal("1"))
+			Expect(ResetVfPKey("ib0", "0000:af:06.0", "2")).To(Succeed())
+			Expect(GetVfPKeyEntry("ib0", "0000:af:06.0", 0)).To(Equal("2"))
+			Expect(ResetVfPKey("ib0", "0000:af:06.0", "")).To(Succeed())
+			Expect(GetVfPKeyEntry("ib0", "0000:af:06.0", 0)).To(Equal("0"))
+		})
+		It("Assuming mapped and unmapped pkey table entries", func() {
+			idx, err := GetVfPKeyEntry("ib0", "0000:af:06.0", 1)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(IsVfPKeyEntryFree(idx)).To(BeTrue())
+			idx, err = GetVfPKeyEntry("ib0", "0000:af:06.0", 2)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(IsVfPKeyEntryFree(idx)).To(BeFalse())
+			_, err = GetVfPKeyEntry("ib0", "0000:af:06.0", 3)
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming existing pkey in additional pkey table entry", func() {
+			err := SetVfPKeyEntry("ib0", "0000:af:06.0", 1, "0x0001")
+			Expect(err).NotTo(HaveOccurred())
+			data, err := ioutil.ReadFile(filepath.Join(vfPKeyIdxDir("mlx5_0", "0000:af:06.0"), "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("1"))
+
+			err = ClearVfPKeyEntry("ib0", "0000:af:06.0", 1)
+			Expect(err).NotTo(HaveOccurred())
+			data, err = ioutil.ReadFile(filepath.Join(vfPKeyIdxDir("mlx5_0", "0000:af:06.0"), "1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal("none"))
+		})
+		It("Assuming not existing pkey", func() {
+			err := SetVfPKey("ib0", "0000:af:06.0", "0x0002")
+			Expect(err).To(HaveOccurred())
+		})
+		It("Assuming PF without infiniband device", func() {
+			Expect(IsVfPKeyConfigurable("ib3", "0000:af:06.0")).To(BeFalse())
+		})
+	})
+	Context("Checking GetNetnsID function", func() {
+		It("Assuming same and different paths", func() {
+			dir, err := ioutil.TempDir("", "ib-sriov-cni-netns-")
+			Expect(err).NotTo(HaveOccurred())
+			defer os.RemoveAll(dir)
+			first := filepath.Join(dir, "first")
+			second := filepath.Join(dir, "second")
+			Expect(ioutil.WriteFile(first, []byte{}, 0600)).To(Succeed())
+			Expect(ioutil.WriteFile(second, []byte{}, 0600)).To(Succeed())
+
+			firstID, err := GetNetnsID(first)
+			Expect(err).NotTo(HaveOccurred())
+			secondID, err := GetNetnsID(second)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(firstID).NotTo(Equal(secondID))
+
+			f, err := os.Open(first)
+			Expect(err).NotTo(HaveOccurred())
+			defer f.Close()
+			Expect(GetNetnsIDFromFd(f.Fd())).To(Equal(firstID))
+		})
+		It("Assuming not existing path", func() {
+			_, err := GetNetnsID("/proc/0/ns/net")
+			Expect(err).To(HaveOccurred())
+		})
+	})
+	Context("Checking SaveNetConf function", func() {
+		var dataDir string
+
+		BeforeEach(func() {
+			var err error
+			dataDir, err = ioutil.TempDir("", "ib-sriov-cni-cache-")
+			Expect(err).NotTo(HaveOccurred())
+		})
+
+		AfterEach(func() {
+			Expect(os.RemoveAll(dataDir)).To(Succeed())
+		})
+
+		It("Assuming new cache entry", func() {
+			err := SaveNetConf("cid", dataDir, "net1", map[string]string{"Master": "ib0"})
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := ReadScratchNetConf(filepath.Join(dataDir, "cid-net1"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"Master":"ib0"}`))
+
+			files, err := ioutil.ReadDir(dataDir)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(files).To(HaveLen(1), "no temporary files should be left behind")
+		})
+		It("Assuming existing partially written cache entry", func() {
+			path := filepath.Join(dataDir, "cid-net1")
+			Expect(ioutil.WriteFile(path, []byte(`{"Mast`), 0600)).To(Succeed())
+
+			err := SaveNetConf("cid", dataDir, "net1", map[string]string{"Master": "ib0"})
+			Expect(err).NotTo(HaveOccurred())
+
+			data, err := ReadScratchNetConf(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"Master":"ib0"}`))
+		})
+		It("Assuming cached entry overwritten", func() {
+			path := filepath.Join(dataDir, "cid-net1")
+			Expect(ioutil.WriteFile(path, []byte(`{"Master":"ib0","mtu":2044,"pkey":"0x1"}`), 0600)).To(Succeed())
+
+			conflict, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]interface{}{"Master": "ib0", "mtu": 4092},
+				nil, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflict.Path).To(Equal(path))
+			Expect(conflict.Changes).To(Equal([]string{"mtu: 2044 -> 4092", `pkey: "0x1" -> unset`}))
+
+			data, err := ReadScratchNetConf(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"Master":"ib0","mtu":4092}`))
+		})
+		It("Assuming cached entry merged", func() {
+			path := filepath.Join(dataDir, "cid-net1")
+			Expect(ioutil.WriteFile(path, []byte(`{"Master":"ib0","mtu":2044,"pkey":"0x1","AddedPKeys":["0x2"]}`), 0600)).To(Succeed())
+
+			conflict, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]interface{}{"Master": "ib0", "mtu": 4092},
+				[]string{"mtu", "pkey"}, false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflict.Changes).To(Equal([]string{`AddedPKeys: ["0x2"] -> unset`, "mtu: 2044 -> 4092"}))
+
+			data, err := ReadScratchNetConf(path)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(data)).To(Equal(`{"Master":"ib0","mtu":4092,"pkey":"0x1"}`))
+		})
+		It("Assuming no cached entry", func() {
+			conflict, err := SaveNetConfPolicy("cid", dataDir, "net1", map[string]string{"Master": "ib0"}, nil,
+				false)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(conflict).To(BeNil())
+		})
+		It("Assuming not serializable conf", func() {
+			err := SaveNetConf("cid", dataDir, "net1", make(chan int))
+			Expect(err).To(HaveOccurred())
+
+			_, err = os.Stat(filepath.Join(dataDir, "cid-net1"))
+			Expect(os.IsNotExist(err)).To(BeTrue())
+		})
+	})
 })